@@ -0,0 +1,96 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authorization
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WorkspaceLabel is the label selecting the BeamlitWorkspace of the resources of a namespace
+const WorkspaceLabel = "beamlit.com/workspace"
+
+// BeamlitWorkspaceSpec defines the connection to a Beamlit workspace
+type BeamlitWorkspaceSpec struct {
+	// BaseURL is the base URL of the Beamlit API
+	// If not set, the base URL of the operator is used
+	// +kubebuilder:validation:Optional
+	BaseURL string `json:"baseUrl,omitempty"`
+
+	// CredentialsSecretRef is the reference to the Secret holding the Beamlit token of the workspace
+	// The Secret is watched, so credentials can be rotated without restarting the operator
+	// +kubebuilder:validation:Required
+	CredentialsSecretRef SecretKeyReference `json:"credentialsSecretRef"`
+
+	// NamespaceSelector restricts the namespaces whose resources can select the workspace,
+	// with the beamlit.com/workspace label of the namespace or with spec.workspaceRef
+	// If not set, the resources of every namespace can select the workspace. Cluster-scoped resources can always select it
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// SecretKeyReference is the reference to a key of a Secret
+type SecretKeyReference struct {
+	// Namespace is the namespace of the Secret
+	// +kubebuilder:validation:Required
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the Secret
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Key is the key of the Beamlit token in the Secret
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=token
+	Key string `json:"key,omitempty"`
+}
+
+// BeamlitWorkspaceStatus defines the observed state of BeamlitWorkspace
+type BeamlitWorkspaceStatus struct {
+	// Conditions are the latest observations of the workspace connection
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,shortName=bws
+
+// BeamlitWorkspace is the Schema for the beamlitworkspaces API
+// It holds the connection to a Beamlit workspace, selected by namespaces with the beamlit.com/workspace label,
+// or by resources with spec.workspaceRef
+type BeamlitWorkspace struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BeamlitWorkspaceSpec   `json:"spec,omitempty"`
+	Status BeamlitWorkspaceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BeamlitWorkspaceList contains a list of BeamlitWorkspace
+type BeamlitWorkspaceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BeamlitWorkspace `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BeamlitWorkspace{}, &BeamlitWorkspaceList{})
+}
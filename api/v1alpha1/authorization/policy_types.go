@@ -18,25 +18,51 @@ package authorization
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // PolicySpec defines the desired state of Policy on Beamlit
+// Each policy type has its own sub-spec, and only the sub-spec matching the type can be set
+// +kubebuilder:validation:XValidation:rule="self.type == 'location' ? has(self.locations) && size(self.locations) > 0 : !has(self.locations) || size(self.locations) == 0",message="locations must be set for location policies only"
+// +kubebuilder:validation:XValidation:rule="self.type == 'flavor' ? has(self.flavors) && size(self.flavors) > 0 : !has(self.flavors) || size(self.flavors) == 0",message="flavors must be set for flavor policies only"
+// +kubebuilder:validation:XValidation:rule="self.type == 'gateway' ? has(self.gateway) : !has(self.gateway)",message="gateway must be set for gateway policies only"
 type PolicySpec struct {
 	// DisplayName is the display name of the policy
 	// +kubebuilder:validation:Optional
 	DisplayName string `json:"displayName,omitempty"`
 
+	// RemoteName is the name of the policy on Beamlit
+	// If not set, the name is derived from the policy name using the operator naming strategy
+	// +kubebuilder:validation:Optional
+	RemoteName string `json:"remoteName,omitempty"`
+
+	// WorkspaceRef is the name of the BeamlitWorkspace the policy is synced to
+	// If not set, a Policy uses the BeamlitWorkspace of its namespace, and a ClusterPolicy the workspace of the operator
+	// +kubebuilder:validation:Optional
+	WorkspaceRef string `json:"workspaceRef,omitempty"`
+
 	// Type is the type of the policy
+	// Gateway policies are enforced locally by the Beamlit gateway, and are not synced to Beamlit
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Enum=location;flavor
+	// +kubebuilder:validation:Enum=location;flavor;gateway
 	Type PolicyType `json:"type"`
 
-	Locations []PolicyLocation `json:"locations"`
+	// Locations is the list of locations allowed by a location policy
+	// +kubebuilder:validation:Optional
+	Locations []PolicyLocation `json:"locations,omitempty"`
+
+	// Flavors is the list of flavors allowed by a flavor policy
+	// +kubebuilder:validation:Optional
+	Flavors []PolicyFlavor `json:"flavors,omitempty"`
 
-	// Flavors is the list of flavors allowed by the policy
-	// If not set, all flavors are allowed
+	// Gateway is the set of rules enforced by the Beamlit gateway on the requests to the models of a gateway policy
 	// +kubebuilder:validation:Optional
-	Flavors []PolicyFlavor `json:"flavors"`
+	Gateway *GatewayPolicy `json:"gateway,omitempty"`
+
+	// ResourceTypes is the list of Beamlit resource types the policy applies to (e.g. model, function)
+	// If not set, the policy applies to all resource types
+	// +kubebuilder:validation:Optional
+	ResourceTypes []string `json:"resourceTypes,omitempty"`
 }
 
 type PolicyType string
@@ -44,6 +70,7 @@ type PolicyType string
 const (
 	PolicyTypeLocation PolicyType = "location"
 	PolicyTypeFlavor   PolicyType = "flavor"
+	PolicyTypeGateway  PolicyType = "gateway"
 )
 
 type PolicySubTypeLocation string
@@ -72,6 +99,43 @@ type PolicyFlavor struct {
 	Name string `json:"name"`
 }
 
+// GatewayPolicy defines the rules enforced locally by the Beamlit gateway
+// A request must satisfy every rule to be proxied
+// +kubebuilder:validation:XValidation:rule="has(self.rateLimit) || has(self.allowedCIDRs) || has(self.requiredHeaders)",message="at least one rule must be set"
+type GatewayPolicy struct {
+	// RateLimit limits the rate of requests per consumer
+	// +kubebuilder:validation:Optional
+	RateLimit *GatewayRateLimit `json:"rateLimit,omitempty"`
+
+	// AllowedCIDRs is the list of CIDRs the callers must belong to
+	// If not set, all callers are allowed
+	// +kubebuilder:validation:Optional
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+
+	// RequiredHeaders is the list of headers every request must have
+	// +kubebuilder:validation:Optional
+	RequiredHeaders []string `json:"requiredHeaders,omitempty"`
+}
+
+// GatewayRateLimit defines a token bucket rate limit per consumer
+type GatewayRateLimit struct {
+	// RequestsPerSecond is the number of requests per second allowed for a consumer
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	RequestsPerSecond int32 `json:"requestsPerSecond"`
+
+	// Burst is the maximum number of requests allowed at once for a consumer
+	// If not set, it is equal to RequestsPerSecond
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	Burst int32 `json:"burst,omitempty"`
+
+	// ConsumerHeader is the header identifying the consumer
+	// If not set, consumers are identified by their IP address
+	// +kubebuilder:validation:Optional
+	ConsumerHeader string `json:"consumerHeader,omitempty"`
+}
+
 // PolicyStatus defines the observed state of Policy
 type PolicyStatus struct {
 	// CreatedAtOnBeamlit is the time when the policy was created on Beamlit
@@ -80,6 +144,15 @@ type PolicyStatus struct {
 	UpdatedAtOnBeamlit metav1.Time `json:"updatedAtOnBeamlit,omitempty"`
 	// Workspace is the workspace of the policy
 	Workspace string `json:"workspace"`
+	// RemoteName is the name of the policy on Beamlit
+	RemoteName string `json:"remoteName,omitempty"`
+	// WorkspaceRef is the name of the BeamlitWorkspace the policy was synced to, empty for the workspace of the operator
+	WorkspaceRef string `json:"workspaceRef,omitempty"`
+	// Conditions are the latest observations of the policy state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -103,6 +176,25 @@ type PolicyList struct {
 	Items           []Policy `json:"items"`
 }
 
+// PolicyObject is implemented by Policy and ClusterPolicy
+// +kubebuilder:object:generate=false
+type PolicyObject interface {
+	metav1.Object
+	runtime.Object
+	GetPolicySpec() *PolicySpec
+	GetPolicyStatus() *PolicyStatus
+}
+
+// GetPolicySpec returns the spec of the policy
+func (p *Policy) GetPolicySpec() *PolicySpec {
+	return &p.Spec
+}
+
+// GetPolicyStatus returns the status of the policy
+func (p *Policy) GetPolicyStatus() *PolicyStatus {
+	return &p.Status
+}
+
 func init() {
 	SchemeBuilder.Register(&Policy{}, &PolicyList{})
 }
@@ -54,6 +54,11 @@ type ModelDeploymentSpec struct {
 	// +kubebuilder:default="production"
 	Environment string `json:"environment,omitempty"`
 
+	// WorkspaceRef is the name of the BeamlitWorkspace the model deployment is deployed to
+	// If not set, the BeamlitWorkspace of the namespace is used, or the workspace of the operator
+	// +kubebuilder:validation:Optional
+	WorkspaceRef string `json:"workspaceRef,omitempty"`
+
 	// Policies is the list of policies to apply to the model deployment
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default={}
@@ -73,21 +78,23 @@ type ModelDeploymentSpec struct {
 type PolicyRefType string
 
 const (
-	PolicyRefTypeRemotePolicy PolicyRefType = "remotePolicy"
-	PolicyRefTypeLocalPolicy  PolicyRefType = "localPolicy"
+	PolicyRefTypeRemotePolicy  PolicyRefType = "remotePolicy"
+	PolicyRefTypeLocalPolicy   PolicyRefType = "localPolicy"
+	PolicyRefTypeClusterPolicy PolicyRefType = "clusterPolicy"
 )
 
 // PolicyRef is the reference to a policy
 type PolicyRef struct {
 	// RefType is the type of the policy reference
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Enum=remotePolicy;localPolicy
+	// +kubebuilder:validation:Enum=remotePolicy;localPolicy;clusterPolicy
 	// +kubebuilder:default=remotePolicy
 	RefType PolicyRefType `json:"refType"`
 
 	// Ref is the reference to the policy
+	// A local policy must live in the namespace of the model deployment, use a cluster policy to share a policy across namespaces
 	// +kubebuilder:validation:Optional
-	Ref corev1.ObjectReference `json:",inline"`
+	Ref corev1.ObjectReference `json:",inline,omitempty"`
 
 	// Name is the name of the policy
 	// +kubebuilder:validation:Optional
@@ -152,7 +159,22 @@ type OffloadingConfig struct {
 
 type ServiceReference struct {
 	corev1.ObjectReference `json:",inline"`
-	TargetPort             int32 `json:"targetPort,omitempty"`
+	// TargetPort is the port of the Service serving the model. Its target port may be named, it is resolved against the pod template of the model.
+	TargetPort int32 `json:"targetPort,omitempty"`
+	// AdditionalTargetPorts are the other ports of the Service offloaded along with TargetPort, e.g. a gRPC port next to an HTTP inference port.
+	// Each port is routed independently. Only used by ServiceRef.
+	// +kubebuilder:validation:Optional
+	AdditionalTargetPorts []int32 `json:"additionalTargetPorts,omitempty"`
+	// ClientServiceName is the name of a Service of the namespace of the Service, called by the clients of the model which can't target
+	// the <svc>-offload Service instead. With the offload-service configurer, it is pointed at the gateway pods while the model is configured,
+	// and restored afterwards. Only used by ServiceRef.
+	// +kubebuilder:validation:Optional
+	ClientServiceName string `json:"clientServiceName,omitempty"`
+}
+
+// Ports returns the offloaded ports of the Service, TargetPort first
+func (s *ServiceReference) Ports() []int32 {
+	return append([]int32{s.TargetPort}, s.AdditionalTargetPorts...)
 }
 
 type SupportedScheme string
@@ -225,6 +247,41 @@ type OffloadingBehavior struct {
 	Percentage int32 `json:"percentage,omitempty"`
 }
 
+// SyncedSecret is a Beamlit secret holding the value of a key of a Secret
+type SyncedSecret struct {
+	// Name is the name of the secret on Beamlit
+	Name string `json:"name"`
+
+	// Source is the key the value is read from, as Kind/name/key, e.g. Secret/hf/token
+	Source string `json:"source"`
+
+	// ResourceVersion is the resourceVersion of the Secret when the value was pushed to Beamlit, to push it again only when the Secret changes
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type ConversionAction string
+
+const (
+	ConversionActionDropped   ConversionAction = "dropped"
+	ConversionActionRewritten ConversionAction = "rewritten"
+	ConversionActionResolved  ConversionAction = "resolved"
+)
+
+// ConversionReportEntry is a change made to the pod template of the model source when it was pushed to Beamlit
+type ConversionReportEntry struct {
+	// Field is the path of the changed field in the pod template, e.g. spec.containers[model].env[API_KEY]
+	Field string `json:"field"`
+
+	// Action is the change made to the field
+	// dropped: the field was removed, rewritten: the field was replaced by a reference to a Beamlit secret,
+	// resolved: the value read by the field was inlined
+	// +kubebuilder:validation:Enum=dropped;rewritten;resolved
+	Action ConversionAction `json:"action"`
+
+	// Reason is why the field was changed
+	Reason string `json:"reason"`
+}
+
 // ModelDeploymentStatus defines the observed state of ModelDeployment
 type ModelDeploymentStatus struct {
 	// OffloadingStatus is the status of the offloading
@@ -240,16 +297,36 @@ type ModelDeploymentStatus struct {
 	// Workspace is the workspace of the model deployment
 	Workspace string `json:"workspace,omitempty"`
 
+	// WorkspaceRef is the name of the BeamlitWorkspace the model deployment was deployed to, empty for the workspace of the operator
+	WorkspaceRef string `json:"workspaceRef,omitempty"`
+
 	// CreatedAtOnBeamlit is the time when the model deployment was created on Beamlit
 	CreatedAtOnBeamlit metav1.Time `json:"createdAtOnBeamlit,omitempty"`
 
 	// UpdatedAtOnBeamlit is the time when the model deployment was updated on Beamlit
 	UpdatedAtOnBeamlit metav1.Time `json:"updatedAtOnBeamlit,omitempty"`
+
+	// ConversionReport lists the fields of the pod template of the model source that were dropped or rewritten
+	// because they only work in the cluster, when the model deployment was pushed to Beamlit
+	// +optional
+	ConversionReport []ConversionReportEntry `json:"conversionReport,omitempty"`
+
+	// SyncedSecrets are the Beamlit secrets holding the values of the Secrets read by the env vars of the pod template
+	// of the model source, when the operator syncs them
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	SyncedSecrets []SyncedSecret `json:"syncedSecrets,omitempty"`
+
+	// Conditions are the latest observations of the model deployment state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
-//+kubebuilder:subresource:scale:specpath=.spec.minNumReplicasPerLocation,statuspath=.status.availableReplicas,selectorpath=.status.conditions
 
 // ModelDeployment is the Schema for the modeldeployments API
 type ModelDeployment struct {
@@ -22,6 +22,7 @@ package deployment
 
 import (
 	"k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -45,6 +46,21 @@ func (in *AuthConfig) DeepCopy() *AuthConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConversionReportEntry) DeepCopyInto(out *ConversionReportEntry) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConversionReportEntry.
+func (in *ConversionReportEntry) DeepCopy() *ConversionReportEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(ConversionReportEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ModelDeployment) DeepCopyInto(out *ModelDeployment) {
 	*out = *in
@@ -111,12 +127,12 @@ func (in *ModelDeploymentSpec) DeepCopyInto(out *ModelDeploymentSpec) {
 	if in.ServiceRef != nil {
 		in, out := &in.ServiceRef, &out.ServiceRef
 		*out = new(ServiceReference)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.MetricServiceRef != nil {
 		in, out := &in.MetricServiceRef, &out.MetricServiceRef
 		*out = new(ServiceReference)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Policies != nil {
 		in, out := &in.Policies, &out.Policies
@@ -150,6 +166,23 @@ func (in *ModelDeploymentStatus) DeepCopyInto(out *ModelDeploymentStatus) {
 	*out = *in
 	in.CreatedAtOnBeamlit.DeepCopyInto(&out.CreatedAtOnBeamlit)
 	in.UpdatedAtOnBeamlit.DeepCopyInto(&out.UpdatedAtOnBeamlit)
+	if in.ConversionReport != nil {
+		in, out := &in.ConversionReport, &out.ConversionReport
+		*out = make([]ConversionReportEntry, len(*in))
+		copy(*out, *in)
+	}
+	if in.SyncedSecrets != nil {
+		in, out := &in.SyncedSecrets, &out.SyncedSecrets
+		*out = make([]SyncedSecret, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelDeploymentStatus.
@@ -316,6 +349,11 @@ func (in *ServerlessConfig) DeepCopy() *ServerlessConfig {
 func (in *ServiceReference) DeepCopyInto(out *ServiceReference) {
 	*out = *in
 	out.ObjectReference = in.ObjectReference
+	if in.AdditionalTargetPorts != nil {
+		in, out := &in.AdditionalTargetPorts, &out.AdditionalTargetPorts
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceReference.
@@ -328,6 +366,21 @@ func (in *ServiceReference) DeepCopy() *ServiceReference {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SyncedSecret) DeepCopyInto(out *SyncedSecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SyncedSecret.
+func (in *SyncedSecret) DeepCopy() *SyncedSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(SyncedSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ToolDeployment) DeepCopyInto(out *ToolDeployment) {
 	*out = *in
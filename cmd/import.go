@@ -0,0 +1,62 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/importer"
+)
+
+// runImport runs the import subcommand, which writes the manifests of the policies and models
+// existing on Beamlit to stdout, and returns the exit code
+func runImport(args []string) int {
+	importCmd := importer.Importer{}
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.StringVar(&importCmd.Namespace, "namespace", "default", "Namespace of the generated resources")
+	flags.StringVar(&importCmd.Environment, "environment", "production", "Beamlit environment to import the models from")
+	flags.BoolVar(&importCmd.Adopt, "adopt", false, "Take ownership of the imported policies and models on Beamlit, so the operator manages them")
+	flags.BoolVar(&importCmd.SkipPolicies, "skip-policies", false, "Do not import policies")
+	flags.BoolVar(&importCmd.SkipModels, "skip-models", false, "Do not import models")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		setupLog.Error(err, "unable to parse flags")
+		return 1
+	}
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts), zap.WriteTo(os.Stderr)))
+
+	beamlitClient, err := beamlit.NewClient()
+	if err != nil {
+		setupLog.Error(err, "unable to create beamlit client")
+		return 1
+	}
+	importCmd.BeamlitClient = beamlitClient
+	if err := importCmd.Run(ctrl.SetupSignalHandler(), os.Stdout); err != nil {
+		setupLog.Error(err, "unable to import resources from Beamlit")
+		return 1
+	}
+	return 0
+}
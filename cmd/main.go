@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -24,10 +25,12 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
@@ -38,20 +41,35 @@ import (
 	"github.com/beamlit/beamlit-controller/internal/beamlit"
 	"github.com/beamlit/beamlit-controller/internal/config"
 	"github.com/beamlit/beamlit-controller/internal/controller"
+	"github.com/beamlit/beamlit-controller/internal/controller/helper"
 	"github.com/beamlit/beamlit-controller/internal/dataplane/configurer"
 	"github.com/beamlit/beamlit-controller/internal/dataplane/offloader"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/xds"
 	"github.com/beamlit/beamlit-controller/internal/informers/health"
 	"github.com/beamlit/beamlit-controller/internal/informers/metric"
+	"github.com/beamlit/beamlit-controller/internal/reporter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayclientset "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -70,6 +88,10 @@ func init() {
 
 //nolint:gocyclo
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+
 	var cfgPath string
 	flag.StringVar(&cfgPath, "config", "", "Path to the config file")
 
@@ -137,10 +159,8 @@ func main() {
 	}
 
 	namespacesList := make(map[string]cache.Config)
-	if *cfg.Namespaces != "" {
-		for _, ns := range strings.Split(*cfg.Namespaces, ",") {
-			namespacesList[ns] = cache.Config{}
-		}
+	for _, ns := range splitNamespaces(cfg.Namespaces) {
+		namespacesList[ns] = cache.Config{}
 	}
 
 	if len(namespacesList) > 0 {
@@ -150,8 +170,41 @@ func main() {
 		}
 	}
 
+	// Only the metadata of the Secrets is cached, in the namespaces the manager is allowed to watch them
+	secretNamespaces := make(map[string]cache.Config)
+	if cfg.BeamlitCredentials != nil {
+		secretNamespaces[*cfg.BeamlitCredentials.Namespace] = cache.Config{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", *cfg.BeamlitCredentials.Name),
+		}
+	}
+	workspaceCredentialsNamespaces := splitNamespaces(cfg.WorkspaceCredentialsNamespaces)
+	for _, ns := range workspaceCredentialsNamespaces {
+		secretNamespaces[ns] = cache.Config{}
+	}
+	// The Secrets synced to Beamlit are watched in the namespaces of the ModelDeployments
+	syncSecrets := cfg.PodTemplate != nil && cfg.PodTemplate.SyncSecrets != nil && *cfg.PodTemplate.SyncSecrets
+	if syncSecrets {
+		if len(namespacesList) == 0 {
+			secretNamespaces = map[string]cache.Config{cache.AllNamespaces: {}}
+		}
+		for ns := range namespacesList {
+			secretNamespaces[ns] = cache.Config{}
+		}
+	}
+	if len(secretNamespaces) > 0 {
+		ctrlOpts.Cache.ByObject = map[ctrlclient.Object]cache.ByObject{
+			&corev1.Secret{}: {Namespaces: secretNamespaces},
+		}
+	}
+
 	ctx := ctrl.SetupSignalHandler()
 
+	shutdownTracing, err := setupTracing(ctx, cfg.Tracing)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
 	kubeConfig, err := ctrl.GetConfig()
 	if err != nil {
 		setupLog.Error(err, "unable to get config")
@@ -197,13 +250,44 @@ func main() {
 		os.Exit(1)
 	}
 
-	beamlitClient, err := beamlit.NewClient()
+	requestPolicy, err := newRequestPolicy(cfg.BeamlitClient)
+	if err != nil {
+		setupLog.Error(err, "invalid beamlit client config")
+		os.Exit(1)
+	}
+	beamlitClient, err := beamlit.NewClient(beamlit.WithRequestPolicy(requestPolicy))
 	if err != nil {
 		setupLog.Error(err, "unable to create beamlit client")
 		os.Exit(1)
 	}
 
-	configurer, err := configurer.NewConfigurer(ctx, configurer.KubernetesConfigurerType, clientset)
+	if cfg.BeamlitCredentials != nil {
+		credentialsReconciler := &controller.CredentialsReconciler{
+			Client:         mgr.GetClient(),
+			SecretReader:   mgr.GetAPIReader(),
+			BeamlitClient:  beamlitClient,
+			SecretRef:      types.NamespacedName{Namespace: *cfg.BeamlitCredentials.Namespace, Name: *cfg.BeamlitCredentials.Name},
+			TokenKey:       "token",
+			BaseURLKey:     "baseUrl",
+			DefaultBaseURL: os.Getenv("BEAMLIT_BASE_URL"),
+		}
+		if cfg.BeamlitCredentials.TokenKey != nil {
+			credentialsReconciler.TokenKey = *cfg.BeamlitCredentials.TokenKey
+		}
+		if cfg.BeamlitCredentials.BaseURLKey != nil {
+			credentialsReconciler.BaseURLKey = *cfg.BeamlitCredentials.BaseURLKey
+		}
+		if err := credentialsReconciler.LoadCredentials(ctx, mgr.GetAPIReader()); err != nil {
+			setupLog.Error(err, "unable to load beamlit credentials")
+			os.Exit(1)
+		}
+		if err := credentialsReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "BeamlitCredentials")
+			os.Exit(1)
+		}
+	}
+
+	configurer, err := configurer.NewConfigurer(ctx, configurerType(cfg), clientset)
 	if err != nil {
 		setupLog.Error(err, "unable to create configurer")
 		os.Exit(1)
@@ -216,25 +300,15 @@ func main() {
 				Name:      *cfg.ProxyService.Name,
 			},
 			TargetPort: int32(*cfg.ProxyService.Port),
+		}, &controller.ModelServiceOwners{
+			Reader:  mgr.GetAPIReader(),
+			Watched: splitNamespaces(cfg.Namespaces),
 		}); err != nil {
 			setupLog.Error(err, "unable to start configurer")
 		}
 	}()
 
-	offloader, err := offloader.NewOffloader(
-		ctx,
-		offloader.BeamlitGatewayOffloaderType,
-		clientset,
-		beamlitclientset.NewClientSet(
-			http.DefaultClient,
-			fmt.Sprintf(
-				"%s.%s.svc.cluster.local:%d",
-				*cfg.ProxyService.Name,
-				*cfg.ProxyService.Namespace,
-				*cfg.ProxyService.AdminPort,
-			),
-		),
-	)
+	offloader, err := newOffloader(ctx, cfg, kubeConfig, clientset, mgr)
 	if err != nil {
 		setupLog.Error(err, "unable to create offloader")
 		os.Exit(1)
@@ -242,22 +316,50 @@ func main() {
 
 	client := mgr.GetClient()
 	scheme := mgr.GetScheme()
+	workspaceClients := controller.NewWorkspaceClients(client, mgr.GetAPIReader(), beamlitClient, os.Getenv("BEAMLIT_BASE_URL"))
+	workspaceClients.ClientOptions = []beamlit.Option{beamlit.WithRequestPolicy(requestPolicy)}
+	if err = (&controller.BeamlitWorkspaceReconciler{
+		Client:                client,
+		Scheme:                scheme,
+		WorkspaceClients:      workspaceClients,
+		CredentialsNamespaces: workspaceCredentialsNamespaces,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BeamlitWorkspace")
+		os.Exit(1)
+	}
+	offloadReporter, err := newOffloadReporter(cfg.OffloadEvents, workspaceClients)
+	if err != nil {
+		setupLog.Error(err, "invalid offload events config")
+		os.Exit(1)
+	}
+	if err := mgr.Add(offloadReporter); err != nil {
+		setupLog.Error(err, "unable to add offload reporter")
+		os.Exit(1)
+	}
 	ctrl := &controller.ModelDeploymentReconciler{
 		Client:               client,
 		Scheme:               scheme,
-		BeamlitClient:        beamlitClient,
+		WorkspaceClients:     workspaceClients,
 		MetricInformer:       metricInformer,
 		MetricStatusChan:     metricChan,
 		Configurer:           configurer,
 		HealthInformer:       healthInformer,
 		HealthStatusChan:     healthChan,
+		ServiceEventChan:     configurer.Events(),
 		Offloader:            offloader,
+		OffloadReporter:      offloadReporter,
 		ManagedModels:        make(map[string]controller.ManagedModel),
 		OngoingOffloadings:   sync.Map{},
 		ModelState:           sync.Map{},
 		DefaultRemoteBackend: nil,
 		BeamlitModels:        make(map[string]string),
 	}
+	ctrl.PodTemplateTransformer, err = newPodTemplateTransformer(cfg.PodTemplate, mgr.GetAPIReader())
+	if err != nil {
+		setupLog.Error(err, "invalid pod template config")
+		os.Exit(1)
+	}
+	ctrl.SyncSecrets = syncSecrets
 
 	if cfg.DefaultRemoteBackend.Host != nil {
 		ctrl.DefaultRemoteBackend = &beamlitdeploymentv1alpha1.RemoteBackend{
@@ -281,15 +383,27 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "ModelDeployment")
 		os.Exit(1)
 	}
+	managedPolicies := controller.NewManagedPolicies()
 	if err = (&controller.PolicyReconciler{
-		Client:          client,
-		Scheme:          scheme,
-		BeamlitClient:   beamlitClient,
-		ManagedPolicies: make(map[string]controller.ManagedPolicyRef),
+		Client:           client,
+		Scheme:           scheme,
+		WorkspaceClients: workspaceClients,
+		ManagedPolicies:  managedPolicies,
+		NamingStrategy:   *cfg.PolicyNamingStrategy,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Policy")
 		os.Exit(1)
 	}
+	if err = (&controller.ClusterPolicyReconciler{
+		Client:           client,
+		Scheme:           scheme,
+		WorkspaceClients: workspaceClients,
+		ManagedPolicies:  managedPolicies,
+		NamingStrategy:   *cfg.PolicyNamingStrategy,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterPolicy")
+		os.Exit(1)
+	}
 	if err = (&controller.ToolDeploymentReconciler{
 		Client: client,
 		Scheme: scheme,
@@ -325,4 +439,213 @@ func main() {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		setupLog.Error(err, "unable to export the remaining spans")
+	}
+}
+
+// newRequestPolicy returns the Beamlit request policy with the settings of cfg, the defaults are kept for the unset ones
+func newRequestPolicy(cfg *config.BeamlitClientConfig) (*beamlit.RequestPolicy, error) {
+	policy := beamlit.DefaultRequestPolicy()
+	if cfg == nil {
+		return policy, nil
+	}
+	if cfg.MaxRetries != nil {
+		policy.MaxRetries = *cfg.MaxRetries
+	}
+	if cfg.InitialBackoff != nil {
+		initialBackoff, err := time.ParseDuration(*cfg.InitialBackoff)
+		if err != nil {
+			return nil, err
+		}
+		policy.InitialBackoff = initialBackoff
+	}
+	if cfg.MaxBackoff != nil {
+		maxBackoff, err := time.ParseDuration(*cfg.MaxBackoff)
+		if err != nil {
+			return nil, err
+		}
+		policy.MaxBackoff = maxBackoff
+	}
+	if cfg.RequestsPerSecond != nil {
+		if *cfg.RequestsPerSecond == 0 {
+			policy.Limiter = nil
+		} else {
+			policy.Limiter.SetLimit(rate.Limit(*cfg.RequestsPerSecond))
+		}
+	}
+	if cfg.Burst != nil && policy.Limiter != nil {
+		policy.Limiter.SetBurst(*cfg.Burst)
+	}
+	return policy, nil
+}
+
+// splitNamespaces returns the namespaces of a comma-separated list
+func splitNamespaces(namespaces *string) []string {
+	if namespaces == nil || *namespaces == "" {
+		return nil
+	}
+	return strings.Split(*namespaces, ",")
+}
+
+// newOffloadReporter returns the offload reporter with the settings of cfg, the defaults are kept for the unset ones
+func newOffloadReporter(cfg *config.OffloadEventsConfig, clients reporter.ClientProvider) (*reporter.OffloadReporter, error) {
+	offloadReporter := reporter.NewOffloadReporter(clients)
+	if cfg == nil {
+		return offloadReporter, nil
+	}
+	if cfg.FlushInterval != nil {
+		flushInterval, err := time.ParseDuration(*cfg.FlushInterval)
+		if err != nil {
+			return nil, err
+		}
+		offloadReporter.FlushInterval = flushInterval
+	}
+	if cfg.BatchSize != nil {
+		offloadReporter.BatchSize = *cfg.BatchSize
+	}
+	if cfg.BufferSize != nil {
+		offloadReporter.BufferSize = *cfg.BufferSize
+	}
+	return offloadReporter, nil
+}
+
+// configurerType returns the type of configurer selected in cfg, defaulting to the noop one with the istio and gateway-api offloaders
+func configurerType(cfg *config.Config) configurer.ConfigurerType {
+	if cfg.Configurer != nil && cfg.Configurer.Type != nil {
+		return configurer.ConfigurerType(*cfg.Configurer.Type)
+	}
+	if cfg.Offloader != nil && cfg.Offloader.Type != nil {
+		switch *cfg.Offloader.Type {
+		case config.OffloaderTypeIstio, config.OffloaderTypeGatewayAPI:
+			// The traffic is split by the mesh or the gateway, the model Services must be left untouched
+			return configurer.NoopConfigurerType
+		}
+	}
+	return configurer.KubernetesConfigurerType
+}
+
+// newOffloader returns the offloader of the type selected in cfg, the Beamlit gateway one by default.
+// The xDS server of the envoy-xds offloader is added to mgr.
+func newOffloader(ctx context.Context, cfg *config.Config, kubeConfig *rest.Config, clientset kubernetes.Interface, mgr ctrl.Manager) (offloader.Offloader, error) {
+	offloaderType := offloader.BeamlitGatewayOffloaderType
+	if cfg.Offloader != nil && cfg.Offloader.Type != nil {
+		offloaderType = offloader.OffloaderType(*cfg.Offloader.Type)
+	}
+	options := offloader.Options{}
+	switch offloaderType {
+	case offloader.BeamlitGatewayOffloaderType:
+		options.ProxyClient = beamlitclientset.NewClientSet(
+			http.DefaultClient,
+			fmt.Sprintf(
+				"%s.%s.svc.cluster.local:%d",
+				*cfg.ProxyService.Name,
+				*cfg.ProxyService.Namespace,
+				*cfg.ProxyService.AdminPort,
+			),
+		)
+	case offloader.EnvoyXDSOffloaderType:
+		// The TLS config is required by the validation of the config
+		envoyXDS := cfg.Offloader.EnvoyXDS
+		address, nodeID := ":18000", "beamlit-gateway"
+		if envoyXDS.Address != nil {
+			address = *envoyXDS.Address
+		}
+		if envoyXDS.NodeID != nil {
+			nodeID = *envoyXDS.NodeID
+		}
+		tlsConfig, err := xds.NewTLSConfig(envoyXDS.TLS.CertFile, envoyXDS.TLS.KeyFile, envoyXDS.TLS.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		// Envoy listens on the port of the proxy Service the traffic of the models is redirected to
+		xdsServer, err := xds.NewServer(address, nodeID, uint32(*cfg.ProxyService.Port), tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if err := mgr.Add(xdsServer); err != nil {
+			return nil, err
+		}
+		options.ProxyClient = xdsServer
+	case offloader.IstioOffloaderType:
+		dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		options.DynamicClient = dynamicClient
+	case offloader.GatewayAPIOffloaderType:
+		gatewayClient, err := gatewayclientset.NewForConfig(kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		options.GatewayClient = gatewayClient
+		if gatewayAPI := cfg.Offloader.GatewayAPI; gatewayAPI != nil {
+			for _, parentRef := range gatewayAPI.ParentRefs {
+				ref := gatewayv1.ParentReference{Name: gatewayv1.ObjectName(*parentRef.Name)}
+				if parentRef.Namespace != nil {
+					ref.Namespace = (*gatewayv1.Namespace)(parentRef.Namespace)
+				}
+				if parentRef.SectionName != nil {
+					ref.SectionName = (*gatewayv1.SectionName)(parentRef.SectionName)
+				}
+				options.GatewayAPI.ParentRefs = append(options.GatewayAPI.ParentRefs, ref)
+			}
+			if serviceImport := gatewayAPI.RemoteServiceImport; serviceImport != nil {
+				options.GatewayAPI.RemoteServiceImport = &offloader.ServiceImportReference{
+					Name: *serviceImport.Name,
+					Port: int32(*serviceImport.Port),
+				}
+			}
+		}
+	}
+	return offloader.NewOffloader(ctx, offloaderType, clientset, options)
+}
+
+// newPodTemplateTransformer returns the transformer of the pod templates pushed to Beamlit with the settings of cfg,
+// reading the ConfigMaps and the Secrets with reader
+func newPodTemplateTransformer(cfg *config.PodTemplateConfig, reader ctrlclient.Reader) (*helper.PodTemplateTransformer, error) {
+	transformer := &helper.PodTemplateTransformer{Reader: reader}
+	if cfg == nil {
+		return transformer, nil
+	}
+	transformer.KeepFields = cfg.KeepFields
+	transformer.AllowedSecrets = cfg.AllowedSecrets
+	if err := transformer.Validate(); err != nil {
+		return nil, err
+	}
+	return transformer, nil
+}
+
+// setupTracing exports the spans to the OTLP collector of cfg, and returns the function exporting the remaining spans on shutdown
+// The trace context is propagated to Beamlit even when the spans are not exported
+func setupTracing(ctx context.Context, cfg *config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg == nil || cfg.Endpoint == nil || *cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(*cfg.Endpoint)}
+	if cfg.Insecure != nil && *cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "beamlit-controller")))
+	if err != nil {
+		return nil, err
+	}
+	samplingRatio := 1.0
+	if cfg.SamplingRatio != nil {
+		samplingRatio = *cfg.SamplingRatio
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
 }
@@ -0,0 +1,67 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/beamlit/beamlit-controller/internal/config"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/configurer"
+)
+
+func TestConfigurerType(t *testing.T) {
+	type testCase struct {
+		cfg  *config.Config
+		want configurer.ConfigurerType
+	}
+	testCases := map[string]testCase{
+		"When no offloader is configured, must default to the kubernetes configurer": {
+			cfg:  &config.Config{},
+			want: configurer.KubernetesConfigurerType,
+		},
+		"When the offloader is the Beamlit gateway, must default to the kubernetes configurer": {
+			cfg:  &config.Config{Offloader: &config.OffloaderConfig{Type: ptr(config.OffloaderTypeBeamlitGateway)}},
+			want: configurer.KubernetesConfigurerType,
+		},
+		"When the offloader is istio, must default to the noop configurer": {
+			cfg:  &config.Config{Offloader: &config.OffloaderConfig{Type: ptr(config.OffloaderTypeIstio)}},
+			want: configurer.NoopConfigurerType,
+		},
+		"When the offloader is gateway-api, must default to the noop configurer": {
+			cfg:  &config.Config{Offloader: &config.OffloaderConfig{Type: ptr(config.OffloaderTypeGatewayAPI)}},
+			want: configurer.NoopConfigurerType,
+		},
+		"When the configurer is set, must use it whatever the offloader": {
+			cfg: &config.Config{
+				Offloader:  &config.OffloaderConfig{Type: ptr(config.OffloaderTypeGatewayAPI)},
+				Configurer: &config.ConfigurerConfig{Type: ptr(config.ConfigurerTypeKubernetes)},
+			},
+			want: configurer.KubernetesConfigurerType,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := configurerType(tc.cfg); got != tc.want {
+				t.Errorf("want configurer %s but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"context"
 	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Router struct {
@@ -20,6 +22,7 @@ func NewRouter(ctx context.Context, apiAddr string, proxy Proxy) *Router {
 func (r *Router) Run(ctx context.Context) error {
 	mux := http.NewServeMux()
 	RegisterRoutesV1Alpha1(mux, r.proxy)
+	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
@@ -4,4 +4,5 @@ type Route struct {
 	Name      string    `json:"name" yaml:"name"`
 	Hostnames []string  `json:"hostnames" yaml:"hostnames"`
 	Backends  []Backend `json:"backends" yaml:"backends"`
+	Rules     []Rule    `json:"rules" yaml:"rules"`
 }
@@ -0,0 +1,19 @@
+package v1alpha1
+
+// Rule is enforced by the gateway on every request of a route, before it is proxied to a backend
+type Rule struct {
+	// Name identifies the rule in the gateway metrics, e.g. the policy it comes from
+	Name      string     `json:"name" yaml:"name"`
+	RateLimit *RateLimit `json:"rate_limit" yaml:"rate_limit"`
+	// AllowedCIDRs is the list of CIDRs the caller IP must belong to, all callers are allowed if empty
+	AllowedCIDRs    []string `json:"allowed_cidrs" yaml:"allowed_cidrs"`
+	RequiredHeaders []string `json:"required_headers" yaml:"required_headers"`
+}
+
+// RateLimit is a token bucket rate limit per consumer
+type RateLimit struct {
+	RequestsPerSecond int `json:"requests_per_second" yaml:"requests_per_second"`
+	Burst             int `json:"burst" yaml:"burst"`
+	// ConsumerHeader is the header identifying the consumer, the caller IP is used if empty
+	ConsumerHeader string `json:"consumer_header" yaml:"consumer_header"`
+}
@@ -15,7 +15,7 @@ import (
 )
 
 func (p *ProxyV1Alpha1) RewriteV1Alpha1(r *httputil.ProxyRequest) {
-	routeName, ok := p.routesPerHost.Load(extractHost(r.In.Host))
+	routeName, ok := p.routeForHost(r.In.Host)
 	if !ok {
 		r.Out.Response.Status = http.StatusText(http.StatusNotFound)
 		r.Out.Response.StatusCode = http.StatusNotFound
@@ -23,7 +23,7 @@ func (p *ProxyV1Alpha1) RewriteV1Alpha1(r *httputil.ProxyRequest) {
 		return
 	}
 	slog.Info("route name", "routeName", routeName)
-	route, err := p.persistenceV1Alpha1.GetRoute(r.In.Context(), routeName.(string))
+	route, err := p.persistenceV1Alpha1.GetRoute(r.In.Context(), routeName)
 	if err != nil {
 		r.Out.Response.Status = http.StatusText(http.StatusNotFound)
 		r.Out.Response.StatusCode = http.StatusNotFound
@@ -77,7 +77,7 @@ func handleBackend(r *http.Request, backend v1alpha1.Backend) error {
 
 func (p *ProxyV1Alpha1) ErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	slog.Info("error handler", "err", err)
-	routeName, ok := p.backendHostToRoute.Load(r.Host)
+	routeName, ok := p.routeForBackend(r)
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		if _, err := w.Write([]byte("not found")); err != nil {
@@ -86,7 +86,7 @@ func (p *ProxyV1Alpha1) ErrorHandler(w http.ResponseWriter, r *http.Request, err
 		return
 	}
 	for i := 0; i < 4; i++ {
-		err := p.errorHandler(w, r, routeName.(string))
+		err := p.errorHandler(w, r, routeName)
 		if err == nil {
 			return
 		}
@@ -165,12 +165,12 @@ func (p *ProxyV1Alpha1) ModifyResponse(r *http.Response) error {
 	}
 
 	// Contact another backend
-	routeName, ok := p.backendHostToRoute.Load(r.Request.Host)
+	routeName, ok := p.routeForBackend(r.Request)
 	if !ok {
 		r.StatusCode = http.StatusNotFound
 		return nil
 	}
-	route, err := p.persistenceV1Alpha1.GetRoute(context.TODO(), routeName.(string))
+	route, err := p.persistenceV1Alpha1.GetRoute(context.TODO(), routeName)
 	if err != nil {
 		r.StatusCode = http.StatusNotFound
 		return nil
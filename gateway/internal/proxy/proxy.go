@@ -4,7 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httputil"
-	"strings"
+	"reflect"
 	"sync"
 
 	"github.com/beamlit/beamlit-controller/gateway/api"
@@ -17,6 +17,7 @@ type ProxyV1Alpha1 struct {
 	proxy               *httputil.ReverseProxy
 	routesPerHost       sync.Map // key: host, value: []route name
 	backendHostToRoute  sync.Map // key: host, value: route name
+	rules               *ruleEnforcer
 }
 
 func New() api.Proxy {
@@ -24,6 +25,7 @@ func New() api.Proxy {
 		routesPerHost:       sync.Map{},
 		backendHostToRoute:  sync.Map{},
 		persistenceV1Alpha1: persistence.NewInMemV1Alpha1(),
+		rules:               &ruleEnforcer{},
 	}
 	v1alpha1Proxy.proxy = &httputil.ReverseProxy{
 		Rewrite:        v1alpha1Proxy.RewriteV1Alpha1,
@@ -34,15 +36,50 @@ func New() api.Proxy {
 }
 
 func (p *ProxyV1Alpha1) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if routeName, ok := p.routeForHost(r.Host); ok {
+		if route, err := p.persistenceV1Alpha1.GetRoute(r.Context(), routeName); err == nil {
+			if status, reason := p.rules.enforce(r, route); status != 0 {
+				http.Error(w, reason, status)
+				return
+			}
+		}
+	}
 	p.proxy.ServeHTTP(w, r)
 }
 
+// routeForHost returns the route of a Host header. A hostname registered with a port, routing one of the ports of a model, is matched
+// before the hostname without its port.
+func (p *ProxyV1Alpha1) routeForHost(host string) (string, bool) {
+	routeName, ok := p.routesPerHost.Load(host)
+	if !ok {
+		routeName, ok = p.routesPerHost.Load(extractHost(host))
+	}
+	if !ok {
+		return "", false
+	}
+	return routeName.(string), true
+}
+
+// routeForBackend returns the route of a request sent to a backend. The backends of the routes of the ports of a model share their
+// hostname, so the backend host with its port is matched first.
+func (p *ProxyV1Alpha1) routeForBackend(r *http.Request) (string, bool) {
+	routeName, ok := p.backendHostToRoute.Load(r.URL.Host)
+	if !ok {
+		routeName, ok = p.backendHostToRoute.Load(r.Host)
+	}
+	if !ok {
+		return "", false
+	}
+	return routeName.(string), true
+}
+
 func (p *ProxyV1Alpha1) RegisterRoute(ctx context.Context, route v1alpha1.Route) (v1alpha1.Route, error) {
 	for _, hostname := range route.Hostnames {
 		p.routesPerHost.Store(hostname, route.Name)
 	}
 	for _, b := range route.Backends {
-		p.backendHostToRoute.Store(strings.Split(b.Host, ":")[0], route.Name)
+		p.backendHostToRoute.Store(b.Host, route.Name)
+		p.backendHostToRoute.Store(extractHost(b.Host), route.Name)
 	}
 	return p.persistenceV1Alpha1.RegisterRoute(ctx, route)
 }
@@ -56,11 +93,17 @@ func (p *ProxyV1Alpha1) UpdateRoute(ctx context.Context, route v1alpha1.Route) (
 		p.routesPerHost.Store(hostname, route.Name)
 	}
 	for _, b := range route.Backends {
-		p.backendHostToRoute.Store(strings.Split(b.Host, ":")[0], route.Name)
+		p.backendHostToRoute.Store(b.Host, route.Name)
+		p.backendHostToRoute.Store(extractHost(b.Host), route.Name)
+	}
+	// The rate limiters of the consumers are kept while the rules of the route don't change
+	if current, err := p.persistenceV1Alpha1.GetRoute(ctx, route.Name); err != nil || !reflect.DeepEqual(current.Rules, route.Rules) {
+		p.rules.reset(route.Name)
 	}
 	return p.persistenceV1Alpha1.UpdateRoute(ctx, route)
 }
 
 func (p *ProxyV1Alpha1) DeleteRoute(ctx context.Context, name string) (v1alpha1.Route, error) {
+	p.rules.reset(name)
 	return p.persistenceV1Alpha1.DeleteRoute(ctx, name)
 }
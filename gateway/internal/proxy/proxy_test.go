@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
+)
+
+func Test_routeForHost(t *testing.T) {
+	p := New().(*ProxyV1Alpha1)
+	routes := []v1alpha1.Route{
+		{Name: "llama", Hostnames: []string{"llama", "llama:8080"}},
+		{Name: "llama-9000", Hostnames: []string{"llama:9000"}},
+	}
+	for _, route := range routes {
+		if _, err := p.RegisterRoute(context.Background(), route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		host   string
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "Host without a port must return the route of the hostname",
+			host:   "llama",
+			want:   "llama",
+			wantOk: true,
+		},
+		{
+			name:   "Host with a registered port must return the route of the port",
+			host:   "llama:9000",
+			want:   "llama-9000",
+			wantOk: true,
+		},
+		{
+			name:   "Host with another port must return the route of the hostname",
+			host:   "llama:8000",
+			want:   "llama",
+			wantOk: true,
+		},
+		{
+			name: "Unknown host must not return a route",
+			host: "mistral:9000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := p.routeForHost(tt.host)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("routeForHost() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_UpdateRouteKeepsRateLimiters(t *testing.T) {
+	p := New().(*ProxyV1Alpha1)
+	ctx := context.Background()
+	rules := []v1alpha1.Rule{{Name: "rate", RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 1}}}
+	route := v1alpha1.Route{Name: "llama", Hostnames: []string{"llama"}, Rules: rules}
+	if _, err := p.RegisterRoute(ctx, route); err != nil {
+		t.Fatal(err)
+	}
+	p.rules.limiter(route.Name, rules[0], "10.0.0.1")
+
+	route.Hostnames = append(route.Hostnames, "llama.default")
+	if _, err := p.UpdateRoute(ctx, route); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.rules.limiters.Load("llama/rate"); !ok {
+		t.Error("want the rate limiters kept when the rules of the route don't change")
+	}
+
+	route.Rules = []v1alpha1.Rule{{Name: "rate", RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 2}}}
+	if _, err := p.UpdateRoute(ctx, route); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.rules.limiters.Load("llama/rate"); ok {
+		t.Error("want the rate limiters reset when the rules of the route change")
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/utils/lru"
+
+	"github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
+)
+
+const (
+	ruleResultAllowed       = "allowed"
+	ruleResultRateLimited   = "rate_limited"
+	ruleResultForbidden     = "forbidden"
+	ruleResultMissingHeader = "missing_header"
+)
+
+var ruleEvaluations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "beamlit_gateway_rule_evaluations_total",
+	Help: "Number of requests evaluated against the rules of a route, by result",
+}, []string{"route", "rule", "result"})
+
+func init() {
+	prometheus.MustRegister(ruleEvaluations)
+}
+
+// defaultMaxConsumersPerRule is the number of consumers whose rate limiter is kept for a rule, when it is not set on the enforcer
+// The consumers are chosen by the callers, through their address or a header, so their number must be bounded
+const defaultMaxConsumersPerRule = 10000
+
+// ruleEnforcer enforces the rules of the routes, and keeps the rate limiters of their consumers
+type ruleEnforcer struct {
+	limiters sync.Map // key: route name/rule name, value: *consumerLimiters
+	// maxConsumersPerRule bounds the rate limiters kept for a rule, the least recently seen consumers are evicted first
+	maxConsumersPerRule int
+}
+
+// consumerLimiters are the rate limiters of the consumers of a rule
+type consumerLimiters struct {
+	mu    sync.Mutex
+	cache *lru.Cache // key: consumer, value: *rate.Limiter
+}
+
+// enforce evaluates the rules of route against r
+// It returns the status code and the reason of the rejection, or 0 if the request is allowed
+func (e *ruleEnforcer) enforce(r *http.Request, route v1alpha1.Route) (int, string) {
+	for _, rule := range route.Rules {
+		status, reason, result := e.evaluate(r, route.Name, rule)
+		ruleEvaluations.WithLabelValues(route.Name, rule.Name, result).Inc()
+		if status != 0 {
+			return status, reason
+		}
+	}
+	return 0, ""
+}
+
+func (e *ruleEnforcer) evaluate(r *http.Request, routeName string, rule v1alpha1.Rule) (int, string, string) {
+	for _, header := range rule.RequiredHeaders {
+		if r.Header.Get(header) == "" {
+			return http.StatusBadRequest, fmt.Sprintf("missing header %s", header), ruleResultMissingHeader
+		}
+	}
+	callerIP := extractCallerIP(r.RemoteAddr)
+	if len(rule.AllowedCIDRs) > 0 && !containsIP(rule.AllowedCIDRs, callerIP) {
+		return http.StatusForbidden, "forbidden", ruleResultForbidden
+	}
+	if rule.RateLimit != nil && rule.RateLimit.RequestsPerSecond > 0 {
+		consumer := callerIP.String()
+		if rule.RateLimit.ConsumerHeader != "" {
+			consumer = r.Header.Get(rule.RateLimit.ConsumerHeader)
+		}
+		if !e.limiter(routeName, rule, consumer).Allow() {
+			return http.StatusTooManyRequests, "too many requests", ruleResultRateLimited
+		}
+	}
+	return 0, "", ruleResultAllowed
+}
+
+func (e *ruleEnforcer) limiter(routeName string, rule v1alpha1.Rule, consumer string) *rate.Limiter {
+	key := fmt.Sprintf("%s/%s", routeName, rule.Name)
+	value, ok := e.limiters.Load(key)
+	if !ok {
+		maxConsumers := e.maxConsumersPerRule
+		if maxConsumers <= 0 {
+			maxConsumers = defaultMaxConsumersPerRule
+		}
+		value, _ = e.limiters.LoadOrStore(key, &consumerLimiters{cache: lru.New(maxConsumers)})
+	}
+	consumers := value.(*consumerLimiters)
+	consumers.mu.Lock()
+	defer consumers.mu.Unlock()
+	if limiter, ok := consumers.cache.Get(consumer); ok {
+		return limiter.(*rate.Limiter)
+	}
+	burst := rule.RateLimit.Burst
+	if burst <= 0 {
+		burst = rule.RateLimit.RequestsPerSecond
+	}
+	limiter := rate.NewLimiter(rate.Limit(rule.RateLimit.RequestsPerSecond), burst)
+	consumers.cache.Add(consumer, limiter)
+	return limiter
+}
+
+// reset removes the rate limiters of a route, it must be called when the rules of the route are updated or the route is deleted
+func (e *ruleEnforcer) reset(routeName string) {
+	e.limiters.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), routeName+"/") {
+			e.limiters.Delete(key)
+		}
+		return true
+	})
+}
+
+func extractCallerIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func containsIP(cidrs []string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			slog.Error("invalid CIDR in rule", "cidr", cidr, "error", err)
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
+)
+
+func Test_ruleEnforcer_enforce(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      []v1alpha1.Rule
+		remoteAddr string
+		headers    map[string]string
+		requests   int
+		want       int
+	}{
+		{
+			name:       "Request without rules must be allowed",
+			remoteAddr: "10.0.0.1:1234",
+			requests:   1,
+			want:       0,
+		},
+		{
+			name:       "Request from an allowed CIDR must be allowed",
+			rules:      []v1alpha1.Rule{{Name: "cidr", AllowedCIDRs: []string{"10.0.0.0/8"}}},
+			remoteAddr: "10.0.0.1:1234",
+			requests:   1,
+			want:       0,
+		},
+		{
+			name:       "Request from another CIDR must be forbidden",
+			rules:      []v1alpha1.Rule{{Name: "cidr", AllowedCIDRs: []string{"10.0.0.0/8"}}},
+			remoteAddr: "192.168.0.1:1234",
+			requests:   1,
+			want:       http.StatusForbidden,
+		},
+		{
+			name:       "Request without a required header must be rejected",
+			rules:      []v1alpha1.Rule{{Name: "headers", RequiredHeaders: []string{"X-Tenant"}}},
+			remoteAddr: "10.0.0.1:1234",
+			requests:   1,
+			want:       http.StatusBadRequest,
+		},
+		{
+			name:       "Request with the required headers must be allowed",
+			rules:      []v1alpha1.Rule{{Name: "headers", RequiredHeaders: []string{"X-Tenant"}}},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Tenant": "a"},
+			requests:   1,
+			want:       0,
+		},
+		{
+			name:       "Requests over the burst of a consumer must be rate limited",
+			rules:      []v1alpha1.Rule{{Name: "rate", RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 1, Burst: 2}}},
+			remoteAddr: "10.0.0.1:1234",
+			requests:   3,
+			want:       http.StatusTooManyRequests,
+		},
+		{
+			name:       "Requests within the burst of a consumer must be allowed",
+			rules:      []v1alpha1.Rule{{Name: "rate", RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 1, Burst: 2}}},
+			remoteAddr: "10.0.0.1:1234",
+			requests:   2,
+			want:       0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enforcer := &ruleEnforcer{}
+			route := v1alpha1.Route{Name: "route", Rules: tt.rules}
+			var got int
+			for i := 0; i < tt.requests; i++ {
+				r := httptest.NewRequest(http.MethodGet, "http://model/", nil)
+				r.RemoteAddr = tt.remoteAddr
+				for key, value := range tt.headers {
+					r.Header.Set(key, value)
+				}
+				got, _ = enforcer.enforce(r, route)
+			}
+			if got != tt.want {
+				t.Errorf("enforce() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ruleEnforcer_evictsConsumers(t *testing.T) {
+	enforcer := &ruleEnforcer{maxConsumersPerRule: 2}
+	route := v1alpha1.Route{Name: "route", Rules: []v1alpha1.Rule{
+		{Name: "rate", RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 1, Burst: 1, ConsumerHeader: "X-Tenant"}},
+	}}
+	send := func(tenant string) int {
+		r := httptest.NewRequest(http.MethodGet, "http://model/", nil)
+		r.Header.Set("X-Tenant", tenant)
+		status, _ := enforcer.enforce(r, route)
+		return status
+	}
+	for _, tenant := range []string{"a", "b", "c"} {
+		if got := send(tenant); got != 0 {
+			t.Fatalf("enforce() = %v for the first request of %s, want 0", got, tenant)
+		}
+	}
+	value, _ := enforcer.limiters.Load("route/rate")
+	if got := value.(*consumerLimiters).cache.Len(); got != 2 {
+		t.Errorf("want 2 consumers kept but got %d", got)
+	}
+	if got := send("c"); got != http.StatusTooManyRequests {
+		t.Errorf("enforce() = %v for a recent consumer over its burst, want %v", got, http.StatusTooManyRequests)
+	}
+	if got := send("a"); got != 0 {
+		t.Errorf("enforce() = %v for an evicted consumer, want 0", got)
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"net"
 	"strings"
 
 	"github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
@@ -44,6 +45,14 @@ func handleOAuth(ctx context.Context, auth *v1alpha1.Auth) (*oauth2.Token, error
 	return token, nil
 }
 
+// extractHost returns the host of a host header without its port, an IPv6 address staying within brackets
 func extractHost(h string) string {
-	return strings.Split(h, ":")[0]
+	host, _, err := net.SplitHostPort(h)
+	if err != nil {
+		return h
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
 }
@@ -18,6 +18,16 @@ func Test_extractHost(t *testing.T) {
 			host: "example.com",
 			want: "example.com",
 		},
+		{
+			name: "IPv6 address with a port must return the address in brackets",
+			host: "[fd00::1]:8080",
+			want: "[fd00::1]",
+		},
+		{
+			name: "IPv6 address without a port must return the address in brackets",
+			host: "[fd00::1]",
+			want: "[fd00::1]",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
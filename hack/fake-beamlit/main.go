@@ -0,0 +1,46 @@
+// fake-beamlit serves a fake Beamlit API from memory, to run the controller locally without network access.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+func main() {
+	var addr string
+	var workspace string
+	var latency time.Duration
+	var offloadEvents bool
+	var secrets bool
+	flag.StringVar(&addr, "addr", "127.0.0.1:8090", "The address the fake Beamlit API listens on.")
+	flag.StringVar(&workspace, "workspace", beamlittest.DefaultWorkspace, "The workspace of the resources served by the fake Beamlit API.")
+	flag.DurationVar(&latency, "latency", 0, "The delay of every response of the fake Beamlit API.")
+	flag.BoolVar(&offloadEvents, "offload-events", false, "Serve the events endpoint of models. Without it, the controller reports offloading with the offloading label of models.")
+	flag.BoolVar(&secrets, "secrets", false, "Serve the secrets endpoint of the workspace. Without it, the controller does not sync Secrets and ConfigMaps to Beamlit.")
+	flag.Parse()
+
+	opts := []beamlittest.Option{beamlittest.WithWorkspace(workspace), beamlittest.WithLatency(latency)}
+	if offloadEvents {
+		opts = append(opts, beamlittest.WithOffloadEvents())
+	}
+	if secrets {
+		opts = append(opts, beamlittest.WithSecrets())
+	}
+	handler := beamlittest.NewHandler(opts...)
+	fmt.Printf("export BEAMLIT_BASE_URL=http://%s\n", addr)
+	fmt.Printf("export BEAMLIT_TOKEN=%s\n", handler.Token())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "fake Beamlit API stopped: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -36,33 +36,55 @@ func NewBeamlitToken() (*BeamlitToken, error) {
 	}, nil
 }
 
+// newBeamlitTokenFromCredentials creates a new BeamlitToken from a Beamlit token and the base URL of the Beamlit API.
+func newBeamlitTokenFromCredentials(beamlitToken string, baseURL string) (*BeamlitToken, error) {
+	clientID, clientSecret, err := parseToken(beamlitToken)
+	if err != nil {
+		return nil, err
+	}
+	return &BeamlitToken{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		baseURL:      baseURL,
+	}, nil
+}
+
 // retrieveInfoFromEnv retrieves the clientID, clientSecret, and baseURL from the environment variables.
 // It returns the clientID, clientSecret, and baseURL as strings.
 // It returns no error if the environment variables are not set.
 // It returns an error if the Beamlit token is not base64 encoded, or if the token is not in the format clientID:clientSecret
 func retrieveInfoFromEnv() (string, string, string, error) {
 	var baseURL string
-	var beamlitToken string
 
 	if baseURL = os.Getenv(envBaseURL); baseURL == "" {
 		baseURL = defaultBaseURL
 	}
 
-	if beamlitToken = os.Getenv(envToken); beamlitToken == "" {
-		return "", "", "", nil
+	clientID, clientSecret, err := parseToken(os.Getenv(envToken))
+	if err != nil {
+		return "", "", "", err
+	}
+	return clientID, clientSecret, baseURL, nil
+}
+
+// parseToken returns the clientID and clientSecret of a Beamlit token.
+// It returns no error if the token is empty.
+func parseToken(beamlitToken string) (string, string, error) {
+	if beamlitToken == "" {
+		return "", "", nil
 	}
 
 	decodedToken, err := base64.StdEncoding.DecodeString(beamlitToken)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to decode Beamlit token: %w", err)
+		return "", "", fmt.Errorf("failed to decode Beamlit token: %w", err)
 	}
 
 	splitToken := strings.Split(string(decodedToken), ":")
 	if len(splitToken) != 2 {
-		return "", "", "", fmt.Errorf("invalid Beamlit token format")
+		return "", "", fmt.Errorf("invalid Beamlit token format")
 	}
 
-	return splitToken[0], splitToken[1], baseURL, nil
+	return splitToken[0], splitToken[1], nil
 }
 
 // client is set private to prevent users from using it directly.
@@ -0,0 +1,617 @@
+// Package beamlittest provides a fake Beamlit API serving models and policies from memory,
+// to run the controller and its tests without network access.
+package beamlittest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	beamlit "github.com/beamlit/toolkit/sdk"
+)
+
+const (
+	// DefaultWorkspace is the workspace of the resources served by the fake API
+	DefaultWorkspace = "beamlittest"
+	// DefaultClientID is the client ID accepted by the token endpoint of the fake API
+	DefaultClientID = "beamlittest"
+	// DefaultClientSecret is the client secret accepted by the token endpoint of the fake API
+	DefaultClientSecret = "beamlittest"
+
+	accessTokenTTL = time.Hour
+)
+
+// Server is a fake Beamlit API listening on a local address, to be pointed at with beamlit.NewClientWithCredentials(server.URL, server.Token()).
+type Server struct {
+	*httptest.Server
+	*Handler
+}
+
+// NewServer starts a fake Beamlit API. The caller must call Close when done.
+func NewServer(opts ...Option) *Server {
+	handler := NewHandler(opts...)
+	return &Server{
+		Server:  httptest.NewServer(handler),
+		Handler: handler,
+	}
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithWorkspace sets the workspace of the resources served by the fake API.
+func WithWorkspace(workspace string) Option {
+	return func(h *Handler) {
+		h.workspace = workspace
+	}
+}
+
+// WithCredentials sets the client credentials accepted by the token endpoint of the fake API.
+func WithCredentials(clientID string, clientSecret string) Option {
+	return func(h *Handler) {
+		h.clientID = clientID
+		h.clientSecret = clientSecret
+	}
+}
+
+// WithOffloadEvents exposes the events endpoint of models, which is not served by default.
+func WithOffloadEvents() Option {
+	return func(h *Handler) {
+		h.offloadEvents = map[string][]map[string]any{}
+	}
+}
+
+// WithSecrets exposes the secrets endpoint of the workspace, which is not served by default.
+func WithSecrets() Option {
+	return func(h *Handler) {
+		h.secrets = map[string]string{}
+	}
+}
+
+// WithoutETags serves the models without ETag, their updates are not conditional.
+func WithoutETags() Option {
+	return func(h *Handler) {
+		h.withoutETags = true
+	}
+}
+
+// WithLatency delays every response of the fake API.
+func WithLatency(latency time.Duration) Option {
+	return func(h *Handler) {
+		h.latency = latency
+	}
+}
+
+// Fault makes the fake API fail the requests it matches instead of processing them.
+type Fault struct {
+	// Method is the method of the failed requests, empty to match any method
+	Method string
+	// Path is the prefix of the path of the failed requests, empty to match any path
+	Path string
+	// StatusCode is the status of the failed responses
+	StatusCode int
+	// Body is the body of the failed responses
+	Body string
+	// Header is added to the headers of the failed responses, e.g. Retry-After
+	Header http.Header
+	// Times is the number of requests failed before the fault is removed, 0 to fail requests until ClearFaults is called
+	Times int
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	return (f.Method == "" || f.Method == r.Method) && strings.HasPrefix(r.URL.Path, f.Path)
+}
+
+// Request is a request received by the fake API.
+type Request struct {
+	Method string
+	Path   string
+	// Environment is the environment query parameter of the request
+	Environment string
+}
+
+type storedModel struct {
+	model   beamlit.Model
+	version int
+}
+
+// Handler serves the fake Beamlit API. Its state can be read and changed by tests while it serves requests.
+type Handler struct {
+	workspace    string
+	clientID     string
+	clientSecret string
+
+	mu          sync.Mutex
+	latency     time.Duration
+	accessToken string
+	models      map[string]*storedModel // key: environment/name
+	policies    map[string]beamlit.Policy
+	// offloadEvents are the events reported on models, nil if the events endpoint is not served
+	offloadEvents map[string][]map[string]any // key: environment/name
+	// secrets are the values of the secrets of the workspace, nil if the secrets endpoint is not served
+	secrets  map[string]string
+	faults   []*Fault
+	requests []Request
+	version  int
+	// withoutETags serves the models without ETag
+	withoutETags bool
+}
+
+// NewHandler creates the handler of a fake Beamlit API, to be served on any listener.
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{
+		workspace:    DefaultWorkspace,
+		clientID:     DefaultClientID,
+		clientSecret: DefaultClientSecret,
+		models:       map[string]*storedModel{},
+		policies:     map[string]beamlit.Policy{},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.accessToken = base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", h.clientID, time.Now().UnixNano())))
+	return h
+}
+
+// Token returns the Beamlit token accepted by the fake API, i.e. the base64 encoded client credentials.
+func (h *Handler) Token() string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", h.clientID, h.clientSecret)))
+}
+
+// SetLatency changes the delay of every response of the fake API.
+func (h *Handler) SetLatency(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latency = latency
+}
+
+// InjectFault makes the fake API fail the requests matching fault.
+// Faults are matched in the order they were injected.
+func (h *Handler) InjectFault(fault Fault) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.faults = append(h.faults, &fault)
+}
+
+// ClearFaults removes every fault injected in the fake API.
+func (h *Handler) ClearFaults() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.faults = nil
+}
+
+// Requests returns the requests received by the fake API, in order.
+func (h *Handler) Requests() []Request {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Request(nil), h.requests...)
+}
+
+// Model returns a model stored in the fake API.
+func (h *Handler) Model(environment string, name string) (beamlit.Model, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stored, ok := h.models[modelKey(environment, name)]
+	if !ok {
+		return beamlit.Model{}, false
+	}
+	return copyModel(stored.model), true
+}
+
+// SetModel stores a model in the fake API, as if it was changed on Beamlit outside of the controller.
+// The model must have a name and an environment.
+func (h *Handler) SetModel(model beamlit.Model) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.storeModel(copyModel(model), time.Now())
+}
+
+// RemoveModel removes a model from the fake API, as if it was deleted on Beamlit outside of the controller.
+func (h *Handler) RemoveModel(environment string, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.models, modelKey(environment, name))
+}
+
+// OffloadEvents returns the events reported on a model, when the fake API serves the events endpoint.
+func (h *Handler) OffloadEvents(environment string, name string) []map[string]any {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]map[string]any(nil), h.offloadEvents[modelKey(environment, name)]...)
+}
+
+// Secret returns the value of a secret of the workspace, when the fake API serves the secrets endpoint.
+func (h *Handler) Secret(name string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.secrets[name]
+	return value, ok
+}
+
+// Policy returns a policy stored in the fake API.
+func (h *Handler) Policy(name string) (beamlit.Policy, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	policy, ok := h.policies[name]
+	return copyPolicy(policy), ok
+}
+
+// SetPolicy stores a policy in the fake API, as if it was changed on Beamlit outside of the controller.
+// The policy must have a name.
+func (h *Handler) SetPolicy(policy beamlit.Policy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.storePolicy(copyPolicy(policy), time.Now())
+}
+
+// RemovePolicy removes a policy from the fake API, as if it was deleted on Beamlit outside of the controller.
+func (h *Handler) RemovePolicy(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.policies, name)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	latency := h.latency
+	h.requests = append(h.requests, Request{
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Environment: r.URL.Query().Get("environment"),
+	})
+	h.mu.Unlock()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if fault := h.popFault(r); fault != nil {
+		for key, values := range fault.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(fault.StatusCode)
+		_, _ = w.Write([]byte(fault.Body))
+		return
+	}
+	if r.URL.Path == "/oauth/token" {
+		h.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+h.accessToken {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+	resource, name, _ := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+	switch resource {
+	case "models":
+		if name, ok := strings.CutSuffix(name, "/events"); ok {
+			h.serveOffloadEvents(w, r, name)
+			return
+		}
+		h.serveModels(w, r, name)
+	case "policies":
+		h.servePolicies(w, r, name)
+	case "secrets":
+		h.serveSecrets(w, r, name)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s not found", r.URL.Path))
+	}
+}
+
+// popFault returns the first fault matching r, and removes it once it failed the requested number of requests.
+func (h *Handler) popFault(r *http.Request) *Fault {
+	for i, fault := range h.faults {
+		if !fault.matches(r) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				h.faults = append(h.faults[:i], h.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+func (h *Handler) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != h.clientID || clientSecret != h.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": h.accessToken,
+		"token_type":   "bearer",
+		"expires_in":   int(accessTokenTTL.Seconds()),
+	})
+}
+
+func (h *Handler) serveModels(w http.ResponseWriter, r *http.Request, name string) {
+	environment := r.URL.Query().Get("environment")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		models := []beamlit.Model{}
+		for _, stored := range h.models {
+			if environment == "" || *stored.model.Metadata.Environment == environment {
+				models = append(models, stored.model)
+			}
+		}
+		sort.Slice(models, func(i, j int) bool {
+			return modelKey(*models[i].Metadata.Environment, *models[i].Metadata.Name) < modelKey(*models[j].Metadata.Environment, *models[j].Metadata.Name)
+		})
+		writeJSON(w, http.StatusOK, models)
+	case name == "" && r.Method == http.MethodPost:
+		model, ok := decodeModel(w, r)
+		if !ok {
+			return
+		}
+		key := modelKey(*model.Metadata.Environment, *model.Metadata.Name)
+		if _, exists := h.models[key]; exists {
+			writeError(w, http.StatusConflict, fmt.Sprintf("model %s already exists", key))
+			return
+		}
+		h.writeModel(w, h.storeModel(model, time.Now()))
+	case name != "" && r.Method == http.MethodGet:
+		stored, ok := h.models[modelKey(environment, name)]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("model %s not found", name))
+			return
+		}
+		h.writeModel(w, stored)
+	case name != "" && r.Method == http.MethodPut:
+		model, ok := decodeModel(w, r)
+		if !ok {
+			return
+		}
+		model.Metadata.Name = &name
+		stored, exists := h.models[modelKey(*model.Metadata.Environment, name)]
+		if !exists {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("model %s not found", name))
+			return
+		}
+		if etag := r.Header.Get("If-Match"); etag != "" && etag != strconv.Itoa(stored.version) {
+			writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("model %s has changed", name))
+			return
+		}
+		model.Metadata.CreatedAt = stored.model.Metadata.CreatedAt
+		h.writeModel(w, h.storeModel(model, time.Now()))
+	case name != "" && r.Method == http.MethodDelete:
+		key := modelKey(environment, name)
+		stored, ok := h.models[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("model %s not found", name))
+			return
+		}
+		delete(h.models, key)
+		writeJSON(w, http.StatusOK, stored.model)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) serveOffloadEvents(w http.ResponseWriter, r *http.Request, name string) {
+	if h.offloadEvents == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s not found", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	key := modelKey(r.URL.Query().Get("environment"), name)
+	if _, ok := h.models[key]; !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("model %s not found", name))
+		return
+	}
+	var body struct {
+		Events []map[string]any `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid events: %s", err))
+		return
+	}
+	h.offloadEvents[key] = append(h.offloadEvents[key], body.Events...)
+	writeJSON(w, http.StatusOK, map[string]int{"accepted": len(body.Events)})
+}
+
+func (h *Handler) serveSecrets(w http.ResponseWriter, r *http.Request, name string) {
+	if h.secrets == nil || name == "" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s not found", r.URL.Path))
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid secret: %s", err))
+			return
+		}
+		h.secrets[name] = body.Value
+		writeJSON(w, http.StatusOK, map[string]string{"name": name})
+	case http.MethodDelete:
+		if _, ok := h.secrets[name]; !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("secret %s not found", name))
+			return
+		}
+		delete(h.secrets, name)
+		writeJSON(w, http.StatusOK, map[string]string{"name": name})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) servePolicies(w http.ResponseWriter, r *http.Request, name string) {
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		policies := []beamlit.Policy{}
+		for _, policy := range h.policies {
+			policies = append(policies, policy)
+		}
+		sort.Slice(policies, func(i, j int) bool {
+			return *policies[i].Metadata.Name < *policies[j].Metadata.Name
+		})
+		writeJSON(w, http.StatusOK, policies)
+	case name == "" && r.Method == http.MethodPost:
+		policy, ok := decodePolicy(w, r)
+		if !ok {
+			return
+		}
+		if _, exists := h.policies[*policy.Metadata.Name]; exists {
+			writeError(w, http.StatusConflict, fmt.Sprintf("policy %s already exists", *policy.Metadata.Name))
+			return
+		}
+		writeJSON(w, http.StatusOK, h.storePolicy(policy, time.Now()))
+	case name != "" && r.Method == http.MethodGet:
+		policy, ok := h.policies[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("policy %s not found", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, policy)
+	case name != "" && r.Method == http.MethodPut:
+		policy, ok := decodePolicy(w, r)
+		if !ok {
+			return
+		}
+		policy.Metadata.Name = &name
+		existing, exists := h.policies[name]
+		if !exists {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("policy %s not found", name))
+			return
+		}
+		policy.Metadata.CreatedAt = existing.Metadata.CreatedAt
+		writeJSON(w, http.StatusOK, h.storePolicy(policy, time.Now()))
+	case name != "" && r.Method == http.MethodDelete:
+		policy, ok := h.policies[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("policy %s not found", name))
+			return
+		}
+		delete(h.policies, name)
+		writeJSON(w, http.StatusOK, policy)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// storeModel stores model with a new version, filling the metadata set by Beamlit.
+// The update time has a precision of a nanosecond, for the changes of the same second to be told apart.
+func (h *Handler) storeModel(model beamlit.Model, now time.Time) *storedModel {
+	timestamp := now.UTC().Format(time.RFC3339Nano)
+	if model.Metadata.CreatedAt == nil {
+		model.Metadata.CreatedAt = &timestamp
+	}
+	model.Metadata.UpdatedAt = &timestamp
+	model.Metadata.Workspace = &h.workspace
+	h.version++
+	stored := &storedModel{model: model, version: h.version}
+	h.models[modelKey(*model.Metadata.Environment, *model.Metadata.Name)] = stored
+	return stored
+}
+
+// storePolicy stores policy, filling the metadata set by Beamlit.
+func (h *Handler) storePolicy(policy beamlit.Policy, now time.Time) beamlit.Policy {
+	timestamp := now.UTC().Format(time.RFC3339)
+	if policy.Metadata.CreatedAt == nil {
+		policy.Metadata.CreatedAt = &timestamp
+	}
+	policy.Metadata.UpdatedAt = &timestamp
+	policy.Metadata.Workspace = &h.workspace
+	h.policies[*policy.Metadata.Name] = policy
+	return policy
+}
+
+func (h *Handler) writeModel(w http.ResponseWriter, stored *storedModel) {
+	if !h.withoutETags {
+		w.Header().Set("ETag", strconv.Itoa(stored.version))
+	}
+	writeJSON(w, http.StatusOK, stored.model)
+}
+
+func decodeModel(w http.ResponseWriter, r *http.Request) (beamlit.Model, bool) {
+	var model beamlit.Model
+	if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid model: %s", err))
+		return model, false
+	}
+	var fields []map[string]string
+	if model.Metadata == nil || model.Metadata.Name == nil || *model.Metadata.Name == "" {
+		fields = append(fields, map[string]string{"field": "metadata.name", "message": "is required"})
+	}
+	if model.Metadata == nil || model.Metadata.Environment == nil || *model.Metadata.Environment == "" {
+		fields = append(fields, map[string]string{"field": "metadata.environment", "message": "is required"})
+	}
+	if len(fields) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid model", "errors": fields})
+		return model, false
+	}
+	return model, true
+}
+
+func decodePolicy(w http.ResponseWriter, r *http.Request) (beamlit.Policy, bool) {
+	var policy beamlit.Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid policy: %s", err))
+		return policy, false
+	}
+	if policy.Metadata == nil || policy.Metadata.Name == nil || *policy.Metadata.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error":  "invalid policy",
+			"errors": []map[string]string{{"field": "metadata.name", "message": "is required"}},
+		})
+		return policy, false
+	}
+	return policy, true
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func modelKey(environment string, name string) string {
+	return fmt.Sprintf("%s/%s", environment, name)
+}
+
+// copyModel returns a deep copy of model, so it is not changed by the caller once stored or returned.
+func copyModel(model beamlit.Model) beamlit.Model {
+	var copied beamlit.Model
+	data, _ := json.Marshal(model)
+	_ = json.Unmarshal(data, &copied)
+	return copied
+}
+
+// copyPolicy returns a deep copy of policy, so it is not changed by the caller once stored or returned.
+func copyPolicy(policy beamlit.Policy) beamlit.Policy {
+	var copied beamlit.Policy
+	data, _ := json.Marshal(policy)
+	_ = json.Unmarshal(data, &copied)
+	return copied
+}
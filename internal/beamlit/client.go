@@ -1,10 +1,21 @@
 package beamlit
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"sync"
+	"sync/atomic"
 
 	beamlit "github.com/beamlit/toolkit/sdk"
+	"golang.org/x/oauth2"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const (
@@ -14,27 +25,142 @@ const (
 )
 
 type Client struct {
-	baseURL string
-	client  *beamlit.Client
+	client     atomic.Pointer[beamlit.Client]
+	policy     *RequestPolicy
+	modelLocks sync.Map // key: environment/name, value: *sync.Mutex
 }
 
-func NewClient() (*Client, error) {
-	beamlitToken, err := NewBeamlitToken()
-	if err != nil {
+// Option configures a Client.
+type Option func(*Client)
+
+// WithRequestPolicy sets the retries and the rate limit of the requests of the client.
+// Clients created with the same policy share its rate limit.
+func WithRequestPolicy(policy *RequestPolicy) Option {
+	return func(c *Client) {
+		c.policy = policy
+	}
+}
+
+// NewClient creates a new Client from the BEAMLIT_TOKEN and BEAMLIT_BASE_URL environment variables.
+func NewClient(opts ...Option) (*Client, error) {
+	return NewClientWithCredentials(os.Getenv(envBaseURL), os.Getenv(envToken), opts...)
+}
+
+// NewClientWithCredentials creates a new Client from a Beamlit token and the base URL of the Beamlit API.
+// The default base URL is used if baseURL is empty.
+func NewClientWithCredentials(baseURL string, token string, opts ...Option) (*Client, error) {
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.policy == nil {
+		c.policy = DefaultRequestPolicy()
+	}
+	if err := c.SetCredentials(baseURL, token); err != nil {
 		return nil, err
 	}
+	return c, nil
+}
 
-	baseURL := os.Getenv(envBaseURL)
+// SetCredentials swaps atomically the credentials used by the client.
+// Requests in flight complete with the previous credentials.
+func (c *Client) SetCredentials(baseURL string, token string) error {
 	if baseURL == "" {
 		baseURL = defaultBaseURL
 	}
+	beamlitToken, err := newBeamlitTokenFromCredentials(token, baseURL)
+	if err != nil {
+		return err
+	}
+	client, err := beamlit.NewClient(baseURL, "", beamlit.WithHTTPClient(&retryDoer{
+		doer: &authErrorDoer{
+			client: beamlitToken.client(instrumentedContext(context.Background())),
+		},
+		policy: c.policy,
+	}))
+	if err != nil {
+		return err
+	}
+	c.client.Store(client)
+	return nil
+}
+
+// api returns the Beamlit API client configured with the current credentials.
+func (c *Client) api() *beamlit.Client {
+	return c.client.Load()
+}
 
-	client, err := beamlit.NewClient(baseURL, "", beamlit.WithHTTPClient(beamlitToken.client(context.Background())))
+// do sends a request to an endpoint of the Beamlit API not covered by the SDK, with body encoded in JSON if not nil.
+// path is relative to the base URL of the API, e.g. "./models/name/events".
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any) (*http.Response, error) {
+	api := c.api()
+	serverURL, err := url.Parse(api.Server)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{
-		baseURL: baseURL,
-		client:  client,
-	}, nil
+	endpointURL, err := serverURL.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	endpointURL.RawQuery = query.Encode()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpointURL.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return api.Client.Do(req)
+}
+
+// AuthError is returned when a request to Beamlit fails because of the credentials of the client.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("failed to authenticate on Beamlit: %s", e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// IsAuthError returns true if err is caused by the credentials of the client.
+func IsAuthError(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr)
+}
+
+// authErrorDoer returns an AuthError when the token cannot be retrieved, or when Beamlit rejects the credentials.
+type authErrorDoer struct {
+	client *http.Client
+}
+
+func (d *authErrorDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) {
+			return nil, &AuthError{Err: err}
+		}
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.FromContext(req.Context()).Error(err, "failed to close response body")
+			}
+		}()
+		return nil, newAPIError(fmt.Sprintf("call %s %s", req.Method, req.URL.Path), resp)
+	}
+	return resp, nil
 }
@@ -0,0 +1,169 @@
+package beamlit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is returned when Beamlit answers a request with an error status.
+// Errors with a well-known status are returned as ErrNotFound, ErrConflict, ErrUnauthorized, ErrRateLimited or ErrValidation,
+// which all unwrap to an APIError.
+type APIError struct {
+	// Operation is the operation which failed, e.g. "update Policy"
+	Operation string
+	// StatusCode is the status code of the response
+	StatusCode int
+	// Body is the body of the response
+	Body string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("failed to %s, status code: %d, body: %s", e.Operation, e.StatusCode, e.Body)
+}
+
+// ErrNotFound is returned when the resource does not exist on Beamlit.
+type ErrNotFound struct {
+	APIError
+}
+
+func (e *ErrNotFound) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrConflict is returned when the request conflicts with the current state of the resource on Beamlit.
+type ErrConflict struct {
+	APIError
+}
+
+func (e *ErrConflict) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrUnauthorized is returned when Beamlit rejects the credentials of the client.
+// It is always wrapped in an AuthError.
+type ErrUnauthorized struct {
+	APIError
+}
+
+func (e *ErrUnauthorized) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrRateLimited is returned when Beamlit rate limits the client, once the retries of the client are exhausted.
+type ErrRateLimited struct {
+	APIError
+	// RetryAfter is the delay requested by Beamlit before the next request, 0 if unknown
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrValidation is returned when Beamlit rejects the resource sent by the client.
+// Sending the same resource again fails the same way.
+type ErrValidation struct {
+	APIError
+	// Fields are the details of the invalid fields, when Beamlit reports them
+	Fields []FieldError
+}
+
+func (e *ErrValidation) Unwrap() error {
+	return &e.APIError
+}
+
+func (e *ErrValidation) Error() string {
+	if len(e.Fields) == 0 {
+		return e.APIError.Error()
+	}
+	fields := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		fields = append(fields, field.String())
+	}
+	return fmt.Sprintf("failed to %s, invalid fields: %s", e.Operation, strings.Join(fields, ", "))
+}
+
+// FieldError is the detail of an invalid field reported by Beamlit.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (f FieldError) String() string {
+	if f.Field == "" {
+		return f.Message
+	}
+	return fmt.Sprintf("%s: %s", f.Field, f.Message)
+}
+
+// newAPIError reads the body of resp and returns the error matching its status.
+func newAPIError(operation string, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	apiErr := APIError{
+		Operation:  operation,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return &ErrNotFound{APIError: apiErr}
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return &ErrConflict{APIError: apiErr}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &AuthError{Err: &ErrUnauthorized{APIError: apiErr}}
+	case http.StatusTooManyRequests:
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return &ErrRateLimited{APIError: apiErr, RetryAfter: retryAfter}
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return &ErrValidation{APIError: apiErr, Fields: parseFieldErrors(body)}
+	}
+	return &apiErr
+}
+
+// parseFieldErrors returns the invalid fields listed in an error body, under an "errors", "fields" or "details" key.
+// Each item is either a message, or an object with the field in "field", "path" or "name", and the message in "message" or "error".
+func parseFieldErrors(body []byte) []FieldError {
+	var content map[string]json.RawMessage
+	if err := json.Unmarshal(body, &content); err != nil {
+		return nil
+	}
+	var fieldErrors []FieldError
+	for _, key := range []string{"errors", "fields", "details"} {
+		var items []json.RawMessage
+		if err := json.Unmarshal(content[key], &items); err != nil {
+			continue
+		}
+		for _, item := range items {
+			var message string
+			if err := json.Unmarshal(item, &message); err == nil {
+				fieldErrors = append(fieldErrors, FieldError{Message: message})
+				continue
+			}
+			var detail map[string]string
+			if err := json.Unmarshal(item, &detail); err != nil {
+				continue
+			}
+			fieldError := FieldError{
+				Field:   firstNonEmpty(detail["field"], detail["path"], detail["name"]),
+				Message: firstNonEmpty(detail["message"], detail["error"]),
+			}
+			if fieldError != (FieldError{}) {
+				fieldErrors = append(fieldErrors, fieldError)
+			}
+		}
+	}
+	return fieldErrors
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
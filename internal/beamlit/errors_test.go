@@ -0,0 +1,102 @@
+package beamlit
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAPIError(t *testing.T) {
+	type testCase struct {
+		statusCode int
+		header     http.Header
+		body       string
+		check      func(t *testing.T, err error)
+	}
+	tcs := map[string]testCase{
+		"When the status is 404, must return an ErrNotFound": {
+			statusCode: http.StatusNotFound,
+			check: func(t *testing.T, err error) {
+				var notFoundErr *ErrNotFound
+				if !errors.As(err, &notFoundErr) {
+					t.Errorf("want ErrNotFound but got %T", err)
+				}
+			},
+		},
+		"When the status is 409, must return an ErrConflict": {
+			statusCode: http.StatusConflict,
+			check: func(t *testing.T, err error) {
+				var conflictErr *ErrConflict
+				if !errors.As(err, &conflictErr) {
+					t.Errorf("want ErrConflict but got %T", err)
+				}
+			},
+		},
+		"When the status is 401, must return an ErrUnauthorized wrapped in an AuthError": {
+			statusCode: http.StatusUnauthorized,
+			check: func(t *testing.T, err error) {
+				var unauthorizedErr *ErrUnauthorized
+				if !errors.As(err, &unauthorizedErr) || !IsAuthError(err) {
+					t.Errorf("want ErrUnauthorized in an AuthError but got %T", err)
+				}
+			},
+		},
+		"When the status is 429, must return an ErrRateLimited with the Retry-After delay": {
+			statusCode: http.StatusTooManyRequests,
+			header:     http.Header{"Retry-After": []string{"7"}},
+			check: func(t *testing.T, err error) {
+				var rateLimitedErr *ErrRateLimited
+				if !errors.As(err, &rateLimitedErr) {
+					t.Fatalf("want ErrRateLimited but got %T", err)
+				}
+				if rateLimitedErr.RetryAfter != 7*time.Second {
+					t.Errorf("want RetryAfter 7s but got %s", rateLimitedErr.RetryAfter)
+				}
+			},
+		},
+		"When the status is 400, must return an ErrValidation with the field details": {
+			statusCode: http.StatusBadRequest,
+			body:       `{"error":"invalid model","errors":[{"field":"spec.flavors","message":"unknown flavor"},"name is too long"]}`,
+			check: func(t *testing.T, err error) {
+				var validationErr *ErrValidation
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("want ErrValidation but got %T", err)
+				}
+				want := []FieldError{{Field: "spec.flavors", Message: "unknown flavor"}, {Message: "name is too long"}}
+				if !reflect.DeepEqual(validationErr.Fields, want) {
+					t.Errorf("want fields %v but got %v", want, validationErr.Fields)
+				}
+			},
+		},
+		"When the status is 500, must return an APIError with the body": {
+			statusCode: http.StatusInternalServerError,
+			body:       "internal error",
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("want APIError but got %T", err)
+				}
+				if apiErr.StatusCode != http.StatusInternalServerError || apiErr.Body != "internal error" {
+					t.Errorf("unexpected APIError %v", apiErr)
+				}
+			},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tc.statusCode,
+				Header:     tc.header,
+				Body:       io.NopCloser(strings.NewReader(tc.body)),
+			}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			tc.check(t, newAPIError("update Model", resp))
+		})
+	}
+}
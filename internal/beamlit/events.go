@@ -0,0 +1,88 @@
+package beamlit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// OffloadTrigger is the cause of an offloading transition
+type OffloadTrigger string
+
+const (
+	// OffloadTriggerMetric is set when the offloading metrics of the model reached, or no more reached, their target
+	OffloadTriggerMetric OffloadTrigger = "metric"
+	// OffloadTriggerHealth is set when the model became unhealthy, or healthy again
+	OffloadTriggerHealth OffloadTrigger = "health"
+	// OffloadTriggerManual is set when the offloading was changed by an update of the ModelDeployment
+	OffloadTriggerManual OffloadTrigger = "manual"
+)
+
+// OffloadEvent is an offloading transition of a model, reported on Beamlit
+type OffloadEvent struct {
+	// Timestamp is the time of the transition
+	Timestamp time.Time `json:"timestamp"`
+	// Offloading is true if some traffic of the model is offloaded on Beamlit after the transition
+	Offloading bool `json:"offloading"`
+	// Percentage is the percentage of the traffic offloaded on Beamlit after the transition
+	Percentage int `json:"percentage"`
+	// Trigger is the cause of the transition
+	Trigger OffloadTrigger `json:"trigger"`
+	// MetricValues are the last values of the offloading metrics of the model, by metric name
+	MetricValues map[string]float64 `json:"metricValues,omitempty"`
+	// Source is the ModelDeployment which reported the transition, as namespace/name
+	Source string `json:"source,omitempty"`
+}
+
+// ErrEventsUnsupported is returned when Beamlit does not expose the events endpoint of models.
+type ErrEventsUnsupported struct {
+	APIError
+}
+
+func (e *ErrEventsUnsupported) Unwrap() error {
+	return &e.APIError
+}
+
+// ReportOffloadEvents sends offloading transitions of a model to Beamlit, in a single request
+// It returns an ErrEventsUnsupported if Beamlit does not expose the events endpoint,
+// and an ErrNotFound if the model does not exist on Beamlit
+func (c *Client) ReportOffloadEvents(ctx context.Context, model string, environment string, events []OffloadEvent) error {
+	resp, err := c.do(ctx, http.MethodPost, fmt.Sprintf("./models/%s/events", url.PathEscape(model)),
+		url.Values{"environment": []string{environment}}, map[string][]OffloadEvent{"events": events})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.FromContext(ctx).Error(err, "failed to close response body")
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		// A missing model and a missing events endpoint both answer 404, the model is looked up to tell them apart
+		existing, _, err := c.getModel(ctx, model, environment)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return newAPIError("report offload events", resp)
+		}
+		return &ErrEventsUnsupported{APIError: APIError{
+			Operation:  "report offload events",
+			StatusCode: resp.StatusCode,
+		}}
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return &ErrEventsUnsupported{APIError: APIError{
+			Operation:  "report offload events",
+			StatusCode: resp.StatusCode,
+		}}
+	}
+	if resp.StatusCode >= 299 {
+		return newAPIError("report offload events", resp)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package beamlit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+func TestReportOffloadEvents(t *testing.T) {
+	type testCase struct {
+		options         []beamlittest.Option
+		model           string
+		wantUnsupported bool
+		wantNotFound    bool
+	}
+	tcs := map[string]testCase{
+		"When the events endpoint is served, must report the events": {
+			options: []beamlittest.Option{beamlittest.WithOffloadEvents()},
+			model:   "model",
+		},
+		"When the events endpoint is not served, must return an ErrEventsUnsupported": {
+			model:           "model",
+			wantUnsupported: true,
+		},
+		"When the model doesn't exist, must return an ErrNotFound": {
+			options:      []beamlittest.Option{beamlittest.WithOffloadEvents()},
+			model:        "missing",
+			wantNotFound: true,
+		},
+		"When the model doesn't exist and the events endpoint is not served, must return an ErrNotFound": {
+			model:        "missing",
+			wantNotFound: true,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			server := beamlittest.NewServer(tc.options...)
+			defer server.Close()
+			server.SetModel(newModel(nil))
+			client := newTestClient(t, server)
+			events := []OffloadEvent{{Timestamp: time.Now(), Offloading: true, Percentage: 50, Trigger: OffloadTriggerManual}}
+			err := client.ReportOffloadEvents(context.Background(), tc.model, "production", events)
+			var unsupported *ErrEventsUnsupported
+			if errors.As(err, &unsupported) != tc.wantUnsupported {
+				t.Errorf("want ErrEventsUnsupported %t but got %v", tc.wantUnsupported, err)
+			}
+			var notFound *ErrNotFound
+			if errors.As(err, &notFound) != tc.wantNotFound {
+				t.Errorf("want ErrNotFound %t but got %v", tc.wantNotFound, err)
+			}
+			if !tc.wantUnsupported && !tc.wantNotFound {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := server.OffloadEvents("production", "model"); len(got) != 1 {
+					t.Errorf("want 1 event reported but got %d", len(got))
+				}
+			}
+		})
+	}
+}
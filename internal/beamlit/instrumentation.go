@@ -0,0 +1,130 @@
+package beamlit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	instrumentationName = "github.com/beamlit/beamlit-controller/internal/beamlit"
+	tokenPath           = "/oauth/token"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "beamlit_client_requests_total",
+		Help: "Number of requests sent to Beamlit, by operation and status code, 0 if no response was received",
+	}, []string{"operation", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "beamlit_client_request_duration_seconds",
+		Help:    "Duration of the requests sent to Beamlit, by operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	tokenRefreshesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "beamlit_client_token_refreshes_total",
+		Help: "Number of access tokens requested to Beamlit, by result",
+	}, []string{"result"})
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "beamlit_client_retries_total",
+		Help: "Number of requests to Beamlit sent again after a failure, by operation and status code, 0 for network errors",
+	}, []string{"operation", "code"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestsTotal, requestDuration, tokenRefreshesTotal, retriesTotal)
+}
+
+// operation returns the operation of a request to Beamlit, with the names of the resources replaced by placeholders
+// to bound the cardinality of the metrics, e.g. "PUT /models/{name}". Paths of unknown resources are all reported as "<method> other".
+func operation(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	// The base URL may have a path prefix, e.g. /v0, the operation starts at the first known resource
+	for i, segment := range segments {
+		switch segment {
+		case "models", "policies", "secrets", "oauth":
+			segments = segments[i:]
+			if len(segments) > 1 && segment != "oauth" {
+				segments[1] = "{name}"
+			}
+			return req.Method + " /" + strings.Join(segments, "/")
+		}
+	}
+	return req.Method + " other"
+}
+
+// instrumentedTransport records the metrics and the span of every request sent to Beamlit, including the token requests.
+// The spans are children of the span of the context of the request, e.g. the span of the reconcile sending it.
+type instrumentedTransport struct {
+	base http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := operation(req)
+	ctx, span := otel.Tracer(instrumentationName).Start(req.Context(), "Beamlit "+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+			attribute.String("beamlit.operation", op),
+		),
+	)
+	defer span.End()
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	requestDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	code := 0
+	if resp != nil {
+		code = resp.StatusCode
+		span.SetAttributes(attribute.Int("http.response.status_code", code))
+	}
+	requestsTotal.WithLabelValues(op, strconv.Itoa(code)).Inc()
+	if strings.HasSuffix(req.URL.Path, tokenPath) {
+		result := "success"
+		if err != nil || code >= 300 {
+			result = "failure"
+		}
+		tokenRefreshesTotal.WithLabelValues(result).Inc()
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case code >= 400:
+		span.SetStatus(codes.Error, http.StatusText(code))
+	}
+	return resp, err
+}
+
+// instrumentedContext returns a context making the OAuth2 client send its requests, and those of its token source,
+// through an instrumentedTransport
+func instrumentedContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: &instrumentedTransport{base: http.DefaultTransport},
+	})
+}
+
+// recordRetry counts a retry of req after a response with code, 0 for a network error, and adds it to the span of the request
+func recordRetry(req *http.Request, code int, attempt int, delay time.Duration) {
+	retriesTotal.WithLabelValues(operation(req), strconv.Itoa(code)).Inc()
+	trace.SpanFromContext(req.Context()).AddEvent("beamlit.retry", trace.WithAttributes(
+		attribute.String("beamlit.operation", operation(req)),
+		attribute.Int("http.response.status_code", code),
+		attribute.Int("beamlit.attempt", attempt),
+		attribute.String("beamlit.delay", delay.String()),
+	))
+}
@@ -0,0 +1,102 @@
+package beamlit
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+func TestOperation(t *testing.T) {
+	type testCase struct {
+		method string
+		path   string
+		want   string
+	}
+	tcs := map[string]testCase{
+		"When the path targets a model, must replace its name": {
+			method: http.MethodPut,
+			path:   "/v0/models/my-model",
+			want:   "PUT /models/{name}",
+		},
+		"When the path targets the events of a model, must keep the sub-resource": {
+			method: http.MethodPost,
+			path:   "/models/my-model/events",
+			want:   "POST /models/{name}/events",
+		},
+		"When the path is a collection, must keep it": {
+			method: http.MethodGet,
+			path:   "/v0/policies",
+			want:   "GET /policies",
+		},
+		"When the path targets a secret, must replace its name": {
+			method: http.MethodDelete,
+			path:   "/v0/secrets/default-my-secret",
+			want:   "DELETE /secrets/{name}",
+		},
+		"When the path targets an unknown resource, must not keep the path": {
+			method: http.MethodGet,
+			path:   "/v0/unknown/my-resource",
+			want:   "GET other",
+		},
+		"When the path is the token endpoint, must keep it": {
+			method: http.MethodPost,
+			path:   "/v0/oauth/token",
+			want:   "POST /oauth/token",
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			req := &http.Request{Method: tc.method, URL: &url.URL{Path: tc.path}}
+			if got := operation(req); got != tc.want {
+				t.Errorf("want %q but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previousProvider)
+
+	server := beamlittest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+
+	requestsBefore := testutil.ToFloat64(requestsTotal.WithLabelValues("GET /policies/{name}", "404"))
+	tokensBefore := testutil.ToFloat64(tokenRefreshesTotal.WithLabelValues("success"))
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "Reconcile Policy")
+	if err := client.DeletePolicy(ctx, "policy", "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	if got := testutil.ToFloat64(requestsTotal.WithLabelValues("GET /policies/{name}", "404")) - requestsBefore; got != 1 {
+		t.Errorf("want 1 request recorded but got %v", got)
+	}
+	if got := testutil.ToFloat64(tokenRefreshesTotal.WithLabelValues("success")) - tokensBefore; got != 1 {
+		t.Errorf("want 1 token refresh recorded but got %v", got)
+	}
+	found := false
+	for _, span := range recorder.Ended() {
+		if span.Name() == "Beamlit GET /policies/{name}" {
+			found = true
+			if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("want the request span to be a child of the reconcile span")
+			}
+		}
+	}
+	if !found {
+		t.Error("want a span for the request")
+	}
+}
@@ -3,37 +3,198 @@ package beamlit
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
 	"strconv"
+	"sync"
 
 	beamlit "github.com/beamlit/toolkit/sdk"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// CreateOrUpdateModel creates or updates a model on Beamlit
+// maxConflictRetries is the number of times a write conflicting with a concurrent change of a model is attempted again
+const maxConflictRetries = 3
+
+// offloadingLabel reports on Beamlit whether a model is offloaded, it is only written by NotifyOnModelOffloading
+const offloadingLabel = "offloading"
+
+// CreateOrUpdateModel creates or updates a model on Beamlit on behalf of owner
 // It returns the updated model on Beamlit
-// It returns an error if the request fails, or if the response status is not 200 - OK
-func (c *Client) CreateOrUpdateModel(ctx context.Context, model beamlit.Model) (*beamlit.Model, error) {
+// The offloading label of the model on Beamlit is kept, so the update does not overwrite a concurrent offloading notification
+// It returns an error if the model is owned by another resource on Beamlit, a model without owner is adopted,
+// or if the request fails, or if the response status is not 200 - OK
+func (c *Client) CreateOrUpdateModel(ctx context.Context, model beamlit.Model, owner string) (*beamlit.Model, error) {
 	if model.Metadata.Name == nil || model.Metadata.Environment == nil {
 		return nil, fmt.Errorf("name and environment are required")
 	}
-	resp, err := c.client.GetModel(ctx, *model.Metadata.Name, &beamlit.GetModelParams{
-		Environment: model.Metadata.Environment,
+	return c.writeModel(ctx, *model.Metadata.Name, *model.Metadata.Environment, func(current *beamlit.Model) (*beamlit.Model, error) {
+		if existingOwner := modelOwner(current); existingOwner != "" && existingOwner != owner {
+			return nil, fmt.Errorf("model %s is already owned by %q on Beamlit", *model.Metadata.Name, existingOwner)
+		}
+		desired := model
+		metadata := *desired.Metadata
+		labels := beamlit.MetadataLabels{}
+		if metadata.Labels != nil {
+			maps.Copy(labels, *metadata.Labels)
+		}
+		labels[OwnerLabel] = owner
+		if current != nil && current.Metadata != nil && current.Metadata.Labels != nil {
+			if offloading, ok := (*current.Metadata.Labels)[offloadingLabel]; ok {
+				labels[offloadingLabel] = offloading
+			}
+		}
+		metadata.Labels = &labels
+		desired.Metadata = &metadata
+		return &desired, nil
+	})
+}
+
+// AdoptModel sets owner as the owner of an existing model on Beamlit, so it can be managed by the operator
+// It returns an error if the model is not found, or if it is already owned by another resource
+func (c *Client) AdoptModel(ctx context.Context, name string, environment string, owner string) error {
+	_, err := c.writeModel(ctx, name, environment, func(current *beamlit.Model) (*beamlit.Model, error) {
+		if current == nil || current.Metadata == nil {
+			return nil, fmt.Errorf("model %s not found on Beamlit", name)
+		}
+		if existingOwner := modelOwner(current); existingOwner != "" && existingOwner != owner {
+			return nil, fmt.Errorf("model %s is already owned by %q on Beamlit", name, existingOwner)
+		}
+		labels := beamlit.MetadataLabels{}
+		if current.Metadata.Labels != nil {
+			labels = *current.Metadata.Labels
+		}
+		labels[OwnerLabel] = owner
+		current.Metadata.Labels = &labels
+		return current, nil
+	})
+	return err
+}
+
+func modelOwner(model *beamlit.Model) string {
+	if model == nil || model.Metadata == nil || model.Metadata.Labels == nil {
+		return ""
+	}
+	return (*model.Metadata.Labels)[OwnerLabel]
+}
+
+// NotifyOnModelOffloading sets the offloading label of a model on Beamlit, without changing the rest of the model
+func (c *Client) NotifyOnModelOffloading(ctx context.Context, model string, environment string, offloading bool) error {
+	_, err := c.writeModel(ctx, model, environment, func(current *beamlit.Model) (*beamlit.Model, error) {
+		if current == nil || current.Metadata == nil {
+			return nil, &ErrNotFound{APIError: APIError{Operation: "get Model", StatusCode: http.StatusNotFound}}
+		}
+		labels := beamlit.MetadataLabels{}
+		if current.Metadata.Labels != nil {
+			labels = *current.Metadata.Labels
+		}
+		labels[offloadingLabel] = strconv.FormatBool(offloading)
+		current.Metadata.Labels = &labels
+		return current, nil
 	})
+	return err
+}
+
+// writeModel writes the model returned by desired for the latest version of a model on Beamlit, nil if it does not exist
+// The writes of a model by the client are serialized. The model is created if it does not exist, or else updated
+// on condition that it did not change since it was read: with its ETag when Beamlit returns one, or else with its update time
+// read again before the update.
+// On conflict, desired is applied again to the new latest version of the model
+func (c *Client) writeModel(ctx context.Context, name string, environment string, desired func(current *beamlit.Model) (*beamlit.Model, error)) (*beamlit.Model, error) {
+	unlock := c.lockModel(name, environment)
+	defer unlock()
+	for attempt := 0; ; attempt++ {
+		current, etag, err := c.getModel(ctx, name, environment)
+		if err != nil {
+			return nil, err
+		}
+		updatedAt := modelUpdatedAt(current)
+		model, err := desired(current)
+		if err != nil {
+			return nil, err
+		}
+		var written *beamlit.Model
+		if current == nil {
+			written, err = c.createModel(ctx, *model)
+		} else {
+			if etag == "" {
+				err = c.checkModelUnchanged(ctx, name, environment, updatedAt)
+			}
+			if err == nil {
+				written, err = c.updateModel(ctx, name, *model, etag)
+			}
+		}
+		var conflictErr *ErrConflict
+		if errors.As(err, &conflictErr) && attempt < maxConflictRetries {
+			log.FromContext(ctx).V(1).Info("Model changed concurrently on Beamlit, retrying", "Model", name, "Environment", environment, "Attempt", attempt+1)
+			continue
+		}
+		return written, err
+	}
+}
+
+// checkModelUnchanged returns an ErrConflict if a model read without ETag was changed or deleted on Beamlit since it was read,
+// updatedAt being its update time when it was read
+func (c *Client) checkModelUnchanged(ctx context.Context, name string, environment string, updatedAt string) error {
+	latest, _, err := c.getModel(ctx, name, environment)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if resp.StatusCode == http.StatusNotFound {
-		return c.createModel(ctx, model)
+	if latest == nil || modelUpdatedAt(latest) != updatedAt {
+		return &ErrConflict{APIError: APIError{
+			Operation:  "update Model",
+			StatusCode: http.StatusConflict,
+			Body:       fmt.Sprintf("model %s changed on Beamlit since it was read", name),
+		}}
 	}
-	return c.updateModel(ctx, model)
+	return nil
+}
 
+func modelUpdatedAt(model *beamlit.Model) string {
+	if model == nil || model.Metadata == nil || model.Metadata.UpdatedAt == nil {
+		return ""
+	}
+	return *model.Metadata.UpdatedAt
+}
+
+// lockModel locks the writes of a model by the client, and returns the function unlocking them
+func (c *Client) lockModel(name string, environment string) func() {
+	value, _ := c.modelLocks.LoadOrStore(fmt.Sprintf("%s/%s", environment, name), &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// getModel retrieves a model on Beamlit, with its ETag if Beamlit returns one
+// It returns nil if the model is not found
+func (c *Client) getModel(ctx context.Context, name string, environment string) (*beamlit.Model, string, error) {
+	resp, err := c.api().GetModel(ctx, name, &beamlit.GetModelParams{
+		Environment: &environment,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.FromContext(ctx).Error(err, "failed to close response body")
+		}
+	}()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode >= 299 {
+		return nil, "", newAPIError("get Model", resp)
+	}
+	model := &beamlit.Model{}
+	if err := json.NewDecoder(resp.Body).Decode(model); err != nil {
+		return nil, "", err
+	}
+	return model, resp.Header.Get("ETag"), nil
 }
 
 func (c *Client) createModel(ctx context.Context, model beamlit.Model) (*beamlit.Model, error) {
-	resp, err := c.client.CreateModel(ctx, model)
+	resp, err := c.api().CreateModel(ctx, model)
 	if err != nil {
 		return nil, err
 	}
@@ -43,8 +204,7 @@ func (c *Client) createModel(ctx context.Context, model beamlit.Model) (*beamlit
 		}
 	}()
 	if resp.StatusCode >= 299 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to update Model, status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, newAPIError("create Model", resp)
 	}
 	modelResp := &beamlit.Model{}
 	if err := json.NewDecoder(resp.Body).Decode(modelResp); err != nil {
@@ -53,8 +213,9 @@ func (c *Client) createModel(ctx context.Context, model beamlit.Model) (*beamlit
 	return modelResp, nil
 }
 
-func (c *Client) updateModel(ctx context.Context, model beamlit.Model) (*beamlit.Model, error) {
-	resp, err := c.client.UpdateModel(ctx, *model.Metadata.Name, model)
+// updateModel updates a model on Beamlit, on condition that its ETag still matches etag if not empty
+func (c *Client) updateModel(ctx context.Context, name string, model beamlit.Model, etag string) (*beamlit.Model, error) {
+	resp, err := c.api().UpdateModel(ctx, name, model, ifMatch(etag))
 	if err != nil {
 		return nil, err
 	}
@@ -64,8 +225,7 @@ func (c *Client) updateModel(ctx context.Context, model beamlit.Model) (*beamlit
 		}
 	}()
 	if resp.StatusCode >= 299 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to update Model, status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, newAPIError("update Model", resp)
 	}
 	modelResp := &beamlit.Model{}
 	if err := json.NewDecoder(resp.Body).Decode(modelResp); err != nil {
@@ -74,13 +234,34 @@ func (c *Client) updateModel(ctx context.Context, model beamlit.Model) (*beamlit
 	return modelResp, nil
 }
 
-// DeleteModelDeployment deletes a model deployment on Beamlit
+// ifMatch sets the If-Match header of a request, unless etag is empty
+func ifMatch(etag string) beamlit.RequestEditorFn {
+	return func(ctx context.Context, req *http.Request) error {
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		return nil
+	}
+}
+
+// DeleteModelDeployment deletes a model deployment on Beamlit on behalf of owner
 // It returns an error if the request fails, or if the response status is not 200 - OK
-// It returns nil if the model deployment is not found
-func (c *Client) DeleteModelDeployment(ctx context.Context, model string, environment string) error {
+// It returns nil if the model deployment is not found, or if it is owned by another resource
+func (c *Client) DeleteModelDeployment(ctx context.Context, model string, environment string, owner string) error {
 	logger := log.FromContext(ctx)
+	current, _, err := c.getModel(ctx, model, environment)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+	if existingOwner := modelOwner(current); existingOwner != "" && existingOwner != owner {
+		logger.V(0).Info("Model is not owned by this resource, skipping deletion on Beamlit", "Model", model, "Environment", environment, "Owner", existingOwner)
+		return nil
+	}
 	logger.V(1).Info("Deleting Model", "Model", model, "Environment", environment)
-	resp, err := c.client.DeleteModel(ctx, model, &beamlit.DeleteModelParams{
+	resp, err := c.api().DeleteModel(ctx, model, &beamlit.DeleteModelParams{
 		Environment: environment,
 	})
 	if err != nil {
@@ -96,18 +277,18 @@ func (c *Client) DeleteModelDeployment(ctx context.Context, model string, enviro
 		return nil
 	}
 	if resp.StatusCode >= 299 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete Model, status code: %d, body: %s", resp.StatusCode, string(body))
+		return newAPIError("delete Model", resp)
 	}
 	return nil
 }
 
-func (c *Client) NotifyOnModelOffloading(ctx context.Context, model string, environment string, offloading bool) error {
-	resp, err := c.client.GetModel(ctx, model, &beamlit.GetModelParams{
+// ListModels lists the models deployed in an environment on Beamlit
+func (c *Client) ListModels(ctx context.Context, environment string) ([]beamlit.Model, error) {
+	resp, err := c.api().ListModels(ctx, &beamlit.ListModelsParams{
 		Environment: &environment,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -115,19 +296,11 @@ func (c *Client) NotifyOnModelOffloading(ctx context.Context, model string, envi
 		}
 	}()
 	if resp.StatusCode >= 299 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to get ModelDeployment, status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, newAPIError("list Models", resp)
 	}
-	modelDeployment := &beamlit.Model{}
-	if err := json.NewDecoder(resp.Body).Decode(modelDeployment); err != nil {
-		return err
-	}
-	var labels beamlit.MetadataLabels
-	if modelDeployment.Metadata.Labels != nil {
-		labels = *modelDeployment.Metadata.Labels
+	models := []beamlit.Model{}
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, err
 	}
-	labels["offloading"] = strconv.FormatBool(offloading)
-	modelDeployment.Metadata.Labels = &labels
-	_, err = c.client.UpdateModel(ctx, model, *modelDeployment)
-	return err
+	return models, nil
 }
@@ -0,0 +1,201 @@
+package beamlit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+	beamlit "github.com/beamlit/toolkit/sdk"
+)
+
+func newModel(labels map[string]string) beamlit.Model {
+	return beamlit.Model{
+		Metadata: &beamlit.EnvironmentMetadata{
+			Name:        toPtr("model"),
+			Environment: toPtr("production"),
+			Labels:      (*beamlit.MetadataLabels)(&labels),
+		},
+	}
+}
+
+func toPtr[T any](v T) *T {
+	return &v
+}
+
+// newTestClient returns a client of a fake Beamlit API, without retries nor rate limit
+func newTestClient(t *testing.T, server *beamlittest.Server) *Client {
+	t.Helper()
+	client, err := NewClientWithCredentials(server.URL, server.Token(), WithRequestPolicy(&RequestPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func countRequests(server *beamlittest.Server, method string, path string) int {
+	count := 0
+	for _, req := range server.Requests() {
+		if req.Method == method && req.Path == path {
+			count++
+		}
+	}
+	return count
+}
+
+func TestWriteModel(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	server.SetModel(newModel(map[string]string{"team": "a", offloadingLabel: "true"}))
+	server.InjectFault(beamlittest.Fault{
+		Method:     http.MethodPut,
+		Path:       "/models/model",
+		StatusCode: http.StatusPreconditionFailed,
+		Times:      1,
+	})
+	client := newTestClient(t, server)
+
+	updated, err := client.CreateOrUpdateModel(context.Background(), newModel(map[string]string{"team": "b"}), "default/model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updates := countRequests(server, http.MethodPut, "/models/model"); updates != 2 {
+		t.Errorf("want 2 updates after a conflict but got %d", updates)
+	}
+	labels := *updated.Metadata.Labels
+	if labels["team"] != "b" || labels[offloadingLabel] != "true" {
+		t.Errorf("want the updated labels with the offloading label but got %v", labels)
+	}
+
+	if err := client.NotifyOnModelOffloading(context.Background(), "model", "production", false); err != nil {
+		t.Fatal(err)
+	}
+	model, _ := server.Model("production", "model")
+	labels = *model.Metadata.Labels
+	if labels["team"] != "b" || labels[offloadingLabel] != "false" {
+		t.Errorf("want the offloading label updated only but got %v", labels)
+	}
+}
+
+func TestWriteModelWithoutETag(t *testing.T) {
+	handler := beamlittest.NewHandler(beamlittest.WithoutETags())
+	handler.SetModel(newModel(map[string]string{"team": "a"}))
+	var once sync.Once
+	// The model is changed on Beamlit right after it is read by the client
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		if r.Method == http.MethodGet && r.URL.Path == "/models/model" {
+			once.Do(func() {
+				handler.SetModel(newModel(map[string]string{"team": "a", offloadingLabel: "true"}))
+			})
+		}
+	}))
+	defer server.Close()
+	client, err := NewClientWithCredentials(server.URL, handler.Token(), WithRequestPolicy(&RequestPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := client.CreateOrUpdateModel(context.Background(), newModel(map[string]string{"team": "b"}), "default/model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := *updated.Metadata.Labels
+	if labels["team"] != "b" || labels[offloadingLabel] != "true" {
+		t.Errorf("want the model updated from its concurrent change but got %v", labels)
+	}
+	updates := 0
+	for _, req := range handler.Requests() {
+		if req.Method == http.MethodPut && req.Path == "/models/model" {
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Errorf("want a single update after the conflict but got %d", updates)
+	}
+}
+
+func TestModelLifecycle(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	created, err := client.CreateOrUpdateModel(ctx, newModel(map[string]string{"team": "a"}), "default/model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Metadata.Workspace == nil || *created.Metadata.Workspace != beamlittest.DefaultWorkspace {
+		t.Errorf("want the model created in the workspace %q but got %v", beamlittest.DefaultWorkspace, created.Metadata.Workspace)
+	}
+
+	drifted := newModel(map[string]string{"team": "drifted"})
+	server.SetModel(drifted)
+	if _, err := client.CreateOrUpdateModel(ctx, newModel(map[string]string{"team": "a"}), "default/model"); err != nil {
+		t.Fatal(err)
+	}
+	model, _ := server.Model("production", "model")
+	if (*model.Metadata.Labels)["team"] != "a" {
+		t.Errorf("want the drift reverted but got %v", *model.Metadata.Labels)
+	}
+
+	models, err := client.ListModels(ctx, "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 1 {
+		t.Errorf("want 1 model but got %d", len(models))
+	}
+
+	if err := client.DeleteModelDeployment(ctx, "model", "production", "default/model"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Model("production", "model"); ok {
+		t.Error("want the model deleted")
+	}
+	if err := client.DeleteModelDeployment(ctx, "model", "production", "default/model"); err != nil {
+		t.Errorf("want no error when deleting a missing model but got %s", err)
+	}
+}
+
+func TestModelOwnership(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	server.SetModel(newModel(map[string]string{"team": "a"}))
+	if err := client.AdoptModel(ctx, "model", "production", "default/a"); err != nil {
+		t.Fatalf("want the model without owner adopted but got %v", err)
+	}
+	model, _ := server.Model("production", "model")
+	if owner := modelOwner(&model); owner != "default/a" {
+		t.Errorf("want the owner label stamped but got %q", owner)
+	}
+	if err := client.AdoptModel(ctx, "model", "production", "default/b"); err == nil {
+		t.Error("want an error when adopting a model owned by another resource")
+	}
+	if _, err := client.CreateOrUpdateModel(ctx, newModel(map[string]string{"team": "b"}), "default/b"); err == nil {
+		t.Error("want an error when updating a model owned by another resource")
+	}
+	if _, err := client.CreateOrUpdateModel(ctx, newModel(map[string]string{"team": "b"}), "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteModelDeployment(ctx, "model", "production", "default/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Model("production", "model"); !ok {
+		t.Error("want the model kept when deleted by another resource")
+	}
+	if err := client.DeleteModelDeployment(ctx, "model", "production", "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Model("production", "model"); ok {
+		t.Error("want the model deleted by its owner")
+	}
+	if err := client.AdoptModel(ctx, "model", "production", "default/a"); err == nil {
+		t.Error("want an error when adopting a missing model")
+	}
+}
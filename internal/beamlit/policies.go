@@ -4,29 +4,39 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 
 	beamlit "github.com/beamlit/toolkit/sdk"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func (c *Client) CreateOrUpdatePolicy(ctx context.Context, policy beamlit.Policy) (*beamlit.Policy, error) {
-	if policy.Metadata.Name == nil {
+// OwnerLabel is the label set on Beamlit resources to track the Kubernetes resource owning them
+const OwnerLabel = "owner"
+
+// CreateOrUpdatePolicy creates or updates a policy on Beamlit on behalf of owner
+// It returns the updated policy on Beamlit
+// It returns an error if the policy already exists on Beamlit and is owned by another resource, a policy without owner is adopted
+func (c *Client) CreateOrUpdatePolicy(ctx context.Context, policy beamlit.Policy, owner string) (*beamlit.Policy, error) {
+	if policy.Metadata == nil || policy.Metadata.Name == nil {
 		return nil, fmt.Errorf("policy name is required")
 	}
-	policyResp, err := c.client.GetPolicy(ctx, *policy.Metadata.Name)
+	existingPolicy, err := c.getPolicy(ctx, *policy.Metadata.Name)
 	if err != nil {
 		return nil, err
 	}
+	if policy.Metadata.Labels == nil {
+		policy.Metadata.Labels = &beamlit.MetadataLabels{}
+	}
+	(*policy.Metadata.Labels)[OwnerLabel] = owner
 	var resp *http.Response
-	switch policyResp.StatusCode {
-	case http.StatusNotFound:
-		resp, err = c.client.CreatePolicy(ctx, policy)
-	case http.StatusOK:
-		resp, err = c.client.UpdatePolicy(ctx, *policy.Metadata.Name, policy)
-	default:
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if existingPolicy == nil {
+		resp, err = c.api().CreatePolicy(ctx, policy)
+	} else {
+		// A policy without owner, created before the ownership was tracked, is adopted
+		if existingOwner := policyOwner(existingPolicy); existingOwner != "" && !sameOwner(existingOwner, owner) {
+			return nil, fmt.Errorf("policy %s is already owned by %q on Beamlit", *policy.Metadata.Name, existingOwner)
+		}
+		resp, err = c.api().UpdatePolicy(ctx, *policy.Metadata.Name, policy)
 	}
 	if err != nil {
 		return nil, err
@@ -37,8 +47,7 @@ func (c *Client) CreateOrUpdatePolicy(ctx context.Context, policy beamlit.Policy
 		}
 	}()
 	if resp.StatusCode >= 299 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to update Policy, status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, newAPIError("update Policy", resp)
 	}
 	updatedPolicy := &beamlit.Policy{}
 	if err := json.NewDecoder(resp.Body).Decode(updatedPolicy); err != nil {
@@ -47,22 +56,130 @@ func (c *Client) CreateOrUpdatePolicy(ctx context.Context, policy beamlit.Policy
 	return updatedPolicy, nil
 }
 
-func (c *Client) DeletePolicy(ctx context.Context, name string) error {
-	resp, err := c.client.DeletePolicy(ctx, name)
+// DeletePolicy deletes a policy on Beamlit on behalf of owner
+// It returns nil if the policy is not found, or if it is owned by another resource. A policy without owner is deleted.
+func (c *Client) DeletePolicy(ctx context.Context, name string, owner string) error {
+	logger := log.FromContext(ctx)
+	existingPolicy, err := c.getPolicy(ctx, name)
+	if err != nil {
+		return err
+	}
+	if existingPolicy == nil {
+		return nil
+	}
+	if existingOwner := policyOwner(existingPolicy); existingOwner != "" && !sameOwner(existingOwner, owner) {
+		logger.V(0).Info("Policy is not owned by this resource, skipping deletion on Beamlit", "Policy", name, "Owner", existingOwner)
+		return nil
+	}
+	resp, err := c.api().DeletePolicy(ctx, name)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Error(err, "failed to close response body")
+		}
+	}()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode >= 299 {
+		return newAPIError("delete Policy", resp)
+	}
+	return nil
+}
+
+// getPolicy retrieves a policy on Beamlit
+// It returns nil if the policy is not found
+func (c *Client) getPolicy(ctx context.Context, name string) (*beamlit.Policy, error) {
+	resp, err := c.api().GetPolicy(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
 			log.FromContext(ctx).Error(err, "failed to close response body")
 		}
 	}()
 	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode >= 299 {
+		return nil, newAPIError("get Policy", resp)
+	}
+	policy := &beamlit.Policy{}
+	if err := json.NewDecoder(resp.Body).Decode(policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func policyOwner(policy *beamlit.Policy) string {
+	if policy.Metadata == nil || policy.Metadata.Labels == nil {
+		return ""
+	}
+	return (*policy.Metadata.Labels)[OwnerLabel]
+}
+
+// sameOwner reports whether existingOwner, read on Beamlit, is owner
+// Cluster policies used to be owned by /<name> and are now owned by cluster/<name>, the previous owner is replaced on the next update
+func sameOwner(existingOwner string, owner string) bool {
+	return existingOwner == owner || "cluster"+existingOwner == owner
+}
+
+// ListPolicies lists the policies of the workspace on Beamlit
+func (c *Client) ListPolicies(ctx context.Context) ([]beamlit.Policy, error) {
+	resp, err := c.api().ListPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.FromContext(ctx).Error(err, "failed to close response body")
+		}
+	}()
+	if resp.StatusCode >= 299 {
+		return nil, newAPIError("list Policies", resp)
+	}
+	policies := []beamlit.Policy{}
+	if err := json.NewDecoder(resp.Body).Decode(&policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// AdoptPolicy sets owner as the owner of an existing policy on Beamlit, so it can be managed by the operator
+// It returns an error if the policy is not found, or if it is already owned by another resource
+func (c *Client) AdoptPolicy(ctx context.Context, name string, owner string) error {
+	existingPolicy, err := c.getPolicy(ctx, name)
+	if err != nil {
+		return err
+	}
+	if existingPolicy == nil {
+		return fmt.Errorf("policy %s not found on Beamlit", name)
+	}
+	existingOwner := policyOwner(existingPolicy)
+	if existingOwner == owner {
 		return nil
 	}
+	if existingOwner != "" && !sameOwner(existingOwner, owner) {
+		return fmt.Errorf("policy %s is already owned by %q on Beamlit", name, existingOwner)
+	}
+	if existingPolicy.Metadata.Labels == nil {
+		existingPolicy.Metadata.Labels = &beamlit.MetadataLabels{}
+	}
+	(*existingPolicy.Metadata.Labels)[OwnerLabel] = owner
+	resp, err := c.api().UpdatePolicy(ctx, name, *existingPolicy)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.FromContext(ctx).Error(err, "failed to close response body")
+		}
+	}()
 	if resp.StatusCode >= 299 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete Policy, status code: %d, body: %s", resp.StatusCode, string(body))
+		return newAPIError("adopt Policy", resp)
 	}
 	return nil
 }
@@ -0,0 +1,165 @@
+package beamlit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+	beamlit "github.com/beamlit/toolkit/sdk"
+)
+
+func newPolicy(policyType string) beamlit.Policy {
+	return beamlit.Policy{
+		Metadata: &beamlit.Metadata{
+			Name: toPtr("policy"),
+		},
+		Spec: &beamlit.PolicySpec{
+			Type: toPtr(policyType),
+		},
+	}
+}
+
+func TestPolicyOwnership(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	if _, err := client.CreateOrUpdatePolicy(ctx, newPolicy("location"), "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateOrUpdatePolicy(ctx, newPolicy("flavor"), "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	policy, _ := server.Policy("policy")
+	if *policy.Spec.Type != "flavor" {
+		t.Errorf("want the policy updated but got type %s", *policy.Spec.Type)
+	}
+
+	if _, err := client.CreateOrUpdatePolicy(ctx, newPolicy("location"), "default/b"); err == nil {
+		t.Error("want an error when updating a policy owned by another resource")
+	}
+	if err := client.DeletePolicy(ctx, "policy", "default/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Policy("policy"); !ok {
+		t.Error("want the policy kept when deleted by another resource")
+	}
+	if err := client.DeletePolicy(ctx, "policy", "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Policy("policy"); ok {
+		t.Error("want the policy deleted by its owner")
+	}
+}
+
+func TestUnownedPolicy(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	server.SetPolicy(newPolicy("location"))
+	if _, err := client.CreateOrUpdatePolicy(ctx, newPolicy("flavor"), "default/a"); err != nil {
+		t.Fatalf("want the policy without owner adopted but got %v", err)
+	}
+	policy, _ := server.Policy("policy")
+	if owner := policyOwner(&policy); owner != "default/a" {
+		t.Errorf("want the owner label stamped but got %q", owner)
+	}
+
+	server.SetPolicy(newPolicy("location"))
+	if err := client.DeletePolicy(ctx, "policy", "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Policy("policy"); ok {
+		t.Error("want the policy without owner deleted")
+	}
+}
+
+func TestLegacyClusterPolicyOwner(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	legacy := newPolicy("location")
+	legacy.Metadata.Labels = &beamlit.MetadataLabels{OwnerLabel: "/policy"}
+	server.SetPolicy(legacy)
+	if _, err := client.CreateOrUpdatePolicy(ctx, newPolicy("flavor"), "cluster/policy"); err != nil {
+		t.Fatalf("want the policy owned by the previous cluster policy owner updated but got %v", err)
+	}
+	policy, _ := server.Policy("policy")
+	if owner := policyOwner(&policy); owner != "cluster/policy" {
+		t.Errorf("want the owner label replaced but got %q", owner)
+	}
+	if _, err := client.CreateOrUpdatePolicy(ctx, newPolicy("flavor"), "default/policy"); err == nil {
+		t.Error("want an error when updating a cluster policy from a namespaced policy")
+	}
+}
+
+func TestFakeServerErrors(t *testing.T) {
+	type testCase struct {
+		token string
+		fault *beamlittest.Fault
+		check func(t *testing.T, err error)
+	}
+	tcs := map[string]testCase{
+		"When the credentials are invalid, must return an AuthError": {
+			token: "aW52YWxpZDppbnZhbGlk",
+			check: func(t *testing.T, err error) {
+				if !IsAuthError(err) {
+					t.Errorf("want AuthError but got %v", err)
+				}
+			},
+		},
+		"When Beamlit rate limits the client, must return an ErrRateLimited": {
+			fault: &beamlittest.Fault{
+				Path:       "/policies",
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": []string{"5"}},
+			},
+			check: func(t *testing.T, err error) {
+				var rateLimitedErr *ErrRateLimited
+				if !errors.As(err, &rateLimitedErr) {
+					t.Errorf("want ErrRateLimited but got %v", err)
+				}
+			},
+		},
+		"When Beamlit rejects the policy, must return an ErrValidation": {
+			fault: &beamlittest.Fault{
+				Method:     http.MethodPost,
+				Path:       "/policies",
+				StatusCode: http.StatusUnprocessableEntity,
+				Body:       `{"errors":[{"field":"spec.type","message":"unknown type"}]}`,
+			},
+			check: func(t *testing.T, err error) {
+				var validationErr *ErrValidation
+				if !errors.As(err, &validationErr) || len(validationErr.Fields) != 1 {
+					t.Errorf("want ErrValidation with 1 field but got %v", err)
+				}
+			},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			server := beamlittest.NewServer()
+			defer server.Close()
+			if tc.fault != nil {
+				server.InjectFault(*tc.fault)
+			}
+			token := server.Token()
+			if tc.token != "" {
+				token = tc.token
+			}
+			client, err := NewClientWithCredentials(server.URL, token, WithRequestPolicy(&RequestPolicy{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = client.CreateOrUpdatePolicy(context.Background(), newPolicy("location"), "default/a")
+			tc.check(t, err)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package beamlit
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	beamlit "github.com/beamlit/toolkit/sdk"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultMaxRetries        = 3
+	defaultInitialBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff        = 30 * time.Second
+	defaultRequestsPerSecond = 10
+	defaultBurst             = 20
+)
+
+// RequestPolicy configures the retries and the rate limit of the requests to Beamlit.
+type RequestPolicy struct {
+	// MaxRetries is the maximum number of retries of a failed request, 0 disables retries.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry, doubled on every retry with jitter.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay before a retry, including the delay requested by a Retry-After header.
+	MaxBackoff time.Duration
+	// Limiter is the token bucket the requests wait for, nil disables the rate limit.
+	// It is shared by every client created with the policy.
+	Limiter *rate.Limiter
+}
+
+// DefaultRequestPolicy returns the policy used by clients created without WithRequestPolicy.
+func DefaultRequestPolicy() *RequestPolicy {
+	return &RequestPolicy{
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		Limiter:        rate.NewLimiter(defaultRequestsPerSecond, defaultBurst),
+	}
+}
+
+// backoff returns the delay before the retry following attempt, with jitter.
+// The delay requested by the Retry-After header of resp takes precedence, capped to MaxBackoff.
+func (p *RequestPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return min(delay, p.MaxBackoff)
+		}
+	}
+	delay := p.MaxBackoff
+	if attempt < 32 {
+		delay = min(p.InitialBackoff<<attempt, p.MaxBackoff)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1) //nolint:gosec
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// retryable returns true if the request can be sent again after resp or err.
+// Requests rejected with 429 are never processed by Beamlit, so they are retried whatever their method.
+// Other failures are retried for idempotent requests only, as Beamlit may have processed them.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		return !IsAuthError(err) && req.Context().Err() == nil && idempotent(req.Method)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(req.Method)
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryDoer waits for the rate limit before every request, and retries failed requests with the policy backoff.
+type retryDoer struct {
+	doer   beamlit.HttpRequestDoer
+	policy *RequestPolicy
+}
+
+func (d *retryDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if d.policy.Limiter != nil {
+			if err := d.policy.Limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := d.doer.Do(req)
+		if attempt >= d.policy.MaxRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+		delay := d.policy.backoff(attempt, resp)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+			discardBody(ctx, resp)
+		}
+		recordRetry(req, status, attempt+1, delay)
+		log.FromContext(ctx).V(1).Info("Retrying request to Beamlit", "Method", req.Method, "URL", req.URL.String(), "StatusCode", status, "Error", err, "Attempt", attempt+1, "Delay", delay.String())
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// discardBody reads and closes the body of a response which is not returned, so the connection can be reused.
+func discardBody(ctx context.Context, resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		log.FromContext(ctx).Error(err, "failed to close response body")
+	}
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package beamlit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	type testCase struct {
+		value     string
+		wantDelay time.Duration
+		wantOK    bool
+	}
+	tcs := map[string]testCase{
+		"When the header is empty, must not return a delay": {
+			value:  "",
+			wantOK: false,
+		},
+		"When the header is a number of seconds, must return it": {
+			value:     "3",
+			wantDelay: 3 * time.Second,
+			wantOK:    true,
+		},
+		"When the header is an HTTP date, must return the delay until the date": {
+			value:     now.Add(10 * time.Second).Format(http.TimeFormat),
+			wantDelay: 10 * time.Second,
+			wantOK:    true,
+		},
+		"When the header is a past HTTP date, must return no delay": {
+			value:     now.Add(-10 * time.Second).Format(http.TimeFormat),
+			wantDelay: 0,
+			wantOK:    true,
+		},
+		"When the header is invalid, must not return a delay": {
+			value:  "soon",
+			wantOK: false,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tc.value, now)
+			if ok != tc.wantOK || delay != tc.wantDelay {
+				t.Errorf("want (%v, %v) but got (%v, %v)", tc.wantDelay, tc.wantOK, delay, ok)
+			}
+		})
+	}
+}
+
+func TestRetryDoer(t *testing.T) {
+	type testCase struct {
+		method       string
+		statuses     []int
+		wantStatus   int
+		wantAttempts int32
+	}
+	tcs := map[string]testCase{
+		"When a PUT fails with 503, must retry until it succeeds": {
+			method:       http.MethodPut,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 3,
+		},
+		"When a POST fails with 503, must not retry": {
+			method:       http.MethodPost,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 1,
+		},
+		"When a POST is rate limited, must retry": {
+			method:       http.MethodPost,
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 2,
+		},
+		"When a GET keeps failing, must stop after the max retries": {
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantStatus:   http.StatusBadGateway,
+			wantAttempts: 4,
+		},
+		"When a GET fails with 404, must not retry": {
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusNotFound, http.StatusOK},
+			wantStatus:   http.StatusNotFound,
+			wantAttempts: 1,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := attempts.Add(1)
+				if body, err := io.ReadAll(r.Body); err != nil || (r.Method != http.MethodGet && string(body) != "{}") {
+					t.Errorf("attempt %d: unexpected body %q", attempt, string(body))
+				}
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tc.statuses[attempt-1])
+			}))
+			defer server.Close()
+
+			doer := &retryDoer{
+				doer: server.Client(),
+				policy: &RequestPolicy{
+					MaxRetries:     3,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     time.Millisecond,
+				},
+			}
+			var body io.Reader
+			if tc.method != http.MethodGet {
+				body = strings.NewReader("{}")
+			}
+			req, err := http.NewRequest(tc.method, server.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := doer.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close() //nolint:errcheck
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("want status %d but got %d", tc.wantStatus, resp.StatusCode)
+			}
+			if attempts.Load() != tc.wantAttempts {
+				t.Errorf("want %d attempts but got %d", tc.wantAttempts, attempts.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package beamlit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Secret is a secret of a Beamlit workspace, read by the models with ${secrets.<name>}
+type Secret struct {
+	// Name is the name of the secret in the workspace
+	Name string `json:"name"`
+	// Value is the value of the secret
+	Value string `json:"value"`
+	// Labels are the labels of the secret
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ErrSecretsUnsupported is returned when Beamlit does not expose the secrets endpoint of workspaces.
+type ErrSecretsUnsupported struct {
+	APIError
+}
+
+func (e *ErrSecretsUnsupported) Unwrap() error {
+	return &e.APIError
+}
+
+// PutSecret creates or replaces a secret of the workspace of the client
+// It returns an ErrSecretsUnsupported if Beamlit does not expose the secrets endpoint
+func (c *Client) PutSecret(ctx context.Context, secret Secret) error {
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string)
+	}
+	secret.Labels["managed-by"] = "beamlit-operator"
+	resp, err := c.do(ctx, http.MethodPut, fmt.Sprintf("./secrets/%s", url.PathEscape(secret.Name)), nil, secret)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.FromContext(ctx).Error(err, "failed to close response body")
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return &ErrSecretsUnsupported{APIError: APIError{
+			Operation:  "put secret",
+			StatusCode: resp.StatusCode,
+		}}
+	}
+	if resp.StatusCode >= 299 {
+		return newAPIError("put secret", resp)
+	}
+	return nil
+}
+
+// DeleteSecret deletes a secret of the workspace of the client, if it exists
+// It returns an ErrSecretsUnsupported if Beamlit does not expose the secrets endpoint
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("./secrets/%s", url.PathEscape(name)), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.FromContext(ctx).Error(err, "failed to close response body")
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return &ErrSecretsUnsupported{APIError: APIError{
+			Operation:  "delete secret",
+			StatusCode: resp.StatusCode,
+		}}
+	}
+	if resp.StatusCode >= 299 {
+		return newAPIError("delete secret", resp)
+	}
+	return nil
+}
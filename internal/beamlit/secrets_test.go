@@ -0,0 +1,47 @@
+package beamlit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+func TestSecrets(t *testing.T) {
+	server := beamlittest.NewServer(beamlittest.WithSecrets())
+	defer server.Close()
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	if err := client.PutSecret(ctx, Secret{Name: "hf-token", Value: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutSecret(ctx, Secret{Name: "hf-token", Value: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := server.Secret("hf-token"); value != "b" {
+		t.Errorf("want the secret replaced but got %s", value)
+	}
+	if err := client.DeleteSecret(ctx, "hf-token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Secret("hf-token"); ok {
+		t.Error("want the secret deleted")
+	}
+	if err := client.DeleteSecret(ctx, "hf-token"); err != nil {
+		t.Errorf("want no error when deleting a missing secret but got %v", err)
+	}
+}
+
+func TestSecretsUnsupported(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+
+	err := client.PutSecret(context.Background(), Secret{Name: "hf-token", Value: "a"})
+	var unsupportedErr *ErrSecretsUnsupported
+	if !errors.As(err, &unsupportedErr) {
+		t.Errorf("want ErrSecretsUnsupported but got %v", err)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -34,6 +36,26 @@ type Config struct {
 	Namespaces *string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
 	// MetricInformerConfig is the configuration for the metric informer.
 	MetricInformerConfig *MetricInformersConfig `json:"metric_informer,omitempty" yaml:"metricInformer,omitempty"`
+	// PolicyNamingStrategy is the strategy used to name policies on Beamlit.
+	PolicyNamingStrategy *PolicyNamingStrategy `json:"policy_naming_strategy,omitempty" yaml:"policyNamingStrategy,omitempty"`
+	// BeamlitCredentials is the reference to the Secret holding the Beamlit credentials, watched for rotation.
+	// If not set, the credentials are read from the BEAMLIT_TOKEN and BEAMLIT_BASE_URL environment variables at startup.
+	BeamlitCredentials *BeamlitCredentialsConfig `json:"beamlit_credentials,omitempty" yaml:"beamlitCredentials,omitempty"`
+	// WorkspaceCredentialsNamespaces is the comma-separated list of namespaces of the credentials Secrets of the BeamlitWorkspaces.
+	// The Secrets of these namespaces are watched, so the credentials of the workspaces are rotated when they change.
+	WorkspaceCredentialsNamespaces *string `json:"workspace_credentials_namespaces,omitempty" yaml:"workspaceCredentialsNamespaces,omitempty"`
+	// BeamlitClient is the configuration for the retries and the rate limit of the requests to Beamlit.
+	BeamlitClient *BeamlitClientConfig `json:"beamlit_client,omitempty" yaml:"beamlitClient,omitempty"`
+	// OffloadEvents is the configuration for the reporting of the offloading transitions of models on Beamlit.
+	OffloadEvents *OffloadEventsConfig `json:"offload_events,omitempty" yaml:"offloadEvents,omitempty"`
+	// Tracing is the configuration for the export of the OpenTelemetry spans of the reconciles and of the requests to Beamlit.
+	Tracing *TracingConfig `json:"tracing,omitempty" yaml:"tracing,omitempty"`
+	// PodTemplate is the configuration for the conversion of the pod templates of the model sources pushed to Beamlit.
+	PodTemplate *PodTemplateConfig `json:"pod_template,omitempty" yaml:"podTemplate,omitempty"`
+	// Offloader is the configuration for the routing of the offloaded traffic of the models.
+	Offloader *OffloaderConfig `json:"offloader,omitempty" yaml:"offloader,omitempty"`
+	// Configurer is the configuration for the redirection of the traffic of the model Services to the offloader.
+	Configurer *ConfigurerConfig `json:"configurer,omitempty" yaml:"configurer,omitempty"`
 	// Proxy is the configuration for the proxy service.
 	ProxyService ProxyServiceConfig `json:"proxy_service,omitempty" yaml:"proxyService,omitempty"`
 	// DefaultRemoteBackend is the configuration for the default remote backend service.
@@ -62,6 +84,147 @@ type Config struct {
 	} `json:"deployment,omitempty" yaml:"deployment,omitempty"`
 }
 
+type BeamlitCredentialsConfig struct {
+	// Namespace is the namespace of the Secret.
+	Namespace *string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Name is the name of the Secret.
+	Name *string `json:"name,omitempty" yaml:"name,omitempty"`
+	// TokenKey is the key of the Beamlit token in the Secret. Defaults to "token".
+	TokenKey *string `json:"token_key,omitempty" yaml:"tokenKey,omitempty"`
+	// BaseURLKey is the key of the Beamlit base URL in the Secret. Defaults to "baseUrl".
+	// If the key is not in the Secret, the BEAMLIT_BASE_URL environment variable is used.
+	BaseURLKey *string `json:"base_url_key,omitempty" yaml:"baseUrlKey,omitempty"`
+}
+
+type BeamlitClientConfig struct {
+	// MaxRetries is the maximum number of retries of a failed request. Defaults to 3, 0 disables retries.
+	// Requests are retried on network errors, 429 and 5xx responses. Only idempotent requests are retried,
+	// except on 429 responses, which are never processed by Beamlit.
+	MaxRetries *int `json:"max_retries,omitempty" yaml:"maxRetries,omitempty"`
+	// InitialBackoff is the delay before the first retry, doubled on every retry with jitter. Defaults to "500ms".
+	InitialBackoff *string `json:"initial_backoff,omitempty" yaml:"initialBackoff,omitempty"`
+	// MaxBackoff is the maximum delay before a retry, including the delay requested by a Retry-After header. Defaults to "30s".
+	MaxBackoff *string `json:"max_backoff,omitempty" yaml:"maxBackoff,omitempty"`
+	// RequestsPerSecond is the rate limit of the requests to Beamlit, shared by all reconcilers. Defaults to 10, 0 disables the rate limit.
+	RequestsPerSecond *float64 `json:"requests_per_second,omitempty" yaml:"requestsPerSecond,omitempty"`
+	// Burst is the maximum number of requests sent at once under the rate limit. Defaults to 20.
+	Burst *int `json:"burst,omitempty" yaml:"burst,omitempty"`
+}
+
+type OffloadEventsConfig struct {
+	// FlushInterval is the delay between two reports of the buffered offloading transitions. Defaults to "10s".
+	FlushInterval *string `json:"flush_interval,omitempty" yaml:"flushInterval,omitempty"`
+	// BatchSize is the maximum number of transitions of a model reported in a request. Defaults to 50.
+	BatchSize *int `json:"batch_size,omitempty" yaml:"batchSize,omitempty"`
+	// BufferSize is the maximum number of transitions kept while Beamlit is unreachable, the oldest ones are dropped beyond. Defaults to 1000.
+	BufferSize *int `json:"buffer_size,omitempty" yaml:"bufferSize,omitempty"`
+}
+
+type TracingConfig struct {
+	// Endpoint is the host and port of the OTLP HTTP collector receiving the spans, e.g. "otel-collector:4318".
+	// Spans are not exported when empty.
+	Endpoint *string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	// Insecure sends the spans to the collector without TLS. Defaults to false.
+	Insecure *bool `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	// SamplingRatio is the ratio of the traces exported, between 0 and 1. Defaults to 1.
+	SamplingRatio *float64 `json:"sampling_ratio,omitempty" yaml:"samplingRatio,omitempty"`
+}
+
+type PodTemplateConfig struct {
+	// KeepFields are the cluster-specific fields of the pod spec kept in the pod templates pushed to Beamlit, e.g. "tolerations".
+	// By default, nodeSelector, nodeName, affinity, tolerations, topologySpreadConstraints, priorityClassName, schedulerName,
+	// runtimeClassName, serviceAccountName, imagePullSecrets and hostNetwork are stripped.
+	KeepFields []string `json:"keep_fields,omitempty" yaml:"keepFields,omitempty"`
+	// AllowedSecrets are the names of the Secrets whose keys are referenced as Beamlit secrets by the env vars of the pod templates, "*" for all.
+	// The env vars read from other Secrets are dropped.
+	AllowedSecrets []string `json:"allowed_secrets,omitempty" yaml:"allowedSecrets,omitempty"`
+	// SyncSecrets pushes to Beamlit secrets the values of the allowed Secrets read by the env vars of the pod templates. The secrets are deleted when the ModelDeployment is deleted. Defaults to false.
+	SyncSecrets *bool `json:"sync_secrets,omitempty" yaml:"syncSecrets,omitempty"`
+}
+
+type OffloaderType string
+
+const (
+	// OffloaderTypeBeamlitGateway routes the traffic of the models through the Beamlit gateway.
+	OffloaderTypeBeamlitGateway OffloaderType = "beamlit-gateway"
+	// OffloaderTypeGatewayAPI routes the traffic of the models with HTTPRoutes, programmed by the Gateway API implementation of the cluster.
+	OffloaderTypeGatewayAPI OffloaderType = "gateway-api"
+	// OffloaderTypeIstio routes the traffic of the models with VirtualServices, programmed in the sidecars of the Istio service mesh.
+	OffloaderTypeIstio OffloaderType = "istio"
+	// OffloaderTypeEnvoyXDS routes the traffic of the models through Envoy proxies, programmed by the xDS server of the controller.
+	OffloaderTypeEnvoyXDS OffloaderType = "envoy-xds"
+)
+
+type ConfigurerType string
+
+const (
+	// ConfigurerTypeKubernetes redirects the traffic of the model Services to the Beamlit gateway by taking over their EndpointSlices.
+	ConfigurerTypeKubernetes ConfigurerType = "kubernetes"
+	// ConfigurerTypeNoop leaves the model Services untouched, for the offloaders routing their traffic in a service mesh or a gateway.
+	ConfigurerTypeNoop ConfigurerType = "noop"
+	// ConfigurerTypeOffloadService leaves the model Services untouched, the clients reach the Beamlit gateway through a <svc>-offload Service
+	// or a Service whose selector is swapped.
+	ConfigurerTypeOffloadService ConfigurerType = "offload-service"
+)
+
+type ConfigurerConfig struct {
+	// Type is the type of the configurer. Defaults to "noop" with the istio and gateway-api offloaders, "kubernetes" otherwise.
+	Type *ConfigurerType `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+type OffloaderConfig struct {
+	// Type is the type of the offloader. Defaults to "beamlit-gateway".
+	Type *OffloaderType `json:"type,omitempty" yaml:"type,omitempty"`
+	// GatewayAPI is the configuration for the gateway-api offloader.
+	GatewayAPI *GatewayAPIOffloaderConfig `json:"gateway_api,omitempty" yaml:"gatewayAPI,omitempty"`
+	// EnvoyXDS is the configuration for the envoy-xds offloader.
+	EnvoyXDS *EnvoyXDSOffloaderConfig `json:"envoy_xds,omitempty" yaml:"envoyXDS,omitempty"`
+}
+
+type EnvoyXDSOffloaderConfig struct {
+	// Address is the address the xDS server listens on. Defaults to ":18000".
+	Address *string `json:"address,omitempty" yaml:"address,omitempty"`
+	// NodeID is the node ID of the Envoy proxies, set in their bootstrap configuration. Defaults to "beamlit-gateway".
+	NodeID *string `json:"node_id,omitempty" yaml:"nodeID,omitempty"`
+	// TLS is the certificate of the xDS server and the CA of the client certificates of the Envoy proxies, issued to their node ID.
+	// It is required, as the secrets of the remote backends are served to the proxies.
+	TLS *EnvoyXDSTLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+type EnvoyXDSTLSConfig struct {
+	// CertFile is the path of the PEM certificate of the xDS server.
+	CertFile string `json:"cert_file,omitempty" yaml:"certFile,omitempty"`
+	// KeyFile is the path of the PEM private key of the xDS server.
+	KeyFile string `json:"key_file,omitempty" yaml:"keyFile,omitempty"`
+	// ClientCAFile is the path of the PEM CA bundle verifying the client certificates of the Envoy proxies.
+	ClientCAFile string `json:"client_ca_file,omitempty" yaml:"clientCAFile,omitempty"`
+}
+
+type GatewayAPIOffloaderConfig struct {
+	// ParentRefs are the Gateways the HTTPRoutes of the models are attached to.
+	// If empty, the HTTPRoutes are attached to the Services of the models, for the service meshes supporting the Gateway API (GAMMA).
+	ParentRefs []GatewayParentRefConfig `json:"parent_refs,omitempty" yaml:"parentRefs,omitempty"`
+	// RemoteServiceImport is the ServiceImport exposing Beamlit in the namespaces of the models, used as remote backend.
+	// If not set, an ExternalName Service is created for the remote backend of each model.
+	RemoteServiceImport *ServiceImportConfig `json:"remote_service_import,omitempty" yaml:"remoteServiceImport,omitempty"`
+}
+
+type GatewayParentRefConfig struct {
+	// Namespace is the namespace of the Gateway. Defaults to the namespace of the model Service.
+	Namespace *string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Name is the name of the Gateway.
+	Name *string `json:"name,omitempty" yaml:"name,omitempty"`
+	// SectionName is the name of the listener of the Gateway. Defaults to all the listeners.
+	SectionName *string `json:"section_name,omitempty" yaml:"sectionName,omitempty"`
+}
+
+type ServiceImportConfig struct {
+	// Name is the name of the ServiceImport.
+	Name *string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Port is the port of the ServiceImport.
+	Port *int `json:"port,omitempty" yaml:"port,omitempty"`
+}
+
 type ProxyServiceConfig struct {
 	// Namespace is the namespace of the proxy service.
 	Namespace *string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
@@ -73,6 +236,15 @@ type ProxyServiceConfig struct {
 	AdminPort *int `json:"admin_port,omitempty" yaml:"adminPort,omitempty"`
 }
 
+type PolicyNamingStrategy string
+
+const (
+	// PolicyNamingStrategyPlain names policies on Beamlit after the Kubernetes resource.
+	PolicyNamingStrategyPlain PolicyNamingStrategy = "plain"
+	// PolicyNamingStrategyNamespaced names policies on Beamlit after the Kubernetes resource, prefixed by its namespace and suffixed by a short hash of both.
+	PolicyNamingStrategyNamespaced PolicyNamingStrategy = "namespaced"
+)
+
 type MetricInformerType string
 
 const (
@@ -97,6 +269,122 @@ func (c *Config) Validate() error {
 	if c.ProxyService.Namespace == nil || c.ProxyService.Name == nil || c.ProxyService.Port == nil || c.ProxyService.AdminPort == nil {
 		return fmt.Errorf("proxy service is not configured")
 	}
+	if c.BeamlitCredentials != nil && (c.BeamlitCredentials.Namespace == nil || c.BeamlitCredentials.Name == nil) {
+		return fmt.Errorf("beamlit credentials secret is not configured")
+	}
+	if c.BeamlitClient != nil {
+		if err := c.BeamlitClient.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.OffloadEvents != nil {
+		if err := c.OffloadEvents.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Tracing != nil && c.Tracing.SamplingRatio != nil && (*c.Tracing.SamplingRatio < 0 || *c.Tracing.SamplingRatio > 1) {
+		return fmt.Errorf("tracing sampling ratio must be between 0 and 1")
+	}
+	if c.Offloader != nil {
+		if err := c.Offloader.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.DefaultRemoteBackend.AuthConfig != nil && c.Offloader != nil && c.Offloader.Type != nil &&
+		(*c.Offloader.Type == OffloaderTypeGatewayAPI || *c.Offloader.Type == OffloaderTypeIstio) {
+		// The offloader can't refresh OAuth tokens, credentials must be set in a header
+		return fmt.Errorf("the %s offloader does not support the authConfig of the default remote backend, "+
+			"set defaultRemoteBackend.authConfig to null and the Authorization header in defaultRemoteBackend.headers instead", *c.Offloader.Type)
+	}
+	if c.Configurer != nil && c.Configurer.Type != nil {
+		switch *c.Configurer.Type {
+		case ConfigurerTypeKubernetes, ConfigurerTypeNoop, ConfigurerTypeOffloadService:
+		default:
+			return fmt.Errorf("unknown configurer type: %s", *c.Configurer.Type)
+		}
+	}
+	if c.PolicyNamingStrategy != nil {
+		switch *c.PolicyNamingStrategy {
+		case PolicyNamingStrategyPlain, PolicyNamingStrategyNamespaced:
+		default:
+			return fmt.Errorf("unknown policy naming strategy: %s", *c.PolicyNamingStrategy)
+		}
+	}
+	return nil
+}
+
+func (c *BeamlitClientConfig) Validate() error {
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		return fmt.Errorf("beamlit client max retries must not be negative")
+	}
+	for name, value := range map[string]*string{"initial backoff": c.InitialBackoff, "max backoff": c.MaxBackoff} {
+		if value == nil {
+			continue
+		}
+		if d, err := time.ParseDuration(*value); err != nil || d <= 0 {
+			return fmt.Errorf("beamlit client %s must be a positive duration: %s", name, *value)
+		}
+	}
+	if c.RequestsPerSecond != nil && *c.RequestsPerSecond < 0 {
+		return fmt.Errorf("beamlit client requests per second must not be negative")
+	}
+	if c.Burst != nil && *c.Burst < 1 {
+		return fmt.Errorf("beamlit client burst must be positive")
+	}
+	return nil
+}
+
+func (c *OffloadEventsConfig) Validate() error {
+	if c.FlushInterval != nil {
+		if d, err := time.ParseDuration(*c.FlushInterval); err != nil || d <= 0 {
+			return fmt.Errorf("offload events flush interval must be a positive duration: %s", *c.FlushInterval)
+		}
+	}
+	if c.BatchSize != nil && *c.BatchSize < 1 {
+		return fmt.Errorf("offload events batch size must be positive")
+	}
+	if c.BufferSize != nil && *c.BufferSize < 1 {
+		return fmt.Errorf("offload events buffer size must be positive")
+	}
+	return nil
+}
+
+func (c *OffloaderConfig) Validate() error {
+	if c.Type != nil {
+		switch *c.Type {
+		case OffloaderTypeBeamlitGateway, OffloaderTypeGatewayAPI, OffloaderTypeIstio, OffloaderTypeEnvoyXDS:
+		default:
+			return fmt.Errorf("unknown offloader type: %s", *c.Type)
+		}
+	}
+	if c.Type != nil && *c.Type == OffloaderTypeEnvoyXDS {
+		if c.EnvoyXDS == nil || c.EnvoyXDS.TLS == nil || c.EnvoyXDS.TLS.CertFile == "" || c.EnvoyXDS.TLS.KeyFile == "" || c.EnvoyXDS.TLS.ClientCAFile == "" {
+			return fmt.Errorf("envoy xds offloader requires the tls cert file, key file and client ca file")
+		}
+	}
+	if envoyXDS := c.EnvoyXDS; envoyXDS != nil {
+		if envoyXDS.NodeID != nil && *envoyXDS.NodeID == "" {
+			return fmt.Errorf("envoy xds offloader node id must not be empty")
+		}
+		if envoyXDS.Address != nil {
+			if _, _, err := net.SplitHostPort(*envoyXDS.Address); err != nil {
+				return fmt.Errorf("invalid envoy xds offloader address: %w", err)
+			}
+		}
+	}
+	if c.GatewayAPI == nil {
+		return nil
+	}
+	for _, parentRef := range c.GatewayAPI.ParentRefs {
+		if parentRef.Name == nil || *parentRef.Name == "" {
+			return fmt.Errorf("gateway api offloader parent refs must have a name")
+		}
+	}
+	if serviceImport := c.GatewayAPI.RemoteServiceImport; serviceImport != nil {
+		if serviceImport.Name == nil || *serviceImport.Name == "" || serviceImport.Port == nil {
+			return fmt.Errorf("gateway api offloader remote service import must have a name and a port")
+		}
+	}
 	return nil
 }
 
@@ -107,6 +395,7 @@ func (c *Config) Default() {
 	c.EnableLeaderElection = toPointer(false)
 	c.MetricsAddr = toPointer(":8080")
 	c.ProbeAddr = toPointer(":8081")
+	c.PolicyNamingStrategy = toPointer(PolicyNamingStrategyPlain)
 	c.MetricInformerConfig = &MetricInformersConfig{
 		Type: MetricInformerTypeKubernetes,
 	}
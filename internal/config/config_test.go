@@ -1,6 +1,10 @@
 package config
 
-import "testing"
+import (
+	"testing"
+
+	beamlitdeploymentv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
 
 func TestValidate(t *testing.T) {
 	type testCase struct {
@@ -52,6 +56,258 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		"When PolicyNamingStrategy is unknown, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				PolicyNamingStrategy: toPointer(PolicyNamingStrategy("unknown")),
+			},
+			wantErr: true,
+		},
+		"When BeamlitCredentials has no name, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				BeamlitCredentials: &BeamlitCredentialsConfig{
+					Namespace: toPointer("namespace"),
+				},
+			},
+			wantErr: true,
+		},
+		"When BeamlitClient InitialBackoff is not a duration, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				BeamlitClient: &BeamlitClientConfig{
+					InitialBackoff: toPointer("500"),
+				},
+			},
+			wantErr: true,
+		},
+		"When BeamlitClient is valid, must not return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				BeamlitClient: &BeamlitClientConfig{
+					MaxRetries:        toPointer(5),
+					InitialBackoff:    toPointer("1s"),
+					MaxBackoff:        toPointer("1m"),
+					RequestsPerSecond: toPointer(2.5),
+					Burst:             toPointer(5),
+				},
+			},
+			wantErr: false,
+		},
+		"When OffloadEvents BatchSize is not positive, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				OffloadEvents: &OffloadEventsConfig{
+					BatchSize: toPointer(0),
+				},
+			},
+			wantErr: true,
+		},
+		"When OffloadEvents is valid, must not return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				OffloadEvents: &OffloadEventsConfig{
+					FlushInterval: toPointer("30s"),
+					BatchSize:     toPointer(10),
+					BufferSize:    toPointer(100),
+				},
+			},
+			wantErr: false,
+		},
+		"When Tracing SamplingRatio is above 1, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Tracing: &TracingConfig{
+					Endpoint:      toPointer("otel-collector:4318"),
+					SamplingRatio: toPointer(1.5),
+				},
+			},
+			wantErr: true,
+		},
+		"When Offloader type is unknown, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type: toPointer(OffloaderType("unknown")),
+				},
+			},
+			wantErr: true,
+		},
+		"When Offloader gateway API parent ref has no name, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type: toPointer(OffloaderTypeGatewayAPI),
+					GatewayAPI: &GatewayAPIOffloaderConfig{
+						ParentRefs: []GatewayParentRefConfig{{Namespace: toPointer("gateway")}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		"When Offloader envoy xDS address has no port, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type: toPointer(OffloaderTypeEnvoyXDS),
+					EnvoyXDS: &EnvoyXDSOffloaderConfig{
+						Address: toPointer("0.0.0.0"),
+						TLS:     testEnvoyXDSTLS,
+					},
+				},
+			},
+			wantErr: true,
+		},
+		"When Offloader envoy xDS has no TLS, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type:     toPointer(OffloaderTypeEnvoyXDS),
+					EnvoyXDS: &EnvoyXDSOffloaderConfig{},
+				},
+			},
+			wantErr: true,
+		},
+		"When Offloader envoy xDS has TLS, must not return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type: toPointer(OffloaderTypeEnvoyXDS),
+					EnvoyXDS: &EnvoyXDSOffloaderConfig{
+						TLS: testEnvoyXDSTLS,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		"When the default remote backend uses OAuth with the gateway API offloader, must return an error": {
+			input: withOAuthRemoteBackend(&Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type: toPointer(OffloaderTypeGatewayAPI),
+				},
+			}),
+			wantErr: true,
+		},
+		"When the default remote backend uses OAuth with the istio offloader, must return an error": {
+			input: withOAuthRemoteBackend(&Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type: toPointer(OffloaderTypeIstio),
+				},
+			}),
+			wantErr: true,
+		},
+		"When the default remote backend uses OAuth with the Beamlit gateway offloader, must not return an error": {
+			input: withOAuthRemoteBackend(&Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Offloader: &OffloaderConfig{
+					Type: toPointer(OffloaderTypeBeamlitGateway),
+				},
+			}),
+			wantErr: false,
+		},
+		"When Configurer type is unknown, must return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				Configurer: &ConfigurerConfig{
+					Type: toPointer(ConfigurerType("unknown")),
+				},
+			},
+			wantErr: true,
+		},
+		"When PolicyNamingStrategy is namespaced, must not return an error": {
+			input: &Config{
+				ProxyService: ProxyServiceConfig{
+					Namespace: toPointer("namespace"),
+					Name:      toPointer("test"),
+					Port:      toPointer(8080),
+					AdminPort: toPointer(8081),
+				},
+				PolicyNamingStrategy: toPointer(PolicyNamingStrategyNamespaced),
+			},
+			wantErr: false,
+		},
 	}
 	for name, tc := range tcs {
 		t.Run(name, func(t *testing.T) {
@@ -61,3 +317,14 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+var testEnvoyXDSTLS = &EnvoyXDSTLSConfig{
+	CertFile:     "/etc/xds/tls.crt",
+	KeyFile:      "/etc/xds/tls.key",
+	ClientCAFile: "/etc/xds/ca.crt",
+}
+
+func withOAuthRemoteBackend(c *Config) *Config {
+	c.DefaultRemoteBackend.AuthConfig = &beamlitdeploymentv1alpha1.AuthConfig{Type: beamlitdeploymentv1alpha1.AuthTypeOAuth}
+	return c
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+)
+
+// BeamlitWorkspaceReconciler loads the credentials of a BeamlitWorkspace in the WorkspaceClients cache
+// The credentials Secrets of CredentialsNamespaces are watched, and their BeamlitWorkspaces reconciled when they change
+type BeamlitWorkspaceReconciler struct {
+	client.Client
+	Scheme           *runtime.Scheme
+	WorkspaceClients *WorkspaceClients
+	// CredentialsNamespaces are the namespaces of the credentials Secrets, in which the manager is allowed to watch Secrets
+	CredentialsNamespaces []string
+}
+
+//+kubebuilder:rbac:groups=authorization.beamlit.com,resources=beamlitworkspaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=authorization.beamlit.com,resources=beamlitworkspaces/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile loads the credentials of the BeamlitWorkspace and reports the result in its Ready condition
+func (r *BeamlitWorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.V(0).Info("Reconciling BeamlitWorkspace", "Name", req.Name)
+	var workspace authorizationv1alpha1.BeamlitWorkspace
+	if err := r.Get(ctx, req.NamespacedName, &workspace); err != nil {
+		if errors.IsNotFound(err) {
+			logger.V(0).Info("BeamlitWorkspace not found, removing its client", "Name", req.Name)
+			r.WorkspaceClients.Forget(req.Name)
+			return ctrl.Result{}, nil
+		}
+		logger.V(0).Error(err, "Failed to get BeamlitWorkspace")
+		return ctrl.Result{}, err
+	}
+
+	status := workspace.Status.DeepCopy()
+	_, loadErr := r.WorkspaceClients.Load(ctx, &workspace)
+	setReadyCondition(&workspace.Status.Conditions, workspace.Generation, loadErr)
+	if !equality.Semantic.DeepEqual(status, &workspace.Status) {
+		if err := r.Status().Update(ctx, &workspace); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			logger.V(0).Error(err, "Failed to update BeamlitWorkspace status")
+			return ctrl.Result{}, err
+		}
+	}
+	if loadErr != nil {
+		logger.V(0).Error(loadErr, "Failed to load Beamlit credentials of BeamlitWorkspace", "Name", workspace.Name)
+		return ctrl.Result{}, loadErr
+	}
+	return ctrl.Result{}, nil
+}
+
+// workspacesForSecret returns the BeamlitWorkspaces whose credentials are held by secret
+func (r *BeamlitWorkspaceReconciler) workspacesForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	var workspaces authorizationv1alpha1.BeamlitWorkspaceList
+	if err := r.List(ctx, &workspaces); err != nil {
+		log.FromContext(ctx).V(0).Error(err, "Failed to list BeamlitWorkspaces")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, workspace := range workspaces.Items {
+		secretRef := workspace.Spec.CredentialsSecretRef
+		if secretRef.Namespace == secret.GetNamespace() && secretRef.Name == secret.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: workspace.Name}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BeamlitWorkspaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&authorizationv1alpha1.BeamlitWorkspace{})
+	if len(r.CredentialsNamespaces) > 0 {
+		b = b.Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.workspacesForSecret),
+			builder.OnlyMetadata,
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				return slices.Contains(r.CredentialsNamespaces, obj.GetNamespace())
+			})))
+	}
+	return b.Complete(traced("BeamlitWorkspace", r))
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	"github.com/beamlit/beamlit-controller/internal/config"
+)
+
+// ClusterPolicyReconciler reconciles a ClusterPolicy object
+type ClusterPolicyReconciler struct {
+	client.Client
+	Scheme           *runtime.Scheme
+	WorkspaceClients *WorkspaceClients
+	ManagedPolicies  *ManagedPolicies
+	NamingStrategy   config.PolicyNamingStrategy
+}
+
+//+kubebuilder:rbac:groups=authorization.beamlit.com,resources=clusterpolicies,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=authorization.beamlit.com,resources=clusterpolicies/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=authorization.beamlit.com,resources=clusterpolicies/finalizers,verbs=update
+
+// Reconcile syncs a ClusterPolicy to Beamlit the same way as a Policy.
+func (r *ClusterPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.syncer().reconcile(ctx, req, &authorizationv1alpha1.ClusterPolicy{})
+}
+
+func (r *ClusterPolicyReconciler) syncer() *policySyncer {
+	return &policySyncer{
+		Client:           r.Client,
+		workspaceClients: r.WorkspaceClients,
+		managedPolicies:  r.ManagedPolicies,
+		namingStrategy:   r.NamingStrategy,
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&authorizationv1alpha1.ClusterPolicy{}).
+		Complete(traced("ClusterPolicy", r))
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/controller/helper"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/configurer"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/offloader"
+)
+
+const (
+	// conditionTypeAuthenticated reports whether the operator could authenticate on Beamlit to sync the resource
+	conditionTypeAuthenticated = "Authenticated"
+
+	reasonAuthenticated        = "Authenticated"
+	reasonAuthenticationFailed = "AuthenticationFailed"
+)
+
+// setAuthenticatedCondition sets the Authenticated condition, to false if err is not nil
+func setAuthenticatedCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               conditionTypeAuthenticated,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonAuthenticated,
+		Message:            "Successfully authenticated on Beamlit",
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonAuthenticationFailed
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+const (
+	// conditionTypeReady reports whether the credentials of a BeamlitWorkspace could be loaded
+	conditionTypeReady = "Ready"
+
+	reasonCredentialsLoaded      = "CredentialsLoaded"
+	reasonCredentialsUnavailable = "CredentialsUnavailable"
+)
+
+// setReadyCondition sets the Ready condition, to false if err is not nil
+func setReadyCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               conditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonCredentialsLoaded,
+		Message:            "Successfully loaded Beamlit credentials",
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonCredentialsUnavailable
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+const (
+	// conditionTypeSynced reports whether the resource is in sync with Beamlit
+	conditionTypeSynced = "Synced"
+
+	reasonSynced              = "Synced"
+	reasonInvalidSpec         = "InvalidSpec"
+	reasonWorkspaceNotAllowed = "WorkspaceNotAllowed"
+	reasonUnresolvedPolicy    = "UnresolvedPolicy"
+	reasonUnsupportedVolume   = "UnsupportedVolume"
+)
+
+// setSyncedCondition sets the Synced condition, to false if the resource can't be synced because of err:
+// Beamlit rejected it, its workspace is not allowed in its namespace, one of its policies can't be referenced
+// or the pod template of its model source mounts a volume which can't be pushed
+func setSyncedCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               conditionTypeSynced,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonSynced,
+		Message:            "Successfully synced to Beamlit",
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonInvalidSpec
+		switch {
+		case isWorkspaceNotAllowed(err):
+			condition.Reason = reasonWorkspaceNotAllowed
+		case helper.IsUnresolvedPolicy(err):
+			condition.Reason = reasonUnresolvedPolicy
+		case helper.IsUnsupportedVolume(err):
+			condition.Reason = reasonUnsupportedVolume
+		}
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+// setErrorCondition sets the condition reporting err to the user, when err is caused by the credentials, the spec of the resource, its Service or its policies
+// It returns false if err is not reported in a condition
+func setErrorCondition(conditions *[]metav1.Condition, generation int64, err error) bool {
+	switch {
+	case beamlit.IsAuthError(err):
+		setAuthenticatedCondition(conditions, generation, err)
+	case isValidationError(err), isWorkspaceNotAllowed(err), helper.IsUnresolvedPolicy(err), helper.IsUnsupportedVolume(err):
+		setSyncedCondition(conditions, generation, err)
+	case configurer.IsUnsupportedService(err):
+		setServiceConfiguredCondition(conditions, generation, err)
+	case offloader.IsUnsupportedPolicy(err):
+		setPoliciesEnforcedCondition(conditions, generation, err)
+	default:
+		return false
+	}
+	return true
+}
+
+const (
+	// conditionTypeServiceConfigured reports whether the Service of a model deployment is configured to be offloaded
+	conditionTypeServiceConfigured = "ServiceConfigured"
+
+	reasonServiceConfigured          = "ServiceConfigured"
+	reasonServiceReconfigured        = "ServiceReconfigured"
+	reasonServiceConfigurationFailed = "ServiceConfigurationFailed"
+	reasonServiceDeleted             = "ServiceDeleted"
+	reasonUnsupportedService         = "UnsupportedService"
+)
+
+// setServiceConfiguredCondition sets the ServiceConfigured condition, to false if err is not nil
+func setServiceConfiguredCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               conditionTypeServiceConfigured,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonServiceConfigured,
+		Message:            "Successfully configured the Service to be offloaded",
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonServiceConfigurationFailed
+		if configurer.IsUnsupportedService(err) {
+			condition.Reason = reasonUnsupportedService
+		}
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+// setServiceEventCondition sets the ServiceConfigured condition from a change of the Service applied by the configurer
+func setServiceEventCondition(conditions *[]metav1.Condition, generation int64, event configurer.ServiceEvent) {
+	condition := metav1.Condition{
+		Type:               conditionTypeServiceConfigured,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonServiceReconfigured,
+		Message:            "Successfully configured the Service again after it changed",
+	}
+	switch {
+	case event.Type == configurer.ServiceEventDeleted:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonServiceDeleted
+		condition.Message = "The Service was deleted, the model is not offloaded until it is created again"
+		if event.Err != nil {
+			condition.Message = fmt.Sprintf("The Service was deleted, but the objects created to offload it could not be deleted: %s", event.Err)
+		}
+	case configurer.IsUnsupportedService(event.Err):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonUnsupportedService
+		condition.Message = fmt.Sprintf("The Service changed and can't be offloaded anymore, the model is not offloaded until the ModelDeployment is updated: %s", event.Err)
+	case event.Err != nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonServiceConfigurationFailed
+		condition.Message = fmt.Sprintf("The Service changed and could not be configured again: %s", event.Err)
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+const (
+	// conditionTypePoliciesEnforced reports whether the gateway policies of a model deployment are enforced on its offloaded traffic
+	conditionTypePoliciesEnforced = "PoliciesEnforced"
+
+	reasonPoliciesEnforced  = "PoliciesEnforced"
+	reasonUnsupportedPolicy = "UnsupportedPolicy"
+)
+
+// setPoliciesEnforcedCondition sets the PoliciesEnforced condition, to false if the offloader can't enforce the gateway policies
+func setPoliciesEnforcedCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               conditionTypePoliciesEnforced,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonPoliciesEnforced,
+		Message:            "The gateway policies are enforced on the offloaded traffic",
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonUnsupportedPolicy
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
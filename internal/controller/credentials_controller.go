@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+)
+
+// CredentialsReconciler watches the Secret holding the Beamlit credentials,
+// and rotates the credentials of the Beamlit client when it changes
+type CredentialsReconciler struct {
+	client.Client
+	// SecretReader reads the Secret, only the metadata of Secrets is cached by the manager
+	SecretReader  client.Reader
+	BeamlitClient *beamlit.Client
+
+	// SecretRef is the reference to the Secret holding the Beamlit credentials
+	SecretRef types.NamespacedName
+	// TokenKey is the key of the Beamlit token in the Secret
+	TokenKey string
+	// BaseURLKey is the key of the Beamlit base URL in the Secret
+	BaseURLKey string
+	// DefaultBaseURL is the base URL used when the Secret has no BaseURLKey
+	DefaultBaseURL string
+
+	lastResourceVersion string
+}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile loads the credentials of the Secret in the Beamlit client
+func (r *CredentialsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if err := r.LoadCredentials(ctx, r.SecretReader); err != nil {
+		if errors.IsNotFound(err) {
+			logger.V(0).Info("Beamlit credentials Secret not found, keeping current credentials", "Name", req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		logger.V(0).Error(err, "Failed to load Beamlit credentials")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// LoadCredentials reads the Secret with reader and sets its credentials in the Beamlit client
+// The credentials are not reloaded if the Secret has not changed since the last call
+func (r *CredentialsReconciler) LoadCredentials(ctx context.Context, reader client.Reader) error {
+	var secret corev1.Secret
+	if err := reader.Get(ctx, r.SecretRef, &secret); err != nil {
+		return err
+	}
+	if secret.ResourceVersion == r.lastResourceVersion {
+		return nil
+	}
+	token, ok := secret.Data[r.TokenKey]
+	if !ok {
+		return fmt.Errorf("key %s not found in Secret %s", r.TokenKey, r.SecretRef.String())
+	}
+	baseURL := r.DefaultBaseURL
+	if value, ok := secret.Data[r.BaseURLKey]; ok {
+		baseURL = string(value)
+	}
+	if err := r.BeamlitClient.SetCredentials(baseURL, string(token)); err != nil {
+		return err
+	}
+	r.lastResourceVersion = secret.ResourceVersion
+	log.FromContext(ctx).V(0).Info("Loaded Beamlit credentials", "Secret", r.SecretRef.String())
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CredentialsReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("beamlit-credentials").
+		For(&corev1.Secret{}, builder.OnlyMetadata, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return client.ObjectKeyFromObject(obj) == r.SecretRef
+		}))).
+		Complete(r)
+}
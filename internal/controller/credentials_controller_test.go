@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+// invalidToken is a Beamlit token rejected by the fake Beamlit API
+const invalidToken = "aW52YWxpZDppbnZhbGlk"
+
+func TestCredentialsReconcilerRotatesCredentials(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	secretRef := types.NamespacedName{Namespace: "beamlit-system", Name: "beamlit-credentials"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: secretRef.Namespace, Name: secretRef.Name},
+		Data:       map[string][]byte{"token": []byte(invalidToken), "baseURL": []byte(server.URL)},
+	}
+	kubernetesClient := fake.NewClientBuilder().WithObjects(secret).Build()
+	beamlitClient, err := beamlit.NewClientWithCredentials(server.URL, invalidToken, beamlit.WithRequestPolicy(&beamlit.RequestPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconciler := &CredentialsReconciler{
+		Client:        kubernetesClient,
+		SecretReader:  kubernetesClient,
+		BeamlitClient: beamlitClient,
+		SecretRef:     secretRef,
+		TokenKey:      "token",
+		BaseURLKey:    "baseURL",
+	}
+	reconcile := func() {
+		t.Helper()
+		if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: secretRef}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcile()
+	if _, err := beamlitClient.ListPolicies(ctx); !beamlit.IsAuthError(err) {
+		t.Fatalf("want an AuthError with the initial credentials but got %v", err)
+	}
+
+	secret.Data["token"] = []byte(server.Token())
+	if err := kubernetesClient.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+	if _, err := beamlitClient.ListPolicies(ctx); err != nil {
+		t.Errorf("want the client rebuilt with the rotated credentials but got %v", err)
+	}
+
+	// The Secret is unchanged, so the client set by someone else is kept
+	if err := beamlitClient.SetCredentials(server.URL, invalidToken); err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+	if _, err := beamlitClient.ListPolicies(ctx); !beamlit.IsAuthError(err) {
+		t.Errorf("want the credentials not reloaded while the Secret is unchanged but got %v", err)
+	}
+
+	if err := kubernetesClient.Delete(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/controller/helper"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/configurer"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/offloader"
+)
+
+// minRateLimitedRequeue is the delay before reconciling again a resource rate limited by Beamlit without Retry-After
+const minRateLimitedRequeue = time.Second
+
+// beamlitErrorResult returns the result of a reconcile which failed with err
+// Requests rate limited by Beamlit or conflicting with a concurrent change are requeued without reporting an error,
+// and resources rejected by Beamlit or by their workspace, whose policies can't be referenced or enforced, or whose Service can't be offloaded,
+// are not retried until they change. The model sources are not watched, so a pod template mounting an unsupported volume is retried.
+func beamlitErrorResult(err error) (ctrl.Result, error) {
+	var rateLimitedErr *beamlit.ErrRateLimited
+	if errors.As(err, &rateLimitedErr) {
+		return ctrl.Result{RequeueAfter: max(rateLimitedErr.RetryAfter, minRateLimitedRequeue)}, nil
+	}
+	var conflictErr *beamlit.ErrConflict
+	if errors.As(err, &conflictErr) {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	if isValidationError(err) || isWorkspaceNotAllowed(err) || helper.IsUnresolvedPolicy(err) ||
+		configurer.IsUnsupportedService(err) || offloader.IsUnsupportedPolicy(err) {
+		return ctrl.Result{}, reconcile.TerminalError(err)
+	}
+	return ctrl.Result{}, err
+}
+
+// ErrWorkspaceNotAllowed is returned when a resource selects a BeamlitWorkspace whose namespace selector doesn't match its namespace
+type ErrWorkspaceNotAllowed struct {
+	Workspace string
+	Namespace string
+}
+
+func (e *ErrWorkspaceNotAllowed) Error() string {
+	return fmt.Sprintf("BeamlitWorkspace %s can't be selected by the resources of namespace %s", e.Workspace, e.Namespace)
+}
+
+// isWorkspaceNotAllowed returns true if err is caused by a resource selecting a BeamlitWorkspace not allowed in its namespace
+func isWorkspaceNotAllowed(err error) bool {
+	var notAllowedErr *ErrWorkspaceNotAllowed
+	return errors.As(err, &notAllowedErr)
+}
+
+// isValidationError returns true if Beamlit rejected the resource sent by the operator
+func isValidationError(err error) bool {
+	var validationErr *beamlit.ErrValidation
+	return errors.As(err, &validationErr)
+}
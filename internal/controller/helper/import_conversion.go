@@ -0,0 +1,175 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	beamlit "github.com/beamlit/toolkit/sdk"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+// operatorLabels are the labels set by the operator on Beamlit resources, they are not imported back
+var operatorLabels = map[string]struct{}{
+	"managed-by":         {},
+	"owner":              {},
+	"offloading":         {},
+	"offloading-enabled": {},
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// FromBeamlitPolicy converts a Beamlit Policy to a Policy in namespace
+// The policy keeps its name on Beamlit through spec.remoteName, whatever the naming strategy
+// It returns an error if the policy type is not supported by the Policy resource
+func FromBeamlitPolicy(beamlitPolicy beamlit.Policy, namespace string) (*authorizationv1alpha1.Policy, error) {
+	if beamlitPolicy.Metadata == nil || beamlitPolicy.Metadata.Name == nil {
+		return nil, fmt.Errorf("policy name is required")
+	}
+	name := *beamlitPolicy.Metadata.Name
+	policy := &authorizationv1alpha1.Policy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: authorizationv1alpha1.GroupVersion.String(),
+			Kind:       "Policy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ToKubernetesName(name),
+			Namespace: namespace,
+			Labels:    fromBeamlitLabels(beamlitPolicy.Metadata.Labels),
+		},
+		Spec: authorizationv1alpha1.PolicySpec{
+			RemoteName: name,
+		},
+	}
+	if beamlitPolicy.Metadata.DisplayName != nil {
+		policy.Spec.DisplayName = *beamlitPolicy.Metadata.DisplayName
+	}
+	if beamlitPolicy.Spec == nil || beamlitPolicy.Spec.Type == nil {
+		return nil, fmt.Errorf("policy %s has no type", name)
+	}
+	switch authorizationv1alpha1.PolicyType(*beamlitPolicy.Spec.Type) {
+	case authorizationv1alpha1.PolicyTypeLocation:
+		policy.Spec.Type = authorizationv1alpha1.PolicyTypeLocation
+		if beamlitPolicy.Spec.Locations != nil {
+			for _, location := range *beamlitPolicy.Spec.Locations {
+				policy.Spec.Locations = append(policy.Spec.Locations, authorizationv1alpha1.PolicyLocation{
+					Type: authorizationv1alpha1.PolicySubTypeLocation(fromPtr(location.Type)),
+					Name: fromPtr(location.Name),
+				})
+			}
+		}
+	case authorizationv1alpha1.PolicyTypeFlavor:
+		policy.Spec.Type = authorizationv1alpha1.PolicyTypeFlavor
+		if beamlitPolicy.Spec.Flavors != nil {
+			for _, flavor := range *beamlitPolicy.Spec.Flavors {
+				policy.Spec.Flavors = append(policy.Spec.Flavors, authorizationv1alpha1.PolicyFlavor{
+					Type: fromPtr(flavor.Type),
+					Name: fromPtr(flavor.Name),
+				})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("policy %s has unsupported type %s", name, *beamlitPolicy.Spec.Type)
+	}
+	if beamlitPolicy.Spec.ResourceTypes != nil {
+		policy.Spec.ResourceTypes = append(policy.Spec.ResourceTypes, *beamlitPolicy.Spec.ResourceTypes...)
+	}
+	return policy, nil
+}
+
+// FromBeamlitModel converts a Beamlit Model to a ModelDeployment in namespace
+// Beamlit does not know where the model runs in the cluster, so spec.modelSourceRef is left empty and must be set before applying
+// Policies are referenced as remote policies
+func FromBeamlitModel(model beamlit.Model, namespace string) (*modelv1alpha1.ModelDeployment, error) {
+	if model.Metadata == nil || model.Metadata.Name == nil {
+		return nil, fmt.Errorf("model name is required")
+	}
+	name := ToKubernetesName(*model.Metadata.Name)
+	modelDeployment := &modelv1alpha1.ModelDeployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: modelv1alpha1.GroupVersion.String(),
+			Kind:       "ModelDeployment",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    fromBeamlitLabels(model.Metadata.Labels),
+		},
+		Spec: modelv1alpha1.ModelDeploymentSpec{
+			Model:       *model.Metadata.Name,
+			Enabled:     true,
+			Environment: fromPtr(model.Metadata.Environment),
+		},
+	}
+	if model.Spec == nil {
+		return modelDeployment, nil
+	}
+	if model.Spec.Enabled != nil {
+		modelDeployment.Spec.Enabled = *model.Spec.Enabled
+	}
+	if model.Spec.Policies != nil {
+		for _, policy := range *model.Spec.Policies {
+			modelDeployment.Spec.Policies = append(modelDeployment.Spec.Policies, modelv1alpha1.PolicyRef{
+				RefType: modelv1alpha1.PolicyRefTypeRemotePolicy,
+				Name:    policy,
+			})
+		}
+	}
+	if serverlessConfig := model.Spec.ServerlessConfig; serverlessConfig != nil {
+		modelDeployment.Spec.ServerlessConfig = &modelv1alpha1.ServerlessConfig{
+			MinNumReplicas:         int32(fromPtr(serverlessConfig.MinNumReplicas)), //nolint:gosec
+			MaxNumReplicas:         int32(fromPtr(serverlessConfig.MaxNumReplicas)), //nolint:gosec
+			Metric:                 serverlessConfig.Metric,
+			Target:                 serverlessConfig.Target,
+			ScaleDownDelay:         serverlessConfig.ScaleDownDelay,
+			StableWindow:           serverlessConfig.StableWindow,
+			LastPodRetentionPeriod: serverlessConfig.LastPodRetentionPeriod,
+		}
+		if serverlessConfig.ScaleUpMinimum != nil {
+			modelDeployment.Spec.ServerlessConfig.ScaleUpMinimum = toPtr(int32(*serverlessConfig.ScaleUpMinimum)) //nolint:gosec
+		}
+	}
+	return modelDeployment, nil
+}
+
+// ToKubernetesName converts a Beamlit resource name to a valid Kubernetes resource name
+func ToKubernetesName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(name, "-.")
+}
+
+func fromBeamlitLabels(labels *beamlit.MetadataLabels) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	kubernetesLabels := make(map[string]string)
+	for key, value := range *labels {
+		if _, ok := operatorLabels[key]; ok {
+			continue
+		}
+		kubernetesLabels[key] = value
+	}
+	if len(kubernetesLabels) == 0 {
+		return nil
+	}
+	return kubernetesLabels
+}
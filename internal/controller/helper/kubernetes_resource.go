@@ -23,6 +23,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -56,15 +57,49 @@ func retrievePodTemplate(ctx context.Context, kubernetesClient client.Client, ki
 	return *podTemplate, nil
 }
 
-func RetrievePodPort(ctx context.Context, kubernetesClient client.Client, serviceReference *corev1.ObjectReference, targetPort int) (int, error) {
+// RetrievePodPort returns the port of the pods targeted by a port of a Service. A named target port is resolved against the containers
+// of the pod template of sourceRef, and a port without target port targets the same port of the pods.
+func RetrievePodPort(ctx context.Context, kubernetesClient client.Client, serviceReference *corev1.ObjectReference, targetPort int, sourceRef *corev1.ObjectReference) (int, error) {
 	service := corev1.Service{}
 	if err := kubernetesClient.Get(ctx, types.NamespacedName{Name: serviceReference.Name, Namespace: serviceReference.Namespace}, &service); err != nil {
 		return 0, err
 	}
 	for _, port := range service.Spec.Ports {
-		if int(port.Port) == targetPort {
+		if int(port.Port) != targetPort {
+			continue
+		}
+		switch {
+		case port.TargetPort.Type == intstr.String:
+			return resolveNamedPort(ctx, kubernetesClient, sourceRef, port)
+		case port.TargetPort.IntVal == 0:
+			return targetPort, nil
+		default:
 			return int(port.TargetPort.IntVal), nil
 		}
 	}
 	return 0, fmt.Errorf("port %d not found", targetPort)
 }
+
+// resolveNamedPort returns the container port of the pod template of sourceRef named as the target port of a Service port
+func resolveNamedPort(ctx context.Context, kubernetesClient client.Client, sourceRef *corev1.ObjectReference, servicePort corev1.ServicePort) (int, error) {
+	podTemplate, err := retrievePodTemplate(ctx, kubernetesClient, sourceRef.Kind, sourceRef.Name, sourceRef.Namespace)
+	if err != nil {
+		return 0, err
+	}
+	protocol := servicePort.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	for _, container := range podTemplate.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			containerProtocol := containerPort.Protocol
+			if containerProtocol == "" {
+				containerProtocol = corev1.ProtocolTCP
+			}
+			if containerPort.Name == servicePort.TargetPort.StrVal && containerProtocol == protocol {
+				return int(containerPort.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("named port %s not found in the pod template of %s %s/%s", servicePort.TargetPort.StrVal, sourceRef.Kind, sourceRef.Namespace, sourceRef.Name)
+}
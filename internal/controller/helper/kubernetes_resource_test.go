@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRetrievePodPort(t *testing.T) {
+	template := newModelTemplate()
+	template.Spec.Containers[0].Ports = []corev1.ContainerPort{
+		{Name: "http", ContainerPort: 8080},
+		{Name: "grpc", ContainerPort: 9000},
+	}
+	kubernetesClient := fake.NewClientBuilder().WithObjects(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+				{Name: "grpc", Port: 9000, TargetPort: intstr.FromString("grpc")},
+				{Name: "metrics", Port: 9090},
+				{Name: "admin", Port: 9091, TargetPort: intstr.FromString("admin")},
+			}},
+		},
+	).Build()
+	serviceRef := &corev1.ObjectReference{Namespace: "default", Name: "model"}
+	sourceRef := &corev1.ObjectReference{Kind: "Deployment", Namespace: "default", Name: "model"}
+
+	type testCase struct {
+		port    int
+		want    int
+		wantErr bool
+	}
+	tcs := map[string]testCase{
+		"When the target port is a number, must return it": {
+			port: 80,
+			want: 8080,
+		},
+		"When the target port is named, must return the container port of the same name": {
+			port: 9000,
+			want: 9000,
+		},
+		"When the target port is not set, must return the port of the service": {
+			port: 9090,
+			want: 9090,
+		},
+		"When no container port has the name of the target port, must return an error": {
+			port:    9091,
+			wantErr: true,
+		},
+		"When the service has no such port, must return an error": {
+			port:    7000,
+			wantErr: true,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			got, err := RetrievePodPort(context.Background(), kubernetesClient, serviceRef, tc.port, sourceRef)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("wantErr is %v but err is %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want port %d but got %d", tc.want, got)
+			}
+		})
+	}
+}
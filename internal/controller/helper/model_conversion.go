@@ -18,19 +18,28 @@ package helper
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 
 	beamlit "github.com/beamlit/toolkit/sdk"
-	"github.com/mitchellh/mapstructure"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
 	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
 )
 
 // Convert converts a ModelDeployment to a Beamlit ModelDeployment
 // It is used by the controller to convert the Kubernetes resource to the Beamlit API resource
-func ToBeamlitModelDeployment(ctx context.Context, kubernetesClient client.Client, modelDeployment *modelv1alpha1.ModelDeployment) (beamlit.Model, error) {
+// The pod template of the model source is rewritten by transformer, the changes it made are returned as a conversion report
+// workspace is the BeamlitWorkspace the model is synced to, the referenced policies must be synced to the same workspace
+func ToBeamlitModelDeployment(ctx context.Context, kubernetesClient client.Client, transformer *PodTemplateTransformer, modelDeployment *modelv1alpha1.ModelDeployment, workspace string) (beamlit.Model, []modelv1alpha1.ConversionReportEntry, error) {
 	logger := log.FromContext(ctx)
 	logger.V(2).Info("Converting ModelDeployment to Beamlit ModelDeployment", "Name", modelDeployment.Name)
 
@@ -51,10 +60,16 @@ func ToBeamlitModelDeployment(ctx context.Context, kubernetesClient client.Clien
 				ServingPort: toPtr(int(modelDeployment.Status.ServingPort)),
 				MetricPort:  toPtr(int(modelDeployment.Status.MetricPort)),
 			},
-			Policies: toBeamlitPolicies(modelDeployment.Spec.Policies),
 		},
 	}
 
+	policies, err := toBeamlitPolicies(ctx, kubernetesClient, modelDeployment.Namespace, workspace, modelDeployment.Spec.Policies)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to convert policies to Beamlit policies", "Name", modelDeployment.Name)
+		return beamlit.Model{}, nil, err
+	}
+	beamlitModelDeployment.Spec.Policies = policies
+
 	if modelDeployment.Spec.ServerlessConfig != nil {
 		var scaleUpMinimum *int
 		if modelDeployment.Spec.ServerlessConfig.ScaleUpMinimum != nil {
@@ -76,17 +91,22 @@ func ToBeamlitModelDeployment(ctx context.Context, kubernetesClient client.Clien
 	template, err := retrievePodTemplate(ctx, kubernetesClient, modelDeployment.Spec.ModelSourceRef.Kind, modelDeployment.Spec.ModelSourceRef.Name, modelDeployment.Spec.ModelSourceRef.Namespace)
 	if err != nil {
 		logger.V(0).Error(err, "Failed to convert pod template to Beamlit pod template", "Name", modelDeployment.Name)
-		return beamlit.Model{}, err
+		return beamlit.Model{}, nil, err
 	}
-	logger.V(2).Info("Successfully converted pod template to Beamlit pod template", "Name", modelDeployment.Name)
-	var podTemplate map[string]interface{} // TODO: Use a better type
-	if err := mapstructure.Decode(template, &podTemplate); err != nil {
+	podTemplate, report, err := transformer.Transform(ctx, modelDeployment.Spec.ModelSourceRef.Namespace, template)
+	if err != nil {
 		logger.V(0).Error(err, "Failed to convert pod template to Beamlit pod template", "Name", modelDeployment.Name)
-		return beamlit.Model{}, err
+		return beamlit.Model{}, nil, err
 	}
+	logger.V(2).Info("Successfully converted pod template to Beamlit pod template", "Name", modelDeployment.Name, "Changes", len(report))
 	beamlitModelDeployment.Spec.PodTemplate = &podTemplate
 	logger.V(2).Info("Successfully converted ModelDeployment to Beamlit ModelDeployment", "Name", modelDeployment.Name)
-	return beamlitModelDeployment, nil
+	return beamlitModelDeployment, report, nil
+}
+
+// ModelOwner returns the owner set on the model on Beamlit, <namespace>/<name> of the ModelDeployment
+func ModelOwner(modelDeployment *modelv1alpha1.ModelDeployment) string {
+	return fmt.Sprintf("%s/%s", modelDeployment.Namespace, modelDeployment.Name)
 }
 
 func withOffloadingEnabled(labels map[string]string) {
@@ -105,15 +125,165 @@ func toBeamlitLabels(labels map[string]string, opts ...func(labels map[string]st
 	return beamlitLabels
 }
 
-func toBeamlitPolicies(policies []modelv1alpha1.PolicyRef) *[]string {
-	beamlitPolicies := make([]string, len(policies))
-	for i, policy := range policies {
-		switch policy.RefType {
-		case modelv1alpha1.PolicyRefTypeRemotePolicy:
-			beamlitPolicies[i] = policy.Name
-		case modelv1alpha1.PolicyRefTypeLocalPolicy:
-			beamlitPolicies[i] = policy.Ref.Name
+// ErrUnresolvedPolicy is returned when a policy referenced by a model can't be referenced on Beamlit, as long as the policy is unchanged
+type ErrUnresolvedPolicy struct {
+	Policy string
+	Reason string
+}
+
+func (e *ErrUnresolvedPolicy) Error() string {
+	return fmt.Sprintf("policy %s can't be referenced: %s", e.Policy, e.Reason)
+}
+
+// IsUnresolvedPolicy returns true if err is caused by a referenced policy which can't be referenced on Beamlit
+func IsUnresolvedPolicy(err error) bool {
+	var unresolvedErr *ErrUnresolvedPolicy
+	return errors.As(err, &unresolvedErr)
+}
+
+// toBeamlitPolicies converts policy references to policy names on Beamlit
+// Local policies are resolved to the name they were synced with on Beamlit, they must be synced to the workspace of the model
+// Gateway policies are enforced locally, so they are not referenced on Beamlit
+func toBeamlitPolicies(ctx context.Context, kubernetesClient client.Client, namespace string, workspace string, policies []modelv1alpha1.PolicyRef) (*[]string, error) {
+	beamlitPolicies := make([]string, 0, len(policies))
+	for _, policyRef := range policies {
+		if policyRef.RefType == modelv1alpha1.PolicyRefTypeRemotePolicy {
+			beamlitPolicies = append(beamlitPolicies, policyRef.Name)
+			continue
+		}
+		policy, err := GetReferencedPolicy(ctx, kubernetesClient, namespace, policyRef)
+		if err != nil {
+			return nil, err
+		}
+		if policy.GetPolicySpec().Type == authorizationv1alpha1.PolicyTypeGateway {
+			continue
+		}
+		status := policy.GetPolicyStatus()
+		if status.RemoteName == "" {
+			return nil, &ErrUnresolvedPolicy{Policy: policyKey(policy), Reason: "it has not been synced to Beamlit yet"}
+		}
+		if status.WorkspaceRef != workspace {
+			return nil, &ErrUnresolvedPolicy{
+				Policy: policyKey(policy),
+				Reason: fmt.Sprintf("it is synced to %s, but the model is synced to %s", describeWorkspace(status.WorkspaceRef), describeWorkspace(workspace)),
+			}
+		}
+		beamlitPolicies = append(beamlitPolicies, status.RemoteName)
+	}
+	return &beamlitPolicies, nil
+}
+
+// policyKey returns namespace/name of a Policy, or the name of a ClusterPolicy
+func policyKey(policy authorizationv1alpha1.PolicyObject) string {
+	if policy.GetNamespace() == "" {
+		return policy.GetName()
+	}
+	return client.ObjectKeyFromObject(policy).String()
+}
+
+// describeWorkspace describes the BeamlitWorkspace named name in an error message, an empty name stands for the workspace of the operator
+func describeWorkspace(name string) string {
+	if name == "" {
+		return "the workspace of the operator"
+	}
+	return fmt.Sprintf("the BeamlitWorkspace %s", name)
+}
+
+// GatewayPolicies returns the gateway policies referenced by a ModelDeployment
+func GatewayPolicies(ctx context.Context, kubernetesClient client.Client, model *modelv1alpha1.ModelDeployment) ([]authorizationv1alpha1.PolicyObject, error) {
+	var gatewayPolicies []authorizationv1alpha1.PolicyObject
+	for _, policyRef := range model.Spec.Policies {
+		if policyRef.RefType == modelv1alpha1.PolicyRefTypeRemotePolicy {
+			continue
 		}
+		policy, err := GetReferencedPolicy(ctx, kubernetesClient, model.Namespace, policyRef)
+		if err != nil {
+			return nil, err
+		}
+		if policy.GetPolicySpec().Type == authorizationv1alpha1.PolicyTypeGateway {
+			gatewayPolicies = append(gatewayPolicies, policy)
+		}
+	}
+	return gatewayPolicies, nil
+}
+
+// ReferencesPolicy returns true if a ModelDeployment references policy, through a local or a cluster policy reference
+func ReferencesPolicy(model *modelv1alpha1.ModelDeployment, policy authorizationv1alpha1.PolicyObject) bool {
+	refType := modelv1alpha1.PolicyRefTypeLocalPolicy
+	if policy.GetNamespace() == "" {
+		refType = modelv1alpha1.PolicyRefTypeClusterPolicy
+	} else if policy.GetNamespace() != model.Namespace {
+		return false
+	}
+	for _, policyRef := range model.Spec.Policies {
+		if policyRef.RefType == refType && policyRefName(policyRef) == policy.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+// PoliciesFingerprint returns a hash of the Policies and ClusterPolicies referenced by a ModelDeployment, as resolved by the operator
+// It changes when a referenced policy is created, updated, synced under another name or to another workspace, or deleted
+func PoliciesFingerprint(ctx context.Context, kubernetesClient client.Client, model *modelv1alpha1.ModelDeployment) (string, error) {
+	hash := sha256.New()
+	for _, policyRef := range model.Spec.Policies {
+		if policyRef.RefType == modelv1alpha1.PolicyRefTypeRemotePolicy {
+			continue
+		}
+		policy, err := GetReferencedPolicy(ctx, kubernetesClient, model.Namespace, policyRef)
+		if apierrors.IsNotFound(err) {
+			fmt.Fprintf(hash, "%s/%s:missing\n", policyRef.RefType, policyRefName(policyRef))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		status := policy.GetPolicyStatus()
+		fmt.Fprintf(hash, "%s/%s:%s:%d:%s:%s\n", policyRef.RefType, policyRefName(policyRef), policy.GetUID(), policy.GetGeneration(), status.RemoteName, status.WorkspaceRef)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ModelFingerprint returns a hash of a model converted for Beamlit
+// It changes when the pod template of the model source or a ConfigMap inlined in it changes, even if the ModelDeployment is unchanged
+func ModelFingerprint(model beamlit.Model) (string, error) {
+	data, err := json.Marshal(model)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// policyRefName returns the name of the Policy or ClusterPolicy referenced by policyRef
+func policyRefName(policyRef modelv1alpha1.PolicyRef) string {
+	if policyRef.Ref.Name != "" {
+		return policyRef.Ref.Name
+	}
+	return policyRef.Name
+}
+
+// GetReferencedPolicy retrieves the Policy or the ClusterPolicy referenced by a local or a cluster policy reference
+func GetReferencedPolicy(ctx context.Context, kubernetesClient client.Client, namespace string, policyRef modelv1alpha1.PolicyRef) (authorizationv1alpha1.PolicyObject, error) {
+	key := types.NamespacedName{Namespace: policyRef.Ref.Namespace, Name: policyRefName(policyRef)}
+	var policy authorizationv1alpha1.PolicyObject
+	switch policyRef.RefType {
+	case modelv1alpha1.PolicyRefTypeLocalPolicy:
+		// A model can only use the policies of its namespace, the cluster policies are shared across namespaces
+		if key.Namespace != "" && key.Namespace != namespace {
+			return nil, fmt.Errorf("policy %s/%s is not in namespace %s, only cluster policies can be referenced across namespaces", key.Namespace, key.Name, namespace)
+		}
+		key.Namespace = namespace
+		policy = &authorizationv1alpha1.Policy{}
+	case modelv1alpha1.PolicyRefTypeClusterPolicy:
+		key.Namespace = ""
+		policy = &authorizationv1alpha1.ClusterPolicy{}
+	default:
+		return nil, fmt.Errorf("unsupported policy reference type %s", policyRef.RefType)
+	}
+	if err := kubernetesClient.Get(ctx, key, policy); err != nil {
+		return nil, err
 	}
-	return &beamlitPolicies
+	return policy, nil
 }
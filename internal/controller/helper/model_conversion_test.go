@@ -0,0 +1,174 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+func newPolicyModel() *modelv1alpha1.ModelDeployment {
+	return &modelv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+		Spec: modelv1alpha1.ModelDeploymentSpec{Policies: []modelv1alpha1.PolicyRef{
+			{RefType: modelv1alpha1.PolicyRefTypeLocalPolicy, Name: "local"},
+			{RefType: modelv1alpha1.PolicyRefTypeClusterPolicy, Name: "shared"},
+			{RefType: modelv1alpha1.PolicyRefTypeRemotePolicy, Name: "remote"},
+		}},
+	}
+}
+
+func TestReferencesPolicy(t *testing.T) {
+	type testCase struct {
+		policy authorizationv1alpha1.PolicyObject
+		want   bool
+	}
+	tcs := map[string]testCase{
+		"When the policy is referenced as a local policy, must return true": {
+			policy: &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "local"}},
+			want:   true,
+		},
+		"When the policy has the name of a local policy in another namespace, must return false": {
+			policy: &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "local"}},
+			want:   false,
+		},
+		"When the cluster policy is referenced, must return true": {
+			policy: &authorizationv1alpha1.ClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "shared"}},
+			want:   true,
+		},
+		"When the cluster policy has the name of a local policy, must return false": {
+			policy: &authorizationv1alpha1.ClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "local"}},
+			want:   false,
+		},
+		"When the policy has the name of a remote policy, must return false": {
+			policy: &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "remote"}},
+			want:   false,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			if got := ReferencesPolicy(newPolicyModel(), tc.policy); got != tc.want {
+				t.Errorf("want %t but got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPoliciesFingerprint(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := authorizationv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	local := &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "local"}}
+	kubernetesClient := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(local).WithObjects(local).Build()
+	model := newPolicyModel()
+
+	fingerprint := func() string {
+		t.Helper()
+		value, err := PoliciesFingerprint(ctx, kubernetesClient, model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	missing := fingerprint()
+	if missing != fingerprint() {
+		t.Error("want the same fingerprint while the policies don't change")
+	}
+
+	shared := &authorizationv1alpha1.ClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}
+	if err := kubernetesClient.Create(ctx, shared); err != nil {
+		t.Fatal(err)
+	}
+	created := fingerprint()
+	if created == missing {
+		t.Error("want the fingerprint changed when a referenced policy is created")
+	}
+
+	if err := kubernetesClient.Get(ctx, client.ObjectKeyFromObject(local), local); err != nil {
+		t.Fatal(err)
+	}
+	local.Status.RemoteName = "default-local"
+	if err := kubernetesClient.Status().Update(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint() == created {
+		t.Error("want the fingerprint changed when a referenced policy is synced under another name")
+	}
+}
+
+func TestToBeamlitPolicies(t *testing.T) {
+	type testCase struct {
+		status         authorizationv1alpha1.PolicyStatus
+		workspace      string
+		want           []string
+		wantUnresolved bool
+	}
+	tcs := map[string]testCase{
+		"When the policies are synced to the workspace of the model, must return their remote names": {
+			status:    authorizationv1alpha1.PolicyStatus{RemoteName: "default-local", WorkspaceRef: "team-a"},
+			workspace: "team-a",
+			want:      []string{"default-local", "shared", "remote"},
+		},
+		"When a policy has not been synced yet, must return an ErrUnresolvedPolicy": {
+			workspace:      "team-a",
+			wantUnresolved: true,
+		},
+		"When a policy is synced to another workspace, must return an ErrUnresolvedPolicy": {
+			status:         authorizationv1alpha1.PolicyStatus{RemoteName: "default-local"},
+			workspace:      "team-a",
+			wantUnresolved: true,
+		},
+	}
+	scheme := runtime.NewScheme()
+	if err := authorizationv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			local := &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "local"}, Status: tc.status}
+			shared := &authorizationv1alpha1.ClusterPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+				Status:     authorizationv1alpha1.PolicyStatus{RemoteName: "shared", WorkspaceRef: "team-a"},
+			}
+			kubernetesClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(local, shared).Build()
+			got, err := toBeamlitPolicies(context.Background(), kubernetesClient, "default", tc.workspace, newPolicyModel().Spec.Policies)
+			if tc.wantUnresolved {
+				if !IsUnresolvedPolicy(err) {
+					t.Errorf("want an ErrUnresolvedPolicy but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("want %v but got %v", tc.want, *got)
+			}
+		})
+	}
+}
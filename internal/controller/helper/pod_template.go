@@ -0,0 +1,400 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	beamlit "github.com/beamlit/toolkit/sdk"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+// AllSecrets allows every Secret to be referenced as a Beamlit secret in PodTemplateTransformer.AllowedSecrets
+const AllSecrets = "*"
+
+type clusterSpecificField struct {
+	name   string
+	reason string
+	// strip clears the field of the pod spec and returns true if it was set
+	strip func(spec *corev1.PodSpec) bool
+}
+
+// clusterSpecificFields are the fields of the pod spec stripped from the pod templates pushed to Beamlit,
+// unless they are kept by PodTemplateTransformer.KeepFields
+var clusterSpecificFields = []clusterSpecificField{
+	{"nodeSelector", "nodes of the cluster do not exist on Beamlit", func(spec *corev1.PodSpec) bool {
+		set := len(spec.NodeSelector) > 0
+		spec.NodeSelector = nil
+		return set
+	}},
+	{"nodeName", "nodes of the cluster do not exist on Beamlit", func(spec *corev1.PodSpec) bool {
+		set := spec.NodeName != ""
+		spec.NodeName = ""
+		return set
+	}},
+	{"affinity", "affinities refer to nodes and pods of the cluster", func(spec *corev1.PodSpec) bool {
+		set := spec.Affinity != nil
+		spec.Affinity = nil
+		return set
+	}},
+	{"tolerations", "taints of the nodes of the cluster do not exist on Beamlit", func(spec *corev1.PodSpec) bool {
+		set := len(spec.Tolerations) > 0
+		spec.Tolerations = nil
+		return set
+	}},
+	{"topologySpreadConstraints", "topology domains of the cluster do not exist on Beamlit", func(spec *corev1.PodSpec) bool {
+		set := len(spec.TopologySpreadConstraints) > 0
+		spec.TopologySpreadConstraints = nil
+		return set
+	}},
+	{"priorityClassName", "priority classes are local to the cluster", func(spec *corev1.PodSpec) bool {
+		set := spec.PriorityClassName != "" || spec.Priority != nil
+		spec.PriorityClassName = ""
+		spec.Priority = nil
+		return set
+	}},
+	{"schedulerName", "schedulers are local to the cluster", func(spec *corev1.PodSpec) bool {
+		set := spec.SchedulerName != ""
+		spec.SchedulerName = ""
+		return set
+	}},
+	{"runtimeClassName", "runtime classes are local to the cluster", func(spec *corev1.PodSpec) bool {
+		set := spec.RuntimeClassName != nil
+		spec.RuntimeClassName = nil
+		return set
+	}},
+	{"serviceAccountName", "service accounts are local to the cluster", func(spec *corev1.PodSpec) bool {
+		set := spec.ServiceAccountName != "" || spec.DeprecatedServiceAccount != "" || spec.AutomountServiceAccountToken != nil
+		spec.ServiceAccountName = ""
+		spec.DeprecatedServiceAccount = ""
+		spec.AutomountServiceAccountToken = nil
+		return set
+	}},
+	{"imagePullSecrets", "Secrets are local to the cluster", func(spec *corev1.PodSpec) bool {
+		set := len(spec.ImagePullSecrets) > 0
+		spec.ImagePullSecrets = nil
+		return set
+	}},
+	{"hostNetwork", "host namespaces are not available on Beamlit", func(spec *corev1.PodSpec) bool {
+		set := spec.HostNetwork || spec.HostPID || spec.HostIPC
+		spec.HostNetwork, spec.HostPID, spec.HostIPC = false, false, false
+		return set
+	}},
+}
+
+// PodTemplateTransformer rewrites the pod template of a model source before it is pushed to Beamlit.
+// The fields which only work in the cluster are stripped, the env vars read from ConfigMaps are inlined
+// and the env vars read from allowed Secrets reference Beamlit secrets.
+// The zero value strips every cluster-specific field and drops every env var read from a Secret.
+type PodTemplateTransformer struct {
+	// KeepFields are the cluster-specific fields of the pod spec kept in the pod template, by JSON name, e.g. "tolerations"
+	KeepFields []string
+	// AllowedSecrets are the names of the Secrets whose keys are referenced as Beamlit secrets, AllSecrets for all of them
+	// The env vars read from other Secrets are dropped
+	AllowedSecrets []string
+	// Reader reads the ConfigMaps and the Secrets referenced by the pod templates, they can't be read if nil.
+	// It must be uncached: the cached client of the manager would cache every ConfigMap and Secret of the watched namespaces,
+	// and could return a stale version of the ones just changed.
+	Reader client.Reader
+}
+
+// Validate returns an error if a kept field is not a cluster-specific field
+func (t *PodTemplateTransformer) Validate() error {
+	for _, name := range t.KeepFields {
+		if !slices.ContainsFunc(clusterSpecificFields, func(field clusterSpecificField) bool { return field.name == name }) {
+			return fmt.Errorf("unknown cluster-specific pod field: %s", name)
+		}
+	}
+	return nil
+}
+
+// ErrUnsupportedVolume is returned when the pod template of a model source mounts a volume which can't be dropped on Beamlit
+type ErrUnsupportedVolume struct {
+	Volume string
+	Reason string
+}
+
+func (e *ErrUnsupportedVolume) Error() string {
+	return fmt.Sprintf("volume %s is not supported: %s, read its keys from env vars instead", e.Volume, e.Reason)
+}
+
+// IsUnsupportedVolume returns true if err is caused by a volume of a pod template which can't be pushed to Beamlit
+func IsUnsupportedVolume(err error) bool {
+	var unsupportedErr *ErrUnsupportedVolume
+	return errors.As(err, &unsupportedErr)
+}
+
+// ReferencesObject returns true if the pod template of the model source of a ModelDeployment reads env vars from the ConfigMap or the Secret name,
+// kind being ConfigMap or Secret
+func ReferencesObject(ctx context.Context, kubernetesClient client.Client, modelDeployment *modelv1alpha1.ModelDeployment, kind string, name string) (bool, error) {
+	sourceRef := modelDeployment.Spec.ModelSourceRef
+	template, err := retrievePodTemplate(ctx, kubernetesClient, sourceRef.Kind, sourceRef.Name, sourceRef.Namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, container := range slices.Concat(template.Spec.InitContainers, template.Spec.Containers) {
+		for _, envFrom := range container.EnvFrom {
+			if (kind == "ConfigMap" && envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == name) ||
+				(kind == "Secret" && envFrom.SecretRef != nil && envFrom.SecretRef.Name == name) {
+				return true, nil
+			}
+		}
+		for _, envVar := range container.Env {
+			if envVar.ValueFrom == nil {
+				continue
+			}
+			if (kind == "ConfigMap" && envVar.ValueFrom.ConfigMapKeyRef != nil && envVar.ValueFrom.ConfigMapKeyRef.Name == name) ||
+				(kind == "Secret" && envVar.ValueFrom.SecretKeyRef != nil && envVar.ValueFrom.SecretKeyRef.Name == name) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// BeamlitSecretName returns the name of the Beamlit secret holding the value of a key of a Secret, as <namespace>-<secret>-<key>-<hash>
+// The Beamlit secrets are shared by the namespaces of a workspace, the hash keeps the names of different keys unique once sanitized
+func BeamlitSecretName(namespace string, secret string, key string) string {
+	name := fmt.Sprintf("%s-%s-%s-%s", namespace, secret, key, shortHash(namespace+"/"+secret+"/"+key))
+	return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(name))
+}
+
+// Transform returns the pod template to push to Beamlit, and the changes made to it
+// ConfigMaps and Secrets are read in namespace, the namespace of the model source.
+func (t *PodTemplateTransformer) Transform(ctx context.Context, namespace string, template corev1.PodTemplateSpec) (beamlit.PodTemplateSpec, []modelv1alpha1.ConversionReportEntry, error) {
+	if t == nil {
+		t = &PodTemplateTransformer{}
+	}
+	spec := *template.Spec.DeepCopy()
+	var report []modelv1alpha1.ConversionReportEntry
+	for _, field := range clusterSpecificFields {
+		if slices.Contains(t.KeepFields, field.name) {
+			continue
+		}
+		if field.strip(&spec) {
+			report = append(report, dropped("spec."+field.name, field.reason))
+		}
+	}
+
+	droppedVolumes := make(map[string]bool)
+	volumes := spec.Volumes[:0]
+	for _, volume := range spec.Volumes {
+		if volume.EmptyDir != nil {
+			volumes = append(volumes, volume)
+			continue
+		}
+		// The model would start on Beamlit without the files it reads its configuration or credentials from
+		switch {
+		case volume.Secret != nil:
+			return nil, nil, &ErrUnsupportedVolume{Volume: volume.Name, Reason: fmt.Sprintf("Secret %s can't be mounted on Beamlit", volume.Secret.SecretName)}
+		case volume.ConfigMap != nil:
+			return nil, nil, &ErrUnsupportedVolume{Volume: volume.Name, Reason: fmt.Sprintf("ConfigMap %s can't be mounted on Beamlit", volume.ConfigMap.Name)}
+		}
+		droppedVolumes[volume.Name] = true
+		reason := "only emptyDir volumes are available on Beamlit"
+		if volume.PersistentVolumeClaim != nil {
+			reason = fmt.Sprintf("PersistentVolumeClaim %s is local to the cluster", volume.PersistentVolumeClaim.ClaimName)
+		}
+		report = append(report, dropped(fmt.Sprintf("spec.volumes[%s]", volume.Name), reason))
+	}
+	spec.Volumes = volumes
+
+	for _, containers := range []struct {
+		path       string
+		containers []corev1.Container
+	}{{"spec.initContainers", spec.InitContainers}, {"spec.containers", spec.Containers}} {
+		for i := range containers.containers {
+			container := &containers.containers[i]
+			path := fmt.Sprintf("%s[%s]", containers.path, container.Name)
+			mounts := container.VolumeMounts[:0]
+			for _, mount := range container.VolumeMounts {
+				if droppedVolumes[mount.Name] {
+					report = append(report, dropped(fmt.Sprintf("%s.volumeMounts[%s]", path, mount.Name), fmt.Sprintf("volume %s was dropped", mount.Name)))
+					continue
+				}
+				mounts = append(mounts, mount)
+			}
+			container.VolumeMounts = mounts
+			entries, err := t.transformEnv(ctx, namespace, path, container)
+			if err != nil {
+				return nil, nil, err
+			}
+			report = append(report, entries...)
+		}
+	}
+
+	podTemplate, err := toPodTemplateSpec(template.Labels, template.Annotations, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return podTemplate, report, nil
+}
+
+// transformEnv inlines the env vars of a container read from ConfigMaps, references the ones read from allowed Secrets
+// as Beamlit secrets, and drops the other ones read from the cluster
+func (t *PodTemplateTransformer) transformEnv(ctx context.Context, namespace string, path string, container *corev1.Container) ([]modelv1alpha1.ConversionReportEntry, error) {
+	var report []modelv1alpha1.ConversionReportEntry
+	var env []corev1.EnvVar
+	for _, envFrom := range container.EnvFrom {
+		switch {
+		case envFrom.ConfigMapRef != nil:
+			field := fmt.Sprintf("%s.envFrom[configMapRef:%s]", path, envFrom.ConfigMapRef.Name)
+			configMap := &corev1.ConfigMap{}
+			if err := t.get(ctx, types.NamespacedName{Namespace: namespace, Name: envFrom.ConfigMapRef.Name}, configMap); err != nil {
+				if apierrors.IsNotFound(err) && fromPtr(envFrom.ConfigMapRef.Optional) {
+					report = append(report, dropped(field, fmt.Sprintf("optional ConfigMap %s does not exist", envFrom.ConfigMapRef.Name)))
+					continue
+				}
+				return nil, err
+			}
+			for _, key := range sortedKeys(configMap.Data) {
+				env = append(env, corev1.EnvVar{Name: envFrom.Prefix + key, Value: configMap.Data[key]})
+			}
+			report = append(report, resolved(field, fmt.Sprintf("ConfigMap %s is local to the cluster", envFrom.ConfigMapRef.Name)))
+		case envFrom.SecretRef != nil:
+			field := fmt.Sprintf("%s.envFrom[secretRef:%s]", path, envFrom.SecretRef.Name)
+			if !t.secretAllowed(envFrom.SecretRef.Name) {
+				report = append(report, dropped(field, fmt.Sprintf("Secret %s is not allowed on Beamlit", envFrom.SecretRef.Name)))
+				continue
+			}
+			secret := &corev1.Secret{}
+			if err := t.get(ctx, types.NamespacedName{Namespace: namespace, Name: envFrom.SecretRef.Name}, secret); err != nil {
+				if apierrors.IsNotFound(err) && fromPtr(envFrom.SecretRef.Optional) {
+					report = append(report, dropped(field, fmt.Sprintf("optional Secret %s does not exist", envFrom.SecretRef.Name)))
+					continue
+				}
+				return nil, err
+			}
+			for _, key := range sortedKeys(secret.Data) {
+				env = append(env, corev1.EnvVar{Name: envFrom.Prefix + key, Value: beamlitSecretValue(namespace, envFrom.SecretRef.Name, key)})
+			}
+			report = append(report, rewritten(field, fmt.Sprintf("keys of Secret %s reference Beamlit secrets", envFrom.SecretRef.Name)))
+		}
+	}
+	container.EnvFrom = nil
+
+	for _, envVar := range container.Env {
+		if envVar.ValueFrom == nil {
+			env = append(env, envVar)
+			continue
+		}
+		field := fmt.Sprintf("%s.env[%s]", path, envVar.Name)
+		switch source := envVar.ValueFrom; {
+		case source.ConfigMapKeyRef != nil:
+			configMap := &corev1.ConfigMap{}
+			if err := t.get(ctx, types.NamespacedName{Namespace: namespace, Name: source.ConfigMapKeyRef.Name}, configMap); err != nil {
+				if apierrors.IsNotFound(err) && fromPtr(source.ConfigMapKeyRef.Optional) {
+					report = append(report, dropped(field, fmt.Sprintf("optional ConfigMap %s does not exist", source.ConfigMapKeyRef.Name)))
+					continue
+				}
+				return nil, err
+			}
+			value, ok := configMap.Data[source.ConfigMapKeyRef.Key]
+			if !ok {
+				if fromPtr(source.ConfigMapKeyRef.Optional) {
+					report = append(report, dropped(field, fmt.Sprintf("optional key %s is not in ConfigMap %s", source.ConfigMapKeyRef.Key, source.ConfigMapKeyRef.Name)))
+					continue
+				}
+				return nil, fmt.Errorf("key %s not found in ConfigMap %s/%s", source.ConfigMapKeyRef.Key, namespace, source.ConfigMapKeyRef.Name)
+			}
+			env = append(env, corev1.EnvVar{Name: envVar.Name, Value: value})
+			report = append(report, resolved(field, fmt.Sprintf("ConfigMap %s is local to the cluster", source.ConfigMapKeyRef.Name)))
+		case source.SecretKeyRef != nil:
+			if !t.secretAllowed(source.SecretKeyRef.Name) {
+				report = append(report, dropped(field, fmt.Sprintf("Secret %s is not allowed on Beamlit", source.SecretKeyRef.Name)))
+				continue
+			}
+			env = append(env, corev1.EnvVar{Name: envVar.Name, Value: beamlitSecretValue(namespace, source.SecretKeyRef.Name, source.SecretKeyRef.Key)})
+			report = append(report, rewritten(field, fmt.Sprintf("key %s of Secret %s references a Beamlit secret", source.SecretKeyRef.Key, source.SecretKeyRef.Name)))
+		default:
+			report = append(report, dropped(field, "the downward API is not available on Beamlit"))
+		}
+	}
+	container.Env = env
+	return report, nil
+}
+
+// get reads a ConfigMap or a Secret with the Reader of the transformer
+func (t *PodTemplateTransformer) get(ctx context.Context, key types.NamespacedName, obj client.Object) error {
+	if t.Reader == nil {
+		return fmt.Errorf("can't read %T %s: the pod template transformer has no Reader", obj, key)
+	}
+	return t.Reader.Get(ctx, key, obj)
+}
+
+func (t *PodTemplateTransformer) secretAllowed(name string) bool {
+	return slices.Contains(t.AllowedSecrets, AllSecrets) || slices.Contains(t.AllowedSecrets, name)
+}
+
+// beamlitSecretValue returns the value of an env var reading a key of a Secret from the Beamlit secrets
+func beamlitSecretValue(namespace string, secret string, key string) string {
+	return fmt.Sprintf("${secrets.%s}", BeamlitSecretName(namespace, secret, key))
+}
+
+type podTemplateMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// toPodTemplateSpec converts a pod spec to a Beamlit pod template, with its JSON field names
+// Only the labels and the annotations of the template metadata are kept, the rest is set by the cluster.
+func toPodTemplateSpec(labels map[string]string, annotations map[string]string, spec corev1.PodSpec) (beamlit.PodTemplateSpec, error) {
+	data, err := json.Marshal(struct {
+		Metadata podTemplateMetadata `json:"metadata"`
+		Spec     corev1.PodSpec      `json:"spec"`
+	}{podTemplateMetadata{Labels: labels, Annotations: annotations}, spec})
+	if err != nil {
+		return nil, err
+	}
+	var podTemplate beamlit.PodTemplateSpec
+	if err := json.Unmarshal(data, &podTemplate); err != nil {
+		return nil, err
+	}
+	return podTemplate, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func dropped(field string, reason string) modelv1alpha1.ConversionReportEntry {
+	return modelv1alpha1.ConversionReportEntry{Field: field, Action: modelv1alpha1.ConversionActionDropped, Reason: reason}
+}
+
+func rewritten(field string, reason string) modelv1alpha1.ConversionReportEntry {
+	return modelv1alpha1.ConversionReportEntry{Field: field, Action: modelv1alpha1.ConversionActionRewritten, Reason: reason}
+}
+
+func resolved(field string, reason string) modelv1alpha1.ConversionReportEntry {
+	return modelv1alpha1.ConversionReportEntry{Field: field, Action: modelv1alpha1.ConversionActionResolved, Reason: reason}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+func newModelTemplate() corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "model"},
+		},
+		Spec: corev1.PodSpec{
+			NodeSelector:       map[string]string{"gpu": "a100"},
+			ServiceAccountName: "model",
+			Volumes: []corev1.Volume{
+				{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "weights", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "weights"}}},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:  "model",
+					Image: "model:latest",
+					Env: []corev1.EnvVar{
+						{Name: "PORT", Value: "8080"},
+						{Name: "MODEL_NAME", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "model-config"},
+							Key:                  "name",
+						}}},
+						{Name: "HF_TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "hf"},
+							Key:                  "token",
+						}}},
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "cache", MountPath: "/cache"},
+						{Name: "weights", MountPath: "/weights"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestPodTemplateTransformer(t *testing.T) {
+	type testCase struct {
+		transformer *PodTemplateTransformer
+		wantEnv     []interface{}
+		wantReport  []modelv1alpha1.ConversionReportEntry
+	}
+	tcs := map[string]testCase{
+		"When the transformer has the default settings, must strip the cluster-specific fields and drop the secrets": {
+			transformer: &PodTemplateTransformer{},
+			wantReport: []modelv1alpha1.ConversionReportEntry{
+				dropped("spec.nodeSelector", "nodes of the cluster do not exist on Beamlit"),
+				dropped("spec.serviceAccountName", "service accounts are local to the cluster"),
+				dropped("spec.volumes[weights]", "PersistentVolumeClaim weights is local to the cluster"),
+				dropped("spec.containers[model].volumeMounts[weights]", "volume weights was dropped"),
+				resolved("spec.containers[model].env[MODEL_NAME]", "ConfigMap model-config is local to the cluster"),
+				dropped("spec.containers[model].env[HF_TOKEN]", "Secret hf is not allowed on Beamlit"),
+			},
+			wantEnv: []interface{}{
+				map[string]interface{}{"name": "PORT", "value": "8080"},
+				map[string]interface{}{"name": "MODEL_NAME", "value": "llama"},
+			},
+		},
+		"When fields are kept and the secret is allowed, must keep them and reference a Beamlit secret": {
+			transformer: &PodTemplateTransformer{KeepFields: []string{"nodeSelector"}, AllowedSecrets: []string{"hf"}},
+			wantReport: []modelv1alpha1.ConversionReportEntry{
+				dropped("spec.serviceAccountName", "service accounts are local to the cluster"),
+				dropped("spec.volumes[weights]", "PersistentVolumeClaim weights is local to the cluster"),
+				dropped("spec.containers[model].volumeMounts[weights]", "volume weights was dropped"),
+				resolved("spec.containers[model].env[MODEL_NAME]", "ConfigMap model-config is local to the cluster"),
+				rewritten("spec.containers[model].env[HF_TOKEN]", "key token of Secret hf references a Beamlit secret"),
+			},
+			wantEnv: []interface{}{
+				map[string]interface{}{"name": "PORT", "value": "8080"},
+				map[string]interface{}{"name": "MODEL_NAME", "value": "llama"},
+				map[string]interface{}{"name": "HF_TOKEN", "value": "${secrets.default-hf-token-e8b89cd0}"},
+			},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			kubernetesClient := fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-config"},
+				Data:       map[string]string{"name": "llama"},
+			}).Build()
+			transformer := *tc.transformer
+			transformer.Reader = kubernetesClient
+			podTemplate, report, err := transformer.Transform(context.Background(), "default", newModelTemplate())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, tc.wantReport) {
+				t.Errorf("want report %v but got %v", tc.wantReport, report)
+			}
+			spec := podTemplate["spec"].(map[string]interface{})
+			container := spec["containers"].([]interface{})[0].(map[string]interface{})
+			if !reflect.DeepEqual(container["env"], tc.wantEnv) {
+				t.Errorf("want env %v but got %v", tc.wantEnv, container["env"])
+			}
+			if len(spec["volumes"].([]interface{})) != 1 || len(container["volumeMounts"].([]interface{})) != 1 {
+				t.Errorf("want only the emptyDir volume but got %v", spec["volumes"])
+			}
+			if _, ok := spec["serviceAccountName"]; ok {
+				t.Error("want the service account stripped")
+			}
+		})
+	}
+}
+
+func TestPodTemplateTransformerRejectsMountedObjects(t *testing.T) {
+	tcs := map[string]corev1.VolumeSource{
+		"When a Secret is mounted, must return an ErrUnsupportedVolume": {
+			Secret: &corev1.SecretVolumeSource{SecretName: "hf"},
+		},
+		"When a ConfigMap is mounted, must return an ErrUnsupportedVolume": {
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "model-config"}},
+		},
+	}
+	for name, source := range tcs {
+		t.Run(name, func(t *testing.T) {
+			template := newModelTemplate()
+			template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{Name: "mounted", VolumeSource: source})
+			transformer := &PodTemplateTransformer{Reader: fake.NewClientBuilder().Build()}
+			if _, _, err := transformer.Transform(context.Background(), "default", template); !IsUnsupportedVolume(err) {
+				t.Errorf("want an ErrUnsupportedVolume but got %v", err)
+			}
+		})
+	}
+}
+
+func TestPodTemplateTransformerWithoutReader(t *testing.T) {
+	if _, _, err := (&PodTemplateTransformer{}).Transform(context.Background(), "default", newModelTemplate()); err == nil {
+		t.Error("want an error reading the ConfigMap without Reader")
+	}
+}
+
+func TestReferencesObject(t *testing.T) {
+	kubernetesClient := fake.NewClientBuilder().WithObjects(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+		Spec:       appsv1.DeploymentSpec{Template: newModelTemplate()},
+	}).Build()
+	model := &modelv1alpha1.ModelDeployment{Spec: modelv1alpha1.ModelDeploymentSpec{
+		ModelSourceRef: corev1.ObjectReference{Kind: "Deployment", Namespace: "default", Name: "model"},
+	}}
+	type testCase struct {
+		kind string
+		name string
+		want bool
+	}
+	tcs := map[string]testCase{
+		"When the ConfigMap is read by an env var, must return true":                {kind: "ConfigMap", name: "model-config", want: true},
+		"When the Secret is read by an env var, must return true":                   {kind: "Secret", name: "hf", want: true},
+		"When the ConfigMap has the name of a referenced Secret, must return false": {kind: "ConfigMap", name: "hf"},
+		"When the object is not referenced, must return false":                      {kind: "ConfigMap", name: "other"},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			got, err := ReferencesObject(context.Background(), kubernetesClient, model, tc.kind, tc.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("want %t but got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBeamlitSecretName(t *testing.T) {
+	names := map[string]bool{}
+	for _, ref := range [][3]string{
+		{"default", "hf", "token"},
+		{"team-a", "hf", "token"},
+		{"default", "hf.token", "value"},
+		{"default", "hf_token", "value"},
+		{"default", "hf", "token-value"},
+	} {
+		name := BeamlitSecretName(ref[0], ref[1], ref[2])
+		if names[name] {
+			t.Errorf("want a unique name for %v but got %q twice", ref, name)
+		}
+		names[name] = true
+	}
+}
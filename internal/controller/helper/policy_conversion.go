@@ -1,26 +1,76 @@
 package helper
 
 import (
+	"fmt"
+	"net"
+
 	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	"github.com/beamlit/beamlit-controller/internal/config"
 	beamlit "github.com/beamlit/toolkit/sdk"
 )
 
-func ToBeamlitPolicy(policy *authorizationv1alpha1.Policy) *beamlit.Policy {
-	beamlitPolicy := &beamlit.Policy{}
-	beamlitPolicy.Metadata.Name = &policy.Name
-	beamlitPolicy.Metadata.DisplayName = &policy.Spec.DisplayName
-	//beamlitPolicy.Labels = toBeamlitLabels(policy.Labels) // TODO: Add this back when we have a way to convert labels to Beamlit labels
-	switch policy.Spec.Type {
+// BeamlitPolicyName returns the name of the policy on Beamlit
+// spec.remoteName always takes precedence over the naming strategy
+// Cluster policies have no namespace, so they are always named after the resource
+// With the namespaced strategy, a hash of the namespace and the name is appended so that distinct resources never share a name,
+// as dashes may appear in both the namespace and the name
+func BeamlitPolicyName(policy authorizationv1alpha1.PolicyObject, strategy config.PolicyNamingStrategy) string {
+	if remoteName := policy.GetPolicySpec().RemoteName; remoteName != "" {
+		return remoteName
+	}
+	if strategy == config.PolicyNamingStrategyNamespaced && policy.GetNamespace() != "" {
+		return fmt.Sprintf("%s-%s-%s", policy.GetNamespace(), policy.GetName(), shortHash(policy.GetNamespace()+"/"+policy.GetName()))
+	}
+	return policy.GetName()
+}
+
+// PolicyOwner returns the owner set on the policy on Beamlit
+// A policy is owned by <namespace>/<name>, and a cluster policy by cluster/<name>
+func PolicyOwner(policy authorizationv1alpha1.PolicyObject) string {
+	if policy.GetNamespace() == "" {
+		return fmt.Sprintf("cluster/%s", policy.GetName())
+	}
+	return fmt.Sprintf("%s/%s", policy.GetNamespace(), policy.GetName())
+}
+
+// ToBeamlitPolicy converts a Policy or a ClusterPolicy to a Beamlit Policy named name
+func ToBeamlitPolicy(policy authorizationv1alpha1.PolicyObject, name string) *beamlit.Policy {
+	spec := policy.GetPolicySpec()
+	beamlitPolicy := &beamlit.Policy{
+		Metadata: &beamlit.Metadata{
+			Name:        &name,
+			DisplayName: &spec.DisplayName,
+			Labels:      toPtr(toBeamlitLabels(policy.GetLabels())),
+		},
+		Spec: &beamlit.PolicySpec{},
+	}
+	switch spec.Type {
 	case authorizationv1alpha1.PolicyTypeFlavor:
 		beamlitPolicy.Spec.Type = toPtr("flavor")
-		beamlitPolicy.Spec.Flavors = toBeamlitFlavors(policy.Spec.Flavors)
+		beamlitPolicy.Spec.Flavors = toBeamlitFlavors(spec.Flavors)
 	case authorizationv1alpha1.PolicyTypeLocation:
 		beamlitPolicy.Spec.Type = toPtr("location")
-		beamlitPolicy.Spec.Locations = toBeamlitLocations(policy.Spec.Locations)
+		beamlitPolicy.Spec.Locations = toBeamlitLocations(spec.Locations)
+	}
+	if len(spec.ResourceTypes) > 0 {
+		beamlitPolicy.Spec.ResourceTypes = toPtr(append([]string{}, spec.ResourceTypes...))
 	}
 	return beamlitPolicy
 }
 
+// ValidateGatewayPolicy returns an error if the rules of a gateway policy cannot be enforced by the gateway
+func ValidateGatewayPolicy(gatewayPolicy *authorizationv1alpha1.GatewayPolicy) error {
+	if gatewayPolicy == nil {
+		return fmt.Errorf("gateway policy has no rules")
+	}
+	for _, cidr := range gatewayPolicy.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid allowed CIDR %s: %w", cidr, err)
+		}
+	}
+	return nil
+}
+
 func toBeamlitFlavors(flavors []authorizationv1alpha1.PolicyFlavor) *[]beamlit.Flavor {
 	beamlitFlavors := make([]beamlit.Flavor, len(flavors))
 	for i, flavor := range flavors {
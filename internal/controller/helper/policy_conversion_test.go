@@ -0,0 +1,96 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	"github.com/beamlit/beamlit-controller/internal/config"
+)
+
+func TestBeamlitPolicyName(t *testing.T) {
+	type testCase struct {
+		policy   authorizationv1alpha1.PolicyObject
+		strategy config.PolicyNamingStrategy
+		want     string
+	}
+	tcs := map[string]testCase{
+		"When the strategy is plain, must return the name of the policy": {
+			policy:   &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "a-b", Name: "c"}},
+			strategy: config.PolicyNamingStrategyPlain,
+			want:     "c",
+		},
+		"When the strategy is namespaced, must prefix the name with the namespace and suffix it with a hash": {
+			policy:   &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "a-b", Name: "c"}},
+			strategy: config.PolicyNamingStrategyNamespaced,
+			want:     "a-b-c-" + shortHash("a-b/c"),
+		},
+		"When the remote name is set, must return it whatever the strategy": {
+			policy: &authorizationv1alpha1.Policy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "a-b", Name: "c"},
+				Spec:       authorizationv1alpha1.PolicySpec{RemoteName: "remote"},
+			},
+			strategy: config.PolicyNamingStrategyNamespaced,
+			want:     "remote",
+		},
+		"When the policy is a cluster policy, must return its name whatever the strategy": {
+			policy:   &authorizationv1alpha1.ClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "c"}},
+			strategy: config.PolicyNamingStrategyNamespaced,
+			want:     "c",
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			if got := BeamlitPolicyName(tc.policy, tc.strategy); got != tc.want {
+				t.Errorf("want name %s but got %s", tc.want, got)
+			}
+		})
+	}
+
+	first := BeamlitPolicyName(&authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "a-b", Name: "c"}}, config.PolicyNamingStrategyNamespaced)
+	second := BeamlitPolicyName(&authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "b-c"}}, config.PolicyNamingStrategyNamespaced)
+	if first == second {
+		t.Errorf("want distinct names for a-b/c and a/b-c but both got %s", first)
+	}
+}
+
+func TestPolicyOwner(t *testing.T) {
+	type testCase struct {
+		policy authorizationv1alpha1.PolicyObject
+		want   string
+	}
+	tcs := map[string]testCase{
+		"When the policy is namespaced, must return its namespace and name": {
+			policy: &authorizationv1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"}},
+			want:   "default/policy",
+		},
+		"When the policy is a cluster policy, must return its name prefixed by cluster": {
+			policy: &authorizationv1alpha1.ClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy"}},
+			want:   "cluster/policy",
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			if got := PolicyOwner(tc.policy); got != tc.want {
+				t.Errorf("want owner %s but got %s", tc.want, got)
+			}
+		})
+	}
+}
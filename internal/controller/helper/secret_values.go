@@ -0,0 +1,132 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+// SecretValue is the value of a key of a Secret referenced by a pod template, pushed to Beamlit as a secret
+type SecretValue struct {
+	// Name is the name of the secret on Beamlit
+	Name string
+	// Source is the key the value is read from, as Kind/name/key
+	Source string
+	// Value is the value of the key
+	Value string
+	// ResourceVersion is the resourceVersion of the Secret the value is read from, stored in the status of the ModelDeployment to detect changes
+	// without storing anything derived from the value
+	ResourceVersion string
+}
+
+// SecretValues returns the values referenced by the pod template of the model source of a ModelDeployment: the keys of the allowed Secrets read by env vars.
+// The ConfigMaps read by env vars are not returned, their values are inlined in the pod template by Transform,
+// and the pod templates mounting Secrets or ConfigMaps as volumes are rejected by Transform.
+func (t *PodTemplateTransformer) SecretValues(ctx context.Context, kubernetesClient client.Client, modelDeployment *modelv1alpha1.ModelDeployment) ([]SecretValue, error) {
+	if t == nil {
+		t = &PodTemplateTransformer{}
+	}
+	sourceRef := modelDeployment.Spec.ModelSourceRef
+	template, err := retrievePodTemplate(ctx, kubernetesClient, sourceRef.Kind, sourceRef.Name, sourceRef.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	collector := &secretValueCollector{transformer: t, namespace: sourceRef.Namespace, values: make(map[string]SecretValue)}
+	for _, container := range slices.Concat(template.Spec.InitContainers, template.Spec.Containers) {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && t.secretAllowed(envFrom.SecretRef.Name) {
+				if err := collector.collectSecret(ctx, envFrom.SecretRef.Name, nil, fromPtr(envFrom.SecretRef.Optional)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, envVar := range container.Env {
+			if envVar.ValueFrom == nil || envVar.ValueFrom.SecretKeyRef == nil || !t.secretAllowed(envVar.ValueFrom.SecretKeyRef.Name) {
+				continue
+			}
+			ref := envVar.ValueFrom.SecretKeyRef
+			if err := collector.collectSecret(ctx, ref.Name, []string{ref.Key}, fromPtr(ref.Optional)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	values := make([]SecretValue, 0, len(collector.values))
+	for _, name := range sortedKeys(collector.values) {
+		values = append(values, collector.values[name])
+	}
+	return values, nil
+}
+
+// secretValueCollector reads the keys of Secrets, by name of the Beamlit secret holding them.
+// A key read twice is kept once.
+type secretValueCollector struct {
+	transformer *PodTemplateTransformer
+	namespace   string
+	values      map[string]SecretValue
+}
+
+// collectSecret reads keys of a Secret, all of them if keys is empty
+func (c *secretValueCollector) collectSecret(ctx context.Context, name string, keys []string, optional bool) error {
+	secret := &corev1.Secret{}
+	if err := c.transformer.get(ctx, types.NamespacedName{Namespace: c.namespace, Name: name}, secret); err != nil {
+		if apierrors.IsNotFound(err) && optional {
+			return nil
+		}
+		return err
+	}
+	data := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	return c.collect("Secret", name, secret.ResourceVersion, data, keys, optional)
+}
+
+func (c *secretValueCollector) collect(kind string, name string, resourceVersion string, data map[string]string, keys []string, optional bool) error {
+	if len(keys) == 0 {
+		keys = sortedKeys(data)
+	}
+	for _, key := range keys {
+		value, ok := data[key]
+		if !ok {
+			if optional {
+				continue
+			}
+			return fmt.Errorf("key %s not found in %s %s/%s", key, kind, c.namespace, name)
+		}
+		secretName := BeamlitSecretName(c.namespace, name, key)
+		if _, ok := c.values[secretName]; ok {
+			continue
+		}
+		c.values[secretName] = SecretValue{
+			Name:            secretName,
+			Source:          fmt.Sprintf("%s/%s/%s", kind, name, key),
+			Value:           value,
+			ResourceVersion: resourceVersion,
+		}
+	}
+	return nil
+}
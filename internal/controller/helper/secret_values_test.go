@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+func TestSecretValues(t *testing.T) {
+	template := newModelTemplate()
+	kubernetesClient := fake.NewClientBuilder().WithObjects(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-config"},
+			Data:       map[string]string{"name": "llama"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hf"},
+			Data:       map[string][]byte{"token": []byte("hf_123"), "user": []byte("beamlit")},
+		},
+	).Build()
+	model := &modelv1alpha1.ModelDeployment{Spec: modelv1alpha1.ModelDeploymentSpec{
+		ModelSourceRef: corev1.ObjectReference{Kind: "Deployment", Namespace: "default", Name: "model"},
+	}}
+
+	type testCase struct {
+		transformer *PodTemplateTransformer
+		want        []SecretValue
+	}
+	tcs := map[string]testCase{
+		"When the secret is not allowed, must not return the ConfigMap inlined in the pod template": {
+			transformer: &PodTemplateTransformer{Reader: kubernetesClient},
+			want:        []SecretValue{},
+		},
+		"When the secret is allowed, must return the referenced key of the secret": {
+			transformer: &PodTemplateTransformer{AllowedSecrets: []string{AllSecrets}, Reader: kubernetesClient},
+			want: []SecretValue{
+				{Name: "default-hf-token-e8b89cd0", Source: "Secret/hf/token", Value: "hf_123", ResourceVersion: "999"},
+			},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			values, err := tc.transformer.SecretValues(context.Background(), kubernetesClient, model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, tc.want) {
+				t.Errorf("want %v but got %v", tc.want, values)
+			}
+		})
+	}
+
+	t.Run("When the transformer has no Reader, must return an error", func(t *testing.T) {
+		transformer := &PodTemplateTransformer{AllowedSecrets: []string{AllSecrets}}
+		if _, err := transformer.SecretValues(context.Background(), kubernetesClient, model); err == nil {
+			t.Error("want an error reading the secret without Reader")
+		}
+	})
+}
@@ -14,6 +14,26 @@ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 
 package helper
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
 func toPtr[T any](v T) *T {
 	return &v
 }
+
+func fromPtr[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
+
+// shortHash returns the first 8 hexadecimal characters of the SHA-256 of value
+// It is used to keep the generated names unique when their parts are joined with characters that may appear in the parts
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:8]
+}
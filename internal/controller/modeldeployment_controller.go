@@ -24,13 +24,22 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
 	v1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
 	"github.com/beamlit/beamlit-controller/internal/beamlit"
 	"github.com/beamlit/beamlit-controller/internal/controller/helper"
@@ -38,10 +47,16 @@ import (
 	"github.com/beamlit/beamlit-controller/internal/dataplane/offloader"
 	"github.com/beamlit/beamlit-controller/internal/informers/health"
 	"github.com/beamlit/beamlit-controller/internal/informers/metric"
+	"github.com/beamlit/beamlit-controller/internal/reporter"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-const modelDeploymentFinalizer = "modeldeployment.beamlit.com/finalizer"
+const (
+	modelDeploymentFinalizer = "modeldeployment.beamlit.com/finalizer"
+
+	// serviceRefIndexKey indexes the ModelDeployments by the namespace/name of their Service
+	serviceRefIndexKey = "spec.serviceRef"
+)
 
 // ModelDeploymentReconciler reconciles a ModelDeployment object
 
@@ -50,26 +65,38 @@ type ManagedModel struct {
 	name           string
 	healthy        bool
 	lastGeneration int64
+	workspace      string
+	// policies is the fingerprint of the referenced policies the model was last synced with
+	policies string
+	// fingerprint is the fingerprint of the model last pushed to Beamlit
+	fingerprint string
 }
 
 type ModelDeploymentReconciler struct {
 	client.Client
-	Scheme        *runtime.Scheme
-	BeamlitClient *beamlit.Client
+	Scheme           *runtime.Scheme
+	WorkspaceClients *WorkspaceClients
 
 	Offloader        offloader.Offloader
+	OffloadReporter  *reporter.OffloadReporter
 	Configurer       configurer.Configurer
 	MetricInformer   metric.MetricInformer
 	HealthInformer   health.HealthInformer
 	HealthStatusChan <-chan health.HealthStatus
 	MetricStatusChan <-chan metric.MetricStatus
+	ServiceEventChan <-chan configurer.ServiceEvent
 
 	OngoingOffloadings sync.Map // key: namespace/name, value: percentage
 	ModelState         sync.Map // key: namespace/name, value: modelState
+	serviceEvents      sync.Map // key: namespace/name, value: last configurer.ServiceEvent of its Service not reconciled yet
 	ManagedModels      map[string]ManagedModel
-	BeamlitModels      map[string]string // key: spec.model/spec.environment, value: modelDeployment name
+	BeamlitModels      map[string]string // key: workspace/spec.environment/spec.model, value: modelDeployment name
 
 	DefaultRemoteBackend *v1alpha1.RemoteBackend
+	// PodTemplateTransformer rewrites the pod templates of the model sources pushed to Beamlit, the default transformer if nil
+	PodTemplateTransformer *helper.PodTemplateTransformer
+	// SyncSecrets pushes the values of the Secrets read by the env vars of the pod templates to Beamlit secrets
+	SyncSecrets bool
 }
 
 // +kubebuilder:rbac:groups=deployment.beamlit.com,resources=modeldeployments,verbs=get;list;watch;create;update;patch;delete
@@ -81,6 +108,11 @@ type ModelDeploymentReconciler struct {
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes;backendtlspolicies,verbs=get;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.istio.io,resources=serviceentries;destinationrules;virtualservices,verbs=get;create;update;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=authorization.beamlit.com,resources=policies;clusterpolicies,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -130,16 +162,43 @@ func (r *ModelDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		return ctrl.Result{}, nil
 	}
 
+	if r.SyncSecrets {
+		if err := r.syncSecrets(ctx, &model); err != nil {
+			if errors.IsConflict(err) {
+				logger.V(0).Info("Conflict detected, retrying", "error", err)
+				return ctrl.Result{Requeue: true}, nil
+			}
+			logger.V(0).Error(err, "Failed to sync Secrets of ModelDeployment")
+			if setErrorCondition(&model.Status.Conditions, model.Generation, err) {
+				if err := r.Status().Update(ctx, &model); err != nil {
+					logger.V(0).Error(err, "Failed to update ModelDeployment status")
+				}
+			}
+			return beamlitErrorResult(err)
+		}
+	}
+
 	if err := r.createOrUpdate(ctx, &model); err != nil {
 		if errors.IsConflict(err) {
 			logger.V(0).Info("Conflict detected, retrying", "error", err)
 			return ctrl.Result{Requeue: true}, nil
 		}
 		logger.V(0).Error(err, "Failed to create or update ModelDeployment")
+		reported := setErrorCondition(&model.Status.Conditions, model.Generation, err)
+		if event, ok := r.serviceEvents.Load(fmt.Sprintf("%s/%s", model.Namespace, model.Name)); ok && event.(configurer.ServiceEvent).Type == configurer.ServiceEventDeleted {
+			// The Service is configured again once it is recreated
+			setServiceEventCondition(&model.Status.Conditions, model.Generation, event.(configurer.ServiceEvent))
+			reported = true
+		}
+		if reported {
+			if err := r.Status().Update(ctx, &model); err != nil {
+				logger.V(0).Error(err, "Failed to update ModelDeployment status")
+			}
+		}
 		r.ModelState.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
 		r.OngoingOffloadings.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
 		delete(r.ManagedModels, fmt.Sprintf("%s/%s", model.Namespace, model.Name))
-		return ctrl.Result{}, err
+		return beamlitErrorResult(err)
 	}
 	logger.V(0).Info("Successfully created or updated ModelDeployment", "Name", model.Name)
 	return ctrl.Result{}, nil
@@ -147,17 +206,21 @@ func (r *ModelDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 
 func (r *ModelDeploymentReconciler) createOrUpdate(ctx context.Context, model *v1alpha1.ModelDeployment) error {
 	logger := log.FromContext(ctx)
-	if value, ok := r.BeamlitModels[fmt.Sprintf("%s/%s", model.Spec.Environment, model.Spec.Model)]; ok {
+	workspace, err := r.WorkspaceClients.Resolve(ctx, model.Namespace, model.Spec.WorkspaceRef)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to resolve BeamlitWorkspace for ModelDeployment", "Name", model.Name)
+		return err
+	}
+	if value, ok := r.BeamlitModels[beamlitModelKey(workspace, model)]; ok {
 		if value != model.Name {
 			logger.V(1).Error(nil, "ModelDeployment already exists on Beamlit with a different name inside the cluster", "Name", model.Name, "ExistingName", value)
 			return nil
 		}
 	}
-	if value, ok := r.ManagedModels[fmt.Sprintf("%s/%s", model.Namespace, model.Name)]; ok {
-		if value.lastGeneration == model.Generation {
-			logger.V(1).Info("ModelDeployment generation has not changed, skipping", "Name", model.Name)
-			return nil
-		}
+	policies, err := helper.PoliciesFingerprint(ctx, r.Client, model)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to resolve the policies of ModelDeployment", "Name", model.Name)
+		return err
 	}
 	logger.V(1).Info("Converting ModelDeployment to Beamlit ModelDeployment", "Name", model.Name)
 	if model.Spec.ServiceRef != nil {
@@ -165,7 +228,7 @@ func (r *ModelDeploymentReconciler) createOrUpdate(ctx context.Context, model *v
 			Kind:      model.Spec.ServiceRef.Kind,
 			Namespace: model.Spec.ServiceRef.Namespace,
 			Name:      model.Spec.ServiceRef.Name,
-		}, int(model.Spec.ServiceRef.TargetPort))
+		}, int(model.Spec.ServiceRef.TargetPort), &model.Spec.ModelSourceRef)
 		if err != nil {
 			logger.V(0).Error(err, "Failed to retrieve serving port for ModelDeployment", "Name", model.Name)
 			return err
@@ -177,26 +240,60 @@ func (r *ModelDeploymentReconciler) createOrUpdate(ctx context.Context, model *v
 			Kind:      model.Spec.MetricServiceRef.Kind,
 			Namespace: model.Spec.MetricServiceRef.Namespace,
 			Name:      model.Spec.MetricServiceRef.Name,
-		}, int(model.Spec.MetricServiceRef.TargetPort))
+		}, int(model.Spec.MetricServiceRef.TargetPort), &model.Spec.ModelSourceRef)
 		if err != nil {
 			logger.V(0).Error(err, "Failed to retrieve metric port for ModelDeployment", "Name", model.Name)
 			return err
 		}
 		model.Status.MetricPort = int32(metricPort)
 	}
-	beamlitModelDeployment, err := helper.ToBeamlitModelDeployment(ctx, r.Client, model)
+	beamlitModelDeployment, conversionReport, err := helper.ToBeamlitModelDeployment(ctx, r.Client, r.PodTemplateTransformer, model, workspace)
 	if err != nil {
 		logger.V(0).Error(err, "Failed to convert ModelDeployment to Beamlit ModelDeployment")
 		return err
 	}
-	r.BeamlitModels[fmt.Sprintf("%s/%s", model.Spec.Environment, model.Spec.Model)] = model.Name
-	logger.V(1).Info("Creating or updating ModelDeployment on Beamlit", "Name", model.Name)
-	updatedModelDeployment, err := r.BeamlitClient.CreateOrUpdateModel(ctx, beamlitModelDeployment)
+	fingerprint, err := helper.ModelFingerprint(beamlitModelDeployment)
+	if err != nil {
+		return err
+	}
+	// A Service changed by the configurer is configured again with the offloader
+	_, serviceChanged := r.serviceEvents.Load(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+	if value, ok := r.ManagedModels[fmt.Sprintf("%s/%s", model.Namespace, model.Name)]; ok && !serviceChanged {
+		if value.lastGeneration == model.Generation && value.workspace == workspace && value.policies == policies && value.fingerprint == fingerprint {
+			logger.V(1).Info("ModelDeployment generation, policies and pod template have not changed, skipping", "Name", model.Name)
+			return nil
+		}
+	}
+	if !model.Status.CreatedAtOnBeamlit.IsZero() && model.Status.WorkspaceRef != workspace {
+		logger.V(1).Info("Workspace changed, deleting previous ModelDeployment on Beamlit", "Name", model.Name, "Workspace", model.Status.WorkspaceRef)
+		previousClient, err := r.WorkspaceClients.ClientFor(ctx, model.Status.WorkspaceRef)
+		if err != nil {
+			return err
+		}
+		if err := previousClient.DeleteModelDeployment(ctx, model.Spec.Model, model.Spec.Environment, helper.ModelOwner(model)); err != nil {
+			logger.V(0).Error(err, "Failed to delete previous ModelDeployment on Beamlit", "Name", model.Name)
+			return err
+		}
+		delete(r.BeamlitModels, beamlitModelKey(model.Status.WorkspaceRef, model))
+	}
+	beamlitClient, err := r.WorkspaceClients.ClientFor(ctx, workspace)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to get Beamlit client for ModelDeployment", "Name", model.Name, "Workspace", workspace)
+		return err
+	}
+	for _, entry := range conversionReport {
+		logger.V(1).Info("Changed pod template pushed to Beamlit", "Name", model.Name, "Field", entry.Field, "Action", entry.Action, "Reason", entry.Reason)
+	}
+	model.Status.ConversionReport = conversionReport
+	r.BeamlitModels[beamlitModelKey(workspace, model)] = model.Name
+	logger.V(1).Info("Creating or updating ModelDeployment on Beamlit", "Name", model.Name, "Workspace", workspace)
+	updatedModelDeployment, err := beamlitClient.CreateOrUpdateModel(ctx, beamlitModelDeployment, helper.ModelOwner(model))
 	if err != nil {
 		logger.V(0).Error(err, "Failed to create or update ModelDeployment on Beamlit")
 		return err
 	}
 	model.Status.Workspace = *updatedModelDeployment.Metadata.Workspace
+	model.Status.WorkspaceRef = workspace
 	createdAt, err := time.Parse(time.RFC3339, *updatedModelDeployment.Metadata.CreatedAt)
 	if err != nil {
 		logger.V(0).Error(err, "Failed to parse CreatedAt on Beamlit", "Name", model.Name)
@@ -209,6 +306,8 @@ func (r *ModelDeploymentReconciler) createOrUpdate(ctx context.Context, model *v
 		return err
 	}
 	model.Status.UpdatedAtOnBeamlit = metav1.NewTime(updatedAt)
+	setAuthenticatedCondition(&model.Status.Conditions, model.Generation, nil)
+	setSyncedCondition(&model.Status.Conditions, model.Generation, nil)
 	if err := r.configureOffloading(ctx, model); err != nil {
 		logger.V(0).Error(err, "Failed to configure offloading for ModelDeployment")
 		return err
@@ -224,6 +323,9 @@ func (r *ModelDeploymentReconciler) createOrUpdate(ctx context.Context, model *v
 		name:           model.Name,
 		healthy:        true,
 		lastGeneration: model.Generation,
+		workspace:      workspace,
+		policies:       policies,
+		fingerprint:    fingerprint,
 	}
 
 	return nil
@@ -243,13 +345,42 @@ func (r *ModelDeploymentReconciler) configureOffloading(ctx context.Context, mod
 		logger.V(0).Error(err, "Failed to cleanup offloading for ModelDeployment")
 		return err
 	}
-	r.OngoingOffloadings.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
-	r.ModelState.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+	// The ongoing offloading is kept until the model is configured again, so that a failed configuration is retried from it
+	offloaded := 0
+	if value, ok := r.OngoingOffloadings.Load(fmt.Sprintf("%s/%s", model.Namespace, model.Name)); ok {
+		offloaded = value.(int)
+	}
+	healthy := true
+	if value, ok := r.ModelState.Load(fmt.Sprintf("%s/%s", model.Namespace, model.Name)); ok {
+		healthy = value.(bool)
+	}
 	delete(r.ManagedModels, fmt.Sprintf("%s/%s", model.Namespace, model.Name))
 	logger.V(1).Info("Successfully unregistered offloading for ModelDeployment", "Name", model.Name)
 	if !model.Spec.Enabled || model.Spec.OffloadingConfig == nil {
+		r.OngoingOffloadings.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+		r.ModelState.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+		r.serviceEvents.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+		if offloaded != 0 {
+			r.notifyOnBeamlit(ctx, model, 0, beamlit.OffloadTriggerManual, nil)
+		}
+		meta.RemoveStatusCondition(&model.Status.Conditions, conditionTypeServiceConfigured)
+		meta.RemoveStatusCondition(&model.Status.Conditions, conditionTypePoliciesEnforced)
 		return nil
 	}
+	gatewayPolicies, err := helper.GatewayPolicies(ctx, r.Client, model)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to retrieve gateway policies for ModelDeployment")
+		return err
+	}
+	enforcer, canEnforce := r.Offloader.(offloader.PolicyEnforcer)
+	if !canEnforce && len(gatewayPolicies) > 0 {
+		// The traffic offloaded without the gateway policies would bypass them
+		unsupportedErr := &offloader.ErrUnsupportedPolicy{Model: types.NamespacedName{Namespace: model.Namespace, Name: model.Name}}
+		for _, policy := range gatewayPolicies {
+			unsupportedErr.Policies = append(unsupportedErr.Policies, policy.GetName())
+		}
+		return unsupportedErr
+	}
 	if model.Spec.OffloadingConfig.RemoteBackend == nil { // TODO: Make this really configurable
 		logger.V(1).Info("Setting default remote service reference for ModelDeployment", "Name", model.Name)
 		model.Spec.OffloadingConfig.RemoteBackend = r.DefaultRemoteBackend
@@ -259,9 +390,22 @@ func (r *ModelDeploymentReconciler) configureOffloading(ctx context.Context, mod
 		logger.V(0).Error(err, "Failed to configure offloading for ModelDeployment")
 		return err
 	}
+	setServiceConfiguredCondition(&model.Status.Conditions, model.Generation, nil)
+	if event, ok := r.serviceEvents.LoadAndDelete(fmt.Sprintf("%s/%s", model.Namespace, model.Name)); ok {
+		// The Service changed and is configured again
+		setServiceEventCondition(&model.Status.Conditions, model.Generation, configurer.ServiceEvent{
+			Service: event.(configurer.ServiceEvent).Service,
+			Type:    configurer.ServiceEventReconfigured,
+		})
+	}
 	logger.V(1).Info("Successfully configured local service for ModelDeployment", "Name", model.Name)
-	r.OngoingOffloadings.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), 0)
-	r.ModelState.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), true)
+	// An unhealthy model stays fully offloaded, a model offloaded on its metrics is offloaded at the percentage of the new spec
+	percentage := offloaded
+	if offloaded != 0 && healthy {
+		percentage = int(model.Spec.OffloadingConfig.Behavior.Percentage)
+	}
+	r.OngoingOffloadings.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), percentage)
+	r.ModelState.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), healthy)
 	// TODO: Make condition duration configurable
 	logger.V(1).Info("Registering metrics watcher for ModelDeployment", "Name", model.Name)
 	r.MetricInformer.Register(ctx, fmt.Sprintf("%s/%s", model.Namespace, model.Name), model.Spec.OffloadingConfig.Metrics, model.Spec.ModelSourceRef, 5*time.Second, 5*time.Second)
@@ -269,13 +413,27 @@ func (r *ModelDeploymentReconciler) configureOffloading(ctx context.Context, mod
 	logger.V(1).Info("Registering health watcher for ModelDeployment", "Name", model.Name)
 	r.HealthInformer.Register(ctx, fmt.Sprintf("%s/%s", model.Namespace, model.Name), model.Spec.ModelSourceRef)
 	logger.V(1).Info("Successfully registered health watcher for ModelDeployment", "Name", model.Name)
-	backendServiceRef := model.Spec.ServiceRef.DeepCopy()
-	backendServiceRef.Name = fmt.Sprintf("%s-beamlit", backendServiceRef.Name) // TODO: Make this returned by the service controller
+	backendServiceRef, err := r.Configurer.GetLocalBeamlitService(ctx, model.Spec.ServiceRef)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to get local service for ModelDeployment")
+		return err
+	}
+	if canEnforce {
+		enforcer.SetGatewayPolicies(model, gatewayPolicies)
+	}
+	if len(gatewayPolicies) > 0 {
+		setPoliciesEnforcedCondition(&model.Status.Conditions, model.Generation, nil)
+	} else {
+		meta.RemoveStatusCondition(&model.Status.Conditions, conditionTypePoliciesEnforced)
+	}
 	logger.V(1).Info("Configuring offloading for ModelDeployment", "Name", model.Name)
-	if err := r.Offloader.Configure(ctx, model, backendServiceRef, model.Spec.OffloadingConfig.RemoteBackend, 0); err != nil {
+	if err := r.Offloader.Configure(ctx, model, backendServiceRef, model.Spec.OffloadingConfig.RemoteBackend, percentage); err != nil {
 		logger.V(0).Error(err, "Failed to configure offloading for ModelDeployment")
 		return err
 	}
+	if percentage != offloaded {
+		r.notifyOnBeamlit(ctx, model, percentage, beamlit.OffloadTriggerManual, nil)
+	}
 	logger.V(1).Info("Successfully registered offloading for ModelDeployment", "Name", model.Name)
 	return nil
 }
@@ -283,29 +441,38 @@ func (r *ModelDeploymentReconciler) configureOffloading(ctx context.Context, mod
 func (r *ModelDeploymentReconciler) finalizeModel(ctx context.Context, model *v1alpha1.ModelDeployment) error {
 	logger := log.FromContext(ctx)
 	logger.V(1).Info("Finalizing ModelDeployment", "Name", model.Name)
-	delete(r.BeamlitModels, fmt.Sprintf("%s/%s", model.Spec.Environment, model.Spec.Model))
+	delete(r.BeamlitModels, beamlitModelKey(model.Status.WorkspaceRef, model))
 	r.OngoingOffloadings.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
 	r.ModelState.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+	r.serviceEvents.Delete(fmt.Sprintf("%s/%s", model.Namespace, model.Name))
 	delete(r.ManagedModels, fmt.Sprintf("%s/%s", model.Namespace, model.Name))
 	logger.V(1).Info("Successfully deleted offloading for ModelDeployment", "Name", model.Name)
-	if model.Spec.OffloadingConfig == nil {
-		return nil
-	}
-	r.MetricInformer.Unregister(ctx, fmt.Sprintf("%s/%s", model.Namespace, model.Name))
-	logger.V(1).Info("Successfully removed metrics watcher for ModelDeployment", "Name", model.Name)
-	r.HealthInformer.Unregister(ctx, fmt.Sprintf("%s/%s", model.Namespace, model.Name))
-	logger.V(1).Info("Successfully removed health watcher for ModelDeployment", "Name", model.Name)
-	logger.V(1).Info("Successfully cleaned up offloading for ModelDeployment", "Name", model.Name)
-	if err := r.Configurer.Unconfigure(ctx, model.Spec.ServiceRef); err != nil {
-		logger.V(0).Error(err, "Failed to unconfigure local service for ModelDeployment")
+	if err := r.deleteSyncedSecrets(ctx, model, model.Status.WorkspaceRef, model.Status.SyncedSecrets); err != nil {
+		logger.V(0).Error(err, "Failed to delete synced secrets of ModelDeployment")
 		return err
 	}
-	if err := r.Offloader.Cleanup(ctx, model); err != nil {
-		logger.V(0).Error(err, "Failed to cleanup offloading for ModelDeployment")
+	if model.Spec.OffloadingConfig != nil {
+		r.MetricInformer.Unregister(ctx, fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+		logger.V(1).Info("Successfully removed metrics watcher for ModelDeployment", "Name", model.Name)
+		r.HealthInformer.Unregister(ctx, fmt.Sprintf("%s/%s", model.Namespace, model.Name))
+		logger.V(1).Info("Successfully removed health watcher for ModelDeployment", "Name", model.Name)
+		logger.V(1).Info("Successfully cleaned up offloading for ModelDeployment", "Name", model.Name)
+		if err := r.Configurer.Unconfigure(ctx, model.Spec.ServiceRef); err != nil {
+			logger.V(0).Error(err, "Failed to unconfigure local service for ModelDeployment")
+			return err
+		}
+		if err := r.Offloader.Cleanup(ctx, model); err != nil {
+			logger.V(0).Error(err, "Failed to cleanup offloading for ModelDeployment")
+			return err
+		}
+		logger.V(1).Info("Successfully unregistered local service for ModelDeployment", "Name", model.Name)
+	}
+	beamlitClient, err := r.WorkspaceClients.ClientFor(ctx, model.Status.WorkspaceRef)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to get Beamlit client for ModelDeployment", "Name", model.Name, "Workspace", model.Status.WorkspaceRef)
 		return err
 	}
-	logger.V(1).Info("Successfully unregistered local service for ModelDeployment", "Name", model.Name)
-	if err := r.BeamlitClient.DeleteModelDeployment(ctx, model.Spec.Model, model.Spec.Environment); err != nil {
+	if err := beamlitClient.DeleteModelDeployment(ctx, model.Spec.Model, model.Spec.Environment, helper.ModelOwner(model)); err != nil {
 		logger.V(0).Error(err, "Failed to delete ModelDeployment")
 		return err
 	}
@@ -314,10 +481,141 @@ func (r *ModelDeploymentReconciler) finalizeModel(ctx context.Context, model *v1
 }
 
 // SetupWithManager sets up the controller with the Manager.
+// The ModelDeployments are reconciled again when a Policy or a ClusterPolicy they reference changes,
+// a ConfigMap inlined in the pod template of their model source, or a Secret it reads when the Secrets are synced.
+// They are also reconciled again when the configurer changed their Service, and when their Service is created.
+// Only the metadata of the ConfigMaps, the Secrets and the Services is cached.
 func (r *ModelDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.ModelDeployment{}, serviceRefIndexKey, func(obj client.Object) []string {
+		serviceRef := obj.(*v1alpha1.ModelDeployment).Spec.ServiceRef
+		if serviceRef == nil {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s/%s", serviceRef.Namespace, serviceRef.Name)}
+	})
+	if err != nil {
+		return err
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.ModelDeployment{}).
-		Complete(r)
+		Watches(&authorizationv1alpha1.Policy{}, handler.EnqueueRequestsFromMapFunc(r.modelsForPolicy)).
+		Watches(&authorizationv1alpha1.ClusterPolicy{}, handler.EnqueueRequestsFromMapFunc(r.modelsForPolicy)).
+		Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.modelsForSourceObject("ConfigMap")), builder.OnlyMetadata).
+		Watches(&v1.Service{}, handler.EnqueueRequestsFromMapFunc(r.modelsForService), builder.OnlyMetadata, builder.WithPredicates(predicate.Funcs{
+			CreateFunc:  func(event.CreateEvent) bool { return true },
+			UpdateFunc:  func(event.UpdateEvent) bool { return false },
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		}))
+	if r.SyncSecrets {
+		b = b.Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.modelsForSourceObject("Secret")), builder.OnlyMetadata)
+	}
+	if r.ServiceEventChan != nil {
+		serviceEvents := make(chan event.TypedGenericEvent[configurer.ServiceEvent])
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case serviceEvent := <-r.ServiceEventChan:
+					select {
+					case serviceEvents <- event.TypedGenericEvent[configurer.ServiceEvent]{Object: serviceEvent}:
+					case <-ctx.Done():
+						return nil
+					}
+				}
+			}
+		}))
+		if err != nil {
+			return err
+		}
+		b = b.WatchesRawSource(source.Channel(serviceEvents, handler.TypedEnqueueRequestsFromMapFunc(r.modelsForServiceEvent)))
+	}
+	return b.Complete(traced("ModelDeployment", r))
+}
+
+// modelsForService returns the requests of the ModelDeployments offloading a Service
+func (r *ModelDeploymentReconciler) modelsForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	models := &v1alpha1.ModelDeploymentList{}
+	if err := r.List(ctx, models, client.MatchingFields{serviceRefIndexKey: fmt.Sprintf("%s/%s", obj.GetNamespace(), obj.GetName())}); err != nil {
+		log.FromContext(ctx).V(0).Error(err, "Failed to list ModelDeployments offloading service", "Name", obj.GetName(), "Namespace", obj.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(models.Items))
+	for i := range models.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&models.Items[i])})
+	}
+	return requests
+}
+
+// modelsForServiceEvent records a change of a Service applied by the configurer for the ModelDeployments offloading it,
+// and returns their requests for their offloading to be configured again and the change reported in their status
+func (r *ModelDeploymentReconciler) modelsForServiceEvent(ctx context.Context, serviceEvent configurer.ServiceEvent) []reconcile.Request {
+	log.FromContext(ctx).V(1).Info("Service event", "Service", serviceEvent.Service.String(), "Type", serviceEvent.Type)
+	models := &v1alpha1.ModelDeploymentList{}
+	if err := r.List(ctx, models, client.MatchingFields{serviceRefIndexKey: serviceEvent.Service.String()}); err != nil {
+		log.FromContext(ctx).V(0).Error(err, "Failed to list ModelDeployments offloading service", "Service", serviceEvent.Service.String())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(models.Items))
+	for i := range models.Items {
+		r.serviceEvents.Store(fmt.Sprintf("%s/%s", models.Items[i].Namespace, models.Items[i].Name), serviceEvent)
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&models.Items[i])})
+	}
+	return requests
+}
+
+// modelsForSourceObject returns a function returning the requests of the ModelDeployments whose model source reads env vars
+// from a ConfigMap or a Secret, kind being ConfigMap or Secret
+func (r *ModelDeploymentReconciler) modelsForSourceObject(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		logger := log.FromContext(ctx)
+		models := &v1alpha1.ModelDeploymentList{}
+		if err := r.List(ctx, models); err != nil {
+			logger.V(0).Error(err, "Failed to list ModelDeployments referencing object", "Kind", kind, "Name", obj.GetName())
+			return nil
+		}
+		var requests []reconcile.Request
+		for i := range models.Items {
+			if models.Items[i].Spec.ModelSourceRef.Namespace != obj.GetNamespace() {
+				continue
+			}
+			references, err := helper.ReferencesObject(ctx, r.Client, &models.Items[i], kind, obj.GetName())
+			if err != nil {
+				logger.V(1).Info("Failed to read the model source of ModelDeployment", "Name", models.Items[i].Name, "Error", err.Error())
+				continue
+			}
+			if references {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&models.Items[i])})
+			}
+		}
+		return requests
+	}
+}
+
+// modelsForPolicy returns the requests of the ModelDeployments referencing a Policy or a ClusterPolicy
+func (r *ModelDeploymentReconciler) modelsForPolicy(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	policy, ok := obj.(authorizationv1alpha1.PolicyObject)
+	if !ok {
+		return nil
+	}
+	models := &v1alpha1.ModelDeploymentList{}
+	var opts []client.ListOption
+	if policy.GetNamespace() != "" {
+		opts = append(opts, client.InNamespace(policy.GetNamespace()))
+	}
+	if err := r.List(ctx, models, opts...); err != nil {
+		logger.V(0).Error(err, "Failed to list ModelDeployments referencing policy", "Name", policy.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range models.Items {
+		if helper.ReferencesPolicy(&models.Items[i], policy) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&models.Items[i])})
+		}
+	}
+	return requests
 }
 
 func (r *ModelDeploymentReconciler) WatchForInformerUpdates(ctx context.Context) error {
@@ -363,7 +661,7 @@ func (r *ModelDeploymentReconciler) WatchForInformerUpdates(ctx context.Context)
 					model.Spec.OffloadingConfig.RemoteBackend = r.DefaultRemoteBackend
 				}
 				logger.V(1).Info("Handling metric callback for ModelDeployment", "Name", model.Name)
-				if err := r.metricCallback(ctx, model, metricStatus.Reached); err != nil {
+				if err := r.metricCallback(ctx, model, metricStatus.Reached, metricStatus.Values); err != nil {
 					logger.V(0).Error(err, "Failed to handle metric callback for ModelDeployment", "Name", model.Name)
 					continue
 				}
@@ -373,7 +671,7 @@ func (r *ModelDeploymentReconciler) WatchForInformerUpdates(ctx context.Context)
 	}
 }
 
-func (r *ModelDeploymentReconciler) metricCallback(ctx context.Context, model *v1alpha1.ModelDeployment, reached bool) error {
+func (r *ModelDeploymentReconciler) metricCallback(ctx context.Context, model *v1alpha1.ModelDeployment, reached bool, metricValues map[string]float64) error {
 	logger := log.FromContext(ctx)
 	logger.V(1).Info("Metric callback for ModelDeployment", "Name", model.Name, "reached", reached)
 	if value, ok := r.ModelState.Load(fmt.Sprintf("%s/%s", model.Namespace, model.Name)); ok {
@@ -398,9 +696,7 @@ func (r *ModelDeploymentReconciler) metricCallback(ctx context.Context, model *v
 				logger.V(0).Error(err, "Failed to offload model deployment to 0%", "Name", model.Name)
 				return err
 			}
-			if err := r.notifyOnBeamlit(ctx, model, false); err != nil {
-				logger.V(0).Error(err, "Failed to notify on Beamlit", "Name", model.Name)
-			}
+			r.notifyOnBeamlit(ctx, model, 0, beamlit.OffloadTriggerMetric, metricValues)
 			logger.V(1).Info("Successfully offloaded model deployment to 0%", "Name", model.Name)
 		}
 		return nil
@@ -417,9 +713,7 @@ func (r *ModelDeploymentReconciler) metricCallback(ctx context.Context, model *v
 			return err
 		}
 		r.OngoingOffloadings.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), int(model.Spec.OffloadingConfig.Behavior.Percentage))
-		if err := r.notifyOnBeamlit(ctx, model, true); err != nil {
-			logger.V(0).Error(err, "Failed to notify on Beamlit", "Name", model.Name)
-		}
+		r.notifyOnBeamlit(ctx, model, int(model.Spec.OffloadingConfig.Behavior.Percentage), beamlit.OffloadTriggerMetric, metricValues)
 		logger.V(1).Info("Successfully offloaded model deployment", "Name", model.Name, "Namespace", model.Namespace)
 	}
 	return nil
@@ -441,9 +735,7 @@ func (r *ModelDeploymentReconciler) healthCheckCallback(ctx context.Context, mod
 			return err
 		}
 		r.OngoingOffloadings.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), 100)
-		if err := r.notifyOnBeamlit(ctx, model, true); err != nil {
-			logger.V(0).Error(err, "Failed to notify on Beamlit", "Name", model.Name)
-		}
+		r.notifyOnBeamlit(ctx, model, 100, beamlit.OffloadTriggerHealth, nil)
 		r.ModelState.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), false)
 		logger.V(1).Info("Successfully offloaded model deployment", "Name", model.Name, "Namespace", model.Namespace)
 		return nil
@@ -465,17 +757,33 @@ func (r *ModelDeploymentReconciler) healthCheckCallback(ctx context.Context, mod
 			return err
 		}
 		r.OngoingOffloadings.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), int(model.Spec.OffloadingConfig.Behavior.Percentage))
-		if err := r.notifyOnBeamlit(ctx, model, true); err != nil {
-			logger.V(0).Error(err, "Failed to notify on Beamlit", "Name", model.Name)
-		}
+		r.notifyOnBeamlit(ctx, model, int(model.Spec.OffloadingConfig.Behavior.Percentage), beamlit.OffloadTriggerHealth, nil)
 		r.ModelState.Store(fmt.Sprintf("%s/%s", model.Namespace, model.Name), true)
 		logger.V(1).Info("Successfully offloaded model deployment", "Name", model.Name, "Namespace", model.Namespace)
 	}
 	return nil
 }
 
-func (r *ModelDeploymentReconciler) notifyOnBeamlit(ctx context.Context, model *v1alpha1.ModelDeployment, offloading bool) error {
-	return r.BeamlitClient.NotifyOnModelOffloading(ctx, model.Spec.Model, model.Spec.Environment, offloading)
+// notifyOnBeamlit reports an offloading transition of the model on Beamlit, in the background
+func (r *ModelDeploymentReconciler) notifyOnBeamlit(ctx context.Context, model *v1alpha1.ModelDeployment, percentage int, trigger beamlit.OffloadTrigger, metricValues map[string]float64) {
+	r.OffloadReporter.Report(ctx, reporter.OffloadReport{
+		Workspace:   model.Status.WorkspaceRef,
+		Environment: model.Spec.Environment,
+		Model:       model.Spec.Model,
+		Event: beamlit.OffloadEvent{
+			Timestamp:    time.Now(),
+			Offloading:   percentage > 0,
+			Percentage:   percentage,
+			Trigger:      trigger,
+			MetricValues: metricValues,
+			Source:       fmt.Sprintf("%s/%s", model.Namespace, model.Name),
+		},
+	})
+}
+
+// beamlitModelKey returns the key of a model in BeamlitModels
+func beamlitModelKey(workspace string, model *v1alpha1.ModelDeployment) string {
+	return fmt.Sprintf("%s/%s/%s", workspace, model.Spec.Environment, model.Spec.Model)
 }
 
 func (r *ModelDeploymentReconciler) PolicyUpdate(ctx context.Context) error {
@@ -21,64 +21,355 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
 	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/controller/helper"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/configurer"
+	"github.com/beamlit/beamlit-controller/internal/informers/health"
 )
 
+// remoteModelEnabled returns whether the model named name is enabled on the fake Beamlit API, nil if it doesn't exist
+func remoteModelEnabled(name string) func() *bool {
+	return func() *bool {
+		model, ok := beamlitServer.Model("production", name)
+		if !ok || model.Spec == nil {
+			return nil
+		}
+		return model.Spec.Enabled
+	}
+}
+
 var _ = Describe("ModelDeployment Controller", func() {
 	Context("When reconciling a resource", func() {
-		const resourceName = "test-resource"
-
 		ctx := context.Background()
+		labels := map[string]string{"app": "llama"}
+
+		BeforeEach(func() {
+			By("creating the model source and its Service")
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "llama", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{Containers: []corev1.Container{{
+							Name:  "llama",
+							Image: "vllm/vllm-openai",
+							Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8000}},
+						}}},
+					},
+				},
+			}
+			if err := k8sClient.Create(ctx, deployment); err != nil && !errors.IsAlreadyExists(err) {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "llama", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					Selector: labels,
+					Ports:    []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("http")}},
+				},
+			}
+			if err := k8sClient.Create(ctx, service); err != nil && !errors.IsAlreadyExists(err) {
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
 
-		typeNamespacedName := types.NamespacedName{
-			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
+		newModel := func(name string) *modelv1alpha1.ModelDeployment {
+			return &modelv1alpha1.ModelDeployment{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec: modelv1alpha1.ModelDeploymentSpec{
+					Model:          name,
+					Environment:    "production",
+					Enabled:        true,
+					ModelSourceRef: corev1.ObjectReference{Kind: "Deployment", Namespace: "default", Name: "llama"},
+				},
+			}
 		}
-		modeldeployment := &modelv1alpha1.ModelDeployment{}
 
-		BeforeEach(func() {
-			By("creating the custom resource for the Kind ModelDeployment")
-			err := k8sClient.Get(ctx, typeNamespacedName, modeldeployment)
-			if err != nil && errors.IsNotFound(err) {
-				resource := &modelv1alpha1.ModelDeployment{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      resourceName,
-						Namespace: "default",
+		deleteModel := func(model *modelv1alpha1.ModelDeployment) {
+			Expect(k8sClient.Delete(ctx, model)).To(Succeed())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(model), model))
+			}).Should(BeTrue())
+		}
+
+		It("should create, update and delete the model on Beamlit", func() {
+			model := newModel("llama-crud")
+			By("creating the ModelDeployment")
+			Expect(k8sClient.Create(ctx, model)).To(Succeed())
+			Eventually(remoteModelEnabled("llama-crud")).Should(HaveValue(BeTrue()))
+			remote, _ := beamlitServer.Model("production", "llama-crud")
+			Expect((*remote.Metadata.Labels)[beamlit.OwnerLabel]).To(Equal("default/llama-crud"))
+			Expect(remote.Spec.PodTemplate).NotTo(BeNil())
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(model), model)).To(Succeed())
+				g.Expect(model.Status.CreatedAtOnBeamlit.IsZero()).To(BeFalse())
+				g.Expect(model.Status.Conditions).To(ContainElement(And(
+					HaveField("Type", conditionTypeSynced),
+					HaveField("Status", metav1.ConditionTrue),
+				)))
+			}).Should(Succeed())
+
+			By("updating the ModelDeployment")
+			model.Spec.Policies = []modelv1alpha1.PolicyRef{{RefType: modelv1alpha1.PolicyRefTypeRemotePolicy, Name: "console-policy"}}
+			Expect(k8sClient.Update(ctx, model)).To(Succeed())
+			Eventually(func() []string {
+				remote, _ := beamlitServer.Model("production", "llama-crud")
+				if remote.Spec == nil || remote.Spec.Policies == nil {
+					return nil
+				}
+				return *remote.Spec.Policies
+			}).Should(Equal([]string{"console-policy"}))
+
+			By("deleting the ModelDeployment")
+			deleteModel(model)
+			Expect(remoteModelEnabled("llama-crud")()).To(BeNil())
+		})
+
+		It("should revert the drift of the model on Beamlit after a restart", func() {
+			model := newModel("llama-drift")
+			Expect(k8sClient.Create(ctx, model)).To(Succeed())
+			Eventually(remoteModelEnabled("llama-drift")).Should(HaveValue(BeTrue()))
+
+			By("disabling the model on Beamlit")
+			drifted, _ := beamlitServer.Model("production", "llama-drift")
+			disabled := false
+			drifted.Spec.Enabled = &disabled
+			beamlitServer.SetModel(drifted)
+
+			By("reconciling the ModelDeployment with a restarted reconciler")
+			restarted := &ModelDeploymentReconciler{
+				Client:           k8sClient,
+				Scheme:           k8sClient.Scheme(),
+				WorkspaceClients: workspaceClients,
+				Offloader:        modelReconciler.Offloader,
+				OffloadReporter:  modelReconciler.OffloadReporter,
+				Configurer:       modelReconciler.Configurer,
+				MetricInformer:   modelReconciler.MetricInformer,
+				HealthInformer:   modelReconciler.HealthInformer,
+				ManagedModels:    make(map[string]ManagedModel),
+				BeamlitModels:    make(map[string]string),
+			}
+			_, err := restarted.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(model)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(remoteModelEnabled("llama-drift")()).To(HaveValue(BeTrue()))
+
+			deleteModel(model)
+		})
+
+		It("should push the model again when a ConfigMap inlined in its pod template changes", func() {
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "llama-config", Namespace: "default"},
+				Data:       map[string]string{"name": "llama-3"},
+			}
+			Expect(k8sClient.Create(ctx, configMap)).To(Succeed())
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "llama-configured", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{Containers: []corev1.Container{{
+							Name:  "llama",
+							Image: "vllm/vllm-openai",
+							Env: []corev1.EnvVar{{Name: "MODEL_NAME", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "llama-config"},
+								Key:                  "name",
+							}}}},
+						}}},
 					},
-					// TODO(user): Specify other spec details if needed.
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).To(Succeed())
+			model := newModel("llama-configured")
+			model.Spec.ModelSourceRef.Name = "llama-configured"
+			Expect(k8sClient.Create(ctx, model)).To(Succeed())
+			remoteEnv := func() any {
+				remote, ok := beamlitServer.Model("production", "llama-configured")
+				if !ok || remote.Spec == nil || remote.Spec.PodTemplate == nil {
+					return nil
 				}
-				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+				spec, _ := (*remote.Spec.PodTemplate)["spec"].(map[string]any)
+				containers, _ := spec["containers"].([]any)
+				if len(containers) == 0 {
+					return nil
+				}
+				return containers[0].(map[string]any)["env"]
 			}
+			Eventually(remoteEnv).Should(ContainElement(HaveKeyWithValue("value", "llama-3")))
+
+			By("updating the ConfigMap")
+			configMap.Data["name"] = "llama-3.1"
+			Expect(k8sClient.Update(ctx, configMap)).To(Succeed())
+			Eventually(remoteEnv).Should(ContainElement(HaveKeyWithValue("value", "llama-3.1")))
+
+			deleteModel(model)
+			Expect(k8sClient.Delete(ctx, deployment)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, configMap)).To(Succeed())
 		})
 
-		AfterEach(func() {
-			// TODO(user): Cleanup logic after each test, like removing the resource instance.
-			resource := &modelv1alpha1.ModelDeployment{}
-			err := k8sClient.Get(ctx, typeNamespacedName, resource)
-			Expect(err).NotTo(HaveOccurred())
+		It("should push a Secret read by the pod template again when it changes", func() {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "llama-token", Namespace: "default"},
+				StringData: map[string]string{"token": "hf_1"},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "llama-authenticated", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{Containers: []corev1.Container{{
+							Name:  "llama",
+							Image: "vllm/vllm-openai",
+							Env: []corev1.EnvVar{{Name: "HF_TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "llama-token"},
+								Key:                  "token",
+							}}}},
+						}}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).To(Succeed())
+			model := newModel("llama-authenticated")
+			model.Spec.ModelSourceRef.Name = "llama-authenticated"
+			Expect(k8sClient.Create(ctx, model)).To(Succeed())
+			name := helper.BeamlitSecretName("default", "llama-token", "token")
+			remoteSecret := func() string {
+				value, _ := beamlitServer.Secret(name)
+				return value
+			}
+			Eventually(remoteSecret).Should(Equal("hf_1"))
+
+			By("updating the Secret")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), secret)).To(Succeed())
+			secret.StringData = map[string]string{"token": "hf_2"}
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+			Eventually(remoteSecret).Should(Equal("hf_2"))
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(model), model)).To(Succeed())
+				g.Expect(model.Status.SyncedSecrets).To(ConsistOf(modelv1alpha1.SyncedSecret{
+					Name:            name,
+					Source:          "Secret/llama-token/token",
+					ResourceVersion: secret.ResourceVersion,
+				}))
+			}).Should(Succeed())
 
-			By("Cleanup the specific resource instance ModelDeployment")
-			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			deleteModel(model)
+			Eventually(remoteSecret).Should(BeEmpty())
+			Expect(k8sClient.Delete(ctx, deployment)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
 		})
-		It("should successfully reconcile the resource", func() {
-			By("Reconciling the created resource")
-			controllerReconciler := &ModelDeploymentReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+
+		It("should notify Beamlit when the model is offloaded", func() {
+			model := newModel("llama-offloaded")
+			model.Spec.ServiceRef = &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "llama"},
+				TargetPort:      80,
 			}
+			model.Spec.OffloadingConfig = &modelv1alpha1.OffloadingConfig{
+				Behavior: &modelv1alpha1.OffloadingBehavior{Percentage: 50},
+			}
+			key := client.ObjectKeyFromObject(model).String()
+			Expect(k8sClient.Create(ctx, model)).To(Succeed())
+			Eventually(func() bool {
+				weight, ok := offloads.last(key)
+				return ok && weight == 0
+			}).Should(BeTrue(), "the offloading must be configured without traffic to the remote backend")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(model), model)).To(Succeed())
+				g.Expect(model.Status.ServingPort).To(BeEquivalentTo(8000))
+			}).Should(Succeed())
 
-			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
-				NamespacedName: typeNamespacedName,
-			})
-			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			By("reporting the model unhealthy")
+			Eventually(func() int {
+				healthStatusChan <- health.HealthStatus{ModelName: key, Healthy: false}
+				weight, _ := offloads.last(key)
+				return weight
+			}).Should(Equal(100))
+			Eventually(func() []map[string]any {
+				return beamlitServer.OffloadEvents("production", "llama-offloaded")
+			}).Should(ContainElement(And(
+				HaveKeyWithValue("trigger", string(beamlit.OffloadTriggerHealth)),
+				HaveKeyWithValue("offloading", true),
+			)))
+			remote, _ := beamlitServer.Model("production", "llama-offloaded")
+			Expect(*remote.Metadata.Labels).To(HaveKey(ContainSubstring("offloading")))
+
+			By("reporting the model healthy again")
+			Eventually(func() int {
+				healthStatusChan <- health.HealthStatus{ModelName: key, Healthy: true}
+				weight, _ := offloads.last(key)
+				return weight
+			}).Should(Equal(50))
+
+			By("changing the offloading percentage")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(model), model); err != nil {
+					return err
+				}
+				model.Spec.OffloadingConfig.Behavior.Percentage = 30
+				return k8sClient.Update(ctx, model)
+			}).Should(Succeed())
+			Eventually(func() int {
+				weight, _ := offloads.last(key)
+				return weight
+			}).Should(Equal(30))
+			Eventually(func() []map[string]any {
+				return beamlitServer.OffloadEvents("production", "llama-offloaded")
+			}).Should(ContainElement(And(
+				HaveKeyWithValue("trigger", string(beamlit.OffloadTriggerManual)),
+				HaveKeyWithValue("percentage", BeEquivalentTo(30)),
+			)))
+
+			deleteModel(model)
+		})
+
+		It("should configure the offloading again when the configurer changed the Service", func() {
+			model := newModel("llama-service-changed")
+			model.Spec.ServiceRef = &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "llama"},
+				TargetPort:      80,
+			}
+			model.Spec.OffloadingConfig = &modelv1alpha1.OffloadingConfig{
+				Behavior: &modelv1alpha1.OffloadingBehavior{Percentage: 50},
+			}
+			key := client.ObjectKeyFromObject(model).String()
+			Expect(k8sClient.Create(ctx, model)).To(Succeed())
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(model), model)).To(Succeed())
+				g.Expect(meta.FindStatusCondition(model.Status.Conditions, conditionTypeServiceConfigured)).NotTo(BeNil())
+			}).Should(Succeed())
+			configured := offloads.count(key)
+
+			By("sending a change of the Service")
+			serviceEventChan <- configurer.ServiceEvent{
+				Service: types.NamespacedName{Namespace: "default", Name: "llama"},
+				Type:    configurer.ServiceEventReconfigured,
+			}
+			Eventually(func() int {
+				return offloads.count(key)
+			}).Should(BeNumerically(">", configured))
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(model), model)).To(Succeed())
+				condition := meta.FindStatusCondition(model.Status.Conditions, conditionTypeServiceConfigured)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonServiceReconfigured))
+			}).Should(Succeed())
+
+			deleteModel(model)
 		})
 	})
 })
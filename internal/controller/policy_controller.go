@@ -19,8 +19,11 @@ package controller
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -29,16 +32,17 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
-	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/config"
 	"github.com/beamlit/beamlit-controller/internal/controller/helper"
 )
 
 // PolicyReconciler reconciles a Policy object
 type PolicyReconciler struct {
 	client.Client
-	Scheme          *runtime.Scheme
-	BeamlitClient   *beamlit.Client
-	ManagedPolicies map[string]ManagedPolicyRef // key: policyName
+	Scheme           *runtime.Scheme
+	WorkspaceClients *WorkspaceClients
+	ManagedPolicies  *ManagedPolicies
+	NamingStrategy   config.PolicyNamingStrategy
 }
 
 type ManagedPolicyRef struct {
@@ -46,6 +50,36 @@ type ManagedPolicyRef struct {
 	namespacedName  types.NamespacedName
 }
 
+// ManagedPolicies tracks the policies synced to Beamlit, keyed by their workspace and name on Beamlit
+// It is shared by the Policy and ClusterPolicy reconcilers to detect name clashes between them
+type ManagedPolicies struct {
+	mu   sync.Mutex
+	refs map[string]ManagedPolicyRef
+}
+
+func NewManagedPolicies() *ManagedPolicies {
+	return &ManagedPolicies{refs: make(map[string]ManagedPolicyRef)}
+}
+
+func (m *ManagedPolicies) get(name string) (ManagedPolicyRef, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ref, ok := m.refs[name]
+	return ref, ok
+}
+
+func (m *ManagedPolicies) set(name string, ref ManagedPolicyRef) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refs[name] = ref
+}
+
+func (m *ManagedPolicies) delete(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.refs, name)
+}
+
 const policyFinalizer = "policy.beamlit.com/finalizer"
 
 //+kubebuilder:rbac:groups=authorization.beamlit.com,resources=policies,verbs=get;list;watch;create;update;patch;delete
@@ -62,10 +96,30 @@ const policyFinalizer = "policy.beamlit.com/finalizer"
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.3/pkg/reconcile
 func (r *PolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.syncer().reconcile(ctx, req, &authorizationv1alpha1.Policy{})
+}
+
+func (r *PolicyReconciler) syncer() *policySyncer {
+	return &policySyncer{
+		Client:           r.Client,
+		workspaceClients: r.WorkspaceClients,
+		managedPolicies:  r.ManagedPolicies,
+		namingStrategy:   r.NamingStrategy,
+	}
+}
+
+// policySyncer syncs Policy and ClusterPolicy resources to Beamlit
+type policySyncer struct {
+	client.Client
+	workspaceClients *WorkspaceClients
+	managedPolicies  *ManagedPolicies
+	namingStrategy   config.PolicyNamingStrategy
+}
+
+func (r *policySyncer) reconcile(ctx context.Context, req ctrl.Request, policy authorizationv1alpha1.PolicyObject) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 	logger.V(0).Info("Reconciling Policy", "Name", req.NamespacedName)
-	var policy authorizationv1alpha1.Policy
-	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
 		if errors.IsNotFound(err) {
 			logger.V(0).Info("Policy not found", "Name", req.NamespacedName)
 			return ctrl.Result{}, nil
@@ -75,15 +129,15 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	}
 
 	if policy.GetDeletionTimestamp() != nil {
-		if controllerutil.ContainsFinalizer(&policy, policyFinalizer) {
-			logger.V(0).Info("Finalizing Policy", "Name", policy.Name)
-			if err := r.finalizePolicy(ctx, &policy); err != nil {
+		if controllerutil.ContainsFinalizer(policy, policyFinalizer) {
+			logger.V(0).Info("Finalizing Policy", "Name", policy.GetName())
+			if err := r.finalizePolicy(ctx, policy); err != nil {
 				logger.V(0).Error(err, "Failed to finalize Policy")
 				return ctrl.Result{}, err
 			}
-			delete(r.ManagedPolicies, policy.Name)
-			controllerutil.RemoveFinalizer(&policy, policyFinalizer)
-			if err := r.Update(ctx, &policy); err != nil {
+			r.managedPolicies.delete(managedPolicyKey(policy.GetPolicyStatus().WorkspaceRef, r.remoteName(policy)))
+			controllerutil.RemoveFinalizer(policy, policyFinalizer)
+			if err := r.Update(ctx, policy); err != nil {
 				logger.V(0).Error(err, "Failed to update Policy")
 				return ctrl.Result{}, err
 			}
@@ -91,62 +145,169 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, nil
 	}
 
-	if !controllerutil.ContainsFinalizer(&policy, policyFinalizer) {
-		logger.V(0).Info("Adding finalizer to Policy", "Name", policy.Name)
-		controllerutil.AddFinalizer(&policy, policyFinalizer)
-		if err := r.Update(ctx, &policy); err != nil {
+	if !controllerutil.ContainsFinalizer(policy, policyFinalizer) {
+		logger.V(0).Info("Adding finalizer to Policy", "Name", policy.GetName())
+		controllerutil.AddFinalizer(policy, policyFinalizer)
+		if err := r.Update(ctx, policy); err != nil {
 			logger.V(0).Error(err, "Failed to update Policy")
 			return ctrl.Result{}, err
 		}
 		return ctrl.Result{}, nil
 	}
 
-	if err := r.createOrUpdate(ctx, &policy); err != nil {
+	if err := r.createOrUpdate(ctx, policy); err != nil {
 		if errors.IsConflict(err) {
 			logger.V(0).Info("Conflict detected, retrying", "error", err)
 			return ctrl.Result{Requeue: true}, nil
 		}
-		logger.V(0).Error(err, "Failed to create or update Policy")
-		if err := r.Client.Status().Update(ctx, &policy); err != nil {
-			logger.V(0).Error(err, "Failed to update Policy status")
-			return ctrl.Result{}, err
-		}
-		r.ManagedPolicies[policy.Name] = ManagedPolicyRef{
-			lastGeneratedID: policy.Generation,
-			namespacedName:  req.NamespacedName,
+		if setErrorCondition(&policy.GetPolicyStatus().Conditions, policy.GetGeneration(), err) {
+			if err := r.Status().Update(ctx, policy); err != nil {
+				logger.V(0).Error(err, "Failed to update Policy status")
+			}
 		}
-		return ctrl.Result{}, err
+		logger.V(0).Error(err, "Failed to create or update Policy")
+		return beamlitErrorResult(err)
 	}
-	logger.V(0).Info("Successfully created or updated Policy", "Name", policy.Name)
+	logger.V(0).Info("Successfully created or updated Policy", "Name", policy.GetName())
 	return ctrl.Result{}, nil
 
 }
 
-func (r *PolicyReconciler) createOrUpdate(ctx context.Context, policy *authorizationv1alpha1.Policy) error {
-	ref, ok := r.ManagedPolicies[policy.Name]
+func (r *policySyncer) createOrUpdate(ctx context.Context, policy authorizationv1alpha1.PolicyObject) error {
+	logger := log.FromContext(ctx)
+	status := policy.GetPolicyStatus()
+	if policy.GetPolicySpec().Type == authorizationv1alpha1.PolicyTypeGateway {
+		if err := helper.ValidateGatewayPolicy(policy.GetPolicySpec().Gateway); err != nil {
+			return err
+		}
+		return r.removeFromBeamlit(ctx, policy)
+	}
+	workspace, err := r.workspaceClients.Resolve(ctx, policy.GetNamespace(), policy.GetPolicySpec().WorkspaceRef)
+	if err != nil {
+		return err
+	}
+	remoteName := helper.BeamlitPolicyName(policy, r.namingStrategy)
+	ref, ok := r.managedPolicies.get(managedPolicyKey(workspace, remoteName))
 	if ok {
-		if ref.namespacedName.Namespace != policy.Namespace || ref.namespacedName.Name != policy.Name {
-			return fmt.Errorf("policy %s is already defined", policy.Name)
+		if ref.namespacedName != client.ObjectKeyFromObject(policy) {
+			return fmt.Errorf("policy %s is already defined by %s", remoteName, ref.namespacedName.String())
+		}
+	}
+	if ref.lastGeneratedID == policy.GetGeneration() && status.RemoteName == remoteName && status.WorkspaceRef == workspace {
+		return nil
+	}
+	if status.RemoteName != "" && (status.RemoteName != remoteName || status.WorkspaceRef != workspace) {
+		logger.V(1).Info("Remote name or workspace changed, deleting previous Policy on Beamlit", "Name", policy.GetName(), "RemoteName", status.RemoteName, "Workspace", status.WorkspaceRef)
+		if err := r.deletePolicy(ctx, policy, status.WorkspaceRef, status.RemoteName); err != nil {
+			return err
+		}
+	}
+	beamlitClient, err := r.workspaceClients.ClientFor(ctx, workspace)
+	if err != nil {
+		return err
+	}
+	beamlitPolicy, err := beamlitClient.CreateOrUpdatePolicy(ctx, *helper.ToBeamlitPolicy(policy, remoteName), helper.PolicyOwner(policy))
+	if err != nil {
+		return err
+	}
+	status.RemoteName = remoteName
+	status.WorkspaceRef = workspace
+	setAuthenticatedCondition(&status.Conditions, policy.GetGeneration(), nil)
+	setSyncedCondition(&status.Conditions, policy.GetGeneration(), nil)
+	if beamlitPolicy.Metadata != nil {
+		if beamlitPolicy.Metadata.Workspace != nil {
+			status.Workspace = *beamlitPolicy.Metadata.Workspace
+		}
+		if beamlitPolicy.Metadata.CreatedAt != nil {
+			if createdAt, err := time.Parse(time.RFC3339, *beamlitPolicy.Metadata.CreatedAt); err == nil {
+				status.CreatedAtOnBeamlit = metav1.NewTime(createdAt)
+			}
+		}
+		if beamlitPolicy.Metadata.UpdatedAt != nil {
+			if updatedAt, err := time.Parse(time.RFC3339, *beamlitPolicy.Metadata.UpdatedAt); err == nil {
+				status.UpdatedAtOnBeamlit = metav1.NewTime(updatedAt)
+			}
 		}
 	}
-	if ref.lastGeneratedID == policy.Generation {
+	if err := r.Status().Update(ctx, policy); err != nil {
+		return err
+	}
+	r.managedPolicies.set(managedPolicyKey(workspace, remoteName), ManagedPolicyRef{
+		lastGeneratedID: policy.GetGeneration(),
+		namespacedName:  client.ObjectKeyFromObject(policy),
+	})
+	return nil
+}
+
+func (r *policySyncer) finalizePolicy(ctx context.Context, policy authorizationv1alpha1.PolicyObject) error {
+	status := policy.GetPolicyStatus()
+	if policy.GetPolicySpec().Type == authorizationv1alpha1.PolicyTypeGateway && status.RemoteName == "" {
 		return nil
 	}
-	beamlitPolicy, err := r.BeamlitClient.CreateOrUpdatePolicy(ctx, *helper.ToBeamlitPolicy(policy))
+	workspace := status.WorkspaceRef
+	if status.RemoteName == "" {
+		var err error
+		if workspace, err = r.workspaceClients.Resolve(ctx, policy.GetNamespace(), policy.GetPolicySpec().WorkspaceRef); err != nil {
+			if isWorkspaceNotAllowed(err) {
+				// The policy could not be synced to a workspace not allowed in its namespace
+				return nil
+			}
+			return err
+		}
+	}
+	beamlitClient, err := r.workspaceClients.ClientFor(ctx, workspace)
 	if err != nil {
 		return err
 	}
-	policy.Status.Workspace = *beamlitPolicy.Metadata.Workspace
+	return beamlitClient.DeletePolicy(ctx, r.remoteName(policy), helper.PolicyOwner(policy))
+}
+
+// deletePolicy deletes the policy named remoteName from the BeamlitWorkspace named workspace
+func (r *policySyncer) deletePolicy(ctx context.Context, policy authorizationv1alpha1.PolicyObject, workspace string, remoteName string) error {
+	beamlitClient, err := r.workspaceClients.ClientFor(ctx, workspace)
+	if err != nil {
+		return err
+	}
+	if err := beamlitClient.DeletePolicy(ctx, remoteName, helper.PolicyOwner(policy)); err != nil {
+		return err
+	}
+	r.managedPolicies.delete(managedPolicyKey(workspace, remoteName))
 	return nil
 }
 
-func (r *PolicyReconciler) finalizePolicy(ctx context.Context, policy *authorizationv1alpha1.Policy) error {
-	return r.BeamlitClient.DeletePolicy(ctx, policy.Name)
+// removeFromBeamlit deletes the policy from Beamlit if it was synced before, e.g. when its type changed to gateway
+// Gateway policies are enforced by the Beamlit gateway, so they are not synced to Beamlit
+func (r *policySyncer) removeFromBeamlit(ctx context.Context, policy authorizationv1alpha1.PolicyObject) error {
+	status := policy.GetPolicyStatus()
+	if status.RemoteName != "" {
+		log.FromContext(ctx).V(1).Info("Policy is enforced by the gateway, deleting it on Beamlit", "Name", policy.GetName(), "RemoteName", status.RemoteName)
+		if err := r.deletePolicy(ctx, policy, status.WorkspaceRef, status.RemoteName); err != nil {
+			return err
+		}
+		*status = authorizationv1alpha1.PolicyStatus{}
+		if err := r.Status().Update(ctx, policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteName returns the name of the policy on Beamlit, as last synced if any
+func (r *policySyncer) remoteName(policy authorizationv1alpha1.PolicyObject) string {
+	if remoteName := policy.GetPolicyStatus().RemoteName; remoteName != "" {
+		return remoteName
+	}
+	return helper.BeamlitPolicyName(policy, r.namingStrategy)
+}
+
+// managedPolicyKey returns the key of a policy in ManagedPolicies
+func managedPolicyKey(workspace string, remoteName string) string {
+	return fmt.Sprintf("%s/%s", workspace, remoteName)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&authorizationv1alpha1.Policy{}).
-		Complete(r)
+		Complete(traced("Policy", r))
 }
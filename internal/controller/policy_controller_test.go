@@ -19,66 +19,128 @@ package controller
 import (
 	"context"
 
+	beamlitsdk "github.com/beamlit/toolkit/sdk"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/types"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
-
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/config"
 )
 
+// remoteLocations returns the names of the locations of the policy named name on the fake Beamlit API
+func remoteLocations(name string) func() []string {
+	return func() []string {
+		policy, ok := beamlitServer.Policy(name)
+		if !ok || policy.Spec == nil || policy.Spec.Locations == nil {
+			return nil
+		}
+		var locations []string
+		for _, location := range *policy.Spec.Locations {
+			locations = append(locations, *location.Name)
+		}
+		return locations
+	}
+}
+
 var _ = Describe("Policy Controller", func() {
 	Context("When reconciling a resource", func() {
-		const resourceName = "test-resource"
-
 		ctx := context.Background()
 
-		typeNamespacedName := types.NamespacedName{
-			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
-		}
-		policy := &authorizationv1alpha1.Policy{}
-
-		BeforeEach(func() {
-			By("creating the custom resource for the Kind Policy")
-			err := k8sClient.Get(ctx, typeNamespacedName, policy)
-			if err != nil && errors.IsNotFound(err) {
-				resource := &authorizationv1alpha1.Policy{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      resourceName,
-						Namespace: "default",
-					},
-					// TODO(user): Specify other spec details if needed.
-				}
-				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		newLocationPolicy := func(name string, locations ...string) *authorizationv1alpha1.Policy {
+			policy := &authorizationv1alpha1.Policy{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       authorizationv1alpha1.PolicySpec{Type: authorizationv1alpha1.PolicyTypeLocation},
 			}
+			for _, location := range locations {
+				policy.Spec.Locations = append(policy.Spec.Locations, authorizationv1alpha1.PolicyLocation{
+					Type: authorizationv1alpha1.PolicySubTypeLocationContinent,
+					Name: location,
+				})
+			}
+			return policy
+		}
+
+		It("should create, update and delete the policy on Beamlit", func() {
+			policy := newLocationPolicy("eu-only", "eu")
+			By("creating the Policy")
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+			Eventually(remoteLocations("eu-only")).Should(Equal([]string{"eu"}))
+			remote, _ := beamlitServer.Policy("eu-only")
+			Expect((*remote.Metadata.Labels)[beamlit.OwnerLabel]).To(Equal("default/eu-only"))
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(policy), policy)).To(Succeed())
+				g.Expect(policy.Status.RemoteName).To(Equal("eu-only"))
+				g.Expect(controllerutil.ContainsFinalizer(policy, policyFinalizer)).To(BeTrue())
+			}).Should(Succeed())
+
+			By("updating the Policy")
+			policy.Spec.Locations = append(policy.Spec.Locations, authorizationv1alpha1.PolicyLocation{
+				Type: authorizationv1alpha1.PolicySubTypeLocationContinent,
+				Name: "us",
+			})
+			Expect(k8sClient.Update(ctx, policy)).To(Succeed())
+			Eventually(remoteLocations("eu-only")).Should(Equal([]string{"eu", "us"}))
+
+			By("deleting the Policy")
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			Eventually(func() bool {
+				_, ok := beamlitServer.Policy("eu-only")
+				return ok
+			}).Should(BeFalse())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(policy), policy))
+			}).Should(BeTrue())
 		})
 
-		AfterEach(func() {
-			// TODO(user): Cleanup logic after each test, like removing the resource instance.
-			resource := &authorizationv1alpha1.Policy{}
-			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+		It("should revert the drift of the policy on Beamlit after a restart", func() {
+			policy := newLocationPolicy("us-only", "us")
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+			Eventually(remoteLocations("us-only")).Should(Equal([]string{"us"}))
+
+			By("changing the policy on Beamlit")
+			drifted, _ := beamlitServer.Policy("us-only")
+			name, locationType := "asia", "continent"
+			drifted.Spec.Locations = &[]beamlitsdk.PolicyLocation{{Name: &name, Type: &locationType}}
+			beamlitServer.SetPolicy(drifted)
+
+			By("reconciling the Policy with a restarted reconciler")
+			restarted := &PolicyReconciler{
+				Client:           k8sClient,
+				Scheme:           k8sClient.Scheme(),
+				WorkspaceClients: workspaceClients,
+				ManagedPolicies:  NewManagedPolicies(),
+				NamingStrategy:   config.PolicyNamingStrategyPlain,
+			}
+			_, err := restarted.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(policy)})
 			Expect(err).NotTo(HaveOccurred())
+			Expect(remoteLocations("us-only")()).To(Equal([]string{"us"}))
 
-			By("Cleanup the specific resource instance Policy")
-			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
 		})
-		It("should successfully reconcile the resource", func() {
-			By("Reconciling the created resource")
-			controllerReconciler := &PolicyReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
-			}
 
-			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
-				NamespacedName: typeNamespacedName,
+		It("should neither update nor delete a policy owned by another resource on Beamlit", func() {
+			name, owner, policyType := "taken", "other/taken", "location"
+			beamlitServer.SetPolicy(beamlitsdk.Policy{
+				Metadata: &beamlitsdk.Metadata{Name: &name, Labels: &beamlitsdk.MetadataLabels{beamlit.OwnerLabel: owner}},
+				Spec:     &beamlitsdk.PolicySpec{Type: &policyType},
 			})
-			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			policy := newLocationPolicy("taken", "eu")
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+			Consistently(remoteLocations("taken"), "1s").Should(BeEmpty())
+
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(policy), policy))
+			}).Should(BeTrue())
+			remote, ok := beamlitServer.Policy("taken")
+			Expect(ok).To(BeTrue())
+			Expect((*remote.Metadata.Labels)[beamlit.OwnerLabel]).To(Equal(owner))
 		})
 	})
 })
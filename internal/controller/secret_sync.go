@@ -0,0 +1,129 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+)
+
+// syncSecrets pushes to Beamlit the values of the Secrets read by the env vars of the pod template of the model source,
+// and deletes the Beamlit secrets no longer referenced. Values are pushed again only when their Secret changes.
+// The synced secrets are recorded in the status of the ModelDeployment, which is updated if they changed.
+func (r *ModelDeploymentReconciler) syncSecrets(ctx context.Context, model *v1alpha1.ModelDeployment) error {
+	logger := log.FromContext(ctx)
+	workspace, err := r.WorkspaceClients.Resolve(ctx, model.Namespace, model.Spec.WorkspaceRef)
+	if err != nil {
+		return err
+	}
+	values, err := r.PodTemplateTransformer.SecretValues(ctx, r.Client, model)
+	if err != nil {
+		logger.V(0).Error(err, "Failed to read the Secrets of ModelDeployment", "Name", model.Name)
+		return err
+	}
+
+	previous := model.Status.SyncedSecrets
+	if !model.Status.CreatedAtOnBeamlit.IsZero() && model.Status.WorkspaceRef != workspace {
+		logger.V(1).Info("Workspace changed, deleting previous secrets on Beamlit", "Name", model.Name, "Workspace", model.Status.WorkspaceRef)
+		if err := r.deleteSyncedSecrets(ctx, model, model.Status.WorkspaceRef, previous); err != nil {
+			return err
+		}
+		previous = nil
+	}
+	beamlitClient, err := r.WorkspaceClients.ClientFor(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	synced := make([]v1alpha1.SyncedSecret, 0, len(values))
+	for _, value := range values {
+		syncedSecret := v1alpha1.SyncedSecret{Name: value.Name, Source: value.Source, ResourceVersion: value.ResourceVersion}
+		if slices.Contains(previous, syncedSecret) {
+			synced = append(synced, syncedSecret)
+			continue
+		}
+		logger.V(1).Info("Pushing secret to Beamlit", "Name", model.Name, "Secret", value.Name, "Source", value.Source)
+		if err := beamlitClient.PutSecret(ctx, beamlit.Secret{Name: value.Name, Value: value.Value}); err != nil {
+			var unsupportedErr *beamlit.ErrSecretsUnsupported
+			if errors.As(err, &unsupportedErr) {
+				logger.V(0).Info("Beamlit does not support secrets in the workspace, Secrets are not synced", "Name", model.Name, "Workspace", workspace)
+				return nil
+			}
+			logger.V(0).Error(err, "Failed to push secret to Beamlit", "Name", model.Name, "Secret", value.Name)
+			return err
+		}
+		synced = append(synced, syncedSecret)
+	}
+	var removed []v1alpha1.SyncedSecret
+	for _, syncedSecret := range previous {
+		if !slices.ContainsFunc(synced, func(s v1alpha1.SyncedSecret) bool { return s.Name == syncedSecret.Name }) {
+			removed = append(removed, syncedSecret)
+		}
+	}
+	if err := r.deleteSyncedSecrets(ctx, model, workspace, removed); err != nil {
+		return err
+	}
+
+	if slices.Equal(synced, model.Status.SyncedSecrets) {
+		return nil
+	}
+	model.Status.SyncedSecrets = synced
+	return r.Status().Update(ctx, model)
+}
+
+// deleteSyncedSecrets deletes Beamlit secrets synced for a ModelDeployment in a workspace,
+// except the ones also synced for another ModelDeployment of the workspace
+func (r *ModelDeploymentReconciler) deleteSyncedSecrets(ctx context.Context, model *v1alpha1.ModelDeployment, workspace string, secrets []v1alpha1.SyncedSecret) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+	var models v1alpha1.ModelDeploymentList
+	if err := r.List(ctx, &models); err != nil {
+		return err
+	}
+	beamlitClient, err := r.WorkspaceClients.ClientFor(ctx, workspace)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		used := slices.ContainsFunc(models.Items, func(other v1alpha1.ModelDeployment) bool {
+			return (other.Namespace != model.Namespace || other.Name != model.Name) && other.Status.WorkspaceRef == workspace &&
+				slices.ContainsFunc(other.Status.SyncedSecrets, func(s v1alpha1.SyncedSecret) bool { return s.Name == secret.Name })
+		})
+		if used {
+			logger.V(1).Info("Secret still synced for another ModelDeployment, keeping it on Beamlit", "Name", model.Name, "Secret", secret.Name)
+			continue
+		}
+		logger.V(1).Info("Deleting secret on Beamlit", "Name", model.Name, "Secret", secret.Name)
+		if err := beamlitClient.DeleteSecret(ctx, secret.Name); err != nil {
+			var unsupportedErr *beamlit.ErrSecretsUnsupported
+			if errors.As(err, &unsupportedErr) {
+				return nil
+			}
+			logger.V(0).Error(err, "Failed to delete secret on Beamlit", "Name", model.Name, "Secret", secret.Name)
+			return err
+		}
+	}
+	return nil
+}
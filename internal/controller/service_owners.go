@@ -0,0 +1,60 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+// ModelServiceOwners tells the configurer which user Services are still referenced by a ModelDeployment.
+// It reads the ModelDeployments directly from the API server, as it is used before the cache is started.
+type ModelServiceOwners struct {
+	Reader client.Reader
+	// Watched is the list of watched namespaces, all namespaces if empty.
+	Watched []string
+}
+
+// Namespaces returns the watched namespaces.
+func (o *ModelServiceOwners) Namespaces() []string {
+	return o.Watched
+}
+
+// HasOwner returns whether a ModelDeployment references the service in its ServiceRef.
+func (o *ModelServiceOwners) HasOwner(ctx context.Context, service types.NamespacedName) (bool, error) {
+	namespaces := o.Watched
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, namespace := range namespaces {
+		var models v1alpha1.ModelDeploymentList
+		if err := o.Reader.List(ctx, &models, client.InNamespace(namespace)); err != nil {
+			return false, err
+		}
+		for _, model := range models.Items {
+			ref := model.Spec.ServiceRef
+			if ref != nil && ref.Namespace == service.Namespace && ref.Name == service.Name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -17,37 +17,100 @@ limitations under the License.
 package controller
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
 
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
 	deploymentv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+	"github.com/beamlit/beamlit-controller/internal/config"
+	"github.com/beamlit/beamlit-controller/internal/controller/helper"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/configurer"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/offloader"
+	"github.com/beamlit/beamlit-controller/internal/informers/health"
+	"github.com/beamlit/beamlit-controller/internal/informers/metric"
+	"github.com/beamlit/beamlit-controller/internal/reporter"
 	//+kubebuilder:scaffold:imports
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+// The reconcilers run in a manager against envtest, and sync the resources to a fake Beamlit API.
 
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var cancel context.CancelFunc
+
+var beamlitServer *beamlittest.Server
+var workspaceClients *WorkspaceClients
+var healthStatusChan chan health.HealthStatus
+var serviceEventChan chan configurer.ServiceEvent
+var modelReconciler *ModelDeploymentReconciler
+var offloads *recordedOffloads
+
+// binaryAssetsDirectory is the directory of the envtest binaries installed by make test
+var binaryAssetsDirectory = filepath.Join("..", "..", "bin", "k8s",
+	fmt.Sprintf("1.31.0-%s-%s", runtime.GOOS, runtime.GOARCH))
 
 func TestControllers(t *testing.T) {
-	//RegisterFailHandler(Fail)
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		if _, err := os.Stat(binaryAssetsDirectory); err != nil {
+			t.Skip("envtest binaries not found, run make test to install them")
+		}
+	}
+	RegisterFailHandler(Fail)
 
-	//RunSpecs(t, "Controller Suite")
+	RunSpecs(t, "Controller Suite")
+}
+
+// recordedOffloads records the weights of the remote backend configured by the offloader, by model
+type recordedOffloads struct {
+	mu      sync.Mutex
+	weights map[string][]int
+}
+
+func (r *recordedOffloads) record(model *deploymentv1alpha1.ModelDeployment, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := client.ObjectKeyFromObject(model).String()
+	r.weights[key] = append(r.weights[key], weight)
+}
+
+func (r *recordedOffloads) last(key string) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	weights := r.weights[key]
+	if len(weights) == 0 {
+		return 0, false
+	}
+	return weights[len(weights)-1], true
+}
+
+func (r *recordedOffloads) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.weights[key])
 }
 
 var _ = BeforeSuite(func() {
@@ -63,8 +126,7 @@ var _ = BeforeSuite(func() {
 		// default path defined in controller-runtime which is /usr/local/kubebuilder/.
 		// Note that you must have the required binaries setup under the bin directory to perform
 		// the tests directly. When we run make test it will be setup and used automatically.
-		BinaryAssetsDirectory: filepath.Join("..", "..", "bin", "k8s",
-			fmt.Sprintf("1.29.0-%s-%s", runtime.GOOS, runtime.GOARCH)),
+		BinaryAssetsDirectory: binaryAssetsDirectory,
 	}
 
 	var err error
@@ -85,10 +147,99 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("starting the fake Beamlit API")
+	beamlitServer = beamlittest.NewServer(beamlittest.WithOffloadEvents(), beamlittest.WithSecrets())
+	beamlitClient, err := beamlit.NewClientWithCredentials(beamlitServer.URL, beamlitServer.Token(), beamlit.WithRequestPolicy(&beamlit.RequestPolicy{}))
+	Expect(err).NotTo(HaveOccurred())
+
+	By("starting the reconcilers")
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:  scheme.Scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	workspaceClients = NewWorkspaceClients(mgr.GetClient(), mgr.GetAPIReader(), beamlitClient, beamlitServer.URL)
+
+	offloadReporter := reporter.NewOffloadReporter(workspaceClients)
+	offloadReporter.FlushInterval = 100 * time.Millisecond
+	Expect(mgr.Add(offloadReporter)).To(Succeed())
+
+	noopConfigurer, err := configurer.NewConfigurer(ctx, configurer.NoopConfigurerType, nil)
+	Expect(err).NotTo(HaveOccurred())
+	mockCtrl := gomock.NewController(GinkgoT())
+	offloads = &recordedOffloads{weights: map[string][]int{}}
+	mockOffloader := offloader.NewMockOffloader(mockCtrl)
+	mockOffloader.EXPECT().Cleanup(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockOffloader.EXPECT().Configure(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, model *deploymentv1alpha1.ModelDeployment, _ *deploymentv1alpha1.ServiceReference, _ *deploymentv1alpha1.RemoteBackend, weight int) error {
+			offloads.record(model, weight)
+			return nil
+		}).AnyTimes()
+	healthInformer := health.NewMockHealthInformer(mockCtrl)
+	healthInformer.EXPECT().Register(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	healthInformer.EXPECT().Unregister(gomock.Any(), gomock.Any()).AnyTimes()
+	metricInformer := metric.NewMockMetricInformer(mockCtrl)
+	metricInformer.EXPECT().Register(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	metricInformer.EXPECT().Unregister(gomock.Any(), gomock.Any()).AnyTimes()
+	healthStatusChan = make(chan health.HealthStatus)
+	serviceEventChan = make(chan configurer.ServiceEvent)
+
+	modelReconciler = &ModelDeploymentReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		WorkspaceClients: workspaceClients,
+		Offloader:        mockOffloader,
+		OffloadReporter:  offloadReporter,
+		Configurer:       noopConfigurer,
+		MetricInformer:   metricInformer,
+		HealthInformer:   healthInformer,
+		HealthStatusChan: healthStatusChan,
+		ServiceEventChan: serviceEventChan,
+		ManagedModels:    make(map[string]ManagedModel),
+		BeamlitModels:    make(map[string]string),
+		DefaultRemoteBackend: &deploymentv1alpha1.RemoteBackend{
+			Host: "run.beamlit.test",
+		},
+		PodTemplateTransformer: &helper.PodTemplateTransformer{AllowedSecrets: []string{helper.AllSecrets}, Reader: mgr.GetAPIReader()},
+		SyncSecrets:            true,
+	}
+	Expect(modelReconciler.SetupWithManager(mgr)).To(Succeed())
+	managedPolicies := NewManagedPolicies()
+	Expect((&PolicyReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		WorkspaceClients: workspaceClients,
+		ManagedPolicies:  managedPolicies,
+		NamingStrategy:   config.PolicyNamingStrategyPlain,
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&ClusterPolicyReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		WorkspaceClients: workspaceClients,
+		ManagedPolicies:  managedPolicies,
+		NamingStrategy:   config.PolicyNamingStrategyPlain,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	go func() {
+		defer GinkgoRecover()
+		Expect(modelReconciler.WatchForInformerUpdates(ctx)).To(Succeed())
+	}()
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
 })
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	if cancel != nil {
+		cancel()
+	}
+	if beamlitServer != nil {
+		beamlitServer.Close()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
@@ -0,0 +1,60 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const instrumentationName = "github.com/beamlit/beamlit-controller/internal/controller"
+
+// tracedReconciler starts a span for every reconcile, the parent of the spans of the requests sent to Beamlit by the reconcile
+type tracedReconciler struct {
+	kind       string
+	reconciler reconcile.Reconciler
+}
+
+// traced returns reconciler with a span for every reconcile of a resource of kind
+func traced(kind string, reconciler reconcile.Reconciler) reconcile.Reconciler {
+	return &tracedReconciler{kind: kind, reconciler: reconciler}
+}
+
+func (t *tracedReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, "Reconcile "+t.kind)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("k8s.resource.kind", t.kind),
+		attribute.String("k8s.namespace.name", req.Namespace),
+		attribute.String("k8s.resource.name", req.Name),
+	)
+	if spanContext := span.SpanContext(); spanContext.HasTraceID() {
+		ctx = log.IntoContext(ctx, log.FromContext(ctx).WithValues("TraceID", spanContext.TraceID().String()))
+	}
+	result, err := t.reconciler.Reconcile(ctx, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result, err
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+)
+
+const defaultWorkspaceTokenKey = "token"
+
+// WorkspaceClients keeps a Beamlit client per BeamlitWorkspace, and resolves the BeamlitWorkspace selected by a resource
+// Resources selecting no BeamlitWorkspace use the Default client, configured with the credentials of the operator
+type WorkspaceClients struct {
+	// Client reads the namespaces and the BeamlitWorkspaces
+	client.Client
+	// SecretReader reads the credentials Secrets, which are not cached by the manager
+	SecretReader client.Reader
+	// Default is the client of the workspace of the operator
+	Default *beamlit.Client
+	// DefaultBaseURL is the base URL used when a BeamlitWorkspace has no base URL
+	DefaultBaseURL string
+	// ClientOptions are the options of the clients created for the BeamlitWorkspaces
+	ClientOptions []beamlit.Option
+
+	mu      sync.Mutex
+	clients map[string]*workspaceClient // key: BeamlitWorkspace name
+}
+
+type workspaceClient struct {
+	client          *beamlit.Client
+	baseURL         string
+	secretRef       authorizationv1alpha1.SecretKeyReference
+	resourceVersion string
+}
+
+func NewWorkspaceClients(c client.Client, secretReader client.Reader, defaultClient *beamlit.Client, defaultBaseURL string) *WorkspaceClients {
+	return &WorkspaceClients{
+		Client:         c,
+		SecretReader:   secretReader,
+		Default:        defaultClient,
+		DefaultBaseURL: defaultBaseURL,
+		clients:        make(map[string]*workspaceClient),
+	}
+}
+
+// Resolve returns the name of the BeamlitWorkspace selected by a resource of namespace
+// workspaceRef takes precedence over the beamlit.com/workspace label of the namespace
+// An empty name stands for the workspace of the operator
+// It returns an ErrWorkspaceNotAllowed if the namespace selector of the BeamlitWorkspace doesn't match namespace
+func (w *WorkspaceClients) Resolve(ctx context.Context, namespace string, workspaceRef string) (string, error) {
+	if namespace == "" {
+		return workspaceRef, nil
+	}
+	var ns corev1.Namespace
+	if err := w.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+	}
+	name := workspaceRef
+	if name == "" {
+		name = ns.Labels[authorizationv1alpha1.WorkspaceLabel]
+	}
+	if name == "" {
+		return "", nil
+	}
+	var workspace authorizationv1alpha1.BeamlitWorkspace
+	if err := w.Get(ctx, types.NamespacedName{Name: name}, &workspace); err != nil {
+		return "", fmt.Errorf("failed to get BeamlitWorkspace %s: %w", name, err)
+	}
+	if workspace.Spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(workspace.Spec.NamespaceSelector)
+		if err != nil {
+			return "", fmt.Errorf("invalid namespace selector of BeamlitWorkspace %s: %w", name, err)
+		}
+		if !selector.Matches(labels.Set(ns.Labels)) {
+			return "", &ErrWorkspaceNotAllowed{Workspace: name, Namespace: namespace}
+		}
+	}
+	return name, nil
+}
+
+// ClientFor returns the client of the BeamlitWorkspace named name, or the Default client if name is empty
+func (w *WorkspaceClients) ClientFor(ctx context.Context, name string) (*beamlit.Client, error) {
+	if name == "" {
+		return w.Default, nil
+	}
+	w.mu.Lock()
+	cached, ok := w.clients[name]
+	w.mu.Unlock()
+	if ok {
+		return cached.client, nil
+	}
+	var workspace authorizationv1alpha1.BeamlitWorkspace
+	if err := w.Get(ctx, types.NamespacedName{Name: name}, &workspace); err != nil {
+		return nil, fmt.Errorf("failed to get BeamlitWorkspace %s: %w", name, err)
+	}
+	return w.Load(ctx, &workspace)
+}
+
+// Load reads the credentials of workspace and returns its client
+// The credentials of the cached client are rotated when the Secret or the base URL changed since the last call
+func (w *WorkspaceClients) Load(ctx context.Context, workspace *authorizationv1alpha1.BeamlitWorkspace) (*beamlit.Client, error) {
+	secretRef := workspace.Spec.CredentialsSecretRef
+	if secretRef.Key == "" {
+		secretRef.Key = defaultWorkspaceTokenKey
+	}
+	var secret corev1.Secret
+	if err := w.SecretReader.Get(ctx, types.NamespacedName{Namespace: secretRef.Namespace, Name: secretRef.Name}, &secret); err != nil {
+		return nil, err
+	}
+	token, ok := secret.Data[secretRef.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in Secret %s/%s", secretRef.Key, secretRef.Namespace, secretRef.Name)
+	}
+	baseURL := workspace.Spec.BaseURL
+	if baseURL == "" {
+		baseURL = w.DefaultBaseURL
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cached, ok := w.clients[workspace.Name]
+	if ok && cached.baseURL == baseURL && cached.secretRef == secretRef && cached.resourceVersion == secret.ResourceVersion {
+		return cached.client, nil
+	}
+	if ok {
+		if err := cached.client.SetCredentials(baseURL, string(token)); err != nil {
+			return nil, err
+		}
+	} else {
+		beamlitClient, err := beamlit.NewClientWithCredentials(baseURL, string(token), w.ClientOptions...)
+		if err != nil {
+			return nil, err
+		}
+		cached = &workspaceClient{client: beamlitClient}
+		w.clients[workspace.Name] = cached
+	}
+	cached.baseURL = baseURL
+	cached.secretRef = secretRef
+	cached.resourceVersion = secret.ResourceVersion
+	log.FromContext(ctx).V(0).Info("Loaded Beamlit credentials of BeamlitWorkspace", "Name", workspace.Name, "Secret", fmt.Sprintf("%s/%s", secretRef.Namespace, secretRef.Name))
+	return cached.client, nil
+}
+
+// Forget removes the client of the BeamlitWorkspace named name from the cache
+func (w *WorkspaceClients) Forget(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.clients, name)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+func newWorkspaceScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorizationv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newWorkspace(name string, selector *metav1.LabelSelector) *authorizationv1alpha1.BeamlitWorkspace {
+	return &authorizationv1alpha1.BeamlitWorkspace{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: authorizationv1alpha1.BeamlitWorkspaceSpec{
+			CredentialsSecretRef: authorizationv1alpha1.SecretKeyReference{Namespace: "beamlit-system", Name: name},
+			NamespaceSelector:    selector,
+		},
+	}
+}
+
+func TestWorkspaceClientsResolve(t *testing.T) {
+	type testCase struct {
+		namespace     string
+		workspaceRef  string
+		want          string
+		wantForbidden bool
+		wantErr       bool
+	}
+	tcs := map[string]testCase{
+		"When the namespace has no label and no workspaceRef is set, must use the workspace of the operator": {
+			namespace: "default",
+			want:      "",
+		},
+		"When the namespace has a workspace label, must use it": {
+			namespace: "team-a",
+			want:      "team-a",
+		},
+		"When a workspaceRef is set, must take precedence over the label of the namespace": {
+			namespace:    "team-a",
+			workspaceRef: "shared",
+			want:         "shared",
+		},
+		"When the namespace doesn't match the namespace selector of the workspace, must return an ErrWorkspaceNotAllowed": {
+			namespace:     "default",
+			workspaceRef:  "team-a",
+			wantForbidden: true,
+		},
+		"When the resource is cluster-scoped, must ignore the namespace selector of the workspace": {
+			workspaceRef: "team-a",
+			want:         "team-a",
+		},
+		"When the workspace doesn't exist, must return an error": {
+			namespace:    "default",
+			workspaceRef: "unknown",
+			wantErr:      true,
+		},
+	}
+	kubernetesClient := fake.NewClientBuilder().WithScheme(newWorkspaceScheme(t)).WithObjects(
+		newNamespace("default", nil),
+		newNamespace("team-a", map[string]string{authorizationv1alpha1.WorkspaceLabel: "team-a", "team": "a"}),
+		newWorkspace("team-a", &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}),
+		newWorkspace("shared", nil),
+	).Build()
+	workspaceClients := NewWorkspaceClients(kubernetesClient, kubernetesClient, nil, "")
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			got, err := workspaceClients.Resolve(context.Background(), tc.namespace, tc.workspaceRef)
+			if tc.wantForbidden {
+				if !isWorkspaceNotAllowed(err) {
+					t.Errorf("want an ErrWorkspaceNotAllowed but got %v", err)
+				}
+				return
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %t but got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want workspace %q but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWorkspaceClientsRotatesCredentials(t *testing.T) {
+	server := beamlittest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	workspace := newWorkspace("team-a", nil)
+	workspace.Spec.BaseURL = server.URL
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "beamlit-system", Name: "team-a"},
+		Data:       map[string][]byte{defaultWorkspaceTokenKey: []byte(invalidToken)},
+	}
+	kubernetesClient := fake.NewClientBuilder().WithScheme(newWorkspaceScheme(t)).WithObjects(workspace, secret).Build()
+	defaultClient := &beamlit.Client{}
+	workspaceClients := NewWorkspaceClients(kubernetesClient, kubernetesClient, defaultClient, "")
+	workspaceClients.ClientOptions = []beamlit.Option{beamlit.WithRequestPolicy(&beamlit.RequestPolicy{})}
+
+	if got, err := workspaceClients.ClientFor(ctx, ""); err != nil || got != defaultClient {
+		t.Fatalf("want the default client for the workspace of the operator but got %v, %v", got, err)
+	}
+	first, err := workspaceClients.ClientFor(ctx, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.ListPolicies(ctx); !beamlit.IsAuthError(err) {
+		t.Fatalf("want an AuthError with the initial credentials but got %v", err)
+	}
+
+	secret.Data[defaultWorkspaceTokenKey] = []byte(server.Token())
+	if err := kubernetesClient.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	// The cached client is returned until the workspace is loaded again
+	if cached, err := workspaceClients.ClientFor(ctx, "team-a"); err != nil || cached != first {
+		t.Fatalf("want the cached client but got %v, %v", cached, err)
+	}
+	rotated, err := workspaceClients.Load(ctx, workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != first {
+		t.Errorf("want the credentials of the cached client rotated but got a new client")
+	}
+	if _, err := rotated.ListPolicies(ctx); err != nil {
+		t.Errorf("want the rotated credentials used but got %v", err)
+	}
+
+	workspaceClients.Forget("team-a")
+	if err := kubernetesClient.Delete(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := workspaceClients.ClientFor(ctx, "team-a"); err == nil {
+		t.Errorf("want the forgotten client loaded again from the deleted Secret but got no error")
+	}
+	if err := kubernetesClient.Delete(ctx, workspace); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := workspaceClients.ClientFor(ctx, "team-a"); err == nil {
+		t.Errorf("want an error for a deleted workspace but got none")
+	}
+}
@@ -18,9 +18,11 @@ package configurer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -28,13 +30,62 @@ type ConfigurerType string
 
 const (
 	KubernetesConfigurerType ConfigurerType = "kubernetes"
+	NoopConfigurerType       ConfigurerType = "noop"
+	// OffloadServiceConfigurerType leaves the user Services untouched, the clients call a <svc>-offload Service instead
+	OffloadServiceConfigurerType ConfigurerType = "offload-service"
 )
 
+// ServiceEventType is the type of a change of a configured service applied by a configurer
+type ServiceEventType string
+
+const (
+	// ServiceEventReconfigured is sent when a configured service was modified and configured again
+	ServiceEventReconfigured ServiceEventType = "Reconfigured"
+	// ServiceEventDeleted is sent when a configured service was deleted, and the objects created to proxy it were removed
+	ServiceEventDeleted ServiceEventType = "Deleted"
+)
+
+// ServiceEvent is a change of a configured service applied by a configurer
+type ServiceEvent struct {
+	Service types.NamespacedName
+	Type    ServiceEventType
+	// Err is the error of the configurer applying the change, if it failed
+	Err error
+}
+
+// ErrUnsupportedService is returned by Configure when a service can't be offloaded by the configurer, as long as its spec is unchanged.
+// Nothing is configured for the service then.
+type ErrUnsupportedService struct {
+	Service types.NamespacedName
+	Reason  string
+}
+
+func (e *ErrUnsupportedService) Error() string {
+	return fmt.Sprintf("service %s can't be offloaded: %s", e.Service, e.Reason)
+}
+
+// IsUnsupportedService returns true if err is caused by a service which can't be offloaded by the configurer
+func IsUnsupportedService(err error) bool {
+	var unsupportedErr *ErrUnsupportedService
+	return errors.As(err, &unsupportedErr)
+}
+
+// ServiceOwners reports whether the user Services configured by a configurer are still offloaded,
+// so that the configurer restores the ones left configured by a previous process of the operator.
+type ServiceOwners interface {
+	// Namespaces returns the namespaces of the user Services, nil for all namespaces
+	Namespaces() []string
+	// HasOwner returns true if a resource of the operator still offloads the user Service
+	HasOwner(ctx context.Context, service types.NamespacedName) (bool, error)
+}
+
 type configurerFactory func(ctx context.Context, kubeClient kubernetes.Interface) (Configurer, error)
 
 var (
 	configurerFactories = map[ConfigurerType]configurerFactory{
-		KubernetesConfigurerType: newKubernetesConfigurer,
+		KubernetesConfigurerType:     newKubernetesConfigurer,
+		NoopConfigurerType:           newNoopConfigurer,
+		OffloadServiceConfigurerType: newOffloadServiceConfigurer,
 	}
 )
 
@@ -54,7 +105,8 @@ func NewConfigurer(ctx context.Context, configurerType ConfigurerType, kubeClien
 // It also creates a new Service that can be used by the proxy to route traffic to the internal pod
 type Configurer interface {
 	// Start starts the service configurer.
-	Start(ctx context.Context, gatewayService *modelv1alpha1.ServiceReference) error
+	// The user Services configured without owner are restored, if owners is not nil.
+	Start(ctx context.Context, gatewayService *modelv1alpha1.ServiceReference, owners ServiceOwners) error
 	// Configure configures a service to be proxied by Beamlit.
 	Configure(ctx context.Context, service *modelv1alpha1.ServiceReference) error
 	// Unconfigure unconfigures a service from being proxied by Beamlit.
@@ -62,4 +114,7 @@ type Configurer interface {
 
 	// GetService gets the service for a given service reference.
 	GetLocalBeamlitService(ctx context.Context, service *modelv1alpha1.ServiceReference) (*modelv1alpha1.ServiceReference, error)
+	// Events returns a channel that sends the changes of the configured services applied by the configurer.
+	// It returns nil if the configurer doesn't watch the configured services.
+	Events() <-chan ServiceEvent
 }
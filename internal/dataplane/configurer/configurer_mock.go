@@ -15,8 +15,61 @@ import (
 
 	deployment "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
 	gomock "go.uber.org/mock/gomock"
+	types "k8s.io/apimachinery/pkg/types"
 )
 
+// MockServiceOwners is a mock of ServiceOwners interface.
+type MockServiceOwners struct {
+	ctrl     *gomock.Controller
+	recorder *MockServiceOwnersMockRecorder
+}
+
+// MockServiceOwnersMockRecorder is the mock recorder for MockServiceOwners.
+type MockServiceOwnersMockRecorder struct {
+	mock *MockServiceOwners
+}
+
+// NewMockServiceOwners creates a new mock instance.
+func NewMockServiceOwners(ctrl *gomock.Controller) *MockServiceOwners {
+	mock := &MockServiceOwners{ctrl: ctrl}
+	mock.recorder = &MockServiceOwnersMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockServiceOwners) EXPECT() *MockServiceOwnersMockRecorder {
+	return m.recorder
+}
+
+// HasOwner mocks base method.
+func (m *MockServiceOwners) HasOwner(ctx context.Context, service types.NamespacedName) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasOwner", ctx, service)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasOwner indicates an expected call of HasOwner.
+func (mr *MockServiceOwnersMockRecorder) HasOwner(ctx, service any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasOwner", reflect.TypeOf((*MockServiceOwners)(nil).HasOwner), ctx, service)
+}
+
+// Namespaces mocks base method.
+func (m *MockServiceOwners) Namespaces() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Namespaces")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// Namespaces indicates an expected call of Namespaces.
+func (mr *MockServiceOwnersMockRecorder) Namespaces() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Namespaces", reflect.TypeOf((*MockServiceOwners)(nil).Namespaces))
+}
+
 // MockConfigurer is a mock of Configurer interface.
 type MockConfigurer struct {
 	ctrl     *gomock.Controller
@@ -54,6 +107,20 @@ func (mr *MockConfigurerMockRecorder) Configure(ctx, service any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Configure", reflect.TypeOf((*MockConfigurer)(nil).Configure), ctx, service)
 }
 
+// Events mocks base method.
+func (m *MockConfigurer) Events() <-chan ServiceEvent {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Events")
+	ret0, _ := ret[0].(<-chan ServiceEvent)
+	return ret0
+}
+
+// Events indicates an expected call of Events.
+func (mr *MockConfigurerMockRecorder) Events() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Events", reflect.TypeOf((*MockConfigurer)(nil).Events))
+}
+
 // GetLocalBeamlitService mocks base method.
 func (m *MockConfigurer) GetLocalBeamlitService(ctx context.Context, service *deployment.ServiceReference) (*deployment.ServiceReference, error) {
 	m.ctrl.T.Helper()
@@ -70,17 +137,17 @@ func (mr *MockConfigurerMockRecorder) GetLocalBeamlitService(ctx, service any) *
 }
 
 // Start mocks base method.
-func (m *MockConfigurer) Start(ctx context.Context, gatewayService *deployment.ServiceReference) error {
+func (m *MockConfigurer) Start(ctx context.Context, gatewayService *deployment.ServiceReference, owners ServiceOwners) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Start", ctx, gatewayService)
+	ret := m.ctrl.Call(m, "Start", ctx, gatewayService, owners)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Start indicates an expected call of Start.
-func (mr *MockConfigurerMockRecorder) Start(ctx, gatewayService any) *gomock.Call {
+func (mr *MockConfigurerMockRecorder) Start(ctx, gatewayService, owners any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockConfigurer)(nil).Start), ctx, gatewayService)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockConfigurer)(nil).Start), ctx, gatewayService, owners)
 }
 
 // Unconfigure mocks base method.
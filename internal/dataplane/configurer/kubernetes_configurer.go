@@ -20,10 +20,13 @@ import (
 	"context"
 	"fmt"
 	"slices"
+	"sync"
 	"time"
 
 	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -33,57 +36,117 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// kubernetesConfigurer proxies user Services by taking over their EndpointSlices.
+// What it changed is recorded in the StateAnnotation of the user Services, not in memory,
+// so that Unconfigure restores them even when they were configured by a previous process of the operator.
+// The configured user Services are watched, to configure them again when they change and to clean up when they are deleted.
 type kubernetesConfigurer struct {
-	gatewayServiceRef              *modelv1alpha1.ServiceReference
-	kubeClient                     kubernetes.Interface
-	beamlitServicesByModelService  map[types.NamespacedName]*types.NamespacedName
-	initialEndpointPerLocalService map[types.NamespacedName][]*types.NamespacedName
-	stopChans                      map[types.NamespacedName][]chan bool
+	gatewayServiceRef *modelv1alpha1.ServiceReference
+	kubeClient        kubernetes.Interface
+	events            chan ServiceEvent
+
+	// serviceLocks serializes the configuration of each user Service, done by the reconciler and by its watch
+	serviceLocks    keyedMutex
+	mu              sync.Mutex
+	mirrorings      map[types.NamespacedName]*endpointSlicesMirroring
+	serviceWatchers map[types.NamespacedName]chan bool // stop the watch of the user Services
+	serviceRefs     map[types.NamespacedName]*modelv1alpha1.ServiceReference
+}
+
+// endpointSlicesMirroring are the goroutines mirroring the EndpointSlices of a user Service, wg is done once they returned
+type endpointSlicesMirroring struct {
+	stopCh chan bool
+	wg     sync.WaitGroup
+}
+
+// stop stops the goroutines of a mirroring and waits for them to return, it does nothing on a nil mirroring
+func (m *endpointSlicesMirroring) stop() {
+	if m == nil {
+		return
+	}
+	close(m.stopCh)
+	m.wg.Wait()
 }
 
 const (
 	OperatorLabel = "beamlit-operator"
+
+	// mirroredEndpointSlicesResyncPeriod is the period at which the mirrored endpoints slices are applied again and the stale ones deleted
+	mirroredEndpointSlicesResyncPeriod = 5 * time.Minute
+
+	// serviceEventsBufferSize is the number of service events buffered until they are read, the next ones are dropped
+	serviceEventsBufferSize = 100
 )
 
 func newKubernetesConfigurer(ctx context.Context, kubeClient kubernetes.Interface) (Configurer, error) {
 	return &kubernetesConfigurer{
-		kubeClient:                     kubeClient,
-		beamlitServicesByModelService:  make(map[types.NamespacedName]*types.NamespacedName),
-		stopChans:                      make(map[types.NamespacedName][]chan bool),
-		initialEndpointPerLocalService: make(map[types.NamespacedName][]*types.NamespacedName),
-		gatewayServiceRef:              nil,
+		kubeClient:        kubeClient,
+		events:            make(chan ServiceEvent, serviceEventsBufferSize),
+		mirrorings:        make(map[types.NamespacedName]*endpointSlicesMirroring),
+		serviceWatchers:   make(map[types.NamespacedName]chan bool),
+		serviceRefs:       make(map[types.NamespacedName]*modelv1alpha1.ServiceReference),
+		gatewayServiceRef: nil,
 	}, nil
 }
 
-func (s *kubernetesConfigurer) Start(ctx context.Context, gatewayService *modelv1alpha1.ServiceReference) error {
+func (s *kubernetesConfigurer) Start(ctx context.Context, gatewayService *modelv1alpha1.ServiceReference, owners ServiceOwners) error {
 	s.gatewayServiceRef = gatewayService
-	return nil
+	if owners == nil {
+		return nil
+	}
+	return s.restoreOrphanServices(ctx, owners)
 }
 
 func (s *kubernetesConfigurer) GetLocalBeamlitService(ctx context.Context, service *modelv1alpha1.ServiceReference) (*modelv1alpha1.ServiceReference, error) {
-	serviceKey := types.NamespacedName{
-		Namespace: service.Namespace,
-		Name:      service.Name,
+	state, err := s.loadState(ctx, service)
+	if err != nil {
+		return nil, err
 	}
-	serviceRef, ok := s.beamlitServicesByModelService[serviceKey]
-	if !ok {
-		return nil, fmt.Errorf("proxy service not found for model service %s", serviceKey.String())
+	if state == nil || state.BeamlitService == "" {
+		return nil, fmt.Errorf("proxy service not found for model service %s/%s", service.Namespace, service.Name)
 	}
 
 	return &modelv1alpha1.ServiceReference{
 		ObjectReference: corev1.ObjectReference{
-			Namespace: serviceRef.Namespace,
-			Name:      serviceRef.Name,
+			Namespace: service.Namespace,
+			Name:      state.BeamlitService,
 		},
-		TargetPort: service.TargetPort,
+		TargetPort:            service.TargetPort,
+		AdditionalTargetPorts: service.AdditionalTargetPorts,
 	}, nil
 }
 
+func (s *kubernetesConfigurer) Events() <-chan ServiceEvent {
+	return s.events
+}
+
 func (s *kubernetesConfigurer) Configure(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
+	defer s.serviceLocks.lock(types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name})()
+	return s.configure(ctx, serviceRef)
+}
+
+// configure configures a user Service, its lock being held
+func (s *kubernetesConfigurer) configure(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
+	key := types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}
+	service, err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).Get(ctx, serviceRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if err := checkServiceSupported(service); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.serviceRefs[key] = serviceRef.DeepCopy()
+	s.mu.Unlock()
+
 	beamlitService, err := s.createBeamlitModelService(ctx, serviceRef)
 	if err != nil {
 		return err
 	}
+	portNames, err := offloadedPortNames(beamlitService, serviceRef)
+	if err != nil {
+		return err
+	}
 	err = s.addPortToGatewayService(ctx, serviceRef)
 	if err != nil {
 		return err
@@ -93,32 +156,40 @@ func (s *kubernetesConfigurer) Configure(ctx context.Context, serviceRef *modelv
 		return err
 	}
 
-	var targetPort int32
-	for _, port := range beamlitService.Spec.Ports {
-		if port.Port == serviceRef.TargetPort {
-			targetPort = port.TargetPort.IntVal
-			break
-		}
-	}
-	if targetPort == 0 {
-		return fmt.Errorf("target port not found in beamlit service %s", beamlitService.Name)
+	// A service configured again is mirrored with its new ports
+	s.stopMirroring(key)
+	err = s.createMirroredEndpointsSlice(ctx, serviceRef, portNames)
+	if err != nil {
+		return err
 	}
-
-	err = s.createMirroredEndpointsSlice(ctx, serviceRef, targetPort)
+	err = s.applyGatewayEndpointSlices(ctx, service, serviceRef)
 	if err != nil {
 		return err
 	}
+	s.startMirroring(ctx, key,
+		func(stopCh <-chan bool) error {
+			return s.mirrorEndpointSlices(ctx, serviceRef, portNames, stopCh)
+		},
+		func(stopCh <-chan bool) error {
+			return s.mirrorGatewayEndpointSlices(ctx, service, serviceRef, stopCh)
+		},
+	)
 
 	err = s.cleanUnusedEndpointSlices(ctx, serviceRef)
 	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.serviceWatchers[key]; ok {
+		return nil
+	}
 	stopCh := make(chan bool)
-	s.stopChans[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}] = append(s.stopChans[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}], stopCh)
+	s.serviceWatchers[key] = stopCh
 	go func() {
-		if err := s.watchService(ctx, serviceRef, stopCh); err != nil {
-			log.Log.Error(err, "error watching service")
+		if err := s.watchService(ctx, key, stopCh); err != nil {
+			log.FromContext(ctx).Error(err, "error watching service", "Name", key.Name)
 		}
 	}()
 
@@ -130,7 +201,22 @@ func (s *kubernetesConfigurer) createBeamlitModelService(ctx context.Context, se
 	if err != nil {
 		return nil, err
 	}
-	beamlitServiceApplyConfig := v1.Service(fmt.Sprintf("%s-beamlit", serviceRef.Name), serviceRef.Namespace).
+	state, err := s.loadState(ctx, serviceRef)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &configurerState{}
+	}
+	if state.BeamlitService == "" {
+		state.BeamlitService = fmt.Sprintf("%s-beamlit", serviceRef.Name)
+		if err := s.saveState(ctx, serviceRef, state); err != nil {
+			return nil, err
+		}
+	}
+	beamlitServiceApplyConfig := v1.Service(state.BeamlitService, serviceRef.Namespace).
+		WithLabels(map[string]string{"app.kubernetes.io/managed-by": OperatorLabel}).
+		WithAnnotations(map[string]string{ModelServiceAnnotation: serviceRef.Name}).
 		WithSpec(v1.ServiceSpec().
 			WithPorts(func() []*v1.ServicePortApplyConfiguration {
 				var ports []*v1.ServicePortApplyConfiguration
@@ -139,13 +225,20 @@ func (s *kubernetesConfigurer) createBeamlitModelService(ctx context.Context, se
 						WithName(port.Name).
 						WithPort(port.Port).
 						WithProtocol(port.Protocol).
-						WithTargetPort(intstr.FromInt(int(port.TargetPort.IntVal))),
+						WithTargetPort(port.TargetPort),
 					)
 				}
 				return ports
 			}()...).
 			WithSelector(serviceToConfigure.Spec.Selector).
 			WithType(corev1.ServiceTypeClusterIP))
+	// The beamlit service has the IP families of the user service, for its EndpointSlices to be mirrored in each of them
+	if len(serviceToConfigure.Spec.IPFamilies) > 0 {
+		beamlitServiceApplyConfig.Spec.WithIPFamilies(serviceToConfigure.Spec.IPFamilies...)
+	}
+	if serviceToConfigure.Spec.IPFamilyPolicy != nil {
+		beamlitServiceApplyConfig.Spec.WithIPFamilyPolicy(*serviceToConfigure.Spec.IPFamilyPolicy)
+	}
 
 	beamlitService, err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).Apply(ctx, beamlitServiceApplyConfig, metav1.ApplyOptions{
 		FieldManager: OperatorLabel,
@@ -154,14 +247,11 @@ func (s *kubernetesConfigurer) createBeamlitModelService(ctx context.Context, se
 		return nil, err
 	}
 
-	s.beamlitServicesByModelService[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}] = &types.NamespacedName{Namespace: beamlitService.Namespace, Name: beamlitService.Name}
-
 	return beamlitService, nil
 }
 
-// addPortToGatewayService adds a port to the gateway service.
-// It checks if the port already exists, and if it does, it returns.
-// Otherwise, it adds the port to the gateway service.
+// addPortToGatewayService adds the offloaded ports of a service to the gateway service.
+// The ports already exposed by the gateway service are skipped.
 func (s *kubernetesConfigurer) addPortToGatewayService(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
 	gatewayService, err := s.kubeClient.CoreV1().Services(s.gatewayServiceRef.Namespace).Get(ctx, s.gatewayServiceRef.Name, metav1.GetOptions{})
 	if err != nil {
@@ -173,37 +263,37 @@ func (s *kubernetesConfigurer) addPortToGatewayService(ctx context.Context, serv
 		return err
 	}
 
-	var portToAdd corev1.ServicePort
-	for _, port := range serviceToConfigure.Spec.Ports {
-		if port.Port == serviceRef.TargetPort {
-			portToAdd = port
-			break
+	for _, offloadedPort := range serviceRef.Ports() {
+		protocol := corev1.ProtocolTCP
+		for _, port := range serviceToConfigure.Spec.Ports {
+			if port.Port == offloadedPort {
+				protocol = port.Protocol
+				break
+			}
 		}
-	}
-
-	addPort := true
-	for _, port := range gatewayService.Spec.Ports {
-		if port.Port == serviceRef.TargetPort && port.Protocol == portToAdd.Protocol {
-			addPort = false
-			break
+		addPort := true
+		for _, port := range gatewayService.Spec.Ports {
+			if port.Port == offloadedPort && port.Protocol == protocol {
+				addPort = false
+				break
+			}
+		}
+		if addPort {
+			gatewayService.Spec.Ports = append(gatewayService.Spec.Ports, corev1.ServicePort{
+				Name:       fmt.Sprintf("%d-beamlit", offloadedPort),
+				Port:       offloadedPort,
+				Protocol:   protocol,
+				TargetPort: intstr.FromInt(int(s.gatewayServiceRef.TargetPort)),
+			})
 		}
-	}
-	if addPort {
-		gatewayService.Spec.Ports = append(gatewayService.Spec.Ports, corev1.ServicePort{
-			Name:       fmt.Sprintf("%d-beamlit", serviceRef.TargetPort),
-			Port:       serviceRef.TargetPort,
-			Protocol:   portToAdd.Protocol,
-			TargetPort: intstr.FromInt(int(s.gatewayServiceRef.TargetPort)),
-		})
 	}
 
-	var externalIPs []string
 	for _, clusterIP := range serviceToConfigure.Spec.ClusterIPs {
-		if !slices.Contains(gatewayService.Spec.ExternalIPs, clusterIP) {
-			externalIPs = append(externalIPs, clusterIP)
+		if clusterIP != corev1.ClusterIPNone && !slices.Contains(gatewayService.Spec.ExternalIPs, clusterIP) {
+			gatewayService.Spec.ExternalIPs = append(gatewayService.Spec.ExternalIPs, clusterIP)
 		}
 	}
-	gatewayService.Spec.ExternalIPs = externalIPs
+	matchIPFamilies(gatewayService, serviceToConfigure)
 	_, err = s.kubeClient.CoreV1().Services(s.gatewayServiceRef.Namespace).Update(ctx, gatewayService, metav1.UpdateOptions{
 		FieldManager: OperatorLabel,
 	})
@@ -223,14 +313,19 @@ func (s *kubernetesConfigurer) takeOverEndpointsSlices(ctx context.Context, serv
 	if err != nil {
 		return err
 	}
-	if _, ok := s.initialEndpointPerLocalService[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}]; !ok {
-		s.initialEndpointPerLocalService[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}] = make([]*types.NamespacedName, 0)
+	var takenOver []string
+	for _, endpoint := range userServiceEndpoints.Items {
+		if endpoint.Labels["endpointslice.kubernetes.io/managed-by"] != OperatorLabel {
+			takenOver = append(takenOver, endpoint.Name)
+		}
+	}
+	if err := s.recordEndpointSlices(ctx, serviceRef, takenOver); err != nil {
+		return err
 	}
 	for _, endpoint := range userServiceEndpoints.Items {
 		if endpoint.Labels["endpointslice.kubernetes.io/managed-by"] == OperatorLabel {
 			continue
 		}
-		s.initialEndpointPerLocalService[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}] = append(s.initialEndpointPerLocalService[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}], &types.NamespacedName{Namespace: endpoint.Namespace, Name: endpoint.Name})
 		endpoint.Labels["endpointslice.kubernetes.io/managed-by"] = OperatorLabel
 		endpoint.Labels["kubernetes.io/service-name"] = serviceRef.Name
 		_, err = s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Update(ctx, &endpoint, metav1.UpdateOptions{})
@@ -241,55 +336,50 @@ func (s *kubernetesConfigurer) takeOverEndpointsSlices(ctx context.Context, serv
 	return nil
 }
 
-// createMirroredEndpointsSlice creates a mirrored endpoints slice for a given service reference.
-// It mirrors the endpoints slice of the model beamlit service created for the user service, minus the service target port.
-func (s *kubernetesConfigurer) createMirroredEndpointsSlice(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, targetPort int32) error {
-	mirroredEndpointsSlice, err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).List(ctx, metav1.ListOptions{
+// createMirroredEndpointsSlice creates the mirrored endpoints slices for a given service reference, one per endpoints slice
+// of the model beamlit service created for the user service, minus the offloaded ports. The stale ones are deleted.
+func (s *kubernetesConfigurer) createMirroredEndpointsSlice(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, portNames map[string]bool) error {
+	beamlitEndpointSlices, err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).List(ctx, metav1.ListOptions{
 		LabelSelector: "kubernetes.io/service-name=" + fmt.Sprintf("%s-beamlit", serviceRef.Name),
 	})
 	if err != nil {
 		return err
 	}
-	if len(mirroredEndpointsSlice.Items) == 0 {
+	if len(beamlitEndpointSlices.Items) == 0 {
 		return fmt.Errorf("no mirrored endpoints slice found for service %s", serviceRef.Name)
 	}
 
-	if len(mirroredEndpointsSlice.Items) > 1 {
-		return fmt.Errorf("multiple mirrored endpoints slices found for service %s, this shouldn't happen", serviceRef.Name)
+	mirrored := make([]*discoveryv1.EndpointSlice, 0, len(beamlitEndpointSlices.Items))
+	for i := range beamlitEndpointSlices.Items {
+		if err := s.applyMirroredEndpointSlice(ctx, serviceRef, &beamlitEndpointSlices.Items[i], portNames); err != nil {
+			return err
+		}
+		mirrored = append(mirrored, &beamlitEndpointSlices.Items[i])
 	}
+	return s.deleteStaleMirroredEndpointSlices(ctx, serviceRef, mirrored)
+}
 
-	esApplyConfig := discoveryv1apply.EndpointSlice(fmt.Sprintf("%s-beamlit-mirrored", serviceRef.Name), serviceRef.Namespace).
-		WithAddressType(mirroredEndpointsSlice.Items[0].AddressType).
+// applyMirroredEndpointSlice mirrors an endpoints slice of the model beamlit service into an endpoints slice of the user service,
+// minus the offloaded ports.
+func (s *kubernetesConfigurer) applyMirroredEndpointSlice(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, beamlitEndpointSlice *discoveryv1.EndpointSlice, portNames map[string]bool) error {
+	esApplyConfig := discoveryv1apply.EndpointSlice(mirroredEndpointSliceName(serviceRef.Name, beamlitEndpointSlice.Name), serviceRef.Namespace).
+		WithAddressType(beamlitEndpointSlice.AddressType).
 		WithLabels(map[string]string{
 			"beamlit.com/to-update":                  "true",
 			"kubernetes.io/service-name":             serviceRef.Name,
 			"endpointslice.kubernetes.io/managed-by": "beamlit-operator",
 		})
 
+	ports := withoutPorts(beamlitEndpointSlice.Ports, portNames)
+
 	endpoints := make([]*discoveryv1apply.EndpointApplyConfiguration, 0)
-	for _, endpoint := range mirroredEndpointsSlice.Items[0].Endpoints {
-		endpointApply := discoveryv1apply.Endpoint().
-			WithAddresses(endpoint.Addresses...).
-			WithConditions(discoveryv1apply.EndpointConditions().
-				WithReady(*endpoint.Conditions.Ready).
-				WithServing(*endpoint.Conditions.Serving).
-				WithTerminating(*endpoint.Conditions.Terminating))
-
-		if endpoint.Hostname != nil {
-			endpointApply.WithHostname(*endpoint.Hostname)
-		}
-		if endpoint.NodeName != nil {
-			endpointApply.WithNodeName(*endpoint.NodeName)
-		}
-		endpoints = append(endpoints, endpointApply)
+	for _, endpoint := range beamlitEndpointSlice.Endpoints {
+		endpoints = append(endpoints, endpointApplyConfiguration(endpoint))
 	}
 	esApplyConfig.WithEndpoints(endpoints...)
 
-	ports := make([]*discoveryv1apply.EndpointPortApplyConfiguration, 0)
-	for _, port := range mirroredEndpointsSlice.Items[0].Ports {
-		if port.Port != nil && *port.Port == targetPort {
-			continue
-		}
+	portsApply := make([]*discoveryv1apply.EndpointPortApplyConfiguration, 0)
+	for _, port := range ports {
 		portApply := discoveryv1apply.EndpointPort()
 		if port.Name != nil {
 			portApply.WithName(*port.Name)
@@ -300,25 +390,92 @@ func (s *kubernetesConfigurer) createMirroredEndpointsSlice(ctx context.Context,
 		if port.Protocol != nil {
 			portApply.WithProtocol(*port.Protocol)
 		}
-		ports = append(ports, portApply)
+		portsApply = append(portsApply, portApply)
 	}
-	esApplyConfig.WithPorts(ports...)
+	esApplyConfig.WithPorts(portsApply...)
 
-	_, err = s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Apply(ctx, esApplyConfig, metav1.ApplyOptions{
+	_, err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Apply(ctx, esApplyConfig, metav1.ApplyOptions{
 		FieldManager: "beamlit-operator",
 		Force:        true,
 	})
+	return err
+}
+
+// applyGatewayEndpointSlices adds the endpoints of the gateway on the offloaded ports of a NodePort or LoadBalancer user service,
+// for the traffic sent to its node ports or load balancer to reach the gateway.
+// The traffic sent to its cluster IPs is already sent to the gateway by its external IPs.
+func (s *kubernetesConfigurer) applyGatewayEndpointSlices(ctx context.Context, service *corev1.Service, serviceRef *modelv1alpha1.ServiceReference) error {
+	var ports []int32
+	if exposedOnNodes(service) {
+		ports = serviceRef.Ports()
+	}
+	return applyGatewayEndpointSlices(ctx, s.kubeClient, s.gatewayServiceRef, service, ports)
+}
+
+// applyGatewayEndpointSlices adds the endpoints of the gateway on the given ports of a service, one endpoints slice per endpoints slice
+// of the gateway service, with the target port of the gateway. The stale ones are deleted, all of them if ports is empty.
+func applyGatewayEndpointSlices(ctx context.Context, kubeClient kubernetes.Interface, gatewayServiceRef *modelv1alpha1.ServiceReference, service *corev1.Service, ports []int32) error {
+	applied := make(map[string]bool)
+	if len(ports) > 0 {
+		gatewayEndpointSlices, err := kubeClient.DiscoveryV1().EndpointSlices(gatewayServiceRef.Namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: "kubernetes.io/service-name=" + gatewayServiceRef.Name,
+		})
+		if err != nil {
+			return err
+		}
+		var endpointPorts []*discoveryv1apply.EndpointPortApplyConfiguration
+		for _, port := range service.Spec.Ports {
+			if slices.Contains(ports, port.Port) {
+				endpointPorts = append(endpointPorts, discoveryv1apply.EndpointPort().
+					WithName(port.Name).
+					WithPort(gatewayServiceRef.TargetPort).
+					WithProtocol(port.Protocol))
+			}
+		}
+		for _, gatewayEndpointSlice := range gatewayEndpointSlices.Items {
+			if len(service.Spec.IPFamilies) > 0 && !slices.Contains(service.Spec.IPFamilies, corev1.IPFamily(gatewayEndpointSlice.AddressType)) {
+				continue
+			}
+			name := gatewayEndpointSliceName(service.Name, gatewayServiceRef.Name, gatewayEndpointSlice.Name)
+			endpoints := make([]*discoveryv1apply.EndpointApplyConfiguration, 0, len(gatewayEndpointSlice.Endpoints))
+			for _, endpoint := range gatewayEndpointSlice.Endpoints {
+				endpoints = append(endpoints, endpointApplyConfiguration(endpoint))
+			}
+			esApplyConfig := discoveryv1apply.EndpointSlice(name, service.Namespace).
+				WithAddressType(gatewayEndpointSlice.AddressType).
+				WithLabels(map[string]string{
+					"beamlit.com/to-update":                  "true",
+					"kubernetes.io/service-name":             service.Name,
+					"endpointslice.kubernetes.io/managed-by": "beamlit-operator",
+				}).
+				WithEndpoints(endpoints...).
+				WithPorts(endpointPorts...)
+			_, err := kubeClient.DiscoveryV1().EndpointSlices(service.Namespace).Apply(ctx, esApplyConfig, metav1.ApplyOptions{
+				FieldManager: "beamlit-operator",
+				Force:        true,
+			})
+			if err != nil {
+				return err
+			}
+			applied[name] = true
+		}
+	}
+
+	userServiceEndpoints, err := kubeClient.DiscoveryV1().EndpointSlices(service.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "kubernetes.io/service-name=" + service.Name,
+	})
 	if err != nil {
 		return err
 	}
-
-	stopCh := make(chan bool)
-	s.stopChans[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}] = append(s.stopChans[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}], stopCh)
-	go func() {
-		if err := s.mirrorEndpointSlices(ctx, serviceRef, targetPort, stopCh); err != nil {
-			log.FromContext(ctx).Error(err, "error while mirroring endpoints slices")
+	for _, endpointSlice := range userServiceEndpoints.Items {
+		if !isGatewayEndpointSlice(service.Name, &endpointSlice) || applied[endpointSlice.Name] {
+			continue
 		}
-	}()
+		err = kubeClient.DiscoveryV1().EndpointSlices(service.Namespace).Delete(ctx, endpointSlice.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -330,8 +487,18 @@ func (s *kubernetesConfigurer) cleanUnusedEndpointSlices(ctx context.Context, se
 		return err
 	}
 
+	// EndpointSlices created by the EndpointSlice controller since the takeover are recorded to be restored as well
+	var takenOver []string
 	for _, endpoint := range userServiceEndpoints.Items {
-		if endpoint.Name == fmt.Sprintf("%s-beamlit-mirrored", serviceRef.Name) {
+		if endpoint.Labels["endpointslice.kubernetes.io/managed-by"] != OperatorLabel {
+			takenOver = append(takenOver, endpoint.Name)
+		}
+	}
+	if err := s.recordEndpointSlices(ctx, serviceRef, takenOver); err != nil {
+		return err
+	}
+	for _, endpoint := range userServiceEndpoints.Items {
+		if isMirroredEndpointSlice(serviceRef.Name, &endpoint) || isGatewayEndpointSlice(serviceRef.Name, &endpoint) {
 			continue
 		}
 		endpoint.Labels["endpointslice.kubernetes.io/managed-by"] = "beamlit-operator"
@@ -345,20 +512,32 @@ func (s *kubernetesConfigurer) cleanUnusedEndpointSlices(ctx context.Context, se
 	return nil
 }
 
+// Unconfigure restores a user Service from the state recorded on it, and removes the objects created to proxy it.
 func (s *kubernetesConfigurer) Unconfigure(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
+	defer s.serviceLocks.lock(types.NamespacedName{Namespace: service.Namespace, Name: service.Name})()
+	return s.unconfigure(ctx, service)
+}
+
+// unconfigure restores a user Service, its lock being held
+func (s *kubernetesConfigurer) unconfigure(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second) // TODO: change this
 	defer cancel()
-	if value, ok := s.beamlitServicesByModelService[types.NamespacedName{Namespace: service.Namespace, Name: service.Name}]; !ok || value == nil {
-		return nil
-	}
 	logger := log.FromContext(ctx)
-	logger.V(1).Info("Unconfiguring service", "Name", service.Name)
-	err := s.stopWatchers(ctx, service)
+	state, err := s.loadState(ctx, service)
 	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.V(1).Info("Service not found, deleting the objects created to proxy it", "Name", service.Name)
+			return s.cleanupDeletedService(ctx, service, nil)
+		}
 		return err
 	}
+	if state == nil {
+		return nil
+	}
+	logger.V(1).Info("Unconfiguring service", "Name", service.Name)
+	s.stopWatchers(ctx, service)
 	logger.V(1).Info("Adding kubernetes managed endpoints slice", "Name", service.Name)
-	err = s.addKubernetesManagedEndpointsSlice(ctx, service)
+	err = s.addKubernetesManagedEndpointsSlice(ctx, service, state)
 	if err != nil {
 		return err
 	}
@@ -368,59 +547,126 @@ func (s *kubernetesConfigurer) Unconfigure(ctx context.Context, service *modelv1
 		return err
 	}
 	logger.V(1).Info("Deleting beamlit service", "Name", service.Name)
-	err = s.deleteBeamlitService(ctx, service)
+	err = s.deleteBeamlitService(ctx, service, state)
 	if err != nil {
 		return err
 	}
 	logger.V(1).Info("Deleting external IPs from gateway service", "Name", service.Name)
-	err = s.deleteExternalIPsFromGatewayService(ctx, service)
+	userService, err := s.kubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
-	err = s.watchEndpointsSliceToBeUpdated(ctx, service)
+	err = s.deleteExternalIPsFromGatewayService(ctx, userService.Spec.ClusterIPs)
 	if err != nil {
 		return err
 	}
+	err = s.watchEndpointsSliceToBeUpdated(ctx, service, state)
+	if err != nil {
+		return err
+	}
+	if err := s.saveState(ctx, service, nil); err != nil {
+		return err
+	}
 	logger.V(1).Info("Successfully unregistered service", "Name", service.Name)
 	return nil
 }
 
-func (s *kubernetesConfigurer) deleteBeamlitService(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
-	beamlitService, ok := s.beamlitServicesByModelService[types.NamespacedName{Namespace: service.Namespace, Name: service.Name}]
-	if !ok {
-		return fmt.Errorf("beamlit service not found for model service %s", service.Name)
+func (s *kubernetesConfigurer) deleteBeamlitService(ctx context.Context, service *modelv1alpha1.ServiceReference, state *configurerState) error {
+	if state.BeamlitService == "" {
+		return nil
 	}
-	delete(s.beamlitServicesByModelService, types.NamespacedName{Namespace: service.Namespace, Name: service.Name})
-	return s.kubeClient.CoreV1().Services(beamlitService.Namespace).Delete(ctx, beamlitService.Name, metav1.DeleteOptions{})
+	err := s.kubeClient.CoreV1().Services(service.Namespace).Delete(ctx, state.BeamlitService, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
 }
 
-func (s *kubernetesConfigurer) stopWatchers(_ context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
-	stopCh, ok := s.stopChans[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}]
-	if !ok {
-		return fmt.Errorf("stop channel not found for service %s", serviceRef.Name)
+// cleanupDeletedService deletes the objects created to proxy a user Service which was deleted along with its state.
+// The cluster IPs of the deleted Service are removed from the gateway service when it is known.
+func (s *kubernetesConfigurer) cleanupDeletedService(ctx context.Context, service *modelv1alpha1.ServiceReference, deleted *corev1.Service) error {
+	s.stopWatchers(ctx, service)
+	if err := s.deleteBeamlitEndpointsSlice(ctx, service); err != nil {
+		return err
 	}
-	for _, ch := range stopCh {
-		select {
-		case _, ok := <-ch:
-			if ok {
-				close(ch)
-			}
-		default:
+	if err := s.deleteOrphanBeamlitService(ctx, service); err != nil {
+		return err
+	}
+	if deleted == nil || len(deleted.Spec.ClusterIPs) == 0 {
+		return nil
+	}
+	return s.deleteExternalIPsFromGatewayService(ctx, deleted.Spec.ClusterIPs)
+}
+
+// deleteOrphanBeamlitService deletes the Service created to proxy a user Service which was deleted along with its state
+func (s *kubernetesConfigurer) deleteOrphanBeamlitService(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
+	beamlitService, err := s.kubeClient.CoreV1().Services(service.Namespace).Get(ctx, fmt.Sprintf("%s-beamlit", service.Name), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
 			return nil
 		}
+		return err
 	}
-	return nil
+	if beamlitService.Annotations[ModelServiceAnnotation] != service.Name {
+		return nil
+	}
+	return s.deleteBeamlitService(ctx, service, &configurerState{BeamlitService: beamlitService.Name})
+}
+
+// stopWatchers stops the goroutines watching a user Service and mirroring its EndpointSlices, if this process started them
+func (s *kubernetesConfigurer) stopWatchers(_ context.Context, serviceRef *modelv1alpha1.ServiceReference) {
+	key := types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}
+	s.stopMirroring(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.serviceWatchers[key]; ok {
+		close(ch)
+	}
+	delete(s.serviceWatchers, key)
+	delete(s.serviceRefs, key)
+}
+
+// startMirroring runs the functions mirroring the EndpointSlices of a user Service, each in a goroutine, until stopMirroring is called
+// The previous mirroring of the Service, if any, is replaced and stopped.
+func (s *kubernetesConfigurer) startMirroring(ctx context.Context, key types.NamespacedName, mirrors ...func(stopCh <-chan bool) error) {
+	mirroring := &endpointSlicesMirroring{stopCh: make(chan bool)}
+	for _, mirror := range mirrors {
+		mirroring.wg.Add(1)
+		go func() {
+			defer mirroring.wg.Done()
+			if err := mirror(mirroring.stopCh); err != nil {
+				log.FromContext(ctx).Error(err, "error while mirroring endpoints slices")
+			}
+		}()
+	}
+	s.mu.Lock()
+	previous := s.mirrorings[key]
+	s.mirrorings[key] = mirroring
+	s.mu.Unlock()
+	previous.stop()
 }
 
-func (s *kubernetesConfigurer) addKubernetesManagedEndpointsSlice(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
-	initialEndpoints := s.initialEndpointPerLocalService[types.NamespacedName{Namespace: service.Namespace, Name: service.Name}]
-	for _, endpoint := range initialEndpoints {
-		endpointSlice, err := s.kubeClient.DiscoveryV1().EndpointSlices(endpoint.Namespace).Get(ctx, endpoint.Name, metav1.GetOptions{})
+// stopMirroring stops the goroutines mirroring the EndpointSlices of a user Service and waits for them to return,
+// for no mirrored EndpointSlice to be applied once it is stopped
+func (s *kubernetesConfigurer) stopMirroring(key types.NamespacedName) {
+	s.mu.Lock()
+	mirroring := s.mirrorings[key]
+	delete(s.mirrorings, key)
+	s.mu.Unlock()
+	mirroring.stop()
+}
+
+func (s *kubernetesConfigurer) addKubernetesManagedEndpointsSlice(ctx context.Context, service *modelv1alpha1.ServiceReference, state *configurerState) error {
+	for _, name := range state.EndpointSlices {
+		endpointSlice, err := s.kubeClient.DiscoveryV1().EndpointSlices(service.Namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
 			return err
 		}
 		endpointSlice.Labels["endpointslice.kubernetes.io/managed-by"] = "endpointslice-controller.k8s.io"
-		_, err = s.kubeClient.DiscoveryV1().EndpointSlices(endpoint.Namespace).Update(ctx, endpointSlice, metav1.UpdateOptions{})
+		_, err = s.kubeClient.DiscoveryV1().EndpointSlices(service.Namespace).Update(ctx, endpointSlice, metav1.UpdateOptions{})
 		if err != nil {
 			return err
 		}
@@ -447,20 +693,16 @@ func (s *kubernetesConfigurer) deleteBeamlitEndpointsSlice(ctx context.Context,
 	return nil
 }
 
-func (s *kubernetesConfigurer) deleteExternalIPsFromGatewayService(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
+// deleteExternalIPsFromGatewayService removes the cluster IPs of a user service from the external IPs of the gateway service
+func (s *kubernetesConfigurer) deleteExternalIPsFromGatewayService(ctx context.Context, clusterIPs []string) error {
 	gatewayService, err := s.kubeClient.CoreV1().Services(s.gatewayServiceRef.Namespace).Get(ctx, s.gatewayServiceRef.Name, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
 
-	userService, err := s.kubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
-
 	var externalIPs []string
 	for _, externalIP := range gatewayService.Spec.ExternalIPs {
-		if !slices.Contains(userService.Spec.ClusterIPs, externalIP) {
+		if !slices.Contains(clusterIPs, externalIP) {
 			externalIPs = append(externalIPs, externalIP)
 		}
 	}
@@ -472,7 +714,8 @@ func (s *kubernetesConfigurer) deleteExternalIPsFromGatewayService(ctx context.C
 	return nil
 }
 
-func (s *kubernetesConfigurer) watchEndpointsSliceToBeUpdated(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
+// watchEndpointsSliceToBeUpdated waits for the EndpointSlice controller to fill again one of the restored EndpointSlices
+func (s *kubernetesConfigurer) watchEndpointsSliceToBeUpdated(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, state *configurerState) error {
 	retry := 0
 	maxRetries := 5
 	for {
@@ -480,26 +723,26 @@ func (s *kubernetesConfigurer) watchEndpointsSliceToBeUpdated(ctx context.Contex
 		case <-ctx.Done():
 			return nil
 		default:
-			initialEndpoints := s.initialEndpointPerLocalService[types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name}]
-			if len(initialEndpoints) == 0 {
+			if len(state.EndpointSlices) == 0 {
 				return nil
 			}
-			for _, endpoint := range initialEndpoints {
-				endpointSlice, err := s.kubeClient.DiscoveryV1().EndpointSlices(endpoint.Namespace).Get(ctx, endpoint.Name, metav1.GetOptions{})
+			for _, name := range state.EndpointSlices {
+				endpointSlice, err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Get(ctx, name, metav1.GetOptions{})
 				if err != nil {
+					if apierrors.IsNotFound(err) {
+						continue
+					}
 					return err
 				}
 				if endpointSlice.Labels["endpointslice.kubernetes.io/managed-by"] == "endpointslice-controller.k8s.io" {
 					// Check if there are endpoints in the endpoint slice
 					if len(endpointSlice.Endpoints) > 0 {
-						delete(s.initialEndpointPerLocalService, types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name})
 						return nil
 					}
 				}
 			}
 			retry++
 			if retry >= maxRetries {
-				delete(s.initialEndpointPerLocalService, types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name})
 				return nil
 			}
 			time.Sleep(time.Duration(retry) * 100 * time.Millisecond)
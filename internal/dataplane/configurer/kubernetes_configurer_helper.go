@@ -19,121 +19,446 @@ package configurer
 import (
 	"context"
 	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	discoveryv1apply "k8s.io/client-go/applyconfigurations/discovery/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// mirrorEndpointSlices mirrors the endpoint slices for the given service reference and removes the target port from the user's service endpoint slice
-func (s *kubernetesConfigurer) mirrorEndpointSlices(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, targetPort int32, stopCh <-chan bool) error {
-	beamlitServiceEndpoints, err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Watch(ctx, metav1.ListOptions{
-		LabelSelector: "kubernetes.io/service-name=" + fmt.Sprintf("%s-beamlit", serviceRef.Name),
+// mirrorEndpointSlices mirrors the endpoint slices of the model beamlit service with an informer until stopCh is closed.
+// Each of them is mirrored into an endpoint slice of the user service, minus the offloaded ports. The informer resyncs periodically,
+// the mirrored endpoint slices are then applied again and the ones whose beamlit endpoint slice is gone are deleted.
+func (s *kubernetesConfigurer) mirrorEndpointSlices(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, portNames map[string]bool, stopCh <-chan bool) error {
+	logger := log.FromContext(ctx)
+	selector := labels.SelectorFromSet(labels.Set{"kubernetes.io/service-name": fmt.Sprintf("%s-beamlit", serviceRef.Name)})
+	factory := informers.NewSharedInformerFactoryWithOptions(s.kubeClient, mirroredEndpointSlicesResyncPeriod,
+		informers.WithNamespace(serviceRef.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector.String()
+		}))
+	informer := factory.Discovery().V1().EndpointSlices()
+	apply := func(obj interface{}) {
+		endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok || !selector.Matches(labels.Set(endpointSlice.Labels)) {
+			return
+		}
+		if err := s.applyMirroredEndpointSlice(ctx, serviceRef, endpointSlice, portNames); err != nil {
+			logger.Error(err, "Failed to mirror endpoint slice", "Name", endpointSlice.Name, "Namespace", endpointSlice.Namespace)
+		}
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: apply,
+		UpdateFunc: func(_, newObj interface{}) {
+			apply(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+			if !ok || !selector.Matches(labels.Set(endpointSlice.Labels)) {
+				return
+			}
+			name := mirroredEndpointSliceName(serviceRef.Name, endpointSlice.Name)
+			err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				logger.Error(err, "Failed to delete mirrored endpoint slice", "Name", name, "Namespace", serviceRef.Namespace)
+			}
+		},
 	})
 	if err != nil {
 		return err
 	}
 
+	// The informer is shut down, waiting for the handlers to return, before mirrorEndpointSlices returns
+	stop := make(chan struct{})
+	defer factory.Shutdown()
+	defer close(stop)
+	factory.Start(stop)
+
+	ticker := time.NewTicker(mirroredEndpointSlicesResyncPeriod)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-stopCh:
-			beamlitServiceEndpoints.Stop()
 			return nil
 		case <-ctx.Done():
-			beamlitServiceEndpoints.Stop()
 			return ctx.Err()
-		case event := <-beamlitServiceEndpoints.ResultChan():
-			if event.Type == watch.Error {
-				return fmt.Errorf("error watching endpoint slice: %v", event.Object)
+		case <-ticker.C:
+			beamlitEndpointSlices, err := informer.Lister().List(selector)
+			if err != nil {
+				logger.Error(err, "Failed to list beamlit endpoint slices", "Name", serviceRef.Name, "Namespace", serviceRef.Namespace)
+				continue
 			}
-
-			endpointSlice, ok := event.Object.(*discoveryv1.EndpointSlice)
-			if !ok {
-				return fmt.Errorf("unexpected object type: %T", event.Object)
+			if err := s.deleteStaleMirroredEndpointSlices(ctx, serviceRef, beamlitEndpointSlices); err != nil {
+				logger.Error(err, "Failed to delete stale mirrored endpoint slices", "Name", serviceRef.Name, "Namespace", serviceRef.Namespace)
 			}
+		}
+	}
+}
 
-			for i, port := range endpointSlice.Ports {
-				if port.Port == nil {
-					continue
-				}
-				if *port.Port == targetPort {
-					// remove the port
-					endpointSlice.Ports = append(endpointSlice.Ports[:i], endpointSlice.Ports[i+1:]...)
-				}
-			}
+// mirrorGatewayEndpointSlices keeps the endpoints of the gateway added to a NodePort or LoadBalancer user service up to date
+// with an informer until stopCh is closed
+func (s *kubernetesConfigurer) mirrorGatewayEndpointSlices(ctx context.Context, service *corev1.Service, serviceRef *modelv1alpha1.ServiceReference, stopCh <-chan bool) error {
+	if !exposedOnNodes(service) {
+		return nil
+	}
+	return mirrorGatewayEndpointSlices(ctx, s.kubeClient, s.gatewayServiceRef, service, serviceRef.Ports(), stopCh)
+}
 
-			userServiceEndpointSlices, err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).List(ctx, metav1.ListOptions{
-				LabelSelector: "kubernetes.io/service-name=" + serviceRef.Name,
-			})
+// mirrorGatewayEndpointSlices keeps the endpoints of the gateway added on the given ports of a service up to date
+// with an informer until stopCh is closed
+func mirrorGatewayEndpointSlices(ctx context.Context, kubeClient kubernetes.Interface, gatewayServiceRef *modelv1alpha1.ServiceReference, service *corev1.Service, ports []int32, stopCh <-chan bool) error {
+	logger := log.FromContext(ctx)
+	selector := labels.SelectorFromSet(labels.Set{"kubernetes.io/service-name": gatewayServiceRef.Name})
+	factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, mirroredEndpointSlicesResyncPeriod,
+		informers.WithNamespace(gatewayServiceRef.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector.String()
+		}))
+	apply := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok || !selector.Matches(labels.Set(endpointSlice.Labels)) {
+			return
+		}
+		if err := applyGatewayEndpointSlices(ctx, kubeClient, gatewayServiceRef, service, ports); err != nil {
+			logger.Error(err, "Failed to add the gateway endpoints to service", "Name", service.Name, "Namespace", service.Namespace)
+		}
+	}
+	_, err := factory.Discovery().V1().EndpointSlices().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: apply,
+		UpdateFunc: func(_, newObj interface{}) {
+			apply(newObj)
+		},
+		DeleteFunc: apply,
+	})
+	if err != nil {
+		return err
+	}
 
-			if err != nil {
-				return err
-			}
+	stop := make(chan struct{})
+	defer factory.Shutdown()
+	defer close(stop)
+	factory.Start(stop)
+	select {
+	case <-stopCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-			var userServiceEndpointSlice discoveryv1.EndpointSlice
-			for _, slice := range userServiceEndpointSlices.Items {
-				if slice.Labels["beamlit.com/to-update"] == "true" {
-					userServiceEndpointSlice = slice
-					break
-				}
-			}
+// deleteStaleMirroredEndpointSlices deletes the mirrored endpoint slices of a user service which mirror none of the given beamlit endpoint slices
+func (s *kubernetesConfigurer) deleteStaleMirroredEndpointSlices(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, beamlitEndpointSlices []*discoveryv1.EndpointSlice) error {
+	mirroredNames := make(map[string]bool, len(beamlitEndpointSlices))
+	for _, endpointSlice := range beamlitEndpointSlices {
+		mirroredNames[mirroredEndpointSliceName(serviceRef.Name, endpointSlice.Name)] = true
+	}
+	userServiceEndpoints, err := s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "kubernetes.io/service-name=" + serviceRef.Name,
+	})
+	if err != nil {
+		return err
+	}
+	for _, endpointSlice := range userServiceEndpoints.Items {
+		if !isMirroredEndpointSlice(serviceRef.Name, &endpointSlice) || mirroredNames[endpointSlice.Name] {
+			continue
+		}
+		log.FromContext(ctx).V(1).Info("Deleting stale mirrored endpoint slice", "Name", endpointSlice.Name, "Namespace", endpointSlice.Namespace)
+		err = s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Delete(ctx, endpointSlice.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
 
-			if userServiceEndpointSlice.Name == "" {
-				return fmt.Errorf("second endpoint slice not found")
-			}
+// mirroredEndpointSliceName returns the name of the endpoint slice of a user service mirroring an endpoint slice of its model beamlit service.
+// It keeps the suffix generated for the beamlit endpoint slice, so that it is stable for as long as the beamlit endpoint slice exists.
+func mirroredEndpointSliceName(serviceName string, beamlitEndpointSliceName string) string {
+	return fmt.Sprintf("%s-beamlit-mirrored-%s", serviceName, strings.TrimPrefix(beamlitEndpointSliceName, serviceName+"-beamlit-"))
+}
+
+// gatewayEndpointSliceName returns the name of the endpoint slice of a user service with the endpoints of an endpoint slice of the gateway service
+func gatewayEndpointSliceName(serviceName string, gatewayServiceName string, gatewayEndpointSliceName string) string {
+	return fmt.Sprintf("%s-beamlit-gateway-%s", serviceName, strings.TrimPrefix(gatewayEndpointSliceName, gatewayServiceName+"-"))
+}
 
-			userServiceEndpointSlice.Ports = endpointSlice.Ports
-			userServiceEndpointSlice.AddressType = endpointSlice.AddressType
-			userServiceEndpointSlice.Endpoints = endpointSlice.Endpoints
+// isGatewayEndpointSlice returns whether an endpoint slice of a user service has the endpoints of the gateway
+func isGatewayEndpointSlice(serviceName string, endpointSlice *discoveryv1.EndpointSlice) bool {
+	return endpointSlice.Labels["beamlit.com/to-update"] == "true" && strings.HasPrefix(endpointSlice.Name, serviceName+"-beamlit-gateway-")
+}
 
-			_, err = s.kubeClient.DiscoveryV1().EndpointSlices(serviceRef.Namespace).Update(ctx, &userServiceEndpointSlice, metav1.UpdateOptions{})
-			if err != nil {
-				return err
+// endpointApplyConfiguration returns the apply configuration of an endpoint
+func endpointApplyConfiguration(endpoint discoveryv1.Endpoint) *discoveryv1apply.EndpointApplyConfiguration {
+	conditions := discoveryv1apply.EndpointConditions()
+	if endpoint.Conditions.Ready != nil {
+		conditions.WithReady(*endpoint.Conditions.Ready)
+	}
+	if endpoint.Conditions.Serving != nil {
+		conditions.WithServing(*endpoint.Conditions.Serving)
+	}
+	if endpoint.Conditions.Terminating != nil {
+		conditions.WithTerminating(*endpoint.Conditions.Terminating)
+	}
+	endpointApply := discoveryv1apply.Endpoint().
+		WithAddresses(endpoint.Addresses...).
+		WithConditions(conditions)
+	if endpoint.Hostname != nil {
+		endpointApply.WithHostname(*endpoint.Hostname)
+	}
+	if endpoint.NodeName != nil {
+		endpointApply.WithNodeName(*endpoint.NodeName)
+	}
+	if endpoint.Zone != nil {
+		endpointApply.WithZone(*endpoint.Zone)
+	}
+	return endpointApply
+}
+
+// isMirroredEndpointSlice returns whether an endpoint slice of a user service mirrors an endpoint slice of its model beamlit service
+func isMirroredEndpointSlice(serviceName string, endpointSlice *discoveryv1.EndpointSlice) bool {
+	return endpointSlice.Labels["beamlit.com/to-update"] == "true" && strings.HasPrefix(endpointSlice.Name, serviceName+"-beamlit-mirrored-")
+}
+
+// matchIPFamilies adds to the gateway service the IP families of the cluster IPs of a user service, for the traffic sent to them
+// to be proxied by the gateway. The families of the gateway service are left as they are when the API server didn't default them.
+func matchIPFamilies(gatewayService *corev1.Service, service *corev1.Service) {
+	if len(gatewayService.Spec.IPFamilies) == 0 {
+		return
+	}
+	for _, clusterIP := range service.Spec.ClusterIPs {
+		family := ipFamily(clusterIP)
+		if family == "" || slices.Contains(gatewayService.Spec.IPFamilies, family) {
+			continue
+		}
+		gatewayService.Spec.IPFamilies = append(gatewayService.Spec.IPFamilies, family)
+		if gatewayService.Spec.IPFamilyPolicy == nil || *gatewayService.Spec.IPFamilyPolicy == corev1.IPFamilyPolicySingleStack {
+			policy := corev1.IPFamilyPolicyPreferDualStack
+			gatewayService.Spec.IPFamilyPolicy = &policy
+		}
+	}
+}
+
+// ipFamily returns the IP family of an IP address, or an empty family if it isn't an IP address
+func ipFamily(address string) corev1.IPFamily {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return corev1.IPv4Protocol
+	default:
+		return corev1.IPv6Protocol
+	}
+}
+
+// offloadedPortNames returns the names of the ports of a service offloaded through the gateway.
+// The EndpointSlice ports are named after the service ports, whatever their target port.
+func offloadedPortNames(service *corev1.Service, serviceRef *modelv1alpha1.ServiceReference) (map[string]bool, error) {
+	portNames := make(map[string]bool)
+	for _, offloadedPort := range serviceRef.Ports() {
+		found := false
+		for _, port := range service.Spec.Ports {
+			if port.Port == offloadedPort {
+				portNames[port.Name] = true
+				found = true
+				break
 			}
 		}
+		if !found {
+			return nil, fmt.Errorf("port %d not found in service %s/%s", offloadedPort, service.Namespace, service.Name)
+		}
 	}
+	return portNames, nil
 }
 
-// watchService watches the service for changes and calls the appropriate methods on the service controller
-func (s *kubernetesConfigurer) watchService(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, stopCh <-chan bool) error {
-	<-stopCh
-	return nil
-	// TODO: fix this
-	/*
-		serviceWatcher, err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).Watch(ctx, metav1.ListOptions{
-			FieldSelector: fmt.Sprintf("metadata.name=%s", serviceRef.Name),
-		})
-		if err != nil {
-			return err
+// withoutPorts returns the EndpointSlice ports which are not offloaded
+func withoutPorts(ports []discoveryv1.EndpointPort, portNames map[string]bool) []discoveryv1.EndpointPort {
+	kept := make([]discoveryv1.EndpointPort, 0, len(ports))
+	for _, port := range ports {
+		name := ""
+		if port.Name != nil {
+			name = *port.Name
+		}
+		if portNames[name] {
+			continue
+		}
+		kept = append(kept, port)
+	}
+	return kept
+}
+
+// checkServiceSupported returns an ErrUnsupportedService if a user service can't be offloaded by the kubernetes configurer.
+// The name of a headless service can't be resolved to the gateway without losing the DNS names of its pods, which share its EndpointSlices.
+func checkServiceSupported(service *corev1.Service) error {
+	unsupported := func(format string, args ...any) error {
+		return &ErrUnsupportedService{
+			Service: types.NamespacedName{Namespace: service.Namespace, Name: service.Name},
+			Reason:  fmt.Sprintf(format, args...),
 		}
+	}
+	switch service.Spec.Type {
+	case "", corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+	case corev1.ServiceTypeExternalName:
+		return unsupported("%s Services have no endpoints to offload", service.Spec.Type)
+	default:
+		return unsupported("unknown Service type %s", service.Spec.Type)
+	}
+	if service.Spec.ClusterIP == corev1.ClusterIPNone {
+		return unsupported("headless Services are resolved to their pods by DNS, offload a ClusterIP Service selecting the same pods instead")
+	}
+	return nil
+}
 
-		for {
-			select {
-			case <-stopCh:
-				serviceWatcher.Stop()
-				return nil
-			case <-ctx.Done():
-				serviceWatcher.Stop()
-				return ctx.Err()
-			case event := <-serviceWatcher.ResultChan():
-				if event.Type == watch.Error {
-					return fmt.Errorf("error watching service: %v", event.Object)
-				}
-
-				_, ok := event.Object.(*corev1.Service)
-				if !ok {
-					return fmt.Errorf("unexpected object type: %T", event.Object)
-				}
-
-				switch event.Type {
-				case watch.Added, watch.Modified:
-					s.Configure(ctx, serviceRef)
-				case watch.Deleted:
-					s.Unconfigure(ctx, serviceRef)
-					return nil // we don't need to watch the service anymore
-				}
+// exposedOnNodes returns whether a service is exposed out of the cluster on node ports, by itself or by a load balancer
+func exposedOnNodes(service *corev1.Service) bool {
+	return service.Spec.Type == corev1.ServiceTypeNodePort || service.Spec.Type == corev1.ServiceTypeLoadBalancer
+}
+
+// watchService watches a configured user service with an informer until stopCh is closed.
+// The service is configured again when its ports, selector, cluster IPs or load balancer addresses change, and the objects created to proxy it are deleted with it.
+func (s *kubernetesConfigurer) watchService(ctx context.Context, key types.NamespacedName, stopCh <-chan bool) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.kubeClient, 0,
+		informers.WithNamespace(key.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", key.Name).String()
+		}))
+	_, err := factory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldService, ok := oldObj.(*corev1.Service)
+			if !ok {
+				return
+			}
+			newService, ok := newObj.(*corev1.Service)
+			if !ok || newService.Name != key.Name || !serviceChanged(oldService, newService) {
+				return
 			}
+			s.reconfigureService(ctx, key)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
 			}
-	*/
+			service, ok := obj.(*corev1.Service)
+			if !ok || service.Name != key.Name {
+				return
+			}
+			s.serviceDeleted(ctx, key, service)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	factory.Start(stop)
+	select {
+	case <-stopCh:
+	case <-ctx.Done():
+	}
+	close(stop)
+	factory.Shutdown()
+	return nil
+}
+
+// serviceChanged returns whether a change of a user service requires to configure it again.
+// The addresses of its load balancer are routed by the gateway.
+func serviceChanged(oldService *corev1.Service, newService *corev1.Service) bool {
+	return !reflect.DeepEqual(oldService.Spec.Ports, newService.Spec.Ports) ||
+		!reflect.DeepEqual(oldService.Spec.Selector, newService.Spec.Selector) ||
+		!reflect.DeepEqual(oldService.Spec.ClusterIPs, newService.Spec.ClusterIPs) ||
+		!reflect.DeepEqual(oldService.Spec.IPFamilies, newService.Spec.IPFamilies) ||
+		oldService.Spec.Type != newService.Spec.Type ||
+		!reflect.DeepEqual(oldService.Status.LoadBalancer.Ingress, newService.Status.LoadBalancer.Ingress)
+}
+
+// reconfigureService configures again a user service which changed, with its last configured reference
+func (s *kubernetesConfigurer) reconfigureService(ctx context.Context, key types.NamespacedName) {
+	defer s.serviceLocks.lock(key)()
+	s.mu.Lock()
+	serviceRef, ok := s.serviceRefs[key]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	log.FromContext(ctx).V(1).Info("Service changed, configuring it again", "Name", key.Name, "Namespace", key.Namespace)
+	err := s.configure(ctx, serviceRef)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to configure the changed service", "Name", key.Name, "Namespace", key.Namespace)
+	}
+	// A service which can't be offloaded anymore is not left half configured
+	if IsUnsupportedService(err) {
+		if unconfigureErr := s.unconfigure(ctx, serviceRef); unconfigureErr != nil {
+			log.FromContext(ctx).Error(unconfigureErr, "Failed to unconfigure the unsupported service", "Name", key.Name, "Namespace", key.Namespace)
+		}
+	}
+	s.sendEvent(ctx, ServiceEvent{Service: key, Type: ServiceEventReconfigured, Err: err})
+}
+
+// serviceDeleted deletes the objects created to proxy a user service which was deleted
+func (s *kubernetesConfigurer) serviceDeleted(ctx context.Context, key types.NamespacedName, service *corev1.Service) {
+	defer s.serviceLocks.lock(key)()
+	s.mu.Lock()
+	serviceRef, ok := s.serviceRefs[key]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	log.FromContext(ctx).V(1).Info("Service deleted, deleting the objects created to proxy it", "Name", key.Name, "Namespace", key.Namespace)
+	err := s.cleanupDeletedService(ctx, serviceRef, service)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to delete the objects created to proxy the deleted service", "Name", key.Name, "Namespace", key.Namespace)
+	}
+	s.sendEvent(ctx, ServiceEvent{Service: key, Type: ServiceEventDeleted, Err: err})
+}
+
+// sendEvent sends a service event without blocking, it is dropped when the buffer of the events is full
+func (s *kubernetesConfigurer) sendEvent(ctx context.Context, event ServiceEvent) {
+	select {
+	case s.events <- event:
+	default:
+		log.FromContext(ctx).V(0).Info("Dropping service event, the buffer is full", "Name", event.Service.Name, "Namespace", event.Service.Namespace, "Type", event.Type)
+	}
+}
+
+// keyedMutex is a mutex by key, the zero value is unlocked
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*sync.Mutex
+}
+
+// lock locks key and returns the function unlocking it
+func (m *keyedMutex) lock(key types.NamespacedName) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[types.NamespacedName]*sync.Mutex)
+	}
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[key] = lock
+	}
+	m.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
 }
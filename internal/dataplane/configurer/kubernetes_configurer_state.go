@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configurer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// StateAnnotation records on a user Service what the configurer changed to proxy it,
+	// so that the Service can be restored by another process of the operator, e.g. after a restart
+	StateAnnotation = "beamlit.com/configurer-state"
+	// ModelServiceAnnotation is set on the Services created by the configurer, with the name of the user Service they select the pods of
+	ModelServiceAnnotation = "beamlit.com/model-service"
+)
+
+// configurerState is what the configurer changed to proxy a user Service
+type configurerState struct {
+	// BeamlitService is the name of the Service created in the namespace of the user Service, selecting its pods
+	BeamlitService string `json:"beamlitService,omitempty"`
+	// EndpointSlices are the names of the EndpointSlices of the user Service taken over from the EndpointSlice controller
+	EndpointSlices []string `json:"endpointSlices,omitempty"`
+}
+
+// loadState returns the state recorded on a user Service, nil if the Service is not proxied
+func (s *kubernetesConfigurer) loadState(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) (*configurerState, error) {
+	service, err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).Get(ctx, serviceRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := service.Annotations[StateAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	state := &configurerState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on service %s/%s: %w", StateAnnotation, serviceRef.Namespace, serviceRef.Name, err)
+	}
+	return state, nil
+}
+
+// saveState records the state on a user Service, or removes it if state is nil
+// It must be called before changing the objects it records, so that a crash never loses a change.
+func (s *kubernetesConfigurer) saveState(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, state *configurerState) error {
+	var value *string
+	if state != nil {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		value = ptr(string(data))
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]*string{StateAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.kubeClient.CoreV1().Services(serviceRef.Namespace).Patch(ctx, serviceRef.Name, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: OperatorLabel,
+	})
+	return err
+}
+
+// recordEndpointSlices adds EndpointSlices to the state of a user Service, before they are taken over
+func (s *kubernetesConfigurer) recordEndpointSlices(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, names []string) error {
+	state, err := s.loadState(ctx, serviceRef)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &configurerState{}
+	}
+	changed := false
+	for _, name := range names {
+		if !slices.Contains(state.EndpointSlices, name) {
+			state.EndpointSlices = append(state.EndpointSlices, name)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.saveState(ctx, serviceRef, state)
+}
+
+// restoreOrphanServices restores the user Services carrying a state without owner,
+// e.g. the ones whose ModelDeployment was deleted while the operator was down.
+// A Service which can't be restored is logged and left configured.
+func (s *kubernetesConfigurer) restoreOrphanServices(ctx context.Context, owners ServiceOwners) error {
+	logger := log.FromContext(ctx)
+	namespaces := owners.Namespaces()
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	for _, namespace := range namespaces {
+		services, err := s.kubeClient.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, service := range services.Items {
+			if _, ok := service.Annotations[StateAnnotation]; !ok {
+				continue
+			}
+			key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+			owned, err := owners.HasOwner(ctx, key)
+			if err != nil {
+				return err
+			}
+			if owned {
+				continue
+			}
+			logger.V(0).Info("Restoring service configured without owner", "Service", key.String())
+			serviceRef := &modelv1alpha1.ServiceReference{ObjectReference: corev1.ObjectReference{Namespace: service.Namespace, Name: service.Name}}
+			if err := s.Unconfigure(ctx, serviceRef); err != nil {
+				logger.V(0).Error(err, "Failed to restore service configured without owner", "Service", key.String())
+			}
+		}
+	}
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,974 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configurer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+func newTestObjects() []runtime.Object {
+	return []runtime.Object{
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+			Spec: corev1.ServiceSpec{
+				ClusterIPs: []string{"10.0.0.1"},
+				Selector:   map[string]string{"app": "model"},
+				Ports:      []corev1.ServicePort{{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "beamlit", Name: "gateway"},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-abcde", Labels: map[string]string{
+				"kubernetes.io/service-name":             "model",
+				"endpointslice.kubernetes.io/managed-by": "endpointslice-controller.k8s.io",
+			}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+		},
+		// Created by the EndpointSlice controller for the Service created by the configurer
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-beamlit-fghij", Labels: map[string]string{
+				"kubernetes.io/service-name":             "model-beamlit",
+				"endpointslice.kubernetes.io/managed-by": "endpointslice-controller.k8s.io",
+			}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{{
+				Addresses:  []string{"10.1.0.1"},
+				Conditions: discoveryv1.EndpointConditions{Ready: ptr(true), Serving: ptr(true), Terminating: ptr(false)},
+			}},
+			Ports: []discoveryv1.EndpointPort{{Name: ptr("http"), Port: ptr(int32(8080)), Protocol: ptr(corev1.ProtocolTCP)}},
+		},
+	}
+}
+
+func TestKubernetesConfigurerRestoresAfterRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kubeClient := fake.NewClientset(newTestObjects()...)
+	serviceRef := &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:      80,
+	}
+	gatewayRef := &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}
+
+	previous, _ := newKubernetesConfigurer(ctx, kubeClient)
+	if err := previous.Start(ctx, gatewayRef, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := previous.Configure(ctx, serviceRef); err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous process of the operator exited, a new one only knows what is recorded in the cluster
+	previous.(*kubernetesConfigurer).stopWatchers(ctx, serviceRef)
+	current, _ := newKubernetesConfigurer(ctx, kubeClient)
+	if err := current.Start(ctx, gatewayRef, nil); err != nil {
+		t.Fatal(err)
+	}
+	beamlitService, err := current.GetLocalBeamlitService(ctx, serviceRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if beamlitService.Name != "model-beamlit" {
+		t.Errorf("want local beamlit service model-beamlit but got %s", beamlitService.Name)
+	}
+	if err := current.Unconfigure(ctx, serviceRef); err != nil {
+		t.Fatal(err)
+	}
+
+	endpointSlice, err := kubeClient.DiscoveryV1().EndpointSlices("default").Get(ctx, "model-abcde", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if managedBy := endpointSlice.Labels["endpointslice.kubernetes.io/managed-by"]; managedBy != "endpointslice-controller.k8s.io" {
+		t.Errorf("want endpoint slice given back to the EndpointSlice controller but it is managed by %s", managedBy)
+	}
+	if _, err := kubeClient.DiscoveryV1().EndpointSlices("default").Get(ctx, "model-beamlit-mirrored-fghij", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("want mirrored endpoint slice deleted but got %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-beamlit", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("want beamlit service deleted but got %v", err)
+	}
+	service, err := kubeClient.CoreV1().Services("default").Get(ctx, "model", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := service.Annotations[StateAnnotation]; ok {
+		t.Errorf("want state annotation removed but got %s", service.Annotations[StateAnnotation])
+	}
+}
+
+func TestKubernetesConfigurerRestoresOrphanServicesAtStart(t *testing.T) {
+	type testCase struct {
+		owned        bool
+		wantRestored bool
+	}
+	testCases := map[string]testCase{
+		"When the service has no owner anymore, must restore it": {
+			owned:        false,
+			wantRestored: true,
+		},
+		"When the service still has an owner, must keep it configured": {
+			owned:        true,
+			wantRestored: false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			kubeClient := fake.NewClientset(newTestObjects()...)
+			serviceRef := &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+				TargetPort:      80,
+			}
+			gatewayRef := &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+				TargetPort:      8000,
+			}
+
+			previous, _ := newKubernetesConfigurer(ctx, kubeClient)
+			if err := previous.Start(ctx, gatewayRef, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := previous.Configure(ctx, serviceRef); err != nil {
+				t.Fatal(err)
+			}
+			previous.(*kubernetesConfigurer).stopWatchers(ctx, serviceRef)
+
+			// The ModelDeployment may have been deleted while the operator was down
+			owners := NewMockServiceOwners(gomock.NewController(t))
+			owners.EXPECT().Namespaces().Return([]string{"default"})
+			owners.EXPECT().HasOwner(gomock.Any(), types.NamespacedName{Namespace: "default", Name: "model"}).Return(tc.owned, nil)
+			current, _ := newKubernetesConfigurer(ctx, kubeClient)
+			if err := current.Start(ctx, gatewayRef, owners); err != nil {
+				t.Fatal(err)
+			}
+
+			service, err := kubeClient.CoreV1().Services("default").Get(ctx, "model", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := service.Annotations[StateAnnotation]; ok == tc.wantRestored {
+				t.Errorf("want service restored %t but state annotation present %t", tc.wantRestored, ok)
+			}
+			_, err = kubeClient.CoreV1().Services("default").Get(ctx, "model-beamlit", metav1.GetOptions{})
+			if deleted := apierrors.IsNotFound(err); deleted != tc.wantRestored {
+				t.Errorf("want beamlit service deleted %t but got %v", tc.wantRestored, err)
+			}
+		})
+	}
+}
+
+func TestKubernetesConfigurerOffloadsSeveralPorts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	objects := newTestObjects()
+	objects[0].(*corev1.Service).Spec.Ports = []corev1.ServicePort{
+		{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromString("http")},
+		{Name: "grpc", Port: 9000, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(9000)},
+		{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(9090)},
+	}
+	objects[3].(*discoveryv1.EndpointSlice).Ports = []discoveryv1.EndpointPort{
+		{Name: ptr("http"), Port: ptr(int32(8080)), Protocol: ptr(corev1.ProtocolTCP)},
+		{Name: ptr("grpc"), Port: ptr(int32(9000)), Protocol: ptr(corev1.ProtocolTCP)},
+		{Name: ptr("metrics"), Port: ptr(int32(9090)), Protocol: ptr(corev1.ProtocolTCP)},
+	}
+	kubeClient := fake.NewClientset(objects...)
+	serviceRef := &modelv1alpha1.ServiceReference{
+		ObjectReference:       corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:            80,
+		AdditionalTargetPorts: []int32{9000},
+	}
+
+	configurer, _ := newKubernetesConfigurer(ctx, kubeClient)
+	if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := configurer.Configure(ctx, serviceRef); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := kubeClient.CoreV1().Services("beamlit").Get(ctx, "gateway", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gateway.Spec.Ports) != 2 || gateway.Spec.Ports[0].Port != 80 || gateway.Spec.Ports[1].Port != 9000 {
+		t.Errorf("want ports 80 and 9000 on the gateway service but got %v", gateway.Spec.Ports)
+	}
+	beamlitService, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-beamlit", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targetPort := beamlitService.Spec.Ports[0].TargetPort; targetPort != intstr.FromString("http") {
+		t.Errorf("want the named target port kept on the beamlit service but got %v", targetPort)
+	}
+	mirrored, err := kubeClient.DiscoveryV1().EndpointSlices("default").Get(ctx, "model-beamlit-mirrored-fghij", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrored.Ports) != 1 || *mirrored.Ports[0].Name != "metrics" {
+		t.Errorf("want only the metrics port left to the model pods but got %v", mirrored.Ports)
+	}
+	local, err := configurer.GetLocalBeamlitService(ctx, serviceRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports := local.Ports(); len(ports) != 2 || ports[1] != 9000 {
+		t.Errorf("want the ports of the local beamlit service to be 80 and 9000 but got %v", ports)
+	}
+
+	t.Run("When a port isn't exposed by the service, must return an error", func(t *testing.T) {
+		invalid := serviceRef.DeepCopy()
+		invalid.AdditionalTargetPorts = []int32{7000}
+		if err := configurer.Configure(ctx, invalid); err == nil {
+			t.Error("want an error but got nil")
+		}
+		gateway, err := kubeClient.CoreV1().Services("beamlit").Get(ctx, "gateway", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(gateway.Spec.Ports) != 2 {
+			t.Errorf("want the gateway service unchanged but got %v", gateway.Spec.Ports)
+		}
+	})
+}
+
+func TestKubernetesConfigurerIPFamilies(t *testing.T) {
+	type testCase struct {
+		families       []corev1.IPFamily
+		clusterIPs     []string
+		wantPolicy     corev1.IPFamilyPolicy
+		wantFamilies   []corev1.IPFamily
+		wantMirroredTo []string
+	}
+	tcs := map[string]testCase{
+		"When the service is IPv4, must mirror the IPv4 endpoints and keep the gateway single stack": {
+			families:       []corev1.IPFamily{corev1.IPv4Protocol},
+			clusterIPs:     []string{"10.0.0.1"},
+			wantPolicy:     corev1.IPFamilyPolicySingleStack,
+			wantFamilies:   []corev1.IPFamily{corev1.IPv4Protocol},
+			wantMirroredTo: []string{"model-beamlit-mirrored-ipv4"},
+		},
+		"When the service is IPv6, must mirror the IPv6 endpoints and add IPv6 to the gateway": {
+			families:       []corev1.IPFamily{corev1.IPv6Protocol},
+			clusterIPs:     []string{"fd00::1"},
+			wantPolicy:     corev1.IPFamilyPolicyPreferDualStack,
+			wantFamilies:   []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol},
+			wantMirroredTo: []string{"model-beamlit-mirrored-ipv6"},
+		},
+		"When the service is dual stack, must mirror the endpoints of both families and make the gateway dual stack": {
+			families:       []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol},
+			clusterIPs:     []string{"10.0.0.1", "fd00::1"},
+			wantPolicy:     corev1.IPFamilyPolicyPreferDualStack,
+			wantFamilies:   []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol},
+			wantMirroredTo: []string{"model-beamlit-mirrored-ipv4", "model-beamlit-mirrored-ipv6"},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			policy := corev1.IPFamilyPolicySingleStack
+			if len(tc.families) > 1 {
+				policy = corev1.IPFamilyPolicyRequireDualStack
+			}
+			gatewayPolicy := corev1.IPFamilyPolicySingleStack
+			objects := []runtime.Object{
+				&corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+					Spec: corev1.ServiceSpec{
+						ClusterIPs:     tc.clusterIPs,
+						IPFamilies:     tc.families,
+						IPFamilyPolicy: &policy,
+						Selector:       map[string]string{"app": "model"},
+						Ports:          []corev1.ServicePort{{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+					},
+				},
+				&corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "beamlit", Name: "gateway"},
+					Spec: corev1.ServiceSpec{
+						ExternalIPs:    []string{"192.0.2.10"},
+						IPFamilies:     []corev1.IPFamily{corev1.IPv4Protocol},
+						IPFamilyPolicy: &gatewayPolicy,
+					},
+				},
+			}
+			// Created by the EndpointSlice controller, one per IP family of the Services
+			addresses := map[corev1.IPFamily]string{corev1.IPv4Protocol: "10.1.0.1", corev1.IPv6Protocol: "fd01::1"}
+			for _, family := range tc.families {
+				addressType := discoveryv1.AddressType(family)
+				for _, serviceName := range []string{"model", "model-beamlit"} {
+					objects = append(objects, &discoveryv1.EndpointSlice{
+						ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: serviceName + "-" + strings.ToLower(string(family)), Labels: map[string]string{
+							"kubernetes.io/service-name":             serviceName,
+							"endpointslice.kubernetes.io/managed-by": "endpointslice-controller.k8s.io",
+						}},
+						AddressType: addressType,
+						Endpoints: []discoveryv1.Endpoint{{
+							Addresses:  []string{addresses[family]},
+							Conditions: discoveryv1.EndpointConditions{Ready: ptr(true), Serving: ptr(true), Terminating: ptr(false)},
+						}},
+						Ports: []discoveryv1.EndpointPort{{Name: ptr("http"), Port: ptr(int32(8080)), Protocol: ptr(corev1.ProtocolTCP)}},
+					})
+				}
+			}
+			kubeClient := fake.NewClientset(objects...)
+			serviceRef := &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+				TargetPort:      80,
+			}
+			configurer, _ := newKubernetesConfigurer(ctx, kubeClient)
+			if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+				TargetPort:      8000,
+			}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := configurer.Configure(ctx, serviceRef); err != nil {
+				t.Fatal(err)
+			}
+
+			gateway, err := kubeClient.CoreV1().Services("beamlit").Get(ctx, "gateway", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append([]string{"192.0.2.10"}, tc.clusterIPs...); !reflect.DeepEqual(gateway.Spec.ExternalIPs, want) {
+				t.Errorf("want external IPs %v on the gateway service but got %v", want, gateway.Spec.ExternalIPs)
+			}
+			if *gateway.Spec.IPFamilyPolicy != tc.wantPolicy || !reflect.DeepEqual(gateway.Spec.IPFamilies, tc.wantFamilies) {
+				t.Errorf("want gateway service %s %v but got %s %v", tc.wantPolicy, tc.wantFamilies, *gateway.Spec.IPFamilyPolicy, gateway.Spec.IPFamilies)
+			}
+			beamlitService, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-beamlit", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(beamlitService.Spec.IPFamilies, tc.families) || *beamlitService.Spec.IPFamilyPolicy != policy {
+				t.Errorf("want beamlit service %s %v but got %v %v", policy, tc.families, beamlitService.Spec.IPFamilyPolicy, beamlitService.Spec.IPFamilies)
+			}
+			for i, name := range tc.wantMirroredTo {
+				mirrored, err := kubeClient.DiscoveryV1().EndpointSlices("default").Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				family := tc.families[i]
+				if mirrored.AddressType != discoveryv1.AddressType(family) || mirrored.Endpoints[0].Addresses[0] != addresses[family] {
+					t.Errorf("want %s endpoint %s mirrored but got %s %v", family, addresses[family], mirrored.AddressType, mirrored.Endpoints)
+				}
+			}
+
+			if err := configurer.Unconfigure(ctx, serviceRef); err != nil {
+				t.Fatal(err)
+			}
+			gateway, err = kubeClient.CoreV1().Services("beamlit").Get(ctx, "gateway", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gateway.Spec.ExternalIPs, []string{"192.0.2.10"}) {
+				t.Errorf("want the cluster IPs of the service removed from the gateway service but got %v", gateway.Spec.ExternalIPs)
+			}
+			for _, name := range tc.wantMirroredTo {
+				if _, err := kubeClient.DiscoveryV1().EndpointSlices("default").Get(ctx, name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+					t.Errorf("want mirrored endpoint slice %s deleted but got %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestKubernetesConfigurerWatchesService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	objects := newTestObjects()
+	objects[1].(*corev1.Service).Spec.ExternalIPs = []string{"192.0.2.10"}
+	kubeClient := fake.NewClientset(objects...)
+	watchStarted := make(chan struct{})
+	var once sync.Once
+	kubeClient.PrependWatchReactor("services", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		once.Do(func() { close(watchStarted) })
+		return false, nil, nil
+	})
+	serviceRef := &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:      80,
+	}
+	configurer, _ := newKubernetesConfigurer(ctx, kubeClient)
+	if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := configurer.Configure(ctx, serviceRef); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-watchStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the service watched")
+	}
+
+	nextEvent := func(t *testing.T) ServiceEvent {
+		t.Helper()
+		select {
+		case event := <-configurer.Events():
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("want a service event")
+			return ServiceEvent{}
+		}
+	}
+
+	t.Run("When the selector of the service changes, must configure it again", func(t *testing.T) {
+		service, err := kubeClient.CoreV1().Services("default").Get(ctx, "model", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		service.Spec.Selector = map[string]string{"app": "model-v2"}
+		if _, err := kubeClient.CoreV1().Services("default").Update(ctx, service, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if event := nextEvent(t); event.Type != ServiceEventReconfigured || event.Err != nil || event.Service.Name != "model" {
+			t.Fatalf("want the service reconfigured but got %v", event)
+		}
+		beamlitService, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-beamlit", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if beamlitService.Spec.Selector["app"] != "model-v2" {
+			t.Errorf("want the beamlit service selecting the new pods but got %v", beamlitService.Spec.Selector)
+		}
+	})
+
+	t.Run("When the offloaded port is removed from the service, must report the error", func(t *testing.T) {
+		service, err := kubeClient.CoreV1().Services("default").Get(ctx, "model", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		service.Spec.Ports[0].Port = 8081
+		if _, err := kubeClient.CoreV1().Services("default").Update(ctx, service, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if event := nextEvent(t); event.Type != ServiceEventReconfigured || event.Err == nil {
+			t.Fatalf("want the service reconfiguration failed but got %v", event)
+		}
+	})
+
+	t.Run("When the service is deleted, must delete the objects created to proxy it", func(t *testing.T) {
+		if err := kubeClient.CoreV1().Services("default").Delete(ctx, "model", metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if event := nextEvent(t); event.Type != ServiceEventDeleted || event.Err != nil {
+			t.Fatalf("want the service deleted but got %v", event)
+		}
+		if _, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-beamlit", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("want beamlit service deleted but got %v", err)
+		}
+		if _, err := kubeClient.DiscoveryV1().EndpointSlices("default").Get(ctx, "model-beamlit-mirrored-fghij", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("want mirrored endpoint slice deleted but got %v", err)
+		}
+		gateway, err := kubeClient.CoreV1().Services("beamlit").Get(ctx, "gateway", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gateway.Spec.ExternalIPs, []string{"192.0.2.10"}) {
+			t.Errorf("want the cluster IPs of the deleted service removed from the gateway service but got %v", gateway.Spec.ExternalIPs)
+		}
+	})
+}
+
+func TestKubernetesConfigurerMirrorsSeveralEndpointSlices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newBeamlitEndpointSlice := func(name string, address string) *discoveryv1.EndpointSlice {
+		return &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{
+				"kubernetes.io/service-name":             "model-beamlit",
+				"endpointslice.kubernetes.io/managed-by": "endpointslice-controller.k8s.io",
+			}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{{
+				Addresses:  []string{address},
+				Conditions: discoveryv1.EndpointConditions{Ready: ptr(true), Serving: ptr(true), Terminating: ptr(false)},
+			}},
+			Ports: []discoveryv1.EndpointPort{{Name: ptr("http"), Port: ptr(int32(8080)), Protocol: ptr(corev1.ProtocolTCP)}},
+		}
+	}
+	objects := append(newTestObjects(),
+		newBeamlitEndpointSlice("model-beamlit-klmno", "10.1.0.2"),
+		// Mirrored by a previous version of the operator, one per address family
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-beamlit-mirrored-ipv4", Labels: map[string]string{
+				"beamlit.com/to-update":                  "true",
+				"kubernetes.io/service-name":             "model",
+				"endpointslice.kubernetes.io/managed-by": "beamlit-operator",
+			}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+		},
+	)
+	kubeClient := fake.NewClientset(objects...)
+	watchStarted := make(chan struct{})
+	var once sync.Once
+	kubeClient.PrependWatchReactor("endpointslices", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		once.Do(func() { close(watchStarted) })
+		return false, nil, nil
+	})
+	configurer, _ := newKubernetesConfigurer(ctx, kubeClient)
+	if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := configurer.Configure(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:      80,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-watchStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the endpoint slices watched")
+	}
+
+	mirroredEndpointSlices := func() map[string]string {
+		t.Helper()
+		endpointSlices, err := kubeClient.DiscoveryV1().EndpointSlices("default").List(ctx, metav1.ListOptions{
+			LabelSelector: "kubernetes.io/service-name=model",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mirrored := make(map[string]string)
+		for _, endpointSlice := range endpointSlices.Items {
+			if isMirroredEndpointSlice("model", &endpointSlice) {
+				mirrored[endpointSlice.Name] = ""
+				if len(endpointSlice.Endpoints) > 0 {
+					mirrored[endpointSlice.Name] = endpointSlice.Endpoints[0].Addresses[0]
+				}
+			}
+		}
+		return mirrored
+	}
+	waitForMirroredEndpointSlices := func(t *testing.T, want map[string]string) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			got := mirroredEndpointSlices()
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("want mirrored endpoint slices %v but got %v", want, got)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	t.Run("When configured, must mirror each endpoint slice and delete the stale ones", func(t *testing.T) {
+		want := map[string]string{
+			"model-beamlit-mirrored-fghij": "10.1.0.1",
+			"model-beamlit-mirrored-klmno": "10.1.0.2",
+		}
+		if got := mirroredEndpointSlices(); !reflect.DeepEqual(got, want) {
+			t.Errorf("want mirrored endpoint slices %v but got %v", want, got)
+		}
+	})
+
+	t.Run("When an endpoint slice is added, must mirror it", func(t *testing.T) {
+		if _, err := kubeClient.DiscoveryV1().EndpointSlices("default").Create(ctx, newBeamlitEndpointSlice("model-beamlit-pqrst", "10.1.0.3"), metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitForMirroredEndpointSlices(t, map[string]string{
+			"model-beamlit-mirrored-fghij": "10.1.0.1",
+			"model-beamlit-mirrored-klmno": "10.1.0.2",
+			"model-beamlit-mirrored-pqrst": "10.1.0.3",
+		})
+	})
+
+	t.Run("When an endpoint slice is updated, must update its mirror", func(t *testing.T) {
+		if _, err := kubeClient.DiscoveryV1().EndpointSlices("default").Update(ctx, newBeamlitEndpointSlice("model-beamlit-klmno", "10.1.0.4"), metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitForMirroredEndpointSlices(t, map[string]string{
+			"model-beamlit-mirrored-fghij": "10.1.0.1",
+			"model-beamlit-mirrored-klmno": "10.1.0.4",
+			"model-beamlit-mirrored-pqrst": "10.1.0.3",
+		})
+	})
+
+	t.Run("When an endpoint slice is deleted, must delete its mirror", func(t *testing.T) {
+		if err := kubeClient.DiscoveryV1().EndpointSlices("default").Delete(ctx, "model-beamlit-fghij", metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitForMirroredEndpointSlices(t, map[string]string{
+			"model-beamlit-mirrored-klmno": "10.1.0.4",
+			"model-beamlit-mirrored-pqrst": "10.1.0.3",
+		})
+	})
+}
+
+// countedWatch is a watch of the fake clientset decrementing the count of the open watches once stopped
+type countedWatch struct {
+	watch.Interface
+	once sync.Once
+	open *atomic.Int32
+}
+
+func (w *countedWatch) Stop() {
+	w.once.Do(func() { w.open.Add(-1) })
+	w.Interface.Stop()
+}
+
+func TestKubernetesConfigurerConcurrentConfigure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kubeClient := fake.NewClientset(newTestObjects()...)
+	var openWatches atomic.Int32
+	kubeClient.PrependWatchReactor("endpointslices", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := kubeClient.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		openWatches.Add(1)
+		return true, &countedWatch{Interface: w, open: &openWatches}, nil
+	})
+	// Slow writes let the configurations overlap
+	kubeClient.PrependReactor("*", "endpointslices", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false, nil, nil
+	})
+	serviceRef := &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:      80,
+	}
+	current, _ := newKubernetesConfigurer(ctx, kubeClient)
+	configurer := current.(*kubernetesConfigurer)
+	if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = configurer.Configure(ctx, serviceRef)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	configurer.mu.Lock()
+	mirrorings := len(configurer.mirrorings)
+	configurer.mu.Unlock()
+	if mirrorings != 1 {
+		t.Errorf("want a single mirroring but got %d", mirrorings)
+	}
+
+	if err := configurer.Unconfigure(ctx, serviceRef); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for openWatches.Load() != 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("want every mirroring stopped once unconfigured but %d endpoint slices watches are open", openWatches.Load())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestKubernetesConfigurerRejectsUnsupportedServices(t *testing.T) {
+	type testCase struct {
+		service    func(service *corev1.Service)
+		serviceRef *modelv1alpha1.ServiceReference
+	}
+	tcs := map[string]testCase{
+		"When the service is an ExternalName, must reject it": {
+			service: func(service *corev1.Service) {
+				service.Spec = corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "model.example.com"}
+			},
+		},
+		"When the service is headless, must reject it": {
+			service: func(service *corev1.Service) {
+				service.Spec.ClusterIP = corev1.ClusterIPNone
+				service.Spec.ClusterIPs = []string{corev1.ClusterIPNone}
+			},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			objects := newTestObjects()
+			tc.service(objects[0].(*corev1.Service))
+			kubeClient := fake.NewClientset(objects...)
+			configurer, _ := newKubernetesConfigurer(ctx, kubeClient)
+			if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+				TargetPort:      8000,
+			}, nil); err != nil {
+				t.Fatal(err)
+			}
+			err := configurer.Configure(ctx, &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+				TargetPort:      80,
+			})
+			if !IsUnsupportedService(err) {
+				t.Fatalf("want an unsupported service error but got %v", err)
+			}
+			if _, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-beamlit", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("want no beamlit service created but got %v", err)
+			}
+			endpointSlice, err := kubeClient.DiscoveryV1().EndpointSlices("default").Get(ctx, "model-abcde", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if managedBy := endpointSlice.Labels["endpointslice.kubernetes.io/managed-by"]; managedBy != "endpointslice-controller.k8s.io" {
+				t.Errorf("want endpoint slice left to the EndpointSlice controller but it is managed by %s", managedBy)
+			}
+		})
+	}
+}
+
+func TestKubernetesConfigurerHeadlessService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	objects := newTestObjects()
+	service := objects[0].(*corev1.Service)
+	service.Spec.ClusterIP = corev1.ClusterIPNone
+	service.Spec.ClusterIPs = []string{corev1.ClusterIPNone}
+	objects[2].(*discoveryv1.EndpointSlice).Endpoints = []discoveryv1.Endpoint{{
+		Addresses:  []string{"10.1.0.1"},
+		Hostname:   ptr("model-0"),
+		Conditions: discoveryv1.EndpointConditions{Ready: ptr(true)},
+	}}
+	kubeClient := fake.NewClientset(objects...)
+	configurer, _ := newKubernetesConfigurer(ctx, kubeClient)
+	if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	err := configurer.Configure(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:      80,
+	})
+	if !IsUnsupportedService(err) {
+		t.Fatalf("want an unsupported service error but got %v", err)
+	}
+
+	// The DNS name of the pod model-0 keeps resolving to it
+	endpointSlices, err := kubeClient.DiscoveryV1().EndpointSlices("default").List(ctx, metav1.ListOptions{
+		LabelSelector: "kubernetes.io/service-name=model",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpointSlices.Items) != 1 {
+		t.Fatalf("want only the endpoint slice of the pods but got %v", endpointSlices.Items)
+	}
+	endpoints := endpointSlices.Items[0].Endpoints
+	if len(endpoints) != 1 || endpoints[0].Hostname == nil || *endpoints[0].Hostname != "model-0" || !reflect.DeepEqual(endpoints[0].Addresses, []string{"10.1.0.1"}) {
+		t.Errorf("want the endpoint of the pod model-0 kept but got %v", endpoints)
+	}
+}
+
+func TestKubernetesConfigurerLoadBalancerService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newGatewayEndpointSlice := func(name string, address string) *discoveryv1.EndpointSlice {
+		return &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "beamlit", Name: name, Labels: map[string]string{
+				"kubernetes.io/service-name":             "gateway",
+				"endpointslice.kubernetes.io/managed-by": "endpointslice-controller.k8s.io",
+			}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{{
+				Addresses:  []string{address},
+				Conditions: discoveryv1.EndpointConditions{Ready: ptr(true)},
+				NodeName:   ptr("node-1"),
+			}},
+		}
+	}
+	objects := append(newTestObjects(), newGatewayEndpointSlice("gateway-uvwxy", "10.2.0.1"))
+	objects[0].(*corev1.Service).Spec.Type = corev1.ServiceTypeLoadBalancer
+	kubeClient := fake.NewClientset(objects...)
+	watchStarted := make(chan struct{})
+	var once sync.Once
+	kubeClient.PrependWatchReactor("endpointslices", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		if action.GetNamespace() == "beamlit" {
+			once.Do(func() { close(watchStarted) })
+		}
+		return false, nil, nil
+	})
+	configurer, _ := newKubernetesConfigurer(ctx, kubeClient)
+	if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	serviceRef := &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:      80,
+	}
+	if err := configurer.Configure(ctx, serviceRef); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-watchStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the gateway endpoint slices watched")
+	}
+
+	gatewayEndpoints := func() map[string][]string {
+		t.Helper()
+		endpointSlices, err := kubeClient.DiscoveryV1().EndpointSlices("default").List(ctx, metav1.ListOptions{
+			LabelSelector: "kubernetes.io/service-name=model",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints := make(map[string][]string)
+		for _, endpointSlice := range endpointSlices.Items {
+			if !isGatewayEndpointSlice("model", &endpointSlice) {
+				continue
+			}
+			if len(endpointSlice.Ports) != 1 || *endpointSlice.Ports[0].Name != "http" || *endpointSlice.Ports[0].Port != 8000 {
+				t.Errorf("want the offloaded port sent to the gateway but got %v", endpointSlice.Ports)
+			}
+			for _, endpoint := range endpointSlice.Endpoints {
+				endpoints[endpointSlice.Name] = append(endpoints[endpointSlice.Name], endpoint.Addresses...)
+			}
+		}
+		return endpoints
+	}
+	waitForGatewayEndpoints := func(t *testing.T, want map[string][]string) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			got := gatewayEndpoints()
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("want gateway endpoints %v but got %v", want, got)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	t.Run("When configured, must add the gateway endpoints to the service", func(t *testing.T) {
+		want := map[string][]string{"model-beamlit-gateway-uvwxy": {"10.2.0.1"}}
+		if got := gatewayEndpoints(); !reflect.DeepEqual(got, want) {
+			t.Errorf("want gateway endpoints %v but got %v", want, got)
+		}
+	})
+
+	t.Run("When a gateway endpoint slice is added, must add its endpoints to the service", func(t *testing.T) {
+		if _, err := kubeClient.DiscoveryV1().EndpointSlices("beamlit").Create(ctx, newGatewayEndpointSlice("gateway-zabcd", "10.2.0.2"), metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitForGatewayEndpoints(t, map[string][]string{
+			"model-beamlit-gateway-uvwxy": {"10.2.0.1"},
+			"model-beamlit-gateway-zabcd": {"10.2.0.2"},
+		})
+	})
+
+	t.Run("When the address of the load balancer changes, must configure the service again", func(t *testing.T) {
+		service, err := kubeClient.CoreV1().Services("default").Get(ctx, "model", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+		if _, err := kubeClient.CoreV1().Services("default").UpdateStatus(ctx, service, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case event := <-configurer.Events():
+			if event.Type != ServiceEventReconfigured || event.Err != nil {
+				t.Fatalf("want the service reconfigured but got %v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("want the service reconfigured")
+		}
+	})
+
+	t.Run("When the service is not a LoadBalancer anymore, must remove the gateway endpoints", func(t *testing.T) {
+		service, err := kubeClient.CoreV1().Services("default").Get(ctx, "model", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		service.Spec.Type = corev1.ServiceTypeClusterIP
+		if _, err := kubeClient.CoreV1().Services("default").Update(ctx, service, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case event := <-configurer.Events():
+			if event.Type != ServiceEventReconfigured || event.Err != nil {
+				t.Fatalf("want the service reconfigured but got %v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("want the service reconfigured")
+		}
+		if got := gatewayEndpoints(); len(got) != 0 {
+			t.Errorf("want no gateway endpoints but got %v", got)
+		}
+	})
+}
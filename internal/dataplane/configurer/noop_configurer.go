@@ -0,0 +1,53 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configurer
+
+import (
+	"context"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	"k8s.io/client-go/kubernetes"
+)
+
+// noopConfigurer leaves the user Services untouched, for the offloaders routing their traffic without the Beamlit gateway,
+// e.g. with the sidecars of a service mesh. The local backend of a model is its Service itself.
+type noopConfigurer struct{}
+
+func newNoopConfigurer(ctx context.Context, kubeClient kubernetes.Interface) (Configurer, error) {
+	return &noopConfigurer{}, nil
+}
+
+func (s *noopConfigurer) Start(ctx context.Context, gatewayService *modelv1alpha1.ServiceReference, owners ServiceOwners) error {
+	return nil
+}
+
+func (s *noopConfigurer) Configure(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
+	return nil
+}
+
+func (s *noopConfigurer) Unconfigure(ctx context.Context, service *modelv1alpha1.ServiceReference) error {
+	return nil
+}
+
+func (s *noopConfigurer) GetLocalBeamlitService(ctx context.Context, service *modelv1alpha1.ServiceReference) (*modelv1alpha1.ServiceReference, error) {
+	return service.DeepCopy(), nil
+}
+
+// Events returns nil, the services are not changed
+func (s *noopConfigurer) Events() <-chan ServiceEvent {
+	return nil
+}
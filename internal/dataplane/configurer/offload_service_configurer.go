@@ -0,0 +1,337 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configurer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	v1 "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ClientServiceStateAnnotation records on a client Service the selector it had before it was pointed at the gateway pods
+	ClientServiceStateAnnotation = "beamlit.com/client-service-state"
+	// OffloadedServiceAnnotation is set on the Services routing the clients of a model to the gateway, with the name of the model Service.
+	// The gateway routes the requests sent to their hostnames to the model.
+	OffloadedServiceAnnotation = "beamlit.com/offloaded-service"
+)
+
+// offloadServiceConfigurer proxies user Services without changing them nor their EndpointSlices.
+// It creates a <svc>-offload ExternalName Service, a DNS alias of the gateway Service the clients of the model target instead of the model Service.
+// The clients which can't be changed call the Service the user opts in with the ClientServiceName of the ServiceReference,
+// whose selector is removed and whose EndpointSlices are replaced by the endpoints of the gateway. The model Service itself is the local backend.
+type offloadServiceConfigurer struct {
+	gatewayServiceRef *modelv1alpha1.ServiceReference
+	kubeClient        kubernetes.Interface
+
+	// serviceLocks serializes the configuration of each model Service
+	serviceLocks keyedMutex
+	mu           sync.Mutex
+	mirrorings   map[types.NamespacedName]*endpointSlicesMirroring // keep the endpoints of the gateway of the client Services up to date
+}
+
+// clientServiceState is the spec of a client Service before it was pointed at the gateway pods
+type clientServiceState struct {
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+func newOffloadServiceConfigurer(ctx context.Context, kubeClient kubernetes.Interface) (Configurer, error) {
+	return &offloadServiceConfigurer{
+		kubeClient: kubeClient,
+		mirrorings: make(map[types.NamespacedName]*endpointSlicesMirroring),
+	}, nil
+}
+
+func (s *offloadServiceConfigurer) Start(ctx context.Context, gatewayService *modelv1alpha1.ServiceReference, owners ServiceOwners) error {
+	s.gatewayServiceRef = gatewayService
+	return nil
+}
+
+func (s *offloadServiceConfigurer) Configure(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
+	defer s.serviceLocks.lock(types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name})()
+	service, err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).Get(ctx, serviceRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if service.Spec.Type == corev1.ServiceTypeExternalName {
+		return &ErrUnsupportedService{
+			Service: types.NamespacedName{Namespace: service.Namespace, Name: service.Name},
+			Reason:  "ExternalName Services have no pods for the gateway to send the traffic kept local to",
+		}
+	}
+	gatewayService, err := s.kubeClient.CoreV1().Services(s.gatewayServiceRef.Namespace).Get(ctx, s.gatewayServiceRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if err := s.addPortToGatewayService(ctx, gatewayService, service, serviceRef); err != nil {
+		return err
+	}
+	if err := s.createOffloadService(ctx, service, gatewayService); err != nil {
+		return err
+	}
+	// The client Service of the model may have been changed
+	if err := s.restoreClientServices(ctx, serviceRef, serviceRef.ClientServiceName); err != nil {
+		return err
+	}
+	if serviceRef.ClientServiceName != "" {
+		return s.redirectClientService(ctx, serviceRef)
+	}
+	return nil
+}
+
+// addPortToGatewayService exposes the offloaded ports of the model Service on the gateway Service, the <svc>-offload Service resolving to it
+func (s *offloadServiceConfigurer) addPortToGatewayService(ctx context.Context, gatewayService *corev1.Service, service *corev1.Service, serviceRef *modelv1alpha1.ServiceReference) error {
+	updated := false
+	for _, offloadedPort := range serviceRef.Ports() {
+		protocol := corev1.ProtocolTCP
+		for _, port := range service.Spec.Ports {
+			if port.Port == offloadedPort {
+				protocol = port.Protocol
+				break
+			}
+		}
+		exposed := false
+		for _, port := range gatewayService.Spec.Ports {
+			if port.Port == offloadedPort && port.Protocol == protocol {
+				exposed = true
+				break
+			}
+		}
+		if exposed {
+			continue
+		}
+		gatewayService.Spec.Ports = append(gatewayService.Spec.Ports, corev1.ServicePort{
+			Name:       fmt.Sprintf("%d-beamlit", offloadedPort),
+			Port:       offloadedPort,
+			Protocol:   protocol,
+			TargetPort: intstr.FromInt(int(s.gatewayServiceRef.TargetPort)),
+		})
+		updated = true
+	}
+	if !updated {
+		return nil
+	}
+	result, err := s.kubeClient.CoreV1().Services(gatewayService.Namespace).Update(ctx, gatewayService, metav1.UpdateOptions{
+		FieldManager: OperatorLabel,
+	})
+	if err != nil {
+		return err
+	}
+	*gatewayService = *result
+	return nil
+}
+
+// createOffloadService creates the <svc>-offload ExternalName Service, resolving to the gateway Service
+func (s *offloadServiceConfigurer) createOffloadService(ctx context.Context, service *corev1.Service, gatewayService *corev1.Service) error {
+	ports := make([]*v1.ServicePortApplyConfiguration, 0, len(service.Spec.Ports))
+	for _, port := range service.Spec.Ports {
+		ports = append(ports, v1.ServicePort().
+			WithName(port.Name).
+			WithPort(port.Port).
+			WithProtocol(port.Protocol))
+	}
+	offloadService := v1.Service(offloadServiceName(service.Name), service.Namespace).
+		WithLabels(map[string]string{"app.kubernetes.io/managed-by": OperatorLabel}).
+		WithAnnotations(map[string]string{OffloadedServiceAnnotation: service.Name}).
+		WithSpec(v1.ServiceSpec().
+			WithType(corev1.ServiceTypeExternalName).
+			WithExternalName(fmt.Sprintf("%s.%s.svc.cluster.local", gatewayService.Name, gatewayService.Namespace)).
+			WithPorts(ports...))
+	_, err := s.kubeClient.CoreV1().Services(service.Namespace).Apply(ctx, offloadService, metav1.ApplyOptions{
+		FieldManager: OperatorLabel,
+		Force:        true,
+	})
+	return err
+}
+
+// redirectClientService points the client Service of a model at the gateway pods, wherever they run: its selector is recorded and removed,
+// and its EndpointSlices are replaced by the endpoints of the gateway, on the target port of the gateway
+func (s *offloadServiceConfigurer) redirectClientService(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
+	clientService, err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).Get(ctx, serviceRef.ClientServiceName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if clientService.Spec.Type == corev1.ServiceTypeExternalName {
+		return &ErrUnsupportedService{
+			Service: types.NamespacedName{Namespace: clientService.Namespace, Name: clientService.Name},
+			Reason:  "ExternalName Services have no endpoints to point at the gateway",
+		}
+	}
+	if owner, ok := clientService.Annotations[OffloadedServiceAnnotation]; ok && owner != serviceRef.Name {
+		return fmt.Errorf("service %s/%s already routes the clients of service %s to the gateway", clientService.Namespace, clientService.Name, owner)
+	}
+	if _, ok := clientService.Annotations[ClientServiceStateAnnotation]; !ok || clientService.Spec.Selector != nil {
+		if !ok {
+			data, err := json.Marshal(clientServiceState{Selector: clientService.Spec.Selector})
+			if err != nil {
+				return err
+			}
+			if clientService.Annotations == nil {
+				clientService.Annotations = map[string]string{}
+			}
+			clientService.Annotations[ClientServiceStateAnnotation] = string(data)
+			clientService.Annotations[OffloadedServiceAnnotation] = serviceRef.Name
+		}
+		// Without selector, the EndpointSlices of the client Service are no longer managed by Kubernetes
+		clientService.Spec.Selector = nil
+		log.FromContext(ctx).V(1).Info("Pointing the client service at the gateway", "Name", clientService.Name, "Service", serviceRef.Name)
+		clientService, err = s.kubeClient.CoreV1().Services(clientService.Namespace).Update(ctx, clientService, metav1.UpdateOptions{
+			FieldManager: OperatorLabel,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.deleteManagedEndpointSlices(ctx, clientService); err != nil {
+		return err
+	}
+	ports := make([]int32, 0, len(clientService.Spec.Ports))
+	for _, port := range clientService.Spec.Ports {
+		ports = append(ports, port.Port)
+	}
+	if err := applyGatewayEndpointSlices(ctx, s.kubeClient, s.gatewayServiceRef, clientService, ports); err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: clientService.Namespace, Name: clientService.Name}
+	// A client service redirected again is mirrored with its new ports
+	s.startMirroring(ctx, key, func(stopCh <-chan bool) error {
+		return mirrorGatewayEndpointSlices(ctx, s.kubeClient, s.gatewayServiceRef, clientService, ports, stopCh)
+	})
+	return nil
+}
+
+// deleteManagedEndpointSlices deletes the EndpointSlices Kubernetes managed for the selector of a client Service
+func (s *offloadServiceConfigurer) deleteManagedEndpointSlices(ctx context.Context, clientService *corev1.Service) error {
+	endpointSlices, err := s.kubeClient.DiscoveryV1().EndpointSlices(clientService.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "kubernetes.io/service-name=" + clientService.Name,
+	})
+	if err != nil {
+		return err
+	}
+	for _, endpointSlice := range endpointSlices.Items {
+		if endpointSlice.Labels["endpointslice.kubernetes.io/managed-by"] != "endpointslice-controller.k8s.io" {
+			continue
+		}
+		err := s.kubeClient.DiscoveryV1().EndpointSlices(clientService.Namespace).Delete(ctx, endpointSlice.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unconfigure deletes the <svc>-offload Service and restores the client Services pointed at the gateway
+func (s *offloadServiceConfigurer) Unconfigure(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference) error {
+	defer s.serviceLocks.lock(types.NamespacedName{Namespace: serviceRef.Namespace, Name: serviceRef.Name})()
+	err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).Delete(ctx, offloadServiceName(serviceRef.Name), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return s.restoreClientServices(ctx, serviceRef, "")
+}
+
+// restoreClientServices restores the client Services pointed at the gateway for a model Service, except the one named kept
+func (s *offloadServiceConfigurer) restoreClientServices(ctx context.Context, serviceRef *modelv1alpha1.ServiceReference, kept string) error {
+	services, err := s.kubeClient.CoreV1().Services(serviceRef.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, service := range services.Items {
+		if service.Annotations[OffloadedServiceAnnotation] != serviceRef.Name || service.Name == offloadServiceName(serviceRef.Name) || service.Name == kept {
+			continue
+		}
+		if err := s.restoreClientService(ctx, &service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreClientService restores the selector of a client Service before deleting the endpoints of the gateway,
+// for Kubernetes to add the endpoints of the pods it selects first
+func (s *offloadServiceConfigurer) restoreClientService(ctx context.Context, clientService *corev1.Service) error {
+	s.stopMirroring(types.NamespacedName{Namespace: clientService.Namespace, Name: clientService.Name})
+	if value, ok := clientService.Annotations[ClientServiceStateAnnotation]; ok {
+		state := clientServiceState{}
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			return fmt.Errorf("invalid %s annotation on service %s/%s: %w", ClientServiceStateAnnotation, clientService.Namespace, clientService.Name, err)
+		}
+		clientService.Spec.Selector = state.Selector
+	}
+	delete(clientService.Annotations, ClientServiceStateAnnotation)
+	delete(clientService.Annotations, OffloadedServiceAnnotation)
+	log.FromContext(ctx).V(1).Info("Restoring the client service", "Name", clientService.Name)
+	restored, err := s.kubeClient.CoreV1().Services(clientService.Namespace).Update(ctx, clientService, metav1.UpdateOptions{
+		FieldManager: OperatorLabel,
+	})
+	if err != nil {
+		return err
+	}
+	return applyGatewayEndpointSlices(ctx, s.kubeClient, s.gatewayServiceRef, restored, nil)
+}
+
+// startMirroring keeps the endpoints of the gateway of a client Service up to date in a goroutine, until stopMirroring is called
+// The previous mirroring of the client Service, if any, is replaced and stopped.
+func (s *offloadServiceConfigurer) startMirroring(ctx context.Context, key types.NamespacedName, mirror func(stopCh <-chan bool) error) {
+	mirroring := &endpointSlicesMirroring{stopCh: make(chan bool)}
+	mirroring.wg.Add(1)
+	go func() {
+		defer mirroring.wg.Done()
+		if err := mirror(mirroring.stopCh); err != nil {
+			log.FromContext(ctx).Error(err, "error while mirroring endpoints slices")
+		}
+	}()
+	s.mu.Lock()
+	previous := s.mirrorings[key]
+	s.mirrorings[key] = mirroring
+	s.mu.Unlock()
+	previous.stop()
+}
+
+// stopMirroring stops the goroutine keeping the endpoints of the gateway of a client Service up to date and waits for it to return
+func (s *offloadServiceConfigurer) stopMirroring(key types.NamespacedName) {
+	s.mu.Lock()
+	mirroring := s.mirrorings[key]
+	delete(s.mirrorings, key)
+	s.mu.Unlock()
+	mirroring.stop()
+}
+
+// GetLocalBeamlitService returns the model Service itself, which is left untouched
+func (s *offloadServiceConfigurer) GetLocalBeamlitService(ctx context.Context, service *modelv1alpha1.ServiceReference) (*modelv1alpha1.ServiceReference, error) {
+	return service.DeepCopy(), nil
+}
+
+func offloadServiceName(serviceName string) string {
+	return fmt.Sprintf("%s-offload", serviceName)
+}
+
+// Events returns nil, the model Services are not changed
+func (s *offloadServiceConfigurer) Events() <-chan ServiceEvent {
+	return nil
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configurer
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+func TestOffloadServiceConfigurer(t *testing.T) {
+	type testCase struct {
+		gatewayNamespace string
+		clientService    string
+		wantErr          bool
+	}
+	tcs := map[string]testCase{
+		"When no client service is opted in, must only create the offload service": {
+			gatewayNamespace: "beamlit",
+		},
+		"When a client service is opted in, must point it at the gateway endpoints": {
+			gatewayNamespace: "default",
+			clientService:    "model-public",
+		},
+		"When a client service is opted in and the gateway runs in another namespace, must point it at the gateway endpoints": {
+			gatewayNamespace: "beamlit",
+			clientService:    "model-public",
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			modelService := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: "10.0.0.1",
+					Selector:  map[string]string{"app": "model"},
+					Ports:     []corev1.ServicePort{{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+				},
+			}
+			clientService := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-public"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: "10.0.0.2",
+					Selector:  map[string]string{"app": "model"},
+					Ports:     []corev1.ServicePort{{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+				},
+			}
+			gatewayService := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: tc.gatewayNamespace, Name: "gateway"},
+				Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "gateway"}},
+			}
+			clientEndpointSlice := &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-public-abcde", Labels: map[string]string{
+					"kubernetes.io/service-name":             "model-public",
+					"endpointslice.kubernetes.io/managed-by": "endpointslice-controller.k8s.io",
+				}},
+				AddressType: discoveryv1.AddressTypeIPv4,
+				Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.1.0.1"}}},
+			}
+			gatewayEndpointSlice := &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{Namespace: tc.gatewayNamespace, Name: "gateway-fghij", Labels: map[string]string{
+					"kubernetes.io/service-name": "gateway",
+				}},
+				AddressType: discoveryv1.AddressTypeIPv4,
+				Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.2.0.1"}}},
+			}
+			kubeClient := fake.NewClientset(modelService.DeepCopy(), clientService.DeepCopy(), gatewayService, clientEndpointSlice, gatewayEndpointSlice)
+			serviceRef := &modelv1alpha1.ServiceReference{
+				ObjectReference:   corev1.ObjectReference{Namespace: "default", Name: "model"},
+				TargetPort:        80,
+				ClientServiceName: tc.clientService,
+			}
+			configurer, err := newOffloadServiceConfigurer(ctx, kubeClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: tc.gatewayNamespace, Name: "gateway"},
+				TargetPort:      8000,
+			}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			err = configurer.Configure(ctx, serviceRef)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("wantErr is %v but err is %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			offloadService, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-offload", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "gateway." + tc.gatewayNamespace + ".svc.cluster.local"; offloadService.Spec.Type != corev1.ServiceTypeExternalName || offloadService.Spec.ExternalName != want {
+				t.Errorf("want offload service aliasing %s but got %s %s", want, offloadService.Spec.Type, offloadService.Spec.ExternalName)
+			}
+			gateway, err := kubeClient.CoreV1().Services(tc.gatewayNamespace).Get(ctx, "gateway", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gateway.Spec.Ports) != 1 || gateway.Spec.Ports[0].Port != 80 || gateway.Spec.Ports[0].TargetPort.IntVal != 8000 {
+				t.Errorf("want port 80 to 8000 on the gateway service but got %v", gateway.Spec.Ports)
+			}
+			model, err := kubeClient.CoreV1().Services("default").Get(ctx, "model", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(model.Spec, modelService.Spec) || !reflect.DeepEqual(model.Annotations, modelService.Annotations) {
+				t.Errorf("want the model service untouched but got %v", model)
+			}
+			local, err := configurer.GetLocalBeamlitService(ctx, serviceRef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if local.Name != "model" {
+				t.Errorf("want the model service as local backend but got %s", local.Name)
+			}
+			client, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-public", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientEndpointSlices, err := kubeClient.DiscoveryV1().EndpointSlices("default").List(ctx, metav1.ListOptions{
+				LabelSelector: "kubernetes.io/service-name=model-public",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.clientService != "" {
+				if client.Spec.Selector != nil || client.Annotations[OffloadedServiceAnnotation] != "model" {
+					t.Errorf("want the client service without selector and annotated as offloaded but got %v %v", client.Annotations, client.Spec)
+				}
+				if len(clientEndpointSlices.Items) != 1 {
+					t.Fatalf("want only the gateway endpoints slice of the client service but got %v", clientEndpointSlices.Items)
+				}
+				endpointSlice := clientEndpointSlices.Items[0]
+				if endpointSlice.Name != "model-public-beamlit-gateway-fghij" || endpointSlice.Endpoints[0].Addresses[0] != "10.2.0.1" ||
+					len(endpointSlice.Ports) != 1 || *endpointSlice.Ports[0].Name != "http" || *endpointSlice.Ports[0].Port != 8000 {
+					t.Errorf("want the gateway endpoints on port http 8000 but got %v", endpointSlice)
+				}
+			} else if !reflect.DeepEqual(client.Spec, clientService.Spec) || len(clientEndpointSlices.Items) != 1 || clientEndpointSlices.Items[0].Name != "model-public-abcde" {
+				t.Errorf("want the client service untouched but got %v %v", client.Spec, clientEndpointSlices.Items)
+			}
+
+			if err := configurer.Unconfigure(ctx, serviceRef); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := kubeClient.CoreV1().Services("default").Get(ctx, "model-offload", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("want offload service deleted but got %v", err)
+			}
+			client, err = kubeClient.CoreV1().Services("default").Get(ctx, "model-public", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(client.Spec, clientService.Spec) || len(client.Annotations) != 0 {
+				t.Errorf("want the client service restored but got %v %v", client.Annotations, client.Spec)
+			}
+			clientEndpointSlices, err = kubeClient.DiscoveryV1().EndpointSlices("default").List(ctx, metav1.ListOptions{
+				LabelSelector: "kubernetes.io/service-name=model-public",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, endpointSlice := range clientEndpointSlices.Items {
+				if isGatewayEndpointSlice("model-public", &endpointSlice) {
+					t.Errorf("want the gateway endpoints slices of the client service deleted but got %s", endpointSlice.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestOffloadServiceConfigurerConcurrentConfigure(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: "10.0.0.1",
+				Selector:  map[string]string{"app": "model"},
+				Ports:     []corev1.ServicePort{{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model-public"},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: "10.0.0.2",
+				Selector:  map[string]string{"app": "model"},
+				Ports:     []corev1.ServicePort{{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "beamlit", Name: "gateway"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "gateway"}},
+		},
+	)
+	var openWatches atomic.Int32
+	kubeClient.PrependWatchReactor("endpointslices", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := kubeClient.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		openWatches.Add(1)
+		return true, &countedWatch{Interface: w, open: &openWatches}, nil
+	})
+	// Slow writes let the configurations overlap
+	kubeClient.PrependReactor("*", "endpointslices", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false, nil, nil
+	})
+	serviceRef := &modelv1alpha1.ServiceReference{
+		ObjectReference:   corev1.ObjectReference{Namespace: "default", Name: "model"},
+		TargetPort:        80,
+		ClientServiceName: "model-public",
+	}
+	current, _ := newOffloadServiceConfigurer(ctx, kubeClient)
+	configurer := current.(*offloadServiceConfigurer)
+	if err := configurer.Start(ctx, &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "beamlit", Name: "gateway"},
+		TargetPort:      8000,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = configurer.Configure(ctx, serviceRef)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	configurer.mu.Lock()
+	mirrorings := len(configurer.mirrorings)
+	configurer.mu.Unlock()
+	if mirrorings != 1 {
+		t.Errorf("want a single mirroring but got %d", mirrorings)
+	}
+
+	if err := configurer.Unconfigure(ctx, serviceRef); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for openWatches.Load() != 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("want every mirroring stopped once unconfigured but %d endpoint slices watches are open", openWatches.Load())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestOffloadServiceConfigurerReplacesMirroring(t *testing.T) {
+	ctx := context.Background()
+	current, _ := newOffloadServiceConfigurer(ctx, fake.NewClientset())
+	configurer := current.(*offloadServiceConfigurer)
+	key := types.NamespacedName{Namespace: "default", Name: "model-public"}
+	stopped := make(chan struct{})
+	configurer.startMirroring(ctx, key, func(stopCh <-chan bool) error {
+		<-stopCh
+		close(stopped)
+		return nil
+	})
+	configurer.startMirroring(ctx, key, func(stopCh <-chan bool) error {
+		<-stopCh
+		return nil
+	})
+	select {
+	case <-stopped:
+	default:
+		t.Error("want the previous mirroring stopped once replaced")
+	}
+	configurer.stopMirroring(key)
+}
@@ -3,25 +3,47 @@ package offloader
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
 	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
 	proxyv1alpha1 "github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
 	beamlitclientset "github.com/beamlit/beamlit-controller/gateway/clientset"
+	"github.com/beamlit/beamlit-controller/internal/dataplane/configurer"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type beamlitGatewayOffloader struct {
-	managedRoutes    sync.Map // key: model name, value: bool
+	managedRoutes    sync.Map // key: model name, value: []string route names
+	routeRules       sync.Map // key: model name, value: []proxyv1alpha1.Rule
 	kubeClient       kubernetes.Interface
-	managementClient *beamlitclientset.ClientSet
-	workspace        string // TODO: remove this
+	managementClient beamlitclientset.V1Alpha1Client
 }
 
-func newBeamlitGatewayOffloader(ctx context.Context, kubeClient kubernetes.Interface, managementClient *beamlitclientset.ClientSet) (Offloader, error) {
-	return &beamlitGatewayOffloader{kubeClient: kubeClient, managementClient: managementClient, managedRoutes: sync.Map{}}, nil
+func newBeamlitGatewayOffloader(ctx context.Context, kubeClient kubernetes.Interface, options Options) (Offloader, error) {
+	if options.ProxyClient == nil {
+		return nil, fmt.Errorf("the %s and %s offloaders require a proxy client", BeamlitGatewayOffloaderType, EnvoyXDSOffloaderType)
+	}
+	return &beamlitGatewayOffloader{kubeClient: kubeClient, managementClient: options.ProxyClient, managedRoutes: sync.Map{}}, nil
+}
+
+// envoyXDSOffloader registers the routes of the models like the Beamlit gateway offloader, in the xDS server used as proxy client.
+// The gateway rules aren't translated to Envoy resources, so it isn't a PolicyEnforcer.
+type envoyXDSOffloader struct {
+	Offloader
+}
+
+func newEnvoyXDSOffloader(ctx context.Context, kubeClient kubernetes.Interface, options Options) (Offloader, error) {
+	offloader, err := newBeamlitGatewayOffloader(ctx, kubeClient, options)
+	if err != nil {
+		return nil, err
+	}
+	return &envoyXDSOffloader{Offloader: offloader}, nil
 }
 
 func (o *beamlitGatewayOffloader) Configure(ctx context.Context, model *modelv1alpha1.ModelDeployment, localBackend *modelv1alpha1.ServiceReference, remoteBackend *modelv1alpha1.RemoteBackend, remoteBackendWeight int) error {
@@ -29,21 +51,71 @@ func (o *beamlitGatewayOffloader) Configure(ctx context.Context, model *modelv1a
 	if err != nil {
 		return err
 	}
-	if o.workspace == "" && model.Status.Workspace != "" {
-		o.workspace = model.Status.Workspace
+	// The clients may reach the gateway through the Services created or swapped by the configurer instead of the model Service
+	services, err := o.kubeClient.CoreV1().Services(service.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	hostnames := serviceHostnames(service)
+	for _, offloadedService := range services.Items {
+		if offloadedService.Annotations[configurer.OffloadedServiceAnnotation] == service.Name {
+			hostnames = append(hostnames, serviceHostnames(&offloadedService)...)
+		}
+	}
+	var previousRouteNames []string
+	if previous, ok := o.managedRoutes.Load(model.Name); ok {
+		previousRouteNames = previous.([]string)
+	}
+	var routeNames []string
+	for i, port := range localBackend.Ports() {
+		route := newBeamlitGatewayRoute(model, localBackend, port, remoteBackend, remoteBackendWeight)
+		// The primary port is also routed for the hostnames without port, the clients omitting the default port of their scheme
+		if i == 0 {
+			route.Hostnames = append(route.Hostnames, hostnames...)
+		}
+		for _, hostname := range hostnames {
+			route.Hostnames = append(route.Hostnames, fmt.Sprintf("%s:%d", hostname, port))
+		}
+		if rules, ok := o.routeRules.Load(model.Name); ok {
+			route.Rules = rules.([]proxyv1alpha1.Rule)
+		}
+		if slices.Contains(previousRouteNames, route.Name) {
+			_, err = o.managementClient.UpdateRoute(ctx, route)
+		} else {
+			_, err = o.managementClient.RegisterRoute(ctx, route)
+		}
+		if err != nil {
+			// Keep track of the routes registered so far, to update or delete them later
+			o.managedRoutes.Store(model.Name, slices.Concat(previousRouteNames, routeNames))
+			return err
+		}
+		routeNames = append(routeNames, route.Name)
+	}
+	// The routes of the ports which aren't offloaded anymore are deleted
+	for _, name := range previousRouteNames {
+		if slices.Contains(routeNames, name) {
+			continue
+		}
+		if _, err := o.managementClient.DeleteRoute(ctx, name); err != nil {
+			return err
+		}
+	}
+	o.managedRoutes.Store(model.Name, routeNames)
+	return nil
+}
+
+// newBeamlitGatewayRoute returns the route of a port of a model, without hostnames. The primary port is routed by the route named
+// after the model, the additional ports by routes suffixed with their port.
+func newBeamlitGatewayRoute(model *modelv1alpha1.ModelDeployment, localBackend *modelv1alpha1.ServiceReference, port int32, remoteBackend *modelv1alpha1.RemoteBackend, remoteBackendWeight int) proxyv1alpha1.Route {
+	name := model.Name
+	if port != localBackend.TargetPort {
+		name = fmt.Sprintf("%s-%d", model.Name, port)
 	}
 	route := proxyv1alpha1.Route{
-		Name: model.Name,
-		Hostnames: []string{
-			service.Spec.ClusterIP,
-			service.Name,
-			fmt.Sprintf("%s.%s", service.Name, service.Namespace),
-			fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace),
-			fmt.Sprintf("%s.%s.svc.cluster.local", service.Name, service.Namespace),
-		},
+		Name: name,
 		Backends: []proxyv1alpha1.Backend{
 			{
-				Host:   fmt.Sprintf("%s.%s.svc.cluster.local:%d", localBackend.Name, localBackend.Namespace, localBackend.TargetPort),
+				Host:   fmt.Sprintf("%s.%s.svc.cluster.local:%d", localBackend.Name, localBackend.Namespace, port),
 				Weight: 100 - remoteBackendWeight,
 				Scheme: "http", // TODO: support HTTPS
 			},
@@ -52,7 +124,7 @@ func (o *beamlitGatewayOffloader) Configure(ctx context.Context, model *modelv1a
 				Weight:       remoteBackendWeight,
 				Scheme:       string(remoteBackend.Scheme),
 				HeadersToAdd: remoteBackend.HeadersToAdd,
-				PathPrefix:   o.variableReplace(remoteBackend.PathPrefix, model),
+				PathPrefix:   variableReplace(remoteBackend.PathPrefix, model),
 			},
 		},
 	}
@@ -72,29 +144,84 @@ func (o *beamlitGatewayOffloader) Configure(ctx context.Context, model *modelv1a
 			}
 		}
 	}
-	if _, ok := o.managedRoutes.Load(model.Name); ok {
-		_, err = o.managementClient.UpdateRoute(ctx, route)
-	} else {
-		_, err = o.managementClient.RegisterRoute(ctx, route)
+	return route
+}
+
+// serviceHostnames returns the hostnames the clients of a Service may send requests to, the clients out of the cluster may use the address
+// of a LoadBalancer Service. The DNS names of the pods of a headless Service aren't routed, the gateway can't send them to the matching pod.
+func serviceHostnames(service *corev1.Service) []string {
+	var hostnames []string
+	clusterIPs := service.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && service.Spec.ClusterIP != "" {
+		clusterIPs = []string{service.Spec.ClusterIP}
 	}
-	if err != nil {
-		return err
+	for _, clusterIP := range clusterIPs {
+		if clusterIP == corev1.ClusterIPNone {
+			continue
+		}
+		hostnames = append(hostnames, hostAddress(clusterIP))
 	}
-	o.managedRoutes.Store(model.Name, true)
-	return nil
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				hostnames = append(hostnames, hostAddress(ingress.IP))
+			}
+			if ingress.Hostname != "" {
+				hostnames = append(hostnames, ingress.Hostname)
+			}
+		}
+	}
+	names := []string{
+		service.Name,
+		fmt.Sprintf("%s.%s", service.Name, service.Namespace),
+		fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service.Name, service.Namespace),
+	}
+	return append(hostnames, names...)
+}
+
+// hostAddress returns an IP address as in the host header, within brackets for IPv6
+func hostAddress(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "[" + ip + "]"
+	}
+	return ip
 }
 
 func (o *beamlitGatewayOffloader) Cleanup(ctx context.Context, model *modelv1alpha1.ModelDeployment) error {
-	if _, ok := o.managedRoutes.Load(model.Name); !ok {
+	routeNames, ok := o.managedRoutes.Load(model.Name)
+	if !ok {
 		return nil
 	}
-	_, err := o.managementClient.DeleteRoute(ctx, model.Name)
+	for _, name := range routeNames.([]string) {
+		if _, err := o.managementClient.DeleteRoute(ctx, name); err != nil {
+			return err
+		}
+	}
 	o.managedRoutes.Delete(model.Name)
-	return err
+	return nil
 }
 
-func (o *beamlitGatewayOffloader) variableReplace(pathPrefix string, model *modelv1alpha1.ModelDeployment) string {
-	pathPrefix = strings.ReplaceAll(pathPrefix, "$workspace", o.workspace)
-	pathPrefix = strings.ReplaceAll(pathPrefix, "$model", model.Spec.Model)
-	return pathPrefix
+func (o *beamlitGatewayOffloader) SetGatewayPolicies(model *modelv1alpha1.ModelDeployment, policies []authorizationv1alpha1.PolicyObject) {
+	rules := make([]proxyv1alpha1.Rule, 0, len(policies))
+	for _, policy := range policies {
+		gatewayPolicy := policy.GetPolicySpec().Gateway
+		if gatewayPolicy == nil {
+			continue
+		}
+		rule := proxyv1alpha1.Rule{
+			Name:            client.ObjectKeyFromObject(policy).String(),
+			AllowedCIDRs:    gatewayPolicy.AllowedCIDRs,
+			RequiredHeaders: gatewayPolicy.RequiredHeaders,
+		}
+		if rateLimit := gatewayPolicy.RateLimit; rateLimit != nil {
+			rule.RateLimit = &proxyv1alpha1.RateLimit{
+				RequestsPerSecond: int(rateLimit.RequestsPerSecond),
+				Burst:             int(rateLimit.Burst),
+				ConsumerHeader:    rateLimit.ConsumerHeader,
+			}
+		}
+		rules = append(rules, rule)
+	}
+	o.routeRules.Store(model.Name, rules)
 }
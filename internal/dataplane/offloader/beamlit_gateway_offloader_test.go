@@ -0,0 +1,79 @@
+package offloader
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	beamlitclientset "github.com/beamlit/beamlit-controller/gateway/clientset"
+)
+
+func TestServiceHostnames(t *testing.T) {
+	names := []string{"model", "model.default", "model.default.svc", "model.default.svc.cluster.local"}
+	type testCase struct {
+		spec   corev1.ServiceSpec
+		status corev1.ServiceStatus
+		want   []string
+	}
+	tcs := map[string]testCase{
+		"When the service is dual stack, must return its cluster IPs and names": {
+			spec: corev1.ServiceSpec{ClusterIPs: []string{"10.0.0.1", "fd00::1"}},
+			want: append([]string{"10.0.0.1", "[fd00::1]"}, names...),
+		},
+		"When the service is headless, must return its names without the names of its pods": {
+			spec: corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, ClusterIPs: []string{corev1.ClusterIPNone}},
+			want: names,
+		},
+		"When the service is a load balancer, must return the addresses of the load balancer": {
+			spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ClusterIPs: []string{"10.0.0.1"}},
+			status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+				{IP: "203.0.113.10"},
+				{Hostname: "model.example.com"},
+			}}},
+			want: append([]string{"10.0.0.1", "203.0.113.10", "model.example.com"}, names...),
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "model"},
+				Spec:       tc.spec,
+				Status:     tc.status,
+			}
+			if got := serviceHostnames(service); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want hostnames %v but got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPolicyEnforcers(t *testing.T) {
+	type testCase struct {
+		offloaderType OffloaderType
+		wantEnforcer  bool
+	}
+	tcs := map[string]testCase{
+		"When the offloader is the Beamlit gateway, must enforce the gateway policies": {
+			offloaderType: BeamlitGatewayOffloaderType,
+			wantEnforcer:  true,
+		},
+		"When the offloader is envoy-xds, must not enforce the gateway policies": {
+			offloaderType: EnvoyXDSOffloaderType,
+			wantEnforcer:  false,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			offloader, err := NewOffloader(context.Background(), tc.offloaderType, nil, Options{ProxyClient: &beamlitclientset.ClientSet{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := offloader.(PolicyEnforcer); ok != tc.wantEnforcer {
+				t.Errorf("want policy enforcer %t but got %t", tc.wantEnforcer, ok)
+			}
+		})
+	}
+}
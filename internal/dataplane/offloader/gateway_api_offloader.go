@@ -0,0 +1,280 @@
+package offloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	gatewayv1apply "sigs.k8s.io/gateway-api/apis/applyconfiguration/apis/v1"
+	gatewayv1alpha2apply "sigs.k8s.io/gateway-api/apis/applyconfiguration/apis/v1alpha2"
+	gatewayv1alpha3apply "sigs.k8s.io/gateway-api/apis/applyconfiguration/apis/v1alpha3"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha3 "sigs.k8s.io/gateway-api/apis/v1alpha3"
+	gatewayclientset "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+)
+
+// GatewayAPIOptions are the settings of the gateway-api offloader.
+type GatewayAPIOptions struct {
+	// ParentRefs are the Gateways the HTTPRoutes of the models are attached to.
+	// If empty, the HTTPRoutes are attached to the Services of the models, for the service meshes implementing the GAMMA initiative.
+	ParentRefs []gatewayv1.ParentReference
+	// RemoteServiceImport is the ServiceImport exposing Beamlit in the namespace of the models, used as remote backend.
+	// If nil, an ExternalName Service is created for the remote backend of each model.
+	RemoteServiceImport *ServiceImportReference
+}
+
+// ServiceImportReference is a reference to a port of a ServiceImport of the Multi-Cluster Services API.
+type ServiceImportReference struct {
+	// Name is the name of the ServiceImport, in the namespace of the Service of each model.
+	Name string
+	// Port is the port of the ServiceImport.
+	Port int32
+}
+
+// gatewayAPIOffloader offloads models with an HTTPRoute splitting the traffic of their Service between the local and the remote backends,
+// programmed by the Gateway API implementation running in the cluster (Envoy Gateway, Istio, Cilium...) instead of the Beamlit gateway.
+type gatewayAPIOffloader struct {
+	kubeClient    kubernetes.Interface
+	gatewayClient gatewayclientset.Interface
+	options       GatewayAPIOptions
+}
+
+func newGatewayAPIOffloader(ctx context.Context, kubeClient kubernetes.Interface, options Options) (Offloader, error) {
+	if options.GatewayClient == nil {
+		return nil, fmt.Errorf("the %s offloader requires a Gateway API client", GatewayAPIOffloaderType)
+	}
+	return &gatewayAPIOffloader{kubeClient: kubeClient, gatewayClient: options.GatewayClient, options: options.GatewayAPI}, nil
+}
+
+func (o *gatewayAPIOffloader) Configure(ctx context.Context, model *modelv1alpha1.ModelDeployment, localBackend *modelv1alpha1.ServiceReference, remoteBackend *modelv1alpha1.RemoteBackend, remoteBackendWeight int) error {
+	if remoteBackend.AuthConfig != nil {
+		return &ErrUnsupportedAuthConfig{AuthType: remoteBackend.AuthConfig.Type, Offloader: GatewayAPIOffloaderType}
+	}
+	if len(localBackend.AdditionalTargetPorts) > 0 {
+		// The HTTPRoute attaches to a single port of the model Service
+		return fmt.Errorf("additional target ports are not supported by the %s offloader", GatewayAPIOffloaderType)
+	}
+	namespace := model.Spec.ServiceRef.Namespace
+	remoteBackendRef, err := o.applyRemoteBackend(ctx, model, remoteBackend)
+	if err != nil {
+		return err
+	}
+
+	localBackendRef := gatewayv1apply.HTTPBackendRef().
+		WithName(gatewayv1.ObjectName(localBackend.Name)).
+		WithPort(gatewayv1.PortNumber(localBackend.TargetPort)).
+		WithWeight(int32(100 - remoteBackendWeight))
+	if localBackend.Namespace != "" && localBackend.Namespace != namespace {
+		localBackendRef.WithNamespace(gatewayv1.Namespace(localBackend.Namespace))
+	}
+
+	host, _, err := splitRemoteHost(remoteBackend)
+	if err != nil {
+		return err
+	}
+	urlRewrite := gatewayv1apply.HTTPURLRewriteFilter().WithHostname(gatewayv1.PreciseHostname(host))
+	if pathPrefix := variableReplace(remoteBackend.PathPrefix, model); pathPrefix != "" {
+		urlRewrite.WithPath(gatewayv1apply.HTTPPathModifier().
+			WithType(gatewayv1.PrefixMatchHTTPPathModifier).
+			WithReplacePrefixMatch(pathPrefix))
+	}
+	remoteBackendRef.
+		WithWeight(int32(remoteBackendWeight)).
+		WithFilters(gatewayv1apply.HTTPRouteFilter().
+			WithType(gatewayv1.HTTPRouteFilterURLRewrite).
+			WithURLRewrite(urlRewrite))
+	if len(remoteBackend.HeadersToAdd) > 0 {
+		headers := make([]*gatewayv1apply.HTTPHeaderApplyConfiguration, 0, len(remoteBackend.HeadersToAdd))
+		for _, name := range sortedKeys(remoteBackend.HeadersToAdd) {
+			headers = append(headers, gatewayv1apply.HTTPHeader().
+				WithName(gatewayv1.HTTPHeaderName(name)).
+				WithValue(remoteBackend.HeadersToAdd[name]))
+		}
+		remoteBackendRef.WithFilters(gatewayv1apply.HTTPRouteFilter().
+			WithType(gatewayv1.HTTPRouteFilterRequestHeaderModifier).
+			WithRequestHeaderModifier(gatewayv1apply.HTTPHeaderFilter().WithSet(headers...)))
+	}
+
+	spec := gatewayv1apply.HTTPRouteSpec().
+		WithRules(gatewayv1apply.HTTPRouteRule().
+			WithMatches(gatewayv1apply.HTTPRouteMatch().
+				WithPath(gatewayv1apply.HTTPPathMatch().
+					WithType(gatewayv1.PathMatchPathPrefix).
+					WithValue("/"))).
+			WithBackendRefs(localBackendRef, remoteBackendRef))
+	if len(o.options.ParentRefs) == 0 {
+		spec.WithParentRefs(gatewayv1apply.ParentReference().
+			WithGroup("").
+			WithKind("Service").
+			WithName(gatewayv1.ObjectName(model.Spec.ServiceRef.Name)).
+			WithPort(gatewayv1.PortNumber(model.Spec.ServiceRef.TargetPort)))
+	} else {
+		for _, parentRef := range o.options.ParentRefs {
+			spec.WithParentRefs(parentReference(parentRef))
+		}
+		serviceName := model.Spec.ServiceRef.Name
+		spec.WithHostnames(
+			gatewayv1.Hostname(serviceName),
+			gatewayv1.Hostname(fmt.Sprintf("%s.%s", serviceName, namespace)),
+			gatewayv1.Hostname(fmt.Sprintf("%s.%s.svc", serviceName, namespace)),
+			gatewayv1.Hostname(fmt.Sprintf("%s.%s.svc.cluster.local", serviceName, namespace)),
+		)
+	}
+
+	route := gatewayv1apply.HTTPRoute(httpRouteName(model), namespace).
+		WithLabels(map[string]string{"app.kubernetes.io/managed-by": fieldManager}).
+		WithAnnotations(map[string]string{ModelDeploymentAnnotation: types.NamespacedName{Namespace: model.Namespace, Name: model.Name}.String()}).
+		WithSpec(spec)
+	_, err = o.gatewayClient.GatewayV1().HTTPRoutes(namespace).Apply(ctx, route, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	return err
+}
+
+// applyRemoteBackend creates the ExternalName Service resolving to the remote backend, and the BackendTLSPolicy originating TLS to it,
+// unless a ServiceImport is configured. It returns the reference to the remote backend for the HTTPRoute.
+func (o *gatewayAPIOffloader) applyRemoteBackend(ctx context.Context, model *modelv1alpha1.ModelDeployment, remoteBackend *modelv1alpha1.RemoteBackend) (*gatewayv1apply.HTTPBackendRefApplyConfiguration, error) {
+	if serviceImport := o.options.RemoteServiceImport; serviceImport != nil {
+		return gatewayv1apply.HTTPBackendRef().
+			WithGroup("multicluster.x-k8s.io").
+			WithKind("ServiceImport").
+			WithName(gatewayv1.ObjectName(serviceImport.Name)).
+			WithPort(gatewayv1.PortNumber(serviceImport.Port)), nil
+	}
+
+	namespace := model.Spec.ServiceRef.Namespace
+	name := remoteServiceName(model)
+	host, port, err := splitRemoteHost(remoteBackend)
+	if err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{ModelDeploymentAnnotation: types.NamespacedName{Namespace: model.Namespace, Name: model.Name}.String()}
+	service := corev1apply.Service(name, namespace).
+		WithLabels(map[string]string{"app.kubernetes.io/managed-by": fieldManager}).
+		WithAnnotations(annotations).
+		WithSpec(corev1apply.ServiceSpec().
+			WithType("ExternalName").
+			WithExternalName(host).
+			WithPorts(corev1apply.ServicePort().
+				WithName(string(remoteBackend.Scheme)).
+				WithPort(port).
+				WithAppProtocol(string(remoteBackend.Scheme))))
+	if _, err := o.kubeClient.CoreV1().Services(namespace).Apply(ctx, service, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	}); err != nil {
+		return nil, err
+	}
+
+	if remoteBackend.Scheme == modelv1alpha1.SupportedSchemeHTTPS {
+		policy := gatewayv1alpha3apply.BackendTLSPolicy(name, namespace).
+			WithLabels(map[string]string{"app.kubernetes.io/managed-by": fieldManager}).
+			WithAnnotations(annotations).
+			WithSpec(gatewayv1alpha3apply.BackendTLSPolicySpec().
+				WithTargetRefs(gatewayv1alpha2apply.LocalPolicyTargetReferenceWithSectionName().
+					WithGroup("").
+					WithKind("Service").
+					WithName(gatewayv1.ObjectName(name))).
+				WithValidation(gatewayv1alpha3apply.BackendTLSPolicyValidation().
+					WithHostname(gatewayv1.PreciseHostname(host)).
+					WithWellKnownCACertificates(gatewayv1alpha3.WellKnownCACertificatesSystem)))
+		_, err := o.gatewayClient.GatewayV1alpha3().BackendTLSPolicies(namespace).Apply(ctx, policy, metav1.ApplyOptions{
+			FieldManager: fieldManager,
+			Force:        true,
+		})
+		if apierrors.IsNotFound(err) {
+			// BackendTLSPolicy is only part of the experimental channel of the Gateway API
+			log.FromContext(ctx).V(0).Info("BackendTLSPolicy is not installed, TLS to the remote backend must be originated by the gateway configuration", "Name", model.Name)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return gatewayv1apply.HTTPBackendRef().
+		WithName(gatewayv1.ObjectName(name)).
+		WithPort(gatewayv1.PortNumber(port)), nil
+}
+
+func (o *gatewayAPIOffloader) Cleanup(ctx context.Context, model *modelv1alpha1.ModelDeployment) error {
+	namespace := model.Spec.ServiceRef.Namespace
+	err := o.gatewayClient.GatewayV1().HTTPRoutes(namespace).Delete(ctx, httpRouteName(model), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if o.options.RemoteServiceImport != nil {
+		return nil
+	}
+	err = o.gatewayClient.GatewayV1alpha3().BackendTLSPolicies(namespace).Delete(ctx, remoteServiceName(model), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err = o.kubeClient.CoreV1().Services(namespace).Delete(ctx, remoteServiceName(model), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func httpRouteName(model *modelv1alpha1.ModelDeployment) string {
+	return fmt.Sprintf("%s-beamlit", model.Name)
+}
+
+func remoteServiceName(model *modelv1alpha1.ModelDeployment) string {
+	return fmt.Sprintf("%s-beamlit-remote", model.Name)
+}
+
+// splitRemoteHost returns the host and the port of the remote backend, the port defaulting to the one of its scheme
+func splitRemoteHost(remoteBackend *modelv1alpha1.RemoteBackend) (string, int32, error) {
+	host, portValue, err := net.SplitHostPort(remoteBackend.Host)
+	if err != nil {
+		// no port in the host
+		if remoteBackend.Scheme == modelv1alpha1.SupportedSchemeHTTPS {
+			return remoteBackend.Host, 443, nil
+		}
+		return remoteBackend.Host, 80, nil
+	}
+	port, err := strconv.ParseInt(portValue, 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in remote backend host %s: %w", remoteBackend.Host, err)
+	}
+	return host, int32(port), nil
+}
+
+func parentReference(parentRef gatewayv1.ParentReference) *gatewayv1apply.ParentReferenceApplyConfiguration {
+	applyConfig := gatewayv1apply.ParentReference().WithName(parentRef.Name)
+	if parentRef.Group != nil {
+		applyConfig.WithGroup(*parentRef.Group)
+	}
+	if parentRef.Kind != nil {
+		applyConfig.WithKind(*parentRef.Kind)
+	}
+	if parentRef.Namespace != nil {
+		applyConfig.WithNamespace(*parentRef.Namespace)
+	}
+	if parentRef.SectionName != nil {
+		applyConfig.WithSectionName(*parentRef.SectionName)
+	}
+	if parentRef.Port != nil {
+		applyConfig.WithPort(*parentRef.Port)
+	}
+	return applyConfig
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,174 @@
+package offloader
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayclientset "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+// startGatewayAPIEnv starts an API server with the CRDs of the experimental channel of the Gateway API.
+// The test is skipped when the envtest binaries are not installed, run it with make test.
+func startGatewayAPIEnv(t *testing.T) (kubernetes.Interface, gatewayclientset.Interface) {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run the test with make test")
+	}
+	moduleDir, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "sigs.k8s.io/gateway-api").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join(strings.TrimSpace(string(moduleDir)), "config", "crd", "experimental")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := testEnv.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	return kubernetes.NewForConfigOrDie(cfg), gatewayclientset.NewForConfigOrDie(cfg)
+}
+
+func newGatewayAPITestModel() *modelv1alpha1.ModelDeployment {
+	return &modelv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "llama"},
+		Spec: modelv1alpha1.ModelDeploymentSpec{
+			Model: "llama",
+			ServiceRef: &modelv1alpha1.ServiceReference{
+				ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "llama"},
+				TargetPort:      80,
+			},
+		},
+		Status: modelv1alpha1.ModelDeploymentStatus{Workspace: "acme"},
+	}
+}
+
+func TestGatewayAPIOffloader(t *testing.T) {
+	kubeClient, gatewayClient := startGatewayAPIEnv(t)
+	ctx := context.Background()
+	model := newGatewayAPITestModel()
+	localBackend := &modelv1alpha1.ServiceReference{
+		ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "llama-beamlit"},
+		TargetPort:      80,
+	}
+	remoteBackend := &modelv1alpha1.RemoteBackend{
+		Host:         "run.beamlit.com",
+		Scheme:       modelv1alpha1.SupportedSchemeHTTPS,
+		PathPrefix:   "/$workspace/models/$model",
+		HeadersToAdd: map[string]string{"Authorization": "Bearer token"},
+	}
+
+	type testCase struct {
+		options     GatewayAPIOptions
+		weight      int
+		wantParent  gatewayv1.ObjectName
+		wantBackend gatewayv1.ObjectName
+		wantRemote  bool
+	}
+	tcs := map[string]testCase{
+		"When no parent ref is configured, must attach the HTTPRoute to the model Service and create an ExternalName Service": {
+			weight:      30,
+			wantParent:  "llama",
+			wantBackend: "llama-beamlit-remote",
+			wantRemote:  true,
+		},
+		"When a Gateway and a ServiceImport are configured, must attach the HTTPRoute to the Gateway and route to the ServiceImport": {
+			options: GatewayAPIOptions{
+				ParentRefs:          []gatewayv1.ParentReference{{Name: "internal"}},
+				RemoteServiceImport: &ServiceImportReference{Name: "beamlit", Port: 443},
+			},
+			weight:      100,
+			wantParent:  "internal",
+			wantBackend: "beamlit",
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			offloader, err := newGatewayAPIOffloader(ctx, kubeClient, Options{GatewayClient: gatewayClient, GatewayAPI: tc.options})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The weights are updated by configuring the model again
+			for _, weight := range []int{0, tc.weight} {
+				if err := offloader.Configure(ctx, model, localBackend, remoteBackend, weight); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			route, err := gatewayClient.GatewayV1().HTTPRoutes("default").Get(ctx, "llama-beamlit", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(route.Spec.ParentRefs) != 1 || route.Spec.ParentRefs[0].Name != tc.wantParent {
+				t.Errorf("want parent %s but got %v", tc.wantParent, route.Spec.ParentRefs)
+			}
+			backendRefs := route.Spec.Rules[0].BackendRefs
+			if len(backendRefs) != 2 {
+				t.Fatalf("want 2 backend refs but got %d", len(backendRefs))
+			}
+			if backendRefs[0].Name != "llama-beamlit" || *backendRefs[0].Weight != int32(100-tc.weight) {
+				t.Errorf("want local backend llama-beamlit with weight %d but got %s with weight %d", 100-tc.weight, backendRefs[0].Name, *backendRefs[0].Weight)
+			}
+			if backendRefs[1].Name != tc.wantBackend || *backendRefs[1].Weight != int32(tc.weight) {
+				t.Errorf("want remote backend %s with weight %d but got %s with weight %d", tc.wantBackend, tc.weight, backendRefs[1].Name, *backendRefs[1].Weight)
+			}
+			if rewrite := backendRefs[1].Filters[0].URLRewrite; rewrite == nil || *rewrite.Path.ReplacePrefixMatch != "/acme/models/llama" {
+				t.Errorf("want path rewritten to /acme/models/llama but got %v", backendRefs[1].Filters)
+			}
+
+			service, err := kubeClient.CoreV1().Services("default").Get(ctx, "llama-beamlit-remote", metav1.GetOptions{})
+			if tc.wantRemote {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if service.Spec.ExternalName != "run.beamlit.com" || service.Spec.Ports[0].Port != 443 {
+					t.Errorf("want remote service resolving to run.beamlit.com:443 but got %s:%d", service.Spec.ExternalName, service.Spec.Ports[0].Port)
+				}
+				if _, err := gatewayClient.GatewayV1alpha3().BackendTLSPolicies("default").Get(ctx, "llama-beamlit-remote", metav1.GetOptions{}); err != nil {
+					t.Errorf("want backend TLS policy but got %v", err)
+				}
+			} else if !apierrors.IsNotFound(err) {
+				t.Errorf("want no remote service but got %v", err)
+			}
+
+			if err := offloader.Cleanup(ctx, model); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := gatewayClient.GatewayV1().HTTPRoutes("default").Get(ctx, "llama-beamlit", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("want HTTPRoute deleted but got %v", err)
+			}
+			if _, err := kubeClient.CoreV1().Services("default").Get(ctx, "llama-beamlit-remote", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("want remote service deleted but got %v", err)
+			}
+		})
+	}
+
+	t.Run("When the remote backend uses OAuth, must return an error", func(t *testing.T) {
+		offloader, err := newGatewayAPIOffloader(ctx, kubeClient, Options{GatewayClient: gatewayClient})
+		if err != nil {
+			t.Fatal(err)
+		}
+		oauthBackend := remoteBackend.DeepCopy()
+		oauthBackend.AuthConfig = &modelv1alpha1.AuthConfig{Type: modelv1alpha1.AuthTypeOAuth}
+		if err := offloader.Configure(ctx, model, localBackend, oauthBackend, 50); !IsUnsupportedAuthConfig(err) {
+			t.Errorf("want an unsupported auth config error but got %v", err)
+		}
+	})
+}
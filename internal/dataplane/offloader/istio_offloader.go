@@ -0,0 +1,174 @@
+package offloader
+
+import (
+	"context"
+	"fmt"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	istioServiceEntries    = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "serviceentries"}
+	istioDestinationRules  = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "destinationrules"}
+	istioVirtualServices   = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "virtualservices"}
+	istioRemoteServicePort = int64(80)
+)
+
+// istioOffloader offloads models with Istio resources programming the sidecars of the clients of their Service:
+// a ServiceEntry for the remote backend host, a DestinationRule originating TLS to it, and a VirtualService splitting the traffic
+// of the model Service between the local and the remote backends. It is meant to be used with the noop configurer.
+type istioOffloader struct {
+	dynamicClient dynamic.Interface
+}
+
+func newIstioOffloader(ctx context.Context, kubeClient kubernetes.Interface, options Options) (Offloader, error) {
+	if options.DynamicClient == nil {
+		return nil, fmt.Errorf("the %s offloader requires a dynamic client", IstioOffloaderType)
+	}
+	return &istioOffloader{dynamicClient: options.DynamicClient}, nil
+}
+
+func (o *istioOffloader) Configure(ctx context.Context, model *modelv1alpha1.ModelDeployment, localBackend *modelv1alpha1.ServiceReference, remoteBackend *modelv1alpha1.RemoteBackend, remoteBackendWeight int) error {
+	if remoteBackend.AuthConfig != nil {
+		return &ErrUnsupportedAuthConfig{AuthType: remoteBackend.AuthConfig.Type, Offloader: IstioOffloaderType}
+	}
+	pathPrefix := variableReplace(remoteBackend.PathPrefix, model)
+	if pathPrefix != "" && remoteBackendWeight > 0 && remoteBackendWeight < 100 {
+		// Istio rewrites paths per route, not per destination
+		return fmt.Errorf("the path prefix of the remote backend is only supported by the %s offloader when the model is fully offloaded", IstioOffloaderType)
+	}
+	namespace := model.Spec.ServiceRef.Namespace
+	host, port, err := splitRemoteHost(remoteBackend)
+	if err != nil {
+		return err
+	}
+
+	// The sidecars send plain HTTP to the port 80 of the ServiceEntry, and originate TLS to the remote backend if its scheme is https
+	if err := o.apply(ctx, istioServiceEntries, model, remoteServiceName(model), map[string]interface{}{
+		"hosts":      []interface{}{host},
+		"location":   "MESH_EXTERNAL",
+		"resolution": "DNS",
+		"ports": []interface{}{
+			map[string]interface{}{
+				"number":     istioRemoteServicePort,
+				"name":       "http",
+				"protocol":   "HTTP",
+				"targetPort": int64(port),
+			},
+		},
+	}); err != nil {
+		return err
+	}
+	if remoteBackend.Scheme == modelv1alpha1.SupportedSchemeHTTPS {
+		if err := o.apply(ctx, istioDestinationRules, model, remoteServiceName(model), map[string]interface{}{
+			"host": host,
+			"trafficPolicy": map[string]interface{}{
+				"portLevelSettings": []interface{}{
+					map[string]interface{}{
+						"port": map[string]interface{}{"number": istioRemoteServicePort},
+						"tls":  map[string]interface{}{"mode": "SIMPLE", "sni": host},
+					},
+				},
+			},
+		}); err != nil {
+			return err
+		}
+	} else if err := o.delete(ctx, istioDestinationRules, namespace, remoteServiceName(model)); err != nil {
+		return err
+	}
+
+	requestHeaders := map[string]interface{}{"host": host}
+	for name, value := range remoteBackend.HeadersToAdd {
+		requestHeaders[name] = value
+	}
+	localHost := fmt.Sprintf("%s.%s.svc.cluster.local", localBackend.Name, localBackend.Namespace)
+	// Each offloaded port of the model Service is routed to the same port of the local backend
+	routes := make([]interface{}, 0, len(localBackend.Ports()))
+	for _, port := range localBackend.Ports() {
+		route := map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"port": int64(port), "uri": map[string]interface{}{"prefix": "/"}}},
+			"route": []interface{}{
+				map[string]interface{}{
+					"destination": map[string]interface{}{
+						"host": localHost,
+						"port": map[string]interface{}{"number": int64(port)},
+					},
+					"weight": int64(100 - remoteBackendWeight),
+				},
+				map[string]interface{}{
+					"destination": map[string]interface{}{
+						"host": host,
+						"port": map[string]interface{}{"number": istioRemoteServicePort},
+					},
+					"weight":  int64(remoteBackendWeight),
+					"headers": map[string]interface{}{"request": map[string]interface{}{"set": requestHeaders}},
+				},
+			},
+		}
+		if pathPrefix != "" && remoteBackendWeight == 100 {
+			route["rewrite"] = map[string]interface{}{"uri": pathPrefix + "/"}
+		}
+		routes = append(routes, route)
+	}
+	return o.apply(ctx, istioVirtualServices, model, httpRouteName(model), map[string]interface{}{
+		"hosts":    []interface{}{fmt.Sprintf("%s.%s.svc.cluster.local", model.Spec.ServiceRef.Name, namespace)},
+		"gateways": []interface{}{"mesh"},
+		"http":     routes,
+	})
+}
+
+func (o *istioOffloader) Cleanup(ctx context.Context, model *modelv1alpha1.ModelDeployment) error {
+	namespace := model.Spec.ServiceRef.Namespace
+	if err := o.delete(ctx, istioVirtualServices, namespace, httpRouteName(model)); err != nil {
+		return err
+	}
+	if err := o.delete(ctx, istioDestinationRules, namespace, remoteServiceName(model)); err != nil {
+		return err
+	}
+	return o.delete(ctx, istioServiceEntries, namespace, remoteServiceName(model))
+}
+
+// apply creates or updates the spec of an Istio resource of a model, in the namespace of the model Service
+func (o *istioOffloader) apply(ctx context.Context, resource schema.GroupVersionResource, model *modelv1alpha1.ModelDeployment, name string, spec map[string]interface{}) error {
+	namespace := model.Spec.ServiceRef.Namespace
+	client := o.dynamicClient.Resource(resource).Namespace(namespace)
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if apierrors.IsNotFound(err) {
+		kind := map[string]string{
+			istioServiceEntries.Resource:   "ServiceEntry",
+			istioDestinationRules.Resource: "DestinationRule",
+			istioVirtualServices.Resource:  "VirtualService",
+		}[resource.Resource]
+		object := &unstructured.Unstructured{}
+		object.SetAPIVersion(resource.GroupVersion().String())
+		object.SetKind(kind)
+		object.SetNamespace(namespace)
+		object.SetName(name)
+		object.SetLabels(map[string]string{"app.kubernetes.io/managed-by": fieldManager})
+		object.SetAnnotations(map[string]string{ModelDeploymentAnnotation: types.NamespacedName{Namespace: model.Namespace, Name: model.Name}.String()})
+		object.Object["spec"] = spec
+		_, err = client.Create(ctx, object, metav1.CreateOptions{FieldManager: fieldManager})
+		return err
+	}
+	existing.Object["spec"] = spec
+	_, err = client.Update(ctx, existing, metav1.UpdateOptions{FieldManager: fieldManager})
+	return err
+}
+
+func (o *istioOffloader) delete(ctx context.Context, resource schema.GroupVersionResource, namespace string, name string) error {
+	err := o.dynamicClient.Resource(resource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package offloader
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
+)
+
+func TestIstioOffloader(t *testing.T) {
+	type testCase struct {
+		remoteBackend   *modelv1alpha1.RemoteBackend
+		weight          int
+		wantErr         bool
+		wantDestination bool
+		wantRewrite     interface{}
+	}
+	tcs := map[string]testCase{
+		"When the remote backend is https, must originate TLS with a DestinationRule": {
+			remoteBackend:   &modelv1alpha1.RemoteBackend{Host: "run.beamlit.com", Scheme: modelv1alpha1.SupportedSchemeHTTPS},
+			weight:          30,
+			wantDestination: true,
+		},
+		"When the remote backend is http, must not create a DestinationRule": {
+			remoteBackend: &modelv1alpha1.RemoteBackend{Host: "beamlit.internal:8080", Scheme: modelv1alpha1.SupportedSchemeHTTP},
+			weight:        30,
+		},
+		"When the model is fully offloaded, must rewrite the path with the path prefix": {
+			remoteBackend:   &modelv1alpha1.RemoteBackend{Host: "run.beamlit.com", Scheme: modelv1alpha1.SupportedSchemeHTTPS, PathPrefix: "/$workspace/models/$model"},
+			weight:          100,
+			wantDestination: true,
+			wantRewrite:     map[string]interface{}{"uri": "/acme/models/llama/"},
+		},
+		"When the model is partially offloaded with a path prefix, must return an error": {
+			remoteBackend: &modelv1alpha1.RemoteBackend{Host: "run.beamlit.com", Scheme: modelv1alpha1.SupportedSchemeHTTPS, PathPrefix: "/$workspace/models/$model"},
+			weight:        30,
+			wantErr:       true,
+		},
+		"When the remote backend uses OAuth, must return an error": {
+			remoteBackend: &modelv1alpha1.RemoteBackend{
+				Host:       "run.beamlit.com",
+				Scheme:     modelv1alpha1.SupportedSchemeHTTPS,
+				AuthConfig: &modelv1alpha1.AuthConfig{Type: modelv1alpha1.AuthTypeOAuth},
+			},
+			weight:  30,
+			wantErr: true,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+			offloader, err := newIstioOffloader(ctx, nil, Options{DynamicClient: dynamicClient})
+			if err != nil {
+				t.Fatal(err)
+			}
+			model := &modelv1alpha1.ModelDeployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "llama"},
+				Spec: modelv1alpha1.ModelDeploymentSpec{
+					Model: "llama",
+					ServiceRef: &modelv1alpha1.ServiceReference{
+						ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "llama"},
+						TargetPort:      80,
+					},
+				},
+				Status: modelv1alpha1.ModelDeploymentStatus{Workspace: "acme"},
+			}
+
+			err = offloader.Configure(ctx, model, model.Spec.ServiceRef, tc.remoteBackend, tc.weight)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("wantErr is %v but err is %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			virtualService, err := dynamicClient.Resource(istioVirtualServices).Namespace("default").Get(ctx, "llama-beamlit", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes, _, _ := unstructured.NestedSlice(virtualService.Object, "spec", "http")
+			destinations := routes[0].(map[string]interface{})["route"].([]interface{})
+			if weight := destinations[1].(map[string]interface{})["weight"]; weight != int64(tc.weight) {
+				t.Errorf("want remote weight %d but got %v", tc.weight, weight)
+			}
+			if rewrite := routes[0].(map[string]interface{})["rewrite"]; !reflect.DeepEqual(rewrite, tc.wantRewrite) {
+				t.Errorf("want rewrite %v but got %v", tc.wantRewrite, rewrite)
+			}
+			if _, err := dynamicClient.Resource(istioServiceEntries).Namespace("default").Get(ctx, "llama-beamlit-remote", metav1.GetOptions{}); err != nil {
+				t.Errorf("want ServiceEntry but got %v", err)
+			}
+			_, err = dynamicClient.Resource(istioDestinationRules).Namespace("default").Get(ctx, "llama-beamlit-remote", metav1.GetOptions{})
+			if tc.wantDestination && err != nil {
+				t.Errorf("want DestinationRule but got %v", err)
+			}
+			if !tc.wantDestination && !apierrors.IsNotFound(err) {
+				t.Errorf("want no DestinationRule but got %v", err)
+			}
+
+			if err := offloader.Cleanup(ctx, model); err != nil {
+				t.Fatal(err)
+			}
+			for resource, name := range map[schema.GroupVersionResource]string{
+				istioVirtualServices:  "llama-beamlit",
+				istioServiceEntries:   "llama-beamlit-remote",
+				istioDestinationRules: "llama-beamlit-remote",
+			} {
+				if _, err := dynamicClient.Resource(resource).Namespace("default").Get(ctx, name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+					t.Errorf("want %s %s deleted but got %v", resource.Resource, name, err)
+				}
+			}
+		})
+	}
+}
@@ -18,11 +18,17 @@ package offloader
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
+	authorizationv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/authorization"
 	modelv1alpha1 "github.com/beamlit/beamlit-controller/api/v1alpha1/deployment"
 	beamlitclientset "github.com/beamlit/beamlit-controller/gateway/clientset"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	gatewayclientset "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
 )
 
 type OffloaderType string
@@ -30,21 +36,46 @@ type OffloaderType string
 const (
 	GatewayAPIOffloaderType     OffloaderType = "gateway-api"
 	BeamlitGatewayOffloaderType OffloaderType = "beamlit-gateway"
+	IstioOffloaderType          OffloaderType = "istio"
+	EnvoyXDSOffloaderType       OffloaderType = "envoy-xds"
 )
 
-type offloaderFactory func(ctx context.Context, kubeClient kubernetes.Interface, proxyClient *beamlitclientset.ClientSet) (Offloader, error)
+const (
+	fieldManager = "beamlit-operator"
+	// ModelDeploymentAnnotation is set on the resources created by the offloaders in the cluster, with the namespace and name of their ModelDeployment
+	ModelDeploymentAnnotation = "beamlit.com/model-deployment"
+)
+
+// Options are the clients and settings of the offloaders, each offloader only reads the ones it uses.
+type Options struct {
+	// ProxyClient is the client of the routes of the Beamlit gateway, used by the beamlit-gateway offloader.
+	// The envoy-xds offloader uses the xDS server of the controller as client.
+	ProxyClient beamlitclientset.V1Alpha1Client
+	// GatewayClient is the client of the Gateway API resources, used by the gateway-api offloader.
+	GatewayClient gatewayclientset.Interface
+	// GatewayAPI are the settings of the gateway-api offloader.
+	GatewayAPI GatewayAPIOptions
+	// DynamicClient is the client of the Istio resources, used by the istio offloader.
+	DynamicClient dynamic.Interface
+}
+
+type offloaderFactory func(ctx context.Context, kubeClient kubernetes.Interface, options Options) (Offloader, error)
 
 var offloaderFactories = map[OffloaderType]offloaderFactory{
 	BeamlitGatewayOffloaderType: newBeamlitGatewayOffloader,
+	GatewayAPIOffloaderType:     newGatewayAPIOffloader,
+	IstioOffloaderType:          newIstioOffloader,
+	// The routes of the Beamlit gateway are translated to Envoy resources by the xDS server used as proxy client
+	EnvoyXDSOffloaderType: newEnvoyXDSOffloader,
 }
 
 // NewOffloader creates a new offloader for the given type
-func NewOffloader(ctx context.Context, offloaderType OffloaderType, kubeClient kubernetes.Interface, proxyClient *beamlitclientset.ClientSet) (Offloader, error) {
+func NewOffloader(ctx context.Context, offloaderType OffloaderType, kubeClient kubernetes.Interface, options Options) (Offloader, error) {
 	factory, ok := offloaderFactories[offloaderType]
 	if !ok {
 		return nil, fmt.Errorf("unsupported offloader type: %s", offloaderType)
 	}
-	return factory(ctx, kubeClient, proxyClient)
+	return factory(ctx, kubeClient, options)
 }
 
 //go:generate go run go.uber.org/mock/mockgen -source=offloader.go -destination=offloader_mock.go -package=offloader Offloader
@@ -56,3 +87,50 @@ type Offloader interface {
 	// Cleanup cleans up the offloader for the given model. It should remove any resources created by the offloader for the given model.
 	Cleanup(ctx context.Context, model *modelv1alpha1.ModelDeployment) error
 }
+
+// PolicyEnforcer is implemented by the offloaders able to enforce gateway policies on the traffic of a model.
+type PolicyEnforcer interface {
+	// SetGatewayPolicies sets the gateway policies enforced on the traffic of the given model, from the next call to Configure.
+	SetGatewayPolicies(model *modelv1alpha1.ModelDeployment, policies []authorizationv1alpha1.PolicyObject)
+}
+
+// ErrUnsupportedPolicy is returned when the gateway policies of a model can't be enforced by the offloader, as long as they are referenced.
+// Nothing is configured for the model then, so its traffic is never offloaded without its policies.
+type ErrUnsupportedPolicy struct {
+	Model    types.NamespacedName
+	Policies []string
+}
+
+func (e *ErrUnsupportedPolicy) Error() string {
+	return fmt.Sprintf("gateway policies %s of model %s can't be enforced by the offloader, they require the Beamlit gateway offloader", strings.Join(e.Policies, ", "), e.Model)
+}
+
+// IsUnsupportedPolicy returns true if err is caused by gateway policies which can't be enforced by the offloader
+func IsUnsupportedPolicy(err error) bool {
+	var unsupportedErr *ErrUnsupportedPolicy
+	return errors.As(err, &unsupportedErr)
+}
+
+// ErrUnsupportedAuthConfig is returned when the authentication of the remote backend of a model can't be configured by the offloader.
+// The credentials must then be sent with an Authorization header in the headersToAdd of the remote backend.
+type ErrUnsupportedAuthConfig struct {
+	AuthType  modelv1alpha1.AuthType
+	Offloader OffloaderType
+}
+
+func (e *ErrUnsupportedAuthConfig) Error() string {
+	return fmt.Sprintf("%s authentication of the remote backend is not supported by the %s offloader, set the Authorization header in headersToAdd instead", e.AuthType, e.Offloader)
+}
+
+// IsUnsupportedAuthConfig returns true if err is caused by an authentication of the remote backend which can't be configured by the offloader
+func IsUnsupportedAuthConfig(err error) bool {
+	var unsupportedErr *ErrUnsupportedAuthConfig
+	return errors.As(err, &unsupportedErr)
+}
+
+// variableReplace replaces the $workspace and $model variables in the path prefix of a remote backend
+func variableReplace(pathPrefix string, model *modelv1alpha1.ModelDeployment) string {
+	pathPrefix = strings.ReplaceAll(pathPrefix, "$workspace", model.Status.Workspace)
+	pathPrefix = strings.ReplaceAll(pathPrefix, "$model", model.Spec.Model)
+	return pathPrefix
+}
@@ -0,0 +1,172 @@
+package xds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// NewTLSConfig returns the TLS config of the xDS server, serving the certificate of certFile and keyFile and requiring a client certificate
+// signed by a CA of clientCAFile. The files are read again on each handshake, so that the certificates can be rotated without restart.
+func NewTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	load := func() (*tls.Config, error) {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the xDS server certificate: %w", err)
+		}
+		caBundle, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the xDS client CA: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificate found in the xDS client CA %s", clientCAFile)
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			MinVersion:   tls.VersionTLS12,
+			// The config returned for the client replaces the one gRPC sets the HTTP/2 protocol in
+			NextProtos: []string{"h2"},
+		}, nil
+	}
+	// Fail at startup on invalid files
+	if _, err := load(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) { return load() },
+	}, nil
+}
+
+// nodeAuthenticator rejects the xDS requests of the Envoy proxies whose node ID isn't the one served, or isn't an identity of their client
+// certificate: a DNS or URI SAN, or the common name. A node ID is only required on the first request of a stream.
+type nodeAuthenticator struct {
+	nodeID string
+
+	mu      sync.Mutex
+	streams map[int64]*streamIdentity
+}
+
+type streamIdentity struct {
+	identities    []string
+	authenticated bool
+}
+
+func newNodeAuthenticator(nodeID string) *nodeAuthenticator {
+	return &nodeAuthenticator{nodeID: nodeID, streams: make(map[int64]*streamIdentity)}
+}
+
+func (a *nodeAuthenticator) OnStreamOpen(ctx context.Context, streamID int64, _ string) error {
+	identities, err := peerIdentities(ctx)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streams[streamID] = &streamIdentity{identities: identities}
+	return nil
+}
+
+func (a *nodeAuthenticator) OnDeltaStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	return a.OnStreamOpen(ctx, streamID, typeURL)
+}
+
+func (a *nodeAuthenticator) OnStreamClosed(streamID int64, _ *corev3.Node) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.streams, streamID)
+}
+
+func (a *nodeAuthenticator) OnDeltaStreamClosed(streamID int64, node *corev3.Node) {
+	a.OnStreamClosed(streamID, node)
+}
+
+func (a *nodeAuthenticator) OnStreamRequest(streamID int64, request *discoverygrpc.DiscoveryRequest) error {
+	return a.authenticateStream(streamID, request.GetNode())
+}
+
+func (a *nodeAuthenticator) OnStreamDeltaRequest(streamID int64, request *discoverygrpc.DeltaDiscoveryRequest) error {
+	return a.authenticateStream(streamID, request.GetNode())
+}
+
+func (a *nodeAuthenticator) OnStreamResponse(context.Context, int64, *discoverygrpc.DiscoveryRequest, *discoverygrpc.DiscoveryResponse) {
+}
+
+func (a *nodeAuthenticator) OnStreamDeltaResponse(int64, *discoverygrpc.DeltaDiscoveryRequest, *discoverygrpc.DeltaDiscoveryResponse) {
+}
+
+func (a *nodeAuthenticator) OnFetchRequest(ctx context.Context, request *discoverygrpc.DiscoveryRequest) error {
+	identities, err := peerIdentities(ctx)
+	if err != nil {
+		return err
+	}
+	return a.authenticate(identities, request.GetNode())
+}
+
+func (a *nodeAuthenticator) OnFetchResponse(*discoverygrpc.DiscoveryRequest, *discoverygrpc.DiscoveryResponse) {
+}
+
+func (a *nodeAuthenticator) authenticateStream(streamID int64, node *corev3.Node) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stream, ok := a.streams[streamID]
+	if !ok {
+		return status.Error(codes.Unauthenticated, "unknown xDS stream")
+	}
+	if node == nil && stream.authenticated {
+		return nil
+	}
+	if err := a.authenticate(stream.identities, node); err != nil {
+		return err
+	}
+	stream.authenticated = true
+	return nil
+}
+
+func (a *nodeAuthenticator) authenticate(identities []string, node *corev3.Node) error {
+	if node.GetId() == "" {
+		return status.Error(codes.Unauthenticated, "the xDS request has no node ID")
+	}
+	if node.GetId() != a.nodeID {
+		return status.Errorf(codes.PermissionDenied, "node %s is not served by this xDS server", node.GetId())
+	}
+	if !slices.Contains(identities, node.GetId()) {
+		return status.Errorf(codes.PermissionDenied, "the client certificate is not issued to node %s", node.GetId())
+	}
+	return nil
+}
+
+// peerIdentities returns the identities of the verified client certificate of the peer of ctx
+func peerIdentities(ctx context.Context) ([]string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no peer")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no verified client certificate")
+	}
+	certificate := tlsInfo.State.VerifiedChains[0][0]
+	identities := slices.Clone(certificate.DNSNames)
+	for _, uri := range certificate.URIs {
+		identities = append(identities, uri.String())
+	}
+	if certificate.Subject.CommonName != "" {
+		identities = append(identities, certificate.Subject.CommonName)
+	}
+	return identities, nil
+}
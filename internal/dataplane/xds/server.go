@@ -0,0 +1,174 @@
+package xds
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
+)
+
+// Server is an xDS management server serving the routes of the offloaded models to Envoy proxies, used in place of the Beamlit gateway.
+// It implements the routes API of the gateway admin client, and publishes a new snapshot of the listener, route, cluster, endpoint and
+// secret resources of all the routes each time one of them changes.
+// The Envoy proxies are authenticated with mutual TLS, the identity of their client certificate being their node ID, as the secrets
+// of the remote backends are served inline.
+type Server struct {
+	// Address is the address the gRPC server listens on, e.g. ":18000".
+	Address string
+	// NodeID is the node ID of the Envoy proxies served, set in their bootstrap configuration.
+	NodeID string
+	// ListenerPort is the port Envoy listens on for the traffic of the models.
+	ListenerPort uint32
+	// TLSConfig is the TLS config of the gRPC server, requiring a client certificate.
+	TLSConfig *tls.Config
+
+	cache   cachev3.SnapshotCache
+	mu      sync.Mutex
+	routes  map[string]v1alpha1.Route
+	version uint64
+}
+
+// NewServer creates an xDS server with an empty snapshot, serving a listener without routes until the first route is registered.
+// tlsConfig must require and verify the client certificates, see NewTLSConfig.
+func NewServer(address string, nodeID string, listenerPort uint32, tlsConfig *tls.Config) (*Server, error) {
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("the xDS server requires a TLS config authenticating the Envoy proxies")
+	}
+	s := &Server{
+		Address:      address,
+		NodeID:       nodeID,
+		ListenerPort: listenerPort,
+		TLSConfig:    tlsConfig,
+		cache:        cachev3.NewSnapshotCache(true, cachev3.IDHash{}, nil),
+		routes:       make(map[string]v1alpha1.Route),
+	}
+	if err := s.publish(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start serves the xDS APIs on Address until ctx is done, it implements manager.Runnable
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// NeedLeaderElection makes the xDS server run on the leader only, the routes being registered by the ModelDeployment reconciler
+func (s *Server) NeedLeaderElection() bool {
+	return true
+}
+
+// Serve serves the aggregated and the per-type xDS APIs on listener until ctx is done, to the Envoy proxies authenticated as NodeID
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(s.TLSConfig)))
+	xdsServer := serverv3.NewServer(ctx, s.cache, newNodeAuthenticator(s.NodeID))
+	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(grpcServer, xdsServer)
+	listenerservice.RegisterListenerDiscoveryServiceServer(grpcServer, xdsServer)
+	routeservice.RegisterRouteDiscoveryServiceServer(grpcServer, xdsServer)
+	clusterservice.RegisterClusterDiscoveryServiceServer(grpcServer, xdsServer)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(grpcServer, xdsServer)
+	secretservice.RegisterSecretDiscoveryServiceServer(grpcServer, xdsServer)
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
+	log.FromContext(ctx).Info("Serving xDS", "Address", listener.Addr().String(), "NodeID", s.NodeID)
+	return grpcServer.Serve(listener)
+}
+
+func (s *Server) GetRoute(ctx context.Context, name string) (*v1alpha1.Route, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	route, ok := s.routes[name]
+	if !ok {
+		return nil, v1alpha1.ErrRouteNotFound
+	}
+	return &route, nil
+}
+
+func (s *Server) RegisterRoute(ctx context.Context, route v1alpha1.Route) (*v1alpha1.Route, error) {
+	return s.storeRoute(ctx, route)
+}
+
+func (s *Server) UpdateRoute(ctx context.Context, route v1alpha1.Route) (*v1alpha1.Route, error) {
+	return s.storeRoute(ctx, route)
+}
+
+func (s *Server) DeleteRoute(ctx context.Context, name string) (*v1alpha1.Route, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	route, ok := s.routes[name]
+	if !ok {
+		return nil, v1alpha1.ErrRouteNotFound
+	}
+	delete(s.routes, name)
+	if err := s.publish(ctx); err != nil {
+		s.routes[name] = route
+		return nil, err
+	}
+	return &route, nil
+}
+
+func (s *Server) storeRoute(ctx context.Context, route v1alpha1.Route) (*v1alpha1.Route, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.routes[route.Name]
+	s.routes[route.Name] = route
+	if err := s.publish(ctx); err != nil {
+		// Keep serving the last valid snapshot
+		if existed {
+			s.routes[route.Name] = previous
+		} else {
+			delete(s.routes, route.Name)
+		}
+		return nil, err
+	}
+	return &route, nil
+}
+
+// publish sets a new snapshot of the routes for NodeID, s.mu must be held
+func (s *Server) publish(ctx context.Context) error {
+	routes := make([]v1alpha1.Route, 0, len(s.routes))
+	for _, route := range s.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+	resources, err := translate(routes, s.ListenerPort)
+	if err != nil {
+		return err
+	}
+	snapshot, err := cachev3.NewSnapshot(strconv.FormatUint(s.version+1, 10), resources)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return fmt.Errorf("inconsistent xDS snapshot: %w", err)
+	}
+	if err := s.cache.SetSnapshot(ctx, s.NodeID, snapshot); err != nil {
+		return err
+	}
+	s.version++
+	return nil
+}
@@ -0,0 +1,461 @@
+package xds
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	credentialinjectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/credential_injector/v3"
+	oauth2credentialv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/http/injected_credentials/oauth2/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	upstreamhttpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
+)
+
+const testNodeID = "beamlit-gateway"
+
+// testPKI is a CA issuing the certificate of the xDS server and the client certificates of the Envoy proxies
+type testPKI struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	serial      int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "xds-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testPKI{certificate: certificate, key: key, serial: 1}
+}
+
+// issue returns a certificate and its key in PEM, for a server if dnsName is set, for the client commonName otherwise
+func (p *testPKI) issue(t *testing.T, commonName string, dnsName string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if dnsName != "" {
+		template.DNSNames = []string{dnsName}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.certificate, &key.PublicKey, p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// serverTLSConfig writes the certificate of the xDS server and the CA to files, and returns the TLS config loading them
+func (p *testPKI) serverTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	dir := t.TempDir()
+	certPEM, keyPEM := p.issue(t, "xds", "xds")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.certificate.Raw})
+	for name, content := range map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM, "ca.crt": caPEM} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tlsConfig, err := NewTLSConfig(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tlsConfig
+}
+
+// clientTLSConfig returns the TLS config of an Envoy proxy trusting the xDS server, with a client certificate issued to commonName
+// by issuer, or without client certificate if issuer is nil
+func (p *testPKI) clientTLSConfig(t *testing.T, issuer *testPKI, commonName string) *tls.Config {
+	t.Helper()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(p.certificate)
+	tlsConfig := &tls.Config{RootCAs: rootCAs, ServerName: "xds", MinVersion: tls.VersionTLS12}
+	if issuer != nil {
+		certificate, err := tls.X509KeyPair(issuer.issue(t, commonName, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig
+}
+
+// newTestServer returns an xDS server authenticating the Envoy proxies with the certificates of pki
+func newTestServer(t *testing.T, pki *testPKI) *Server {
+	t.Helper()
+	server, err := NewServer("", testNodeID, 8080, pki.serverTLSConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// startTestServer serves s in process and returns an ADS stream of an Envoy proxy connecting with clientTLSConfig
+func startTestServer(t *testing.T, s *Server, clientTLSConfig *tls.Config) discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesClient {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		if err := s.Serve(ctx, listener); err != nil {
+			t.Error(err)
+		}
+	}()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		_ = conn.Close()
+	})
+	stream, err := discoveryv3.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		// The handshake failed
+		return &failedStream{err: err}
+	}
+	return stream
+}
+
+// failedStream is an ADS stream which couldn't be opened
+type failedStream struct {
+	discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesClient
+	err error
+}
+
+func (s *failedStream) Send(*discoveryv3.DiscoveryRequest) error {
+	return s.err
+}
+
+func (s *failedStream) Recv() (*discoveryv3.DiscoveryResponse, error) {
+	return nil, s.err
+}
+
+// fetch requests the resources of a type on stream and waits for the response
+func fetch(t *testing.T, stream discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesClient, typeURL string, names ...string) *discoveryv3.DiscoveryResponse {
+	t.Helper()
+	request := &discoveryv3.DiscoveryRequest{
+		Node:          &corev3.Node{Id: testNodeID},
+		TypeUrl:       typeURL,
+		ResourceNames: names,
+	}
+	if err := stream.Send(request); err != nil {
+		t.Fatal(err)
+	}
+	response, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.TypeUrl != typeURL {
+		t.Fatalf("want a response of type %s but got %s", typeURL, response.TypeUrl)
+	}
+	return response
+}
+
+// unmarshal unmarshals resource into message, and checks it against the constraints of the Envoy API
+func unmarshal[T proto.Message](t *testing.T, resource *anypb.Any, message T) T {
+	t.Helper()
+	if err := resource.UnmarshalTo(message); err != nil {
+		t.Fatal(err)
+	}
+	if validator, ok := any(message).(interface{ ValidateAll() error }); ok {
+		if err := validator.ValidateAll(); err != nil {
+			t.Error(err)
+		}
+	}
+	return message
+}
+
+func newTestRoute(localWeight int) v1alpha1.Route {
+	return v1alpha1.Route{
+		Name:      "llama",
+		Hostnames: []string{"llama", "llama.default.svc.cluster.local"},
+		Backends: []v1alpha1.Backend{
+			{
+				Host:   "llama-beamlit.default.svc.cluster.local:80",
+				Weight: localWeight,
+				Scheme: "http",
+			},
+			{
+				Host:         "run.beamlit.com",
+				Weight:       100 - localWeight,
+				Scheme:       "https",
+				PathPrefix:   "/acme/models/llama",
+				HeadersToAdd: map[string]string{"X-Beamlit-Workspace": "acme"},
+				Auth: &v1alpha1.Auth{
+					Type: v1alpha1.AuthTypeOAuth,
+					OAuth: &v1alpha1.OAuth{
+						ClientID:     "client",
+						ClientSecret: "secret",
+						TokenURL:     "https://api.beamlit.com/v0/oauth/token",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t)
+	server := newTestServer(t, pki)
+	if _, err := server.RegisterRoute(ctx, newTestRoute(70)); err != nil {
+		t.Fatal(err)
+	}
+	stream := startTestServer(t, server, pki.clientTLSConfig(t, pki, testNodeID))
+
+	listeners := fetch(t, stream, resourcev3.ListenerType)
+	if len(listeners.Resources) != 1 {
+		t.Fatalf("want 1 listener but got %d", len(listeners.Resources))
+	}
+	listener := unmarshal(t, listeners.Resources[0], &listenerv3.Listener{})
+	if port := listener.GetAddress().GetSocketAddress().GetPortValue(); port != 8080 {
+		t.Errorf("want listener on port 8080 but got %d", port)
+	}
+
+	routes := fetch(t, stream, resourcev3.RouteType, ListenerName)
+	routeConfiguration := unmarshal(t, routes.Resources[0], &routev3.RouteConfiguration{})
+	if len(routeConfiguration.VirtualHosts) != 1 || len(routeConfiguration.VirtualHosts[0].Domains) != 2 {
+		t.Fatalf("want 1 virtual host with the 2 hostnames of the route but got %v", routeConfiguration.VirtualHosts)
+	}
+	local, remote := routeConfiguration.VirtualHosts[0].Routes[0], routeConfiguration.VirtualHosts[0].Routes[1]
+	if numerator := local.Match.GetRuntimeFraction().GetDefaultValue().GetNumerator(); numerator != 700000 {
+		t.Errorf("want 70%% of the requests routed to the local backend but got %d/1000000", numerator)
+	}
+	if remote.Match.RuntimeFraction != nil {
+		t.Errorf("want the remaining requests routed to the remote backend but got %v", remote.Match.RuntimeFraction)
+	}
+	action := remote.GetRoute()
+	if action.GetCluster() != "llama-1" || action.GetHostRewriteLiteral() != "run.beamlit.com" || action.PrefixRewrite != "/acme/models/llama/" {
+		t.Errorf("want remote route to llama-1 rewritten to run.beamlit.com/acme/models/llama/ but got %v", action)
+	}
+	if action.GetRetryPolicy().GetNumRetries().GetValue() != numRetries {
+		t.Errorf("want %d retries but got %v", numRetries, action.GetRetryPolicy())
+	}
+	if len(remote.RequestHeadersToAdd) != 1 || remote.RequestHeadersToAdd[0].Header.Key != "X-Beamlit-Workspace" {
+		t.Errorf("want X-Beamlit-Workspace header added but got %v", remote.RequestHeadersToAdd)
+	}
+
+	clusters := fetch(t, stream, resourcev3.ClusterType)
+	clustersByName := map[string]*clusterv3.Cluster{}
+	for _, resource := range clusters.Resources {
+		cluster := unmarshal(t, resource, &clusterv3.Cluster{})
+		clustersByName[cluster.Name] = cluster
+	}
+	for _, name := range []string{"llama-0", "llama-1", "llama-1-token"} {
+		if _, ok := clustersByName[name]; !ok {
+			t.Errorf("want cluster %s but got %v", name, clustersByName)
+		}
+	}
+	if clustersByName["llama-1"].GetTransportSocket() == nil {
+		t.Error("want TLS originated to the remote backend")
+	}
+	protocolOptions, ok := clustersByName["llama-1"].GetTypedExtensionProtocolOptions()[httpProtocolOptions]
+	if !ok {
+		t.Fatal("want upstream HTTP filters on the remote backend cluster")
+	}
+	injector := unmarshal(t, unmarshal(t, protocolOptions, &upstreamhttpv3.HttpProtocolOptions{}).HttpFilters[0].GetTypedConfig(), &credentialinjectorv3.CredentialInjector{})
+	oauth := unmarshal(t, injector.Credential.TypedConfig, &oauth2credentialv3.OAuth2{})
+	if oauth.GetClientCredentials().GetClientId() != "client" || oauth.GetTokenEndpoint().GetCluster() != "llama-1-token" {
+		t.Errorf("want OAuth credentials of client fetched from llama-1-token but got %v", oauth)
+	}
+
+	secrets := fetch(t, stream, resourcev3.SecretType, oauth.GetClientCredentials().GetClientSecret().GetName())
+	secret := unmarshal(t, secrets.Resources[0], &tlsv3.Secret{})
+	if secret.GetGenericSecret().GetSecret().GetInlineString() != "secret" {
+		t.Errorf("want the client secret served with SDS but got %v", secret)
+	}
+
+	t.Run("When a route is updated, must push the new routes", func(t *testing.T) {
+		// Acknowledge the routes to watch the next version
+		request := &discoveryv3.DiscoveryRequest{
+			Node:          &corev3.Node{Id: testNodeID},
+			TypeUrl:       resourcev3.RouteType,
+			ResourceNames: []string{ListenerName},
+			VersionInfo:   routes.VersionInfo,
+			ResponseNonce: routes.Nonce,
+		}
+		if err := stream.Send(request); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.UpdateRoute(ctx, newTestRoute(0)); err != nil {
+			t.Fatal(err)
+		}
+		response, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		routeConfiguration := unmarshal(t, response.Resources[0], &routev3.RouteConfiguration{})
+		if routes := routeConfiguration.VirtualHosts[0].Routes; len(routes) != 1 || routes[0].GetRoute().GetCluster() != "llama-1" {
+			t.Errorf("want all the requests routed to llama-1 but got %v", routes)
+		}
+	})
+
+	t.Run("When a route is deleted, must return it and forget it", func(t *testing.T) {
+		if _, err := server.DeleteRoute(ctx, "llama"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.GetRoute(ctx, "llama"); !errors.Is(err, v1alpha1.ErrRouteNotFound) {
+			t.Errorf("want route not found but got %v", err)
+		}
+	})
+}
+
+func TestServerEndpoints(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t)
+	server := newTestServer(t, pki)
+	route := v1alpha1.Route{
+		Name:      "llama",
+		Hostnames: []string{"llama"},
+		Backends:  []v1alpha1.Backend{{Host: "10.0.0.12:8000", Weight: 100, Scheme: "http"}},
+	}
+	if _, err := server.RegisterRoute(ctx, route); err != nil {
+		t.Fatal(err)
+	}
+	stream := startTestServer(t, server, pki.clientTLSConfig(t, pki, testNodeID))
+
+	clusters := fetch(t, stream, resourcev3.ClusterType)
+	cluster := unmarshal(t, clusters.Resources[0], &clusterv3.Cluster{})
+	if cluster.GetType() != clusterv3.Cluster_EDS {
+		t.Fatalf("want an EDS cluster for a backend IP address but got %s", cluster.GetType())
+	}
+	endpoints := fetch(t, stream, resourcev3.EndpointType, cluster.Name)
+	loadAssignment := unmarshal(t, endpoints.Resources[0], &endpointv3.ClusterLoadAssignment{})
+	address := loadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint().GetAddress().GetSocketAddress()
+	if address.GetAddress() != "10.0.0.12" || address.GetPortValue() != 8000 {
+		t.Errorf("want endpoint 10.0.0.12:8000 but got %v", address)
+	}
+}
+
+func TestServerAuthentication(t *testing.T) {
+	type testCase struct {
+		// issuer issues the client certificate, there is none if nil
+		issuer     func(pki *testPKI) *testPKI
+		commonName string
+		nodeID     string
+		wantCode   codes.Code
+	}
+	sameCA := func(pki *testPKI) *testPKI { return pki }
+	tcs := map[string]testCase{
+		"When the client certificate is issued to the node ID, must serve the node": {
+			issuer:     sameCA,
+			commonName: testNodeID,
+			nodeID:     testNodeID,
+			wantCode:   codes.OK,
+		},
+		"When the client certificate is issued to another node, must deny the node": {
+			issuer:     sameCA,
+			commonName: "other",
+			nodeID:     testNodeID,
+			wantCode:   codes.PermissionDenied,
+		},
+		"When the node ID is not the one served, must deny the node": {
+			issuer:     sameCA,
+			commonName: "other",
+			nodeID:     "other",
+			wantCode:   codes.PermissionDenied,
+		},
+		"When the client certificate is issued by another CA, must reject the connection": {
+			issuer:     func(*testPKI) *testPKI { return newTestPKI(t) },
+			commonName: testNodeID,
+			nodeID:     testNodeID,
+			wantCode:   codes.Unavailable,
+		},
+		"When the client has no certificate, must reject the connection": {
+			issuer:   func(*testPKI) *testPKI { return nil },
+			nodeID:   testNodeID,
+			wantCode: codes.Unavailable,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			pki := newTestPKI(t)
+			server := newTestServer(t, pki)
+			stream := startTestServer(t, server, pki.clientTLSConfig(t, tc.issuer(pki), tc.commonName))
+			// The stream may be closed by the server before the request is sent, the status is read on Recv
+			_ = stream.Send(&discoveryv3.DiscoveryRequest{
+				Node:    &corev3.Node{Id: tc.nodeID},
+				TypeUrl: resourcev3.ListenerType,
+			})
+			_, err := stream.Recv()
+			if code := status.Code(err); code != tc.wantCode {
+				t.Errorf("want code %s but got %v", tc.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestServerRejectsRules(t *testing.T) {
+	server := newTestServer(t, newTestPKI(t))
+	route := newTestRoute(50)
+	route.Rules = []v1alpha1.Rule{{Name: "default/allow-internal", AllowedCIDRs: []string{"10.0.0.0/8"}}}
+	if _, err := server.RegisterRoute(context.Background(), route); err == nil {
+		t.Error("want an error but got nil")
+	}
+	if _, err := server.GetRoute(context.Background(), route.Name); !errors.Is(err, v1alpha1.ErrRouteNotFound) {
+		t.Errorf("want the route rejected but got %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package xds
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	credentialinjectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/credential_injector/v3"
+	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	upstreamcodecv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/upstream_codec/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	oauth2credentialv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/http/injected_credentials/oauth2/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	upstreamhttpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/beamlit/beamlit-controller/gateway/api/v1alpha1"
+)
+
+const (
+	// ListenerName is the name of the listener receiving the traffic of the models, and of its route configuration
+	ListenerName = "beamlit-gateway"
+
+	credentialInjectorFilter = "envoy.filters.http.credential_injector"
+	oauth2Credential         = "envoy.http.injected_credentials.oauth2"
+	upstreamCodecFilter      = "envoy.filters.http.upstream_codec"
+	httpProtocolOptions      = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+
+	connectTimeout = 5 * time.Second
+	// retryOn and numRetries mirror the Beamlit gateway, which sends the requests again up to 3 times when the backend can't be reached
+	retryOn    = "connect-failure,refused-stream,reset"
+	numRetries = 3
+	// fractionDenominator is the precision of the split of the traffic between the backends of a route
+	fractionDenominator = 1000000
+)
+
+// adsConfigSource fetches the resources referenced by other resources on the aggregated discovery stream
+var adsConfigSource = &corev3.ConfigSource{
+	ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+	ResourceApiVersion:    corev3.ApiVersion_V3,
+}
+
+// translate maps the routes to the Envoy resources: a listener on listenerPort, whose route configuration has a virtual host per route,
+// and a cluster per backend of the routes.
+//
+// The traffic is split between the backends with a route per backend matching a cumulative fraction of the requests, instead of weighted
+// clusters, so that each backend rewrites the path with its own prefix. Envoy draws the random value compared to the fractions once per request.
+// The OAuth credentials of a backend are injected by the credential injector filter of its cluster, the client secret being served with SDS.
+func translate(routes []v1alpha1.Route, listenerPort uint32) (map[resourcev3.Type][]types.Resource, error) {
+	resources := map[resourcev3.Type][]types.Resource{}
+	routeConfiguration := &routev3.RouteConfiguration{Name: ListenerName}
+	for _, route := range routes {
+		if len(route.Rules) > 0 {
+			// The traffic would bypass the rules
+			return nil, fmt.Errorf("route %s: gateway rules are not supported by the xDS server", route.Name)
+		}
+		virtualHost := &routev3.VirtualHost{Name: route.Name, Domains: route.Hostnames}
+		totalWeight := 0
+		for _, backend := range route.Backends {
+			totalWeight += backend.Weight
+		}
+		cumulativeWeight := 0
+		for i, backend := range route.Backends {
+			name := clusterName(route, i)
+			clusterResources, err := translateBackend(name, backend)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", route.Name, err)
+			}
+			for typ, typeResources := range clusterResources {
+				resources[typ] = append(resources[typ], typeResources...)
+			}
+			if backend.Weight <= 0 {
+				continue
+			}
+			cumulativeWeight += backend.Weight
+			virtualHost.Routes = append(virtualHost.Routes, backendRoute(name, backend, cumulativeWeight, totalWeight))
+		}
+		if len(virtualHost.Routes) == 0 {
+			virtualHost.Routes = []*routev3.Route{{
+				Match: &routev3.RouteMatch{PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: "/"}},
+				Action: &routev3.Route_DirectResponse{DirectResponse: &routev3.DirectResponseAction{
+					Status: 503,
+					Body:   &corev3.DataSource{Specifier: &corev3.DataSource_InlineString{InlineString: "no backend"}},
+				}},
+			}}
+		}
+		routeConfiguration.VirtualHosts = append(routeConfiguration.VirtualHosts, virtualHost)
+	}
+	resources[resourcev3.RouteType] = append(resources[resourcev3.RouteType], routeConfiguration)
+
+	listener, err := httpListener(listenerPort)
+	if err != nil {
+		return nil, err
+	}
+	resources[resourcev3.ListenerType] = append(resources[resourcev3.ListenerType], listener)
+	return resources, nil
+}
+
+// backendRoute routes the requests whose random value is below the cumulative weight of the backend to its cluster
+func backendRoute(name string, backend v1alpha1.Backend, cumulativeWeight int, totalWeight int) *routev3.Route {
+	match := &routev3.RouteMatch{PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: "/"}}
+	if cumulativeWeight < totalWeight {
+		match.RuntimeFraction = &corev3.RuntimeFractionalPercent{
+			DefaultValue: &typev3.FractionalPercent{
+				Numerator:   uint32(int64(cumulativeWeight) * fractionDenominator / int64(totalWeight)),
+				Denominator: typev3.FractionalPercent_MILLION,
+			},
+			RuntimeKey: "routes." + name + ".fraction",
+		}
+	}
+	host, _, _ := splitHost(backend)
+	action := &routev3.RouteAction{
+		ClusterSpecifier:     &routev3.RouteAction_Cluster{Cluster: name},
+		HostRewriteSpecifier: &routev3.RouteAction_HostRewriteLiteral{HostRewriteLiteral: host},
+		RetryPolicy: &routev3.RetryPolicy{
+			RetryOn:    retryOn,
+			NumRetries: wrapperspb.UInt32(numRetries),
+		},
+	}
+	if pathPrefix := strings.TrimSuffix(backend.PathPrefix, "/"); pathPrefix != "" {
+		action.PrefixRewrite = pathPrefix + "/"
+	}
+	route := &routev3.Route{
+		Name:   name,
+		Match:  match,
+		Action: &routev3.Route_Route{Route: action},
+	}
+	headers := make([]string, 0, len(backend.HeadersToAdd))
+	for header := range backend.HeadersToAdd {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	for _, header := range headers {
+		route.RequestHeadersToAdd = append(route.RequestHeadersToAdd, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: header, Value: backend.HeadersToAdd[header]},
+			AppendAction: corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD,
+		})
+	}
+	return route
+}
+
+// translateBackend returns the cluster of a backend and the resources it references: its endpoints when the backend host is an IP address,
+// resolved by Envoy otherwise, and the cluster of the token endpoint and the client secret of its OAuth credentials
+func translateBackend(name string, backend v1alpha1.Backend) (map[resourcev3.Type][]types.Resource, error) {
+	host, port, err := splitHost(backend)
+	if err != nil {
+		return nil, err
+	}
+	cluster, loadAssignment, err := upstreamCluster(name, host, port, backend.Scheme == "https", true)
+	if err != nil {
+		return nil, err
+	}
+	resources := map[resourcev3.Type][]types.Resource{}
+	if loadAssignment != nil {
+		resources[resourcev3.EndpointType] = append(resources[resourcev3.EndpointType], loadAssignment)
+	}
+	if auth := backend.Auth; auth != nil && auth.Type == v1alpha1.AuthTypeOAuth && auth.OAuth != nil {
+		tokenCluster, secret, err := injectOAuthCredentials(cluster, auth.OAuth)
+		if err != nil {
+			return nil, err
+		}
+		resources[resourcev3.ClusterType] = append(resources[resourcev3.ClusterType], tokenCluster)
+		resources[resourcev3.SecretType] = append(resources[resourcev3.SecretType], secret)
+	}
+	resources[resourcev3.ClusterType] = append(resources[resourcev3.ClusterType], cluster)
+	return resources, nil
+}
+
+// upstreamCluster returns a DNS cluster when host is a hostname. When host is an IP address, it returns an EDS cluster and its endpoints
+// if eds is true, and a static cluster otherwise.
+func upstreamCluster(name string, host string, port uint32, tls bool, eds bool) (*clusterv3.Cluster, *endpointv3.ClusterLoadAssignment, error) {
+	loadAssignment := &endpointv3.ClusterLoadAssignment{
+		ClusterName: name,
+		Endpoints: []*endpointv3.LocalityLbEndpoints{{
+			LbEndpoints: []*endpointv3.LbEndpoint{{
+				HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+					Address: socketAddress(host, port),
+				}},
+			}},
+		}},
+	}
+	cluster := &clusterv3.Cluster{
+		Name:           name,
+		ConnectTimeout: durationpb.New(connectTimeout),
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && eds:
+		cluster.ClusterDiscoveryType = &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS}
+		cluster.EdsClusterConfig = &clusterv3.Cluster_EdsClusterConfig{EdsConfig: adsConfigSource}
+	case ip != nil:
+		cluster.ClusterDiscoveryType = &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STATIC}
+		cluster.LoadAssignment = loadAssignment
+		loadAssignment = nil
+	default:
+		cluster.ClusterDiscoveryType = &clusterv3.Cluster_Type{Type: clusterv3.Cluster_LOGICAL_DNS}
+		cluster.LoadAssignment = loadAssignment
+		loadAssignment = nil
+	}
+	if tls {
+		tlsContext := &tlsv3.UpstreamTlsContext{}
+		if ip == nil {
+			tlsContext.Sni = host
+		}
+		typedConfig, err := anypb.New(tlsContext)
+		if err != nil {
+			return nil, nil, err
+		}
+		cluster.TransportSocket = &corev3.TransportSocket{
+			Name:       wellknown.TransportSocketTLS,
+			ConfigType: &corev3.TransportSocket_TypedConfig{TypedConfig: typedConfig},
+		}
+	}
+	return cluster, loadAssignment, nil
+}
+
+// injectOAuthCredentials adds the credential injector to the upstream filters of cluster, fetching access tokens with the client credentials
+// grant, and returns the cluster of the token endpoint and the secret holding the client secret
+func injectOAuthCredentials(cluster *clusterv3.Cluster, oauth *v1alpha1.OAuth) (*clusterv3.Cluster, *tlsv3.Secret, error) {
+	tokenURL, err := url.Parse(oauth.TokenURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid token URL %q: %w", oauth.TokenURL, err)
+	}
+	tokenHost, tokenPort, err := splitHost(v1alpha1.Backend{Host: tokenURL.Host, Scheme: tokenURL.Scheme})
+	if err != nil {
+		return nil, nil, err
+	}
+	// The token endpoint is not served with EDS
+	tokenCluster, _, err := upstreamCluster(cluster.Name+"-token", tokenHost, tokenPort, tokenURL.Scheme == "https", false)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret := &tlsv3.Secret{
+		Name: cluster.Name + "-client-secret",
+		Type: &tlsv3.Secret_GenericSecret{GenericSecret: &tlsv3.GenericSecret{
+			Secret: &corev3.DataSource{Specifier: &corev3.DataSource_InlineString{InlineString: oauth.ClientSecret}},
+		}},
+	}
+
+	credential, err := anypb.New(&oauth2credentialv3.OAuth2{
+		TokenEndpoint: &corev3.HttpUri{
+			Uri:              oauth.TokenURL,
+			HttpUpstreamType: &corev3.HttpUri_Cluster{Cluster: tokenCluster.Name},
+			Timeout:          durationpb.New(connectTimeout),
+		},
+		FlowType: &oauth2credentialv3.OAuth2_ClientCredentials_{ClientCredentials: &oauth2credentialv3.OAuth2_ClientCredentials{
+			ClientId:     oauth.ClientID,
+			ClientSecret: &tlsv3.SdsSecretConfig{Name: secret.Name, SdsConfig: adsConfigSource},
+		}},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	filters, err := typedFilters(
+		credentialInjectorFilter, &credentialinjectorv3.CredentialInjector{
+			Overwrite:  true,
+			Credential: &corev3.TypedExtensionConfig{Name: oauth2Credential, TypedConfig: credential},
+		},
+		upstreamCodecFilter, &upstreamcodecv3.UpstreamCodec{},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	protocolOptions, err := anypb.New(&upstreamhttpv3.HttpProtocolOptions{
+		UpstreamProtocolOptions: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_HttpProtocolOptions{
+					HttpProtocolOptions: &corev3.Http1ProtocolOptions{},
+				},
+			},
+		},
+		HttpFilters: filters,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	cluster.TypedExtensionProtocolOptions = map[string]*anypb.Any{httpProtocolOptions: protocolOptions}
+	return tokenCluster, secret, nil
+}
+
+// httpListener returns the listener receiving the traffic of the models, routed by the route configuration fetched with RDS
+func httpListener(port uint32) (*listenerv3.Listener, error) {
+	filters, err := typedFilters(wellknown.Router, &routerv3.Router{})
+	if err != nil {
+		return nil, err
+	}
+	connectionManager, err := anypb.New(&hcmv3.HttpConnectionManager{
+		StatPrefix: "beamlit_gateway",
+		CodecType:  hcmv3.HttpConnectionManager_AUTO,
+		RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{Rds: &hcmv3.Rds{
+			ConfigSource:    adsConfigSource,
+			RouteConfigName: ListenerName,
+		}},
+		// The host header keeps its port, the routes of the ports of a model matching the hostnames of the Services with their port
+		HttpFilters: filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &listenerv3.Listener{
+		Name:    ListenerName,
+		Address: socketAddress("0.0.0.0", port),
+		FilterChains: []*listenerv3.FilterChain{{
+			Filters: []*listenerv3.Filter{{
+				Name:       wellknown.HTTPConnectionManager,
+				ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: connectionManager},
+			}},
+		}},
+	}, nil
+}
+
+// typedFilters returns the HTTP filters of the given name and config pairs
+func typedFilters(namesAndConfigs ...interface{}) ([]*hcmv3.HttpFilter, error) {
+	filters := make([]*hcmv3.HttpFilter, 0, len(namesAndConfigs)/2)
+	for i := 0; i+1 < len(namesAndConfigs); i += 2 {
+		typedConfig, err := anypb.New(namesAndConfigs[i+1].(proto.Message))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, &hcmv3.HttpFilter{
+			Name:       namesAndConfigs[i].(string),
+			ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: typedConfig},
+		})
+	}
+	return filters, nil
+}
+
+func socketAddress(host string, port uint32) *corev3.Address {
+	return &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+		Address:       host,
+		PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: port},
+	}}}
+}
+
+// splitHost returns the host and the port of a backend, the default port of its scheme if its host has none
+func splitHost(backend v1alpha1.Backend) (string, uint32, error) {
+	port := uint32(80)
+	if backend.Scheme == "https" {
+		port = 443
+	}
+	host, portString, err := net.SplitHostPort(backend.Host)
+	if err != nil {
+		// The host has no port
+		return strings.Trim(backend.Host, "[]"), port, nil
+	}
+	parsedPort, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in backend host %q: %w", backend.Host, err)
+	}
+	return host, uint32(parsedPort), nil
+}
+
+func clusterName(route v1alpha1.Route, backendIndex int) string {
+	return fmt.Sprintf("%s-%d", route.Name, backendIndex)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package importer generates Kubernetes manifests for resources already existing on Beamlit
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/controller/helper"
+)
+
+// Importer lists policies and models on Beamlit and writes the matching Policy and ModelDeployment manifests
+type Importer struct {
+	BeamlitClient *beamlit.Client
+	// Namespace is the namespace of the generated resources
+	Namespace string
+	// Environment is the Beamlit environment the models are imported from
+	Environment string
+	// Adopt sets the generated Policy and ModelDeployment as owners of the policy and the model on Beamlit,
+	// so the operator updates them instead of failing on an ownership conflict
+	Adopt bool
+	// SkipPolicies and SkipModels disable the import of policies and models
+	SkipPolicies bool
+	SkipModels   bool
+}
+
+// Run writes the manifests of the imported resources to out, as a multi-document YAML stream
+// Resources which cannot be represented in the cluster are skipped with a log line
+func (i *Importer) Run(ctx context.Context, out io.Writer) error {
+	if !i.SkipPolicies {
+		if err := i.importPolicies(ctx, out); err != nil {
+			return err
+		}
+	}
+	if !i.SkipModels {
+		if err := i.importModels(ctx, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Importer) importPolicies(ctx context.Context, out io.Writer) error {
+	logger := log.FromContext(ctx)
+	policies, err := i.BeamlitClient.ListPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	for _, beamlitPolicy := range policies {
+		policy, err := helper.FromBeamlitPolicy(beamlitPolicy, i.Namespace)
+		if err != nil {
+			logger.V(0).Info("Skipping Policy", "reason", err.Error())
+			continue
+		}
+		if i.Adopt {
+			if err := i.BeamlitClient.AdoptPolicy(ctx, policy.Spec.RemoteName, helper.PolicyOwner(policy)); err != nil {
+				logger.V(0).Info("Skipping Policy", "reason", err.Error())
+				continue
+			}
+		}
+		if err := writeManifest(out, policy); err != nil {
+			return err
+		}
+		logger.V(1).Info("Imported Policy", "Name", policy.Name, "RemoteName", policy.Spec.RemoteName)
+	}
+	return nil
+}
+
+func (i *Importer) importModels(ctx context.Context, out io.Writer) error {
+	logger := log.FromContext(ctx)
+	models, err := i.BeamlitClient.ListModels(ctx, i.Environment)
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		modelDeployment, err := helper.FromBeamlitModel(model, i.Namespace)
+		if err != nil {
+			logger.V(0).Info("Skipping Model", "reason", err.Error())
+			continue
+		}
+		if i.Adopt {
+			if err := i.BeamlitClient.AdoptModel(ctx, modelDeployment.Spec.Model, i.Environment, helper.ModelOwner(modelDeployment)); err != nil {
+				logger.V(0).Info("Skipping Model", "reason", err.Error())
+				continue
+			}
+		}
+		if err := writeManifest(out, modelDeployment, modelSourceRefNote); err != nil {
+			return err
+		}
+		logger.V(1).Info("Imported Model", "Name", modelDeployment.Name, "Model", modelDeployment.Spec.Model)
+	}
+	return nil
+}
+
+// modelSourceRefNote is written above the imported models, as Beamlit does not know which workload serves a model in the cluster
+const modelSourceRefNote = "spec.modelSourceRef must reference the workload serving the model before applying"
+
+// writeManifest writes obj to out without its status and the fields set by the API server
+// The notes are written as YAML comments at the top of the document
+func writeManifest(out io.Writer, obj client.Object, notes ...string) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	delete(content, "status")
+	unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+	manifest, err := yaml.Marshal(content)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "---"); err != nil {
+		return err
+	}
+	for _, note := range notes {
+		if _, err := fmt.Fprintf(out, "# %s\n", note); err != nil {
+			return err
+		}
+	}
+	_, err = out.Write(manifest)
+	return err
+}
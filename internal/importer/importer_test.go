@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	beamlitsdk "github.com/beamlit/toolkit/sdk"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+func toPtr[T any](v T) *T {
+	return &v
+}
+
+func newPolicy(name string, labels map[string]string) beamlitsdk.Policy {
+	return beamlitsdk.Policy{
+		Metadata: &beamlitsdk.Metadata{
+			Name:   toPtr(name),
+			Labels: (*beamlitsdk.MetadataLabels)(&labels),
+		},
+		Spec: &beamlitsdk.PolicySpec{
+			Type: toPtr("location"),
+		},
+	}
+}
+
+func newServer() *beamlittest.Server {
+	server := beamlittest.NewServer()
+	server.SetPolicy(newPolicy("Console_Policy", map[string]string{"team": "a"}))
+	server.SetPolicy(newPolicy("owned", map[string]string{beamlit.OwnerLabel: "other/owned"}))
+	server.SetModel(beamlitsdk.Model{
+		Metadata: &beamlitsdk.EnvironmentMetadata{
+			Name:        toPtr("llama"),
+			Environment: toPtr("production"),
+		},
+		Spec: &beamlitsdk.ModelSpec{
+			Enabled:  toPtr(true),
+			Policies: &[]string{"Console_Policy"},
+		},
+	})
+	return server
+}
+
+func owner(labels *beamlitsdk.MetadataLabels) string {
+	if labels == nil {
+		return ""
+	}
+	return (*labels)[beamlit.OwnerLabel]
+}
+
+func TestImporter(t *testing.T) {
+	type testCase struct {
+		adopt bool
+		check func(t *testing.T, server *beamlittest.Server, manifests string)
+	}
+	tcs := map[string]testCase{
+		"When adopt is disabled, must write the manifests without changing Beamlit": {
+			check: func(t *testing.T, server *beamlittest.Server, manifests string) {
+				for _, want := range []string{"name: console-policy", "remoteName: Console_Policy", "name: owned", "model: llama", "name: Console_Policy\n    refType: remotePolicy"} {
+					if !strings.Contains(manifests, want) {
+						t.Errorf("want %q in the manifests but got:\n%s", want, manifests)
+					}
+				}
+				policy, _ := server.Policy("Console_Policy")
+				if owner := owner(policy.Metadata.Labels); owner != "" {
+					t.Errorf("want the policy not adopted but got owner %q", owner)
+				}
+				model, _ := server.Model("production", "llama")
+				if owner := owner(model.Metadata.Labels); owner != "" {
+					t.Errorf("want the model not adopted but got owner %q", owner)
+				}
+			},
+		},
+		"When adopt is enabled, must adopt the policies and models without owner and skip the others": {
+			adopt: true,
+			check: func(t *testing.T, server *beamlittest.Server, manifests string) {
+				if strings.Contains(manifests, "name: owned") {
+					t.Errorf("want the policy owned by another resource skipped but got:\n%s", manifests)
+				}
+				policy, _ := server.Policy("Console_Policy")
+				if owner := owner(policy.Metadata.Labels); owner != "imported/console-policy" {
+					t.Errorf("want the policy adopted by imported/console-policy but got owner %q", owner)
+				}
+				model, _ := server.Model("production", "llama")
+				if owner := owner(model.Metadata.Labels); owner != "imported/llama" {
+					t.Errorf("want the model adopted by imported/llama but got owner %q", owner)
+				}
+			},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			server := newServer()
+			defer server.Close()
+			client, err := beamlit.NewClientWithCredentials(server.URL, server.Token(), beamlit.WithRequestPolicy(&beamlit.RequestPolicy{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			importer := &Importer{BeamlitClient: client, Namespace: "imported", Environment: "production", Adopt: tc.adopt}
+			var out bytes.Buffer
+			if err := importer.Run(context.Background(), &out); err != nil {
+				t.Fatal(err)
+			}
+			manifests := out.String()
+			if strings.Contains(manifests, " Ref:") {
+				t.Errorf("want the policy references inlined but got:\n%s", manifests)
+			}
+			if !strings.Contains(manifests, "# "+modelSourceRefNote+"\n") {
+				t.Errorf("want the model source note above the model but got:\n%s", manifests)
+			}
+			if strings.Contains(manifests, "kind: Deployment") {
+				t.Errorf("want no model source guessed but got:\n%s", manifests)
+			}
+			tc.check(t, server, manifests)
+		})
+	}
+}
@@ -30,6 +30,8 @@ import (
 type MetricStatus struct {
 	ModelName string
 	Reached   bool
+	// Values are the last observed values of the metrics of the model, by metric name
+	Values map[string]float64
 }
 
 type MetricInformerType int
@@ -190,18 +190,19 @@ func findRequestedResource(ctx context.Context, kubeClient kubernetes.Interface,
 	return requestedResource, nil
 }
 
-func isMetricReached(replicas int32, metric autoscalingv2.MetricTarget, metrics []int64) (bool, error) {
+// isMetricReached returns true if the metrics reached the target, with the value compared to the target
+func isMetricReached(replicas int32, metric autoscalingv2.MetricTarget, metrics []int64) (bool, float64, error) {
 	switch metric.Type {
 	case autoscalingv2.UtilizationMetricType:
 		if metric.AverageUtilization == nil {
-			return false, fmt.Errorf("averageUtilization is nil")
+			return false, 0, fmt.Errorf("averageUtilization is nil")
 		}
 		averageUtilization := int64(0)
 		for _, metric := range metrics {
 			averageUtilization += metric
 		}
 		averageUtilization /= int64(replicas)
-		return averageUtilization >= int64(*metric.AverageUtilization), nil
+		return averageUtilization >= int64(*metric.AverageUtilization), float64(averageUtilization), nil
 	case autoscalingv2.ValueMetricType:
 		metricValue := int64(0)
 		for _, m := range metrics {
@@ -209,15 +210,32 @@ func isMetricReached(replicas int32, metric autoscalingv2.MetricTarget, metrics
 				metricValue += m
 			}
 		}
-		return metric.Value.CmpInt64(metricValue) >= 0, nil
+		return metric.Value.CmpInt64(metricValue) >= 0, float64(metricValue), nil
 	case autoscalingv2.AverageValueMetricType:
 		averageValue := int64(0)
 		for _, metric := range metrics {
 			averageValue += metric
 		}
 		averageValue /= int64(replicas)
-		return metric.AverageValue.CmpInt64(averageValue) >= 0, nil
+		return metric.AverageValue.CmpInt64(averageValue) >= 0, float64(averageValue), nil
 	default:
-		return false, fmt.Errorf("unsupported metric type: %s", metric.Type)
+		return false, 0, fmt.Errorf("unsupported metric type: %s", metric.Type)
 	}
 }
+
+// metricName returns the name of the metric of spec, used to report its values
+func metricName(spec autoscalingv2.MetricSpec) string {
+	switch {
+	case spec.Object != nil:
+		return spec.Object.Metric.Name
+	case spec.Pods != nil:
+		return spec.Pods.Metric.Name
+	case spec.Resource != nil:
+		return string(spec.Resource.Name)
+	case spec.ContainerResource != nil:
+		return fmt.Sprintf("%s/%s", spec.ContainerResource.Container, spec.ContainerResource.Name)
+	case spec.External != nil:
+		return spec.External.Metric.Name
+	}
+	return string(spec.Type)
+}
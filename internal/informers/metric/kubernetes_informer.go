@@ -19,6 +19,7 @@ package metric
 import (
 	"context"
 	"fmt"
+	"maps"
 	"time"
 
 	"github.com/beamlit/beamlit-controller/internal/informers"
@@ -199,6 +200,7 @@ func (mw *k8sMetricWatcher) start(ctx context.Context) {
 			}
 			mw.latestStatus.ModelName = mw.model
 			mw.latestStatus.Reached = reached
+			mw.latestStatus.Values = mw.condition.observedValues()
 			mw.metricChan <- mw.latestStatus
 		}
 	}
@@ -237,7 +239,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				errs = append(errs, err)
 				continue
 			}
-			reached, err := isMetricReached(
+			reached, value, err := isMetricReached(
 				replicas,
 				metric.Object.Target,
 				[]int64{usage},
@@ -246,6 +248,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				errs = append(errs, err)
 				continue
 			}
+			mw.condition.observe(metric, value)
 			mw.condition.update(ctx, metric, reached)
 		case autoscalingv2.PodsMetricSourceType:
 			if metric.Pods == nil {
@@ -266,7 +269,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 			for _, podMetric := range usage {
 				metrics = append(metrics, podMetric.Value)
 			}
-			reached, err := isMetricReached(
+			reached, value, err := isMetricReached(
 				replicas,
 				metric.Pods.Target,
 				metrics,
@@ -275,6 +278,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				errs = append(errs, err)
 				continue
 			}
+			mw.condition.observe(metric, value)
 			mw.condition.update(ctx, metric, reached)
 		case autoscalingv2.ResourceMetricSourceType: // only case with averageUtilization
 			if metric.Resource == nil {
@@ -287,6 +291,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				continue
 			}
 			reached := false
+			value := float64(0)
 			if metric.Resource.Target.AverageUtilization != nil {
 				requestedResource, err := findRequestedResource(ctx, mw.kubernetesClient, mw.watchTarget.Namespace, labelSelector, metric.Resource.Name)
 				if err != nil {
@@ -302,6 +307,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				averageUtilization /= requestedResource
 				averageUtilization /= int64(replicas)
 				reached = averageUtilization >= int64(*metric.Resource.Target.AverageUtilization)
+				value = float64(averageUtilization)
 			} else {
 				metrics := []int64{}
 				for _, podMetric := range usage {
@@ -309,7 +315,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 						metrics = append(metrics, podMetric.Value)
 					}
 				}
-				reached, err = isMetricReached(
+				reached, value, err = isMetricReached(
 					replicas,
 					metric.Resource.Target,
 					metrics,
@@ -319,6 +325,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 					continue
 				}
 			}
+			mw.condition.observe(metric, value)
 			mw.condition.update(ctx, metric, reached)
 		case autoscalingv2.ContainerResourceMetricSourceType:
 			if metric.ContainerResource == nil {
@@ -334,7 +341,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 			for _, podMetric := range usage {
 				metrics = append(metrics, podMetric.Value)
 			}
-			reached, err := isMetricReached(
+			reached, value, err := isMetricReached(
 				replicas,
 				metric.ContainerResource.Target,
 				metrics,
@@ -343,6 +350,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				errs = append(errs, err)
 				continue
 			}
+			mw.condition.observe(metric, value)
 			mw.condition.update(ctx, metric, reached)
 		case autoscalingv2.ExternalMetricSourceType:
 			if metric.External == nil {
@@ -360,7 +368,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				continue
 			}
 			metrics := usage
-			reached, err := isMetricReached(
+			reached, value, err := isMetricReached(
 				replicas,
 				metric.External.Target,
 				metrics,
@@ -369,6 +377,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 				errs = append(errs, err)
 				continue
 			}
+			mw.condition.observe(metric, value)
 			mw.condition.update(ctx, metric, reached)
 		default:
 			panic("unsupported metric type")
@@ -387,6 +396,7 @@ func (mw *k8sMetricWatcher) CheckMetric(ctx context.Context) (bool, error) {
 
 type metricConditionStatus struct {
 	currentMetricReachedMetrics []autoscalingv2.MetricSpec
+	values                      map[string]float64 // The last observed value of each metric, by metric name
 	reached                     bool
 	window                      time.Duration // The window for which the condition must be reached to trigger an event
 	sinceTime                   time.Time     // The time when the condition was first reached
@@ -434,6 +444,19 @@ func (mcs *metricConditionStatus) update(_ context.Context, currentMetric autosc
 
 }
 
+// observe records the last observed value of a metric
+func (mcs *metricConditionStatus) observe(metric autoscalingv2.MetricSpec, value float64) {
+	if mcs.values == nil {
+		mcs.values = map[string]float64{}
+	}
+	mcs.values[metricName(metric)] = value
+}
+
+// observedValues returns a copy of the last observed values of the metrics
+func (mcs *metricConditionStatus) observedValues() map[string]float64 {
+	return maps.Clone(mcs.values)
+}
+
 // isReached returns true if the condition is reached, false otherwise
 func (mcs *metricConditionStatus) isReached() bool {
 	if !mcs.reached {
@@ -157,6 +157,7 @@ func (p *prometheusMetricWatcher) start(ctx context.Context) {
 						logger.Error(err, "Error unmarshalling result to scalar")
 						continue
 					}
+					p.condition.observe(metric, float64(scalar.Value))
 					if float64(scalar.Value) > value {
 						p.condition.update(ctx, metric, true)
 						continue
@@ -164,12 +165,16 @@ func (p *prometheusMetricWatcher) start(ctx context.Context) {
 					p.condition.update(ctx, metric, false)
 				case model.ValVector:
 					reached := false
-					for _, sample := range result.(model.Vector) {
+					observed := float64(0)
+					for i, sample := range result.(model.Vector) {
+						if i == 0 || float64(sample.Value) > observed {
+							observed = float64(sample.Value)
+						}
 						if float64(sample.Value) > value {
 							reached = true
-							break
 						}
 					}
+					p.condition.observe(metric, observed)
 					p.condition.update(ctx, metric, reached)
 				case model.ValMatrix:
 					logger.Error(fmt.Errorf("unsupported metric type: %s", result.Type()), "Unsupported metric type, only scalar is supported")
@@ -183,6 +188,7 @@ func (p *prometheusMetricWatcher) start(ctx context.Context) {
 					p.metricChan <- MetricStatus{
 						ModelName: p.model,
 						Reached:   p.condition.reached,
+						Values:    p.condition.observedValues(),
 					}
 				}
 			}
@@ -0,0 +1,255 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+)
+
+const (
+	defaultFlushInterval = 10 * time.Second
+	defaultBatchSize     = 50
+	defaultBufferSize    = 1000
+	defaultProbeInterval = time.Hour
+	shutdownFlushTimeout = 10 * time.Second
+
+	instrumentationName = "github.com/beamlit/beamlit-controller/internal/reporter"
+)
+
+// ClientProvider returns the Beamlit client of a workspace, "" for the default workspace
+type ClientProvider interface {
+	ClientFor(ctx context.Context, workspace string) (*beamlit.Client, error)
+}
+
+// OffloadReport is an offloading transition of a model, to report on Beamlit
+type OffloadReport struct {
+	// Workspace is the BeamlitWorkspace of the model, "" for the default workspace
+	Workspace string
+	// Environment is the environment of the model on Beamlit
+	Environment string
+	// Model is the name of the model on Beamlit
+	Model string
+	// Event is the transition
+	Event beamlit.OffloadEvent
+}
+
+// modelKey identifies a model on Beamlit
+type modelKey struct {
+	workspace   string
+	environment string
+	model       string
+}
+
+func (k modelKey) String() string {
+	return fmt.Sprintf("%s/%s/%s", k.workspace, k.environment, k.model)
+}
+
+// OffloadReporter reports the offloading transitions of models on Beamlit, in the background.
+// Reports are buffered and sent in batches per model, and kept in the buffer while Beamlit is unreachable.
+// When the workspace does not expose the events endpoint, the offloading label of the model is set instead.
+type OffloadReporter struct {
+	// Clients returns the Beamlit client of the workspace of a model
+	Clients ClientProvider
+	// FlushInterval is the delay between two flushes of the buffer
+	FlushInterval time.Duration
+	// BatchSize is the maximum number of events sent in a request, a full batch is flushed without waiting for FlushInterval
+	BatchSize int
+	// BufferSize is the maximum number of buffered reports, the oldest ones are dropped beyond
+	BufferSize int
+	// ProbeInterval is the delay before trying again the events endpoint of a workspace which does not expose it
+	ProbeInterval time.Duration
+
+	mu          sync.Mutex
+	buffer      []OffloadReport
+	unsupported map[string]time.Time // key: workspace, value: time the events endpoint was found missing
+	flush       chan struct{}
+}
+
+// NewOffloadReporter creates an OffloadReporter with the default settings
+func NewOffloadReporter(clients ClientProvider) *OffloadReporter {
+	return &OffloadReporter{
+		Clients:       clients,
+		FlushInterval: defaultFlushInterval,
+		BatchSize:     defaultBatchSize,
+		BufferSize:    defaultBufferSize,
+		ProbeInterval: defaultProbeInterval,
+	}
+}
+
+// Report buffers an offloading transition, it is sent on the next flush
+func (r *OffloadReporter) Report(ctx context.Context, report OffloadReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enqueue(ctx, report)
+	if len(r.buffer) >= r.BatchSize {
+		select {
+		case r.flushChan() <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Start flushes the buffer every FlushInterval until ctx is done, and flushes it a last time before returning
+func (r *OffloadReporter) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+	ticker := time.NewTicker(r.FlushInterval)
+	defer ticker.Stop()
+	r.mu.Lock()
+	flush := r.flushChan()
+	r.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.V(0).Info("Stopping offload reporter, flushing buffered reports")
+			shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownFlushTimeout)
+			r.Flush(shutdownCtx)
+			cancel()
+			return nil
+		case <-ticker.C:
+			r.Flush(ctx)
+		case <-flush:
+			r.Flush(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection returns false, so reports buffered before losing the leadership are still sent
+func (r *OffloadReporter) NeedLeaderElection() bool {
+	return false
+}
+
+// Flush sends the buffered reports, grouped in batches per model
+// Reports which cannot be sent because of a transient failure are buffered again
+func (r *OffloadReporter) Flush(ctx context.Context) {
+	r.mu.Lock()
+	reports := r.buffer
+	r.buffer = nil
+	r.mu.Unlock()
+	if len(reports) == 0 {
+		return
+	}
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, "Flush offload reports")
+	defer span.End()
+	span.SetAttributes(attribute.Int("beamlit.offload_reports", len(reports)))
+
+	var keys []modelKey
+	events := map[modelKey][]beamlit.OffloadEvent{}
+	for _, report := range reports {
+		key := modelKey{workspace: report.Workspace, environment: report.Environment, model: report.Model}
+		if _, ok := events[key]; !ok {
+			keys = append(keys, key)
+		}
+		events[key] = append(events[key], report.Event)
+	}
+	var failed []OffloadReport
+	for _, key := range keys {
+		modelEvents := events[key]
+		for len(modelEvents) > 0 {
+			batch := modelEvents[:min(max(r.BatchSize, 1), len(modelEvents))]
+			if err := r.send(ctx, key, batch); err != nil {
+				if !transient(err) {
+					log.FromContext(ctx).V(0).Error(err, "Dropping offload events rejected by Beamlit", "Model", key.String(), "Events", len(batch))
+					modelEvents = modelEvents[len(batch):]
+					continue
+				}
+				log.FromContext(ctx).V(0).Error(err, "Failed to report offload events on Beamlit, keeping them for the next flush", "Model", key.String(), "Events", len(modelEvents))
+				for _, event := range modelEvents {
+					failed = append(failed, OffloadReport{Workspace: key.workspace, Environment: key.environment, Model: key.model, Event: event})
+				}
+				break
+			}
+			modelEvents = modelEvents[len(batch):]
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.buffer
+	r.buffer = nil
+	for _, report := range append(failed, pending...) {
+		r.enqueue(ctx, report)
+	}
+}
+
+// send reports a batch of events of a model, or sets the offloading label of the model to the last event
+// when the workspace does not expose the events endpoint
+func (r *OffloadReporter) send(ctx context.Context, key modelKey, batch []beamlit.OffloadEvent) error {
+	client, err := r.Clients.ClientFor(ctx, key.workspace)
+	if err != nil {
+		return err
+	}
+	if !r.eventsUnsupported(key.workspace) {
+		err := client.ReportOffloadEvents(ctx, key.model, key.environment, batch)
+		var unsupportedErr *beamlit.ErrEventsUnsupported
+		if !errors.As(err, &unsupportedErr) {
+			return err
+		}
+		log.FromContext(ctx).V(0).Info("Beamlit does not expose the events endpoint, falling back to the offloading label", "Workspace", key.workspace, "StatusCode", unsupportedErr.StatusCode)
+		r.mu.Lock()
+		if r.unsupported == nil {
+			r.unsupported = map[string]time.Time{}
+		}
+		r.unsupported[key.workspace] = time.Now()
+		r.mu.Unlock()
+	}
+	return client.NotifyOnModelOffloading(ctx, key.model, key.environment, batch[len(batch)-1].Offloading)
+}
+
+// eventsUnsupported returns true if the events endpoint of workspace was found missing less than ProbeInterval ago
+func (r *OffloadReporter) eventsUnsupported(workspace string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	since, ok := r.unsupported[workspace]
+	return ok && time.Since(since) < r.ProbeInterval
+}
+
+// enqueue appends report to the buffer, dropping the oldest report when the buffer is full. r.mu must be held.
+func (r *OffloadReporter) enqueue(ctx context.Context, report OffloadReport) {
+	if r.BufferSize > 0 && len(r.buffer) >= r.BufferSize {
+		dropped := r.buffer[0]
+		log.FromContext(ctx).V(0).Info("Offload report buffer is full, dropping the oldest report", "Model", fmt.Sprintf("%s/%s/%s", dropped.Workspace, dropped.Environment, dropped.Model), "Timestamp", dropped.Event.Timestamp)
+		r.buffer = r.buffer[1:]
+	}
+	r.buffer = append(r.buffer, report)
+}
+
+// flushChan returns the channel requesting a flush before FlushInterval. r.mu must be held.
+func (r *OffloadReporter) flushChan() chan struct{} {
+	if r.flush == nil {
+		r.flush = make(chan struct{}, 1)
+	}
+	return r.flush
+}
+
+// transient returns true if sending the same events again may succeed
+func transient(err error) bool {
+	var validationErr *beamlit.ErrValidation
+	var notFoundErr *beamlit.ErrNotFound
+	return !errors.As(err, &validationErr) && !errors.As(err, &notFoundErr)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reporter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	beamlitsdk "github.com/beamlit/toolkit/sdk"
+
+	"github.com/beamlit/beamlit-controller/internal/beamlit"
+	"github.com/beamlit/beamlit-controller/internal/beamlit/beamlittest"
+)
+
+// staticClients returns the same client for every workspace
+type staticClients struct {
+	client *beamlit.Client
+}
+
+func (s staticClients) ClientFor(_ context.Context, _ string) (*beamlit.Client, error) {
+	return s.client, nil
+}
+
+func toPtr[T any](v T) *T {
+	return &v
+}
+
+func newReport(percentage int, trigger beamlit.OffloadTrigger) OffloadReport {
+	return OffloadReport{
+		Environment: "production",
+		Model:       "model",
+		Event: beamlit.OffloadEvent{
+			Timestamp:  time.Now(),
+			Offloading: percentage > 0,
+			Percentage: percentage,
+			Trigger:    trigger,
+		},
+	}
+}
+
+func TestOffloadReporterFlush(t *testing.T) {
+	type testCase struct {
+		opts           []beamlittest.Option
+		fault          *beamlittest.Fault
+		reports        []OffloadReport
+		wantEvents     int
+		wantRequests   int
+		wantBuffered   int
+		wantOffloading string
+	}
+	tcs := map[string]testCase{
+		"When Beamlit exposes the events endpoint, must send the events in batches": {
+			opts:         []beamlittest.Option{beamlittest.WithOffloadEvents()},
+			reports:      []OffloadReport{newReport(50, beamlit.OffloadTriggerMetric), newReport(100, beamlit.OffloadTriggerHealth), newReport(0, beamlit.OffloadTriggerManual)},
+			wantEvents:   3,
+			wantRequests: 2,
+		},
+		"When Beamlit does not expose the events endpoint, must set the offloading label to the last transition": {
+			reports:        []OffloadReport{newReport(0, beamlit.OffloadTriggerMetric), newReport(100, beamlit.OffloadTriggerHealth)},
+			wantOffloading: "true",
+		},
+		"When Beamlit is unavailable, must keep the events for the next flush": {
+			opts: []beamlittest.Option{beamlittest.WithOffloadEvents()},
+			fault: &beamlittest.Fault{
+				Path:       "/models/model/events",
+				StatusCode: http.StatusServiceUnavailable,
+			},
+			reports:      []OffloadReport{newReport(50, beamlit.OffloadTriggerMetric)},
+			wantRequests: 1,
+			wantBuffered: 1,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			server := beamlittest.NewServer(tc.opts...)
+			defer server.Close()
+			server.SetModel(beamlitsdk.Model{
+				Metadata: &beamlitsdk.EnvironmentMetadata{
+					Name:        toPtr("model"),
+					Environment: toPtr("production"),
+				},
+			})
+			if tc.fault != nil {
+				server.InjectFault(*tc.fault)
+			}
+			client, err := beamlit.NewClientWithCredentials(server.URL, server.Token(), beamlit.WithRequestPolicy(&beamlit.RequestPolicy{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reporter := NewOffloadReporter(staticClients{client: client})
+			reporter.BatchSize = 2
+			for _, report := range tc.reports {
+				reporter.Report(context.Background(), report)
+			}
+			reporter.Flush(context.Background())
+
+			if events := server.OffloadEvents("production", "model"); len(events) != tc.wantEvents {
+				t.Errorf("want %d events on Beamlit but got %d", tc.wantEvents, len(events))
+			}
+			requests := 0
+			for _, req := range server.Requests() {
+				if req.Path == "/models/model/events" {
+					requests++
+				}
+			}
+			if tc.wantRequests != 0 && requests != tc.wantRequests {
+				t.Errorf("want %d requests to the events endpoint but got %d", tc.wantRequests, requests)
+			}
+			if len(reporter.buffer) != tc.wantBuffered {
+				t.Errorf("want %d buffered reports but got %d", tc.wantBuffered, len(reporter.buffer))
+			}
+			if tc.wantOffloading != "" {
+				model, _ := server.Model("production", "model")
+				if offloading := (*model.Metadata.Labels)["offloading"]; offloading != tc.wantOffloading {
+					t.Errorf("want the offloading label %q but got %q", tc.wantOffloading, offloading)
+				}
+			}
+		})
+	}
+}
+
+func TestOffloadReporterBufferSize(t *testing.T) {
+	reporter := NewOffloadReporter(staticClients{})
+	reporter.BufferSize = 2
+	for _, percentage := range []int{10, 20, 30} {
+		reporter.Report(context.Background(), newReport(percentage, beamlit.OffloadTriggerMetric))
+	}
+	if len(reporter.buffer) != 2 || reporter.buffer[0].Event.Percentage != 20 {
+		t.Errorf("want the oldest report dropped but got %v", reporter.buffer)
+	}
+}